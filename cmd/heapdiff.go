@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dunooo0ooo/lang"
+)
+
+// heapDiff compares two heap snapshots and prints the object types whose
+// count or retained size grew.
+func heapDiff(before, after string) error {
+	a, err := readSnapshot(before)
+	if err != nil {
+		return err
+	}
+	b, err := readSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	growth := lang.DiffSnapshots(a, b)
+	if len(growth) == 0 {
+		fmt.Println("no type grew")
+		return nil
+	}
+	fmt.Printf("%-10s %16s %24s %24s\n", "type", "count", "bytes", "retained")
+	for _, g := range growth {
+		fmt.Printf("%-10s %16s %24s %24s\n", g.Type,
+			delta(g.Before.Count, g.After.Count),
+			delta(g.Before.Bytes, g.After.Bytes),
+			delta(g.Before.Retained, g.After.Retained))
+	}
+	return nil
+}
+
+func readSnapshot(path string) (*lang.Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s lang.Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, nil
+}
+
+func delta(before, after int) string {
+	return fmt.Sprintf("%d -> %d (%+d)", before, after, after-before)
+}
@@ -1,76 +1,131 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/dunooo0ooo/lang/internal/bytecode"
-	"github.com/dunooo0ooo/lang/internal/lexer"
-	"github.com/dunooo0ooo/lang/internal/parser"
-	"github.com/dunooo0ooo/lang/internal/runtime"
-	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
-	"github.com/dunooo0ooo/lang/internal/sema"
+	"github.com/dunooo0ooo/lang"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("usage: langrun <file.lang> [--jit]")
+		fmt.Println("usage: langrun <file.lang> [--jit] [--no-inline] [--gcstats] [--seed=<n>]")
+		fmt.Println("       langrun heapdiff <before.json> <after.json>")
+		fmt.Println("       langrun vet [--json] [--severity=<lint>=<severity>]... <file.lang>")
 		os.Exit(1)
 	}
 
-	path := os.Args[1]
-	enableJit := len(os.Args) > 2 && os.Args[2] == "--jit"
-
-	src, err := os.ReadFile(path)
-	if err != nil {
-		panic(err)
+	if os.Args[1] == "vet" {
+		clean, err := vet(os.Args[2:])
+		if err != nil {
+			fmt.Println("vet:", err)
+			os.Exit(2)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+		return
 	}
 
-	p := parser.New(lexer.New(string(src)))
-	prog := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		for _, e := range p.Errors() {
-			fmt.Println(e)
+	if os.Args[1] == "heapdiff" {
+		if len(os.Args) != 4 {
+			fmt.Println("usage: langrun heapdiff <before.json> <after.json>")
+			os.Exit(1)
 		}
-		os.Exit(1)
+		if err := heapDiff(os.Args[2], os.Args[3]); err != nil {
+			fmt.Println("heapdiff:", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	s := sema.New()
-	s.Check(prog)
-	if len(s.Errors()) != 0 {
-		for _, e := range s.Errors() {
-			fmt.Println(e)
+	path := os.Args[1]
+	enableJit := false
+	enableInline := true
+	showGCStats := false
+	var seed int64
+	for _, arg := range os.Args[2:] {
+		switch {
+		case arg == "--jit":
+			enableJit = true
+		case arg == "--no-inline":
+			enableInline = false
+		case arg == "--gcstats":
+			showGCStats = true
+		case strings.HasPrefix(arg, "--seed="):
+			n, err := strconv.ParseInt(strings.TrimPrefix(arg, "--seed="), 10, 64)
+			if err != nil {
+				fmt.Println("bad seed:", arg)
+				os.Exit(1)
+			}
+			seed = n
+		default:
+			fmt.Println("unknown flag:", arg)
+			os.Exit(1)
 		}
-		os.Exit(1)
 	}
 
-	comp := compilation.NewCompiler()
-	mod, err := comp.CompileProgram(prog)
+	src, err := os.ReadFile(path)
 	if err != nil {
 		panic(err)
 	}
 
-	vm := runtime.NewVM(mod, enableJit)
+	prog, err := lang.Compile(string(src), lang.CompileOptions{DisableInlining: !enableInline})
+	if err != nil {
+		var cerr *lang.CompileError
+		if errors.As(err, &cerr) {
+			for _, e := range cerr.Errors {
+				fmt.Println(e)
+			}
+		} else {
+			fmt.Println("compile error:", err)
+		}
+		os.Exit(1)
+	}
+	for _, w := range prog.Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+
+	vm := lang.NewVM(prog, lang.VMOptions{JIT: enableJit, SchedulerSeed: seed})
 
 	start := time.Now()
-	ret, err := vm.Call("main", nil)
+	ret, err := vm.Call("main")
 	if err != nil {
-		panic(err)
+		fmt.Println("runtime error:", err)
+		os.Exit(1)
 	}
 	elapsed := time.Since(start)
 
-	printResult(ret)
+	fmt.Println("result:", ret)
 	fmt.Println("time:", elapsed)
+	if showGCStats {
+		printGCStats(vm.Stats())
+	}
 }
 
-func printResult(v bytecode.Value) {
-	switch v.Kind {
-	case bytecode.ValInt:
-		fmt.Println("result:", v.I)
-	case bytecode.ValFloat:
-		fmt.Println("result:", v.F)
-	default:
-		fmt.Println("result:", v)
+func printGCStats(s lang.Stats) {
+	fmt.Printf("gc: %d minor, %d major, pause total %v, max %v\n",
+		s.MinorCollections, s.MajorCollections, s.TotalPause, s.MaxPause)
+	printHeapCounts("heap", s.HeapCounts)
+
+	byName := make(map[string]lang.HeapCounts, len(s.ByType))
+	names := make([]string, 0, len(s.ByType))
+	for t, c := range s.ByType {
+		byName[t.String()] = c
+		names = append(names, t.String())
 	}
+	sort.Strings(names)
+	for _, name := range names {
+		printHeapCounts("  "+name, byName[name])
+	}
+}
+
+func printHeapCounts(label string, c lang.HeapCounts) {
+	fmt.Printf("%s: allocated %d objects (%d B), freed %d (%d B), live %d (%d B)\n",
+		label, c.AllocatedObjects, c.AllocatedBytes, c.FreedObjects, c.FreedBytes, c.LiveObjects, c.LiveBytes)
 }
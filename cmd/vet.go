@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dunooo0ooo/lang"
+)
+
+const vetUsage = "usage: langrun vet [--json] [--severity=<lint>=<off|info|warning|error>]... <file.lang>\n       langrun vet --list"
+
+// vet prints what the lints find in the program at the path args name and
+// reports whether the program compiles and has no findings of severity
+// error.
+func vet(args []string) (bool, error) {
+	var (
+		path     string
+		asJSON   bool
+		severity = make(map[string]lang.Severity)
+	)
+	for _, arg := range args {
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case arg == "--list":
+			for _, l := range lang.Lints() {
+				fmt.Printf("%-15s %-8s %s\n", l.ID, l.Severity, l.Doc)
+			}
+			return true, nil
+		case strings.HasPrefix(arg, "--severity="):
+			id, name, ok := strings.Cut(strings.TrimPrefix(arg, "--severity="), "=")
+			if !ok {
+				return false, fmt.Errorf("bad flag %s; want --severity=<lint>=<severity>", arg)
+			}
+			s, err := lang.ParseSeverity(name)
+			if err != nil {
+				return false, err
+			}
+			severity[id] = s
+		case strings.HasPrefix(arg, "--"):
+			return false, fmt.Errorf("unknown flag %s\n%s", arg, vetUsage)
+		case path != "":
+			return false, errors.New(vetUsage)
+		default:
+			path = arg
+		}
+	}
+	if path == "" {
+		return false, errors.New(vetUsage)
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	diags, err := lang.Vet(string(src), lang.VetOptions{Severities: severity})
+	var cerr *lang.CompileError
+	if errors.As(err, &cerr) {
+		for _, e := range cerr.Errors {
+			fmt.Printf("%s:%v\n", path, e)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	clean := true
+	for _, d := range diags {
+		clean = clean && d.Severity < lang.SeverityError
+	}
+	if asJSON {
+		type fileDiagnostic struct {
+			File string `json:"file"`
+			lang.Diagnostic
+		}
+		out := make([]fileDiagnostic, len(diags))
+		for i, d := range diags {
+			out[i] = fileDiagnostic{File: path, Diagnostic: d}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return clean, enc.Encode(out)
+	}
+	for _, d := range diags {
+		fmt.Printf("%s:%v\n", path, d)
+	}
+	return clean, nil
+}
@@ -0,0 +1,122 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/optimize"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/runtime"
+	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+// CompileOptions configures Compile. The zero value compiles with all
+// optimizations and no natives.
+type CompileOptions struct {
+	// Natives are the Go functions the program may call.
+	Natives []Native
+	// DisableInlining keeps calls to small functions as calls.
+	DisableInlining bool
+}
+
+// Program is a compiled program. It is immutable: any number of VMs, in
+// any number of goroutines, may run it at once.
+type Program struct {
+	mod      *bytecode.Module
+	sigs     map[string]signature
+	warnings []error
+
+	jitOnce sync.Once
+	jitMod  *bytecode.Module
+}
+
+type signature struct {
+	params []sema.Type
+	ret    sema.Type
+	// typeParams are those of a generic fn, which each call binds anew.
+	typeParams []sema.TypeParam
+}
+
+// CompileError lists the syntax and type errors that stopped compilation.
+type CompileError struct {
+	Errors []error
+}
+
+func (e *CompileError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Compile parses, checks and compiles src. Syntax and type errors come as
+// a *CompileError; a function too big for the bytecode, such as one with
+// more than 256 locals, fails with an error of its own.
+func Compile(src string, opts CompileOptions) (*Program, error) {
+	p := parser.New(lexer.New(src))
+	ast := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &CompileError{Errors: p.Errors()}
+	}
+
+	checker := sema.New()
+	comp := compilation.NewCompiler()
+	comp.SetInlining(!opts.DisableInlining)
+	for _, n := range opts.Natives {
+		if n.Fn == nil {
+			return nil, fmt.Errorf("native %q has no function", n.Name)
+		}
+		params := make([]sema.Type, len(n.Params))
+		kinds := make([]bytecode.TypeKind, len(n.Params))
+		for i, t := range n.Params {
+			params[i], kinds[i] = t.sema(), t.typeKind()
+		}
+		if err := checker.DeclareNative(n.Name, params, n.Result.sema()); err != nil {
+			return nil, err
+		}
+		comp.DeclareNative(&bytecode.Native{
+			Name:       n.Name,
+			ParamTypes: kinds,
+			ReturnType: n.Result.typeKind(),
+			Fn:         n.Fn.wrap(),
+		})
+	}
+
+	checker.Check(ast)
+	if len(checker.Errors()) != 0 {
+		return nil, &CompileError{Errors: checker.Errors()}
+	}
+
+	optimize.NewFolder().Fold(ast)
+
+	mod, err := comp.CompileProgram(ast)
+	if err != nil {
+		return nil, fmt.Errorf("compile: %w", err)
+	}
+	sigs := make(map[string]signature, len(mod.Functions))
+	for name := range mod.Functions {
+		params, ret, _ := checker.FuncType(name)
+		sigs[name] = signature{params: params, ret: ret, typeParams: checker.TypeParams(name)}
+	}
+	warnings := make([]error, len(checker.Warnings()))
+	for i, w := range checker.Warnings() {
+		warnings[i] = w
+	}
+	return &Program{mod: mod, sigs: sigs, warnings: warnings}, nil
+}
+
+// Warnings lists what the checker found suspect in the program without
+// stopping its compilation, such as code that never runs.
+func (p *Program) Warnings() []error { return p.warnings }
+
+// optimized returns the JIT-optimized copy of the program, made on first
+// use and shared by every VM that asks for it.
+func (p *Program) optimized() *bytecode.Module {
+	p.jitOnce.Do(func() { p.jitMod = runtime.OptimizeModule(p.mod) })
+	return p.jitMod
+}
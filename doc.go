@@ -0,0 +1,45 @@
+// Package lang compiles and runs programs written in lang from Go.
+//
+//	prog, err := lang.Compile(src, lang.CompileOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	vm := lang.NewVM(prog, lang.VMOptions{Stdout: &out})
+//	result, err := vm.Call("fact", lang.Int(10))
+//
+// Go functions are made callable from scripts by listing them as natives
+// when compiling:
+//
+//	lang.Compile(src, lang.CompileOptions{Natives: []lang.Native{{
+//		Name:   "clamp",
+//		Params: []lang.Type{lang.IntType, lang.IntType, lang.IntType},
+//		Result: lang.IntType,
+//		Fn: func(args []lang.Value) (lang.Value, error) { ... },
+//	}}})
+//
+// Vet reports code that compiles but is likely a mistake, such as unused
+// variables or comparisons whose result is always the same. TypeAt gives
+// the type of the variable at a position, including the type a let infers
+// when it has no annotation, for an editor to show on hover.
+//
+// # Compatibility
+//
+// This package is the only supported way to use the language from Go; the
+// packages under internal/ change whenever the implementation does. The
+// module follows semantic versioning, and within a major version:
+//
+//   - exported identifiers of this package are not removed or renamed and
+//     their signatures only change in ways that keep existing callers
+//     compiling, such as new fields in option structs;
+//   - the zero value of each option struct keeps meaning the defaults;
+//   - errors matched with errors.Is or errors.As keep matching;
+//   - programs that compile keep compiling and keep producing the same
+//     results and output, except where that fixes a bug;
+//   - the heap snapshot JSON only gains fields until its version changes.
+//
+// Stats, HeapCounts, Snapshot, TypeGrowth, RuntimeError, Diagnostic,
+// Severity, Analyzer and Pass are aliases of types in internal packages
+// and carry the same guarantees as types declared here, except for the
+// syntax tree and types a Pass holds. Compiled bytecode, error message
+// wording and performance are not covered.
+package lang
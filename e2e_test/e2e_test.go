@@ -1,14 +1,21 @@
 package e2e_test
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/dunooo0ooo/lang/internal/ast"
 	"github.com/dunooo0ooo/lang/internal/bytecode"
 	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/optimize"
 	"github.com/dunooo0ooo/lang/internal/parser"
 	"github.com/dunooo0ooo/lang/internal/runtime"
 	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
+	"github.com/dunooo0ooo/lang/internal/runtime/compilation/jit_optimization"
 	"github.com/dunooo0ooo/lang/internal/sema"
 )
 
@@ -158,6 +165,816 @@ fn main() -> int {
 	}
 }
 
+func TestE2E_PeepholeMatchesInterpreter(t *testing.T) {
+	src := `
+fn bubbleSort(arr: []int, n: int) -> void {
+    let pass: int = 0;
+    while pass < n {
+        let j: int = 0;
+        while j < n - 1 {
+            if get(arr, j) > get(arr, j + 1) {
+                let tmp: int = get(arr, j);
+                set(arr, j, get(arr, j + 1));
+                set(arr, j + 1, tmp);
+            }
+            j = j + 1;
+        }
+        pass = pass + 1;
+    }
+    return;
+}
+
+fn main() -> int {
+    let n: int = 50;
+    let arr: []int = array(n);
+    let i: int = 0;
+    while i < n {
+        set(arr, i, n - i + 0);
+        i = i + 1;
+    }
+    bubbleSort(arr, n);
+
+    let done: bool = false;
+    let k: int = 0;
+    while !done {
+        k = k + 1;
+        done = k >= 3;
+    }
+
+    let x: int = -(-get(arr, 0));
+    return x * 100 + k * 10 + get(arr, n - 1) - n;
+}
+`
+	var results []bytecode.Value
+	for _, jit := range []bool{false, true} {
+		prog := mustParse(t, src)
+		mustSema(t, prog)
+
+		mod, err := compilation.NewCompiler().CompileProgram(prog)
+		if err != nil {
+			t.Fatalf("compile error: %v", err)
+		}
+
+		ret, err := runtime.NewVM(mod, jit).Call("main", nil)
+		if err != nil {
+			t.Fatalf("vm call error (jit=%v): %v", jit, err)
+		}
+		results = append(results, ret)
+	}
+
+	if results[0].Kind != bytecode.ValInt || results[0].I != 130 {
+		t.Fatalf("unexpected result: %#v, want int 130", results[0])
+	}
+	if results[1] != results[0] {
+		t.Fatalf("jit result %#v differs from interpreter %#v", results[1], results[0])
+	}
+}
+
+func TestE2E_ConstantFolding(t *testing.T) {
+	src := `
+fn main() -> int {
+    let n: int = 6;
+    let x: int = if n * 2 > 10 { n + 1 } else { n - 1 };
+    if false { return 0; }
+    return x;
+}
+
+fn divZero() -> int {
+    let z: int = 0;
+    return 1 / z;
+}
+`
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+	optimize.NewFolder().Fold(prog)
+
+	comp := compilation.NewCompiler()
+	mod, err := comp.CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	vm := runtime.NewVM(mod, false)
+	ret, err := vm.Call("main", nil)
+	if err != nil {
+		t.Fatalf("vm call error: %v", err)
+	}
+	if ret.Kind != bytecode.ValInt || ret.I != 7 {
+		t.Fatalf("unexpected result: %#v, want int 7", ret)
+	}
+
+	if _, err := vm.Call("divZero", nil); err == nil {
+		t.Fatal("expected division by zero error")
+	}
+}
+
+func TestE2E_InliningKeepsResultsAndLines(t *testing.T) {
+	src := `
+fn sq(x: int) -> int { return x * x; }
+fn clamp(x: int, hi: int) -> int {
+    if x > hi { return hi; }
+    return x;
+}
+fn ratio(a: int, b: int) -> int {
+    return a / b;
+}
+fn fib(n: int) -> int {
+    if n < 2 { return n; }
+    return fib(n - 1) + fib(n - 2);
+}
+fn main() -> int {
+    let s: int = 0;
+    for let i: int = 0; i < 20; i = i + 1 {
+        s = s + clamp(sq(i), 100) + ratio(i, 3);
+    }
+    return s + fib(10);
+}
+fn fail() -> int {
+    let d: int = 0;
+    return ratio(1, d);
+}
+`
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+
+	for _, inline := range []bool{false, true} {
+		for _, jit := range []bool{false, true} {
+			comp := compilation.NewCompiler()
+			comp.SetInlining(inline)
+			mod, err := comp.CompileProgram(prog)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+
+			calls := 0
+			for _, in := range jit_optimization.DecodeAll(mod.Functions["main"].Chunk.Code) {
+				if in.OpCode == bytecode.OpCall {
+					calls++
+				}
+			}
+			if inline && calls != 1 {
+				t.Fatalf("inline: main has %d calls, want only fib", calls)
+			}
+
+			vm := runtime.NewVM(mod, jit)
+			ret, err := vm.Call("main", nil)
+			if err != nil {
+				t.Fatalf("inline=%v jit=%v: vm call error: %v", inline, jit, err)
+			}
+			if ret.Kind != bytecode.ValInt || ret.I != 1397 {
+				t.Fatalf("inline=%v jit=%v: unexpected result: %#v, want int 1397", inline, jit, ret)
+			}
+
+			_, err = vm.Call("fail", nil)
+			var rerr *runtime.RuntimeError
+			if !errors.As(err, &rerr) || rerr.Line != 8 {
+				t.Fatalf("inline=%v jit=%v: got error %v, want division by zero at line 8", inline, jit, err)
+			}
+		}
+	}
+}
+
+func TestE2E_InliningHidesCallerLocals(t *testing.T) {
+	src := `
+enum Color { Red, Green }
+fn red() -> Color { return Red; }
+fn main() -> int {
+    let Red = Green;
+    return match red() { Red => 1, Green => 2 };
+}
+`
+	if got := inlinedAndNot(t, src); got != [2]int64{1, 1} {
+		t.Fatalf("main = %d inlined, %d not inlined; want 1", got[0], got[1])
+	}
+}
+
+func TestE2E_InliningKeepsToLocalLimit(t *testing.T) {
+	var lets strings.Builder
+	for i := 0; i < 252; i++ {
+		lets.WriteString("    let v" + strconv.Itoa(i) + " = 0;\n")
+	}
+	src := `
+fn pick(a: int?, b: int?, c: int?) -> int { return a ?? (b ?? (c ?? 0)); }
+fn main() -> int {
+` + lets.String() + `    return pick(null, null, 8);
+}
+`
+	if got := inlinedAndNot(t, src); got != [2]int64{8, 8} {
+		t.Fatalf("main = %d inlined, %d not inlined; want 8", got[0], got[1])
+	}
+}
+
+// inlinedAndNot returns what main returns compiled with inlining and
+// without it.
+func inlinedAndNot(t *testing.T, src string) [2]int64 {
+	t.Helper()
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+
+	var out [2]int64
+	for i, inline := range []bool{true, false} {
+		comp := compilation.NewCompiler()
+		comp.SetInlining(inline)
+		mod, err := comp.CompileProgram(prog)
+		if err != nil {
+			t.Fatalf("inline=%v: compile error: %v", inline, err)
+		}
+		ret, err := runtime.NewVM(mod, false).Call("main", nil)
+		if err != nil {
+			t.Fatalf("inline=%v: vm call error: %v", inline, err)
+		}
+		out[i] = ret.I
+	}
+	return out
+}
+
+func TestE2E_BoundsChecksEliminatedWithoutJit(t *testing.T) {
+	src, err := os.ReadFile("../programs/perf/sort_10000.lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := mustCompile(t, string(src))
+
+	checked, unchecked := 0, 0
+	for _, in := range jit_optimization.DecodeAll(mod.Functions["main"].Chunk.Code) {
+		switch in.OpCode {
+		case bytecode.OpArrayGet, bytecode.OpArraySet:
+			checked++
+		case bytecode.OpArrayGetUnchecked, bytecode.OpArraySetUnchecked:
+			unchecked++
+		}
+	}
+	// bubbleSort is inlined into main, where arr is array(n) and every
+	// index stays below n.
+	if checked != 0 || unchecked == 0 {
+		t.Fatalf("main has %d checked and %d unchecked accesses, want only unchecked ones", checked, unchecked)
+	}
+}
+
+func TestE2E_StdioOptions(t *testing.T) {
+	src := `
+fn main() -> int {
+    let name: string = read_line();
+    let n: int = read_int();
+    let s: int = 0;
+    for let i: int = 0; i < n; i = i + 1 {
+        s = s + read_int();
+    }
+    print(name);
+    println(s);
+    eprintln(n);
+    return s;
+}
+fn bad() -> int {
+    return read_int();
+}
+`
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+
+	for _, jit := range []bool{false, true} {
+		mod, err := compilation.NewCompiler().CompileProgram(prog)
+		if err != nil {
+			t.Fatalf("compile error: %v", err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		vm := runtime.NewVM(mod, jit,
+			runtime.Stdin(strings.NewReader("ada\r\n3\n10 20\n  12\nx")),
+			runtime.Stdout(&stdout),
+			runtime.Stderr(&stderr))
+		ret, err := vm.Call("main", nil)
+		if err != nil {
+			t.Fatalf("jit=%v: vm call error: %v", jit, err)
+		}
+		if ret.Kind != bytecode.ValInt || ret.I != 42 {
+			t.Fatalf("jit=%v: unexpected result: %#v, want int 42", jit, ret)
+		}
+		if got := stdout.String(); got != "ada 42\n" {
+			t.Fatalf("jit=%v: stdout = %q", jit, got)
+		}
+		if got := stderr.String(); got != "3\n" {
+			t.Fatalf("jit=%v: stderr = %q", jit, got)
+		}
+
+		_, err = vm.Call("bad", nil)
+		var rerr *runtime.RuntimeError
+		if !errors.As(err, &rerr) || rerr.Line != 15 || !strings.Contains(err.Error(), `invalid int "x"`) {
+			t.Fatalf("jit=%v: got error %v, want invalid int at line 15", jit, err)
+		}
+	}
+}
+
+func TestE2E_ReadLineFailsAtEndOfInput(t *testing.T) {
+	src := `
+fn main() -> int {
+    let n = 0;
+    try {
+        while true {
+            read_line();
+            n = n + 1;
+        }
+    } catch e { print(e); }
+    return n;
+}
+`
+	mod := mustCompile(t, src)
+
+	for _, tc := range []struct {
+		stdin string
+		lines int64
+	}{{"", 0}, {"\n", 1}, {"a\n\nb", 3}} {
+		for _, jit := range []bool{false, true} {
+			var out bytes.Buffer
+			vm := runtime.NewVM(mod, jit, runtime.Stdin(strings.NewReader(tc.stdin)), runtime.Stdout(&out))
+			ret, err := vm.Call("main", nil)
+			if err != nil || ret.I != tc.lines {
+				t.Fatalf("jit=%v stdin=%q: main = %v, %v, want %d", jit, tc.stdin, ret, err, tc.lines)
+			}
+			if got := out.String(); got != "Error(read_line: unexpected end of input, 6) " {
+				t.Fatalf("jit=%v stdin=%q: stdout = %q", jit, tc.stdin, got)
+			}
+		}
+	}
+}
+
+func TestE2E_JitLeavesModuleUntouched(t *testing.T) {
+	src := `
+fn main() -> int {
+    let a: []int = [5, 3, 1, 4];
+    let s: int = 0;
+    for let i: int = 0; i < 4; i = i + 1 { s = s + a[i] * 2; }
+    return s;
+}
+`
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+	mod, err := compilation.NewCompiler().CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	before := append([]byte(nil), mod.Functions["main"].Chunk.Code...)
+
+	opt := runtime.OptimizeModule(mod)
+	if !bytes.Equal(mod.Functions["main"].Chunk.Code, before) {
+		t.Fatal("OptimizeModule changed its input")
+	}
+	if bytes.Equal(opt.Functions["main"].Chunk.Code, before) {
+		t.Fatal("OptimizeModule changed nothing")
+	}
+
+	for _, jit := range []bool{true, false} {
+		ret, err := runtime.NewVM(mod, jit).Call("main", nil)
+		if err != nil || ret.I != 26 {
+			t.Fatalf("jit=%v: got %v, %v, want 26", jit, ret, err)
+		}
+		if !bytes.Equal(mod.Functions["main"].Chunk.Code, before) {
+			t.Fatalf("jit=%v: NewVM changed the module", jit)
+		}
+	}
+}
+
+func TestE2E_FloatConversion(t *testing.T) {
+	src := `
+fn mean(xs: []int) -> float {
+    let s = 0;
+    for let i = 0; i < xs.len(); i = i + 1 { s = s + xs[i]; }
+    return float(s) / float(xs.len());
+}
+fn main() -> int {
+    print(mean([1, 2, 4]) * 3.0);
+    print(float(-7) / 2.0);
+    return 0;
+}
+`
+	if ret, out := runMain(t, src); ret != 0 || out != "7 -3.5 " {
+		t.Fatalf("main = %d, stdout %q; want 0, %q", ret, out, "7 -3.5 ")
+	}
+}
+
+func TestE2E_Generators(t *testing.T) {
+	src := `
+gen fn squares(n: int) -> int {
+    for let i: int = 0; i < n; i = i + 1 {
+        yield i * i;
+    }
+}
+gen fn evens(src: iter<int>) -> int {
+    for x in src {
+        if x % 2 == 0 { yield x; }
+    }
+}
+fn main() -> int {
+    let s: int = 0;
+    for x in evens(squares(10)) {
+        print(x);
+        s = s + x;
+    }
+    let it: iter<int> = squares(3);
+    s = s + next(it) + next(it) + next(it);
+    for x in it { s = s + 1000; }
+    return s;
+}
+fn overrun() -> int {
+    let it: iter<int> = squares(1);
+    return next(it) + next(it);
+}
+`
+	if ret, out := runMain(t, src); ret != 125 || out != "0 4 16 36 64 " {
+		t.Fatalf("main = %d, stdout %q; want 125, %q", ret, out, "0 4 16 36 64 ")
+	}
+	if err := runFails(t, src, "overrun"); err.Line != 25 || !strings.Contains(err.Error(), "generator has finished") {
+		t.Fatalf("got error %v, want finished generator at line 25", err)
+	}
+}
+
+func TestE2E_TasksAndChannels(t *testing.T) {
+	src := `
+fn produce(c: chan<int>, n: int) {
+    for let i: int = 1; i <= n; i = i + 1 {
+        send(c, i);
+    }
+    close(c);
+}
+fn square(src: chan<int>, out: chan<int>) {
+    for x in src {
+        send(out, x * x);
+    }
+}
+fn main() -> int {
+    let nums: chan<int> = chan<int>();
+    let squares: chan<int> = chan<int>(100);
+    spawn produce(nums, 10);
+    spawn square(nums, squares);
+    spawn square(nums, squares);
+    wait();
+    close(squares);
+    let s: int = 0;
+    for x in squares { s = s + x; }
+    return s;
+}
+fn late_send() -> int {
+    let c: chan<int> = chan<int>();
+    close(c);
+    send(c, 1);
+    return 0;
+}
+`
+	for seed := int64(0); seed < 4; seed++ {
+		if ret, _ := runMain(t, src, runtime.SchedulerSeed(seed), runtime.TimeSlice(7)); ret != 385 {
+			t.Fatalf("seed=%d: main = %d, want 385", seed, ret)
+		}
+	}
+	if err := runFails(t, src, "late_send"); err.Line != 28 || !strings.Contains(err.Error(), "channel is closed") {
+		t.Fatalf("got error %v, want closed channel at line 28", err)
+	}
+}
+
+func TestE2E_NullSafety(t *testing.T) {
+	src := `
+fn find(xs: []int, want: int) -> []int? {
+    for let i: int = 0; i < 3; i = i + 1 {
+        if xs[i] == want { return [i]; }
+    }
+    return null;
+}
+fn first(xs: []int?) -> int {
+    if xs == null { return -1; }
+    return xs[0];
+}
+fn main() -> int {
+    let xs: []int = [4, 5, 6];
+    let hit: []int? = find(xs, 6);
+    let miss: []int? = find(xs, 9);
+    let a: int = hit?.[0] ?? 100;
+    let b: int = miss?.[0] ?? 100;
+    let name: string? = null;
+    print(name ?? "anon");
+    let c: int = 0;
+    if hit != null && miss == null { c = hit[0]; }
+    return a + b + c * 1000 + first(miss) + first(hit) * 10000;
+}
+`
+	if ret, out := runMain(t, src); ret != 22101 || out != "anon " {
+		t.Fatalf("main = %d, stdout %q; want 22101, %q", ret, out, "anon ")
+	}
+}
+
+func TestE2E_EnumsAndMatch(t *testing.T) {
+	src := `
+enum Shape { Circle(int), Rect(int, int), Empty }
+enum List { Cons(int, List), Nil }
+fn area(s: Shape) -> int {
+    return match s {
+        Circle(r) => 3 * r * r,
+        Rect(w, h) if w == h => w * w + 1000,
+        Rect(w, h) => w * h,
+        Empty => 0,
+    };
+}
+fn sum(l: List) -> int {
+    return match l { Cons(x, rest) => x + sum(rest), Nil => 0 };
+}
+fn sign(n: int?) -> string {
+    return match n { null => "?", 0 => "0", -1 => "-", _ => "+" };
+}
+fn main() -> int {
+    let shapes: []Shape = [Circle(2), Rect(2, 3), Rect(4, 4), Empty];
+    let total: int = 0;
+    for let i: int = 0; i < 4; i = i + 1 {
+        total = total + area(shapes[i]);
+    }
+    print(shapes[1]);
+    print(sign(null));
+    print(sign(-1));
+    print(sign(7));
+    let x: int = 5;
+    match Cons(1, Nil) {
+        Cons(_, Nil) => { x = 6; }
+        _ => {}
+    }
+    return total * 100 + sum(Cons(1, Cons(2, Cons(3, Nil)))) * 10 + x;
+}
+`
+	if ret, out := runMain(t, src); ret != 103466 || out != "Rect(2, 3) ? - + " {
+		t.Fatalf("main = %d, stdout %q; want 103466, %q", ret, out, "Rect(2, 3) ? - + ")
+	}
+}
+
+func TestE2E_ResultsPropagate(t *testing.T) {
+	src := `
+fn half(n: int) -> Result<int, string> {
+    if n % 2 != 0 { return err("odd"); }
+    return ok(n / 2);
+}
+fn quarter(s: string) -> Result<int, string> {
+    let n: int = parse_int(s)?;
+    return ok(1000 + half(half(n)?)?);
+}
+fn value(r: Result<int, string>) -> int {
+    return match r { ok(v) => v, err(e) => { print(e); 0 } };
+}
+fn main() -> int {
+    print(quarter("x"));
+    return value(quarter("8")) + value(quarter("6")) + value(quarter(" 12 ")) * 10000;
+}
+`
+	if ret, out := runMain(t, src); ret != 10031002 || out != `err(cannot parse "x" as int) odd ` {
+		t.Fatalf("main = %d, stdout %q; want 10031002, %q", ret, out, `err(cannot parse "x" as int) odd `)
+	}
+}
+
+func TestE2E_TryCatch(t *testing.T) {
+	src := `
+fn div(a: int, b: int) -> int {
+    return a / b;
+}
+fn check(n: int) -> int {
+    if n < 0 { panic("negative"); }
+    return n;
+}
+gen fn count(n: int) -> int {
+    for let i: int = 0; i < n; i = i + 1 {
+        yield 10 / (n - 1 - i);
+    }
+}
+fn message(e: Error) -> string {
+    return match e { Error(msg, _) => msg };
+}
+fn main() -> int {
+    let total: int = 0;
+    try { total = div(1, 0); } catch e { print(e); }
+    try { check(-1); } catch e { print(message(e)); }
+    total = total + 1 + {
+        try { total = div(6, 0); } catch e { total = 100; }
+        2
+    };
+    try {
+        for v in count(3) { print(v); }
+    } catch e { print(e); }
+    try {
+        try { panic("inner"); } catch e { panic(message(e)); }
+    } catch e { print(e); }
+    for let i: int = 0; i < 3; i = i + 1 {
+        try { total = total + div(10, 1 - i); } catch e { total = total + 1000; }
+    }
+    return total;
+}
+`
+	if ret, out := runMain(t, src); ret != 1003 || out != "Error(division by zero, 3) negative 5 10 Error(division by zero, 11) Error(inner, 29) " {
+		t.Fatalf("main = %d, stdout %q; want 1003, %q", ret, out, "Error(division by zero, 3) negative 5 10 Error(division by zero, 11) Error(inner, 29) ")
+	}
+}
+
+func TestE2E_Generics(t *testing.T) {
+	src := `
+enum Option<T> { Some(T), None }
+fn max<T: Ord>(a: T, b: T) -> T {
+    if a > b { return a; }
+    return b;
+}
+fn sum<N: Num>(xs: []N, n: int, zero: N) -> N {
+    let s: N = zero;
+    for let i: int = 0; i < n; i = i + 1 { s = s + xs[i]; }
+    return s;
+}
+fn or_else<T>(o: Option<T>, d: T) -> T {
+    return match o { Some(x) => x, None => d };
+}
+fn index<T: Eq>(xs: []T, n: int, x: T) -> Option<int> {
+    for let i: int = 0; i < n; i = i + 1 {
+        if xs[i] == x { return Some(i); }
+    }
+    return None;
+}
+gen fn repeat<T>(x: T, n: int) -> T {
+    for let i: int = 0; i < n; i = i + 1 { yield x; }
+}
+fn main() -> int {
+    print(max(3, 7));
+    print(max(2.5, 1.5));
+    print(max('a', 'z'));
+    print(sum([1.5, 2.0, 0.5], 3, 0.0));
+    print(or_else(index(["a", "b", "c"], 3, "c"), -1));
+    print(or_else(index([true], 1, false), -1));
+    for s in repeat("ab", 2) { print(s); }
+    return sum([1, 2, 3], 3, 0);
+}
+`
+	if ret, out := runMain(t, src); ret != 6 || out != "7 2.5 z 4 2 -1 ab ab " {
+		t.Fatalf("main = %d, stdout %q; want 6, %q", ret, out, "7 2.5 z 4 2 -1 ab ab ")
+	}
+}
+
+func TestE2E_GenericSet(t *testing.T) {
+	src := `
+fn swap<T>(xs: []T, i: int, j: int) {
+    let x = xs[i];
+    set(xs, i, xs[j]);
+    set(xs, j, x);
+}
+fn main() -> int {
+    let names = ["a", "b", "c"];
+    swap(names, 0, 2);
+    set(names, 1, "B");
+    print(names[0]);
+    print(names[1]);
+    print(names[2]);
+    let ns = [1, 2];
+    swap(ns, 0, 1);
+    return ns[0];
+}
+`
+	if ret, out := runMain(t, src); ret != 2 || out != "c B a " {
+		t.Fatalf("main = %d, stdout %q; want 2, %q", ret, out, "c B a ")
+	}
+}
+
+func TestE2E_Traits(t *testing.T) {
+	src := `
+trait Shape {
+    fn area(self) -> float;
+    fn scaled(self, k: float) -> Shape;
+}
+trait Twice { fn twice(self) -> int; }
+enum Circle { C(float) }
+enum Square { S(float) }
+impl Shape for Circle {
+    fn area(self) -> float { return match self { C(r) => 3.0 * r * r }; }
+    fn scaled(self, k: float) -> Shape { return match self { C(r) => C(r * k) }; }
+}
+impl Shape for Square {
+    fn area(self) -> float { return match self { S(s) => s * s }; }
+    fn scaled(self, k: float) -> Shape { return match self { S(s) => S(s * k) }; }
+}
+impl Twice for int {
+    fn twice(self) -> int { return self * 2; }
+}
+fn total(shapes: []Shape) -> float {
+    let t: float = 0.0;
+    for let i: int = 0; i < shapes.len(); i = i + 1 { t = t + shapes[i].area(); }
+    return t;
+}
+fn main() -> int {
+    let shapes: []Shape = [C(1.0), S(2.0)];
+    print(total(shapes));
+    let big: Shape = shapes[1].scaled(2.0);
+    print(big.area());
+    print("abc".len());
+    return (21).twice();
+}
+`
+	if ret, out := runMain(t, src); ret != 42 || out != "7 16 3 " {
+		t.Fatalf("main = %d, stdout %q; want 42, %q", ret, out, "7 16 3 ")
+	}
+}
+
+func TestE2E_LetInference(t *testing.T) {
+	src := `
+enum Option<T> { Some(T), None }
+fn make(n: int) -> Option<int> { return Some(n); }
+fn main() -> int {
+    let n = 4;
+    let arr = array(n);
+    let f = 1.5;
+    let s = "ab";
+    let xs: []int = [];
+    let o = make(n);
+    let m = if n > 10 { 1 } else { null };
+    for let i = 0; i < n; i = i + 1 { set(arr, i, i * i); }
+    print(f * 2.0);
+    print(s.len() + xs.len());
+    print(m ?? -1);
+    return match o { Some(v) => v + arr[3], None => 0 };
+}
+`
+	if ret, out := runMain(t, src); ret != 13 || out != "3 2 -1 " {
+		t.Fatalf("main = %d, stdout %q; want 13, %q", ret, out, "3 2 -1 ")
+	}
+}
+
+func TestE2E_Unicode(t *testing.T) {
+	src := `
+fn count(s: string, c: char) -> int {
+    let cs = s.chars();
+    let n = 0;
+    for let i = 0; i < cs.len(); i = i + 1 { if cs[i] == c { n = n + 1; } }
+    return n;
+}
+fn main() -> int {
+    let привет = "héllo, 世界";
+    let cs = привет.chars();
+    print(привет.len());
+    print(cs.len());
+    print(cs[7]);
+    print('界');
+    print('é' < 'z');
+    return count(привет, 'l');
+}
+`
+	if ret, out := runMain(t, src); ret != 2 || out != "14 9 世 界 false " {
+		t.Fatalf("main = %d, stdout %q; want 2, %q", ret, out, "14 9 世 界 false ")
+	}
+}
+
+// runMain folds and compiles src and calls main with the interpreter and
+// with the JIT, failing unless both return the same int and print the
+// same output, and returns them.
+func runMain(t *testing.T, src string, opts ...runtime.Option) (int64, string) {
+	t.Helper()
+	mod := mustCompile(t, src)
+
+	var rets [2]int64
+	var outs [2]string
+	for i, jit := range []bool{false, true} {
+		var out bytes.Buffer
+		vm := runtime.NewVM(mod, jit, append(opts, runtime.Stdout(&out))...)
+		ret, err := vm.Call("main", nil)
+		if err != nil {
+			t.Fatalf("jit=%v: vm call error: %v", jit, err)
+		}
+		rets[i], outs[i] = ret.I, out.String()
+	}
+	if rets[1] != rets[0] || outs[1] != outs[0] {
+		t.Fatalf("jit: main = %d, stdout %q; interpreter: %d, %q", rets[1], outs[1], rets[0], outs[0])
+	}
+	return rets[0], outs[0]
+}
+
+// runFails calls the named function of src like runMain, failing unless
+// both runs stop with the same runtime error, and returns it.
+func runFails(t *testing.T, src, name string) *runtime.RuntimeError {
+	t.Helper()
+	mod := mustCompile(t, src)
+
+	var errs [2]*runtime.RuntimeError
+	for i, jit := range []bool{false, true} {
+		_, err := runtime.NewVM(mod, jit).Call(name, nil)
+		if !errors.As(err, &errs[i]) {
+			t.Fatalf("jit=%v: %s: got error %v, want a runtime error", jit, name, err)
+		}
+	}
+	if errs[1].Error() != errs[0].Error() || errs[1].Line != errs[0].Line {
+		t.Fatalf("jit: %s fails with %v at line %d; interpreter: %v at line %d",
+			name, errs[1], errs[1].Line, errs[0], errs[0].Line)
+	}
+	return errs[0]
+}
+
+// mustCompile parses, checks, folds and compiles src.
+func mustCompile(t *testing.T, src string) *bytecode.Module {
+	t.Helper()
+	prog := mustParse(t, src)
+	mustSema(t, prog)
+	optimize.NewFolder().Fold(prog)
+
+	mod, err := compilation.NewCompiler().CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	return mod
+}
+
 func mustParse(t *testing.T, src string) *ast.Program {
 	t.Helper()
 
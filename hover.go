@@ -0,0 +1,52 @@
+package lang
+
+import (
+	"unicode/utf8"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/sema"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// TypeAt returns the type of the variable named at line and col of src,
+// counted from 1 like the position of a Diagnostic, for an editor to show
+// on hover. The name may be where a let declares it, which gives the type
+// the let infers when it has no annotation, or where it is read. ok is
+// false when no variable is there. The natives of opts are declared as
+// they are by Compile; if src does not compile, TypeAt returns a
+// *CompileError.
+func TypeAt(src string, line, col int, opts CompileOptions) (typ string, ok bool, err error) {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", false, &CompileError{Errors: p.Errors()}
+	}
+	c := sema.New()
+	if err := declareNatives(c, opts.Natives); err != nil {
+		return "", false, err
+	}
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		return "", false, &CompileError{Errors: c.Errors()}
+	}
+	r := sema.NewResolver(c.ExprType)
+	r.Resolve(prog)
+	res := r.Result()
+
+	covers := func(pos token.Position, name string) bool {
+		return pos.Line == line && col >= pos.Col && col < pos.Col+utf8.RuneCountInString(name)
+	}
+	for s, v := range res.Let {
+		if covers(s.NamePos, s.Name) {
+			return v.Ty.String(), true, nil
+		}
+	}
+	for e, v := range res.Vars {
+		if vr, isRef := e.(*ast.VarRef); isRef && covers(vr.NamePos, vr.Name) {
+			return v.Ty.String(), true, nil
+		}
+	}
+	return "", false, nil
+}
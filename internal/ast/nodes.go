@@ -23,15 +23,92 @@ func (i *StmtItem) Pos() token.Position { return i.S.Pos() }
 func (i *StmtItem) isItem()             {}
 
 type FnDecl struct {
-	FnPos   token.Position
+	FnPos      token.Position
+	Name       string
+	TypeParams []TypeParam
+	Params     []Param
+	RetType    *TypeRef
+	Body       *BlockStmt
+	// IsGen marks a gen fn, whose RetType is the type of the values it
+	// yields.
+	IsGen bool
+}
+
+func (d *FnDecl) Pos() token.Position { return d.FnPos }
+func (d *FnDecl) isItem()             {}
+
+// EnumDecl declares a sum type: a value of it is one of the variants,
+// carrying values of that variant's Fields types.
+type EnumDecl struct {
+	EnumPos    token.Position
+	Name       string
+	TypeParams []TypeParam
+	Variants   []Variant
+}
+
+func (d *EnumDecl) Pos() token.Position { return d.EnumPos }
+func (d *EnumDecl) isItem()             {}
+
+// TraitDecl declares the methods the types implementing a trait have.
+type TraitDecl struct {
+	TraitPos token.Position
+	Name     string
+	Methods  []TraitMethod
+}
+
+func (d *TraitDecl) Pos() token.Position { return d.TraitPos }
+func (d *TraitDecl) isItem()             {}
+
+// TraitMethod is the signature of a trait method. Params leave out its
+// self parameter.
+type TraitMethod struct {
 	Name    string
 	Params  []Param
 	RetType *TypeRef
-	Body    *BlockStmt
+	Pos     token.Position
 }
 
-func (d *FnDecl) Pos() token.Position { return d.FnPos }
-func (d *FnDecl) isItem()             {}
+// ImplDecl implements the trait Trait for the type For. The first
+// parameter of each of its methods is self, of type For.
+type ImplDecl struct {
+	ImplPos  token.Position
+	Trait    string
+	TraitPos token.Position
+	For      TypeRef
+	Methods  []*FnDecl
+}
+
+func (d *ImplDecl) Pos() token.Position { return d.ImplPos }
+func (d *ImplDecl) isItem()             {}
+
+// Fns returns the methods of d as functions named by MethodName.
+func (d *ImplDecl) Fns() []*FnDecl {
+	out := make([]*FnDecl, len(d.Methods))
+	for i, m := range d.Methods {
+		fn := *m
+		fn.Name = MethodName(d.For.Name, m.Name)
+		out[i] = &fn
+	}
+	return out
+}
+
+// MethodName names the function implementing method for the type named
+// typ. It is not an identifier, so programs cannot call it directly.
+func MethodName(typ, method string) string { return typ + "." + method }
+
+type Variant struct {
+	Name   string
+	Fields []TypeRef
+	Pos    token.Position
+}
+
+// TypeParam is the T of a generic fn<T: Bound> or enum<T>. Bound names the
+// operations its values need and is empty when there is none.
+type TypeParam struct {
+	Name  string
+	Bound string
+	Pos   token.Position
+}
 
 type Param struct {
 	Name string
@@ -43,6 +120,12 @@ type TypeRef struct {
 	Name string
 	Elem *TypeRef
 	Pos  token.Position
+	// Nullable marks a T? type, which also holds null.
+	Nullable bool
+	// Err is the error type of a Result, whose Elem is its value type.
+	Err *TypeRef
+	// Args are the type arguments of a generic enum type.
+	Args []TypeRef
 }
 
 type Stmt interface {
@@ -60,10 +143,14 @@ func (s *BlockStmt) Pos() token.Position { return s.Lbrace }
 func (s *BlockStmt) isStmt()             {}
 
 type LetStmt struct {
-	LetPos token.Position
-	Name   string
-	Type   *TypeRef
-	Init   Expr
+	LetPos  token.Position
+	NamePos token.Position
+	Name    string
+	Type    *TypeRef
+	Init    Expr
+	// Inferred is the type the checker gives a let without a Type, from
+	// the type of its Init.
+	Inferred *TypeRef
 }
 
 func (s *LetStmt) Pos() token.Position { return s.LetPos }
@@ -116,6 +203,47 @@ type ForStmt struct {
 func (s *ForStmt) Pos() token.Position { return s.ForPos }
 func (s *ForStmt) isStmt()             {}
 
+type ForInStmt struct {
+	ForPos token.Position
+	Var    string
+	VarPos token.Position
+	Iter   Expr
+	Body   *BlockStmt
+}
+
+func (s *ForInStmt) Pos() token.Position { return s.ForPos }
+func (s *ForInStmt) isStmt()             {}
+
+type YieldStmt struct {
+	YieldPos token.Position
+	Value    Expr
+}
+
+func (s *YieldStmt) Pos() token.Position { return s.YieldPos }
+func (s *YieldStmt) isStmt()             {}
+
+// SpawnStmt starts Call as a new task.
+type SpawnStmt struct {
+	SpawnPos token.Position
+	Call     *CallExpr
+}
+
+func (s *SpawnStmt) Pos() token.Position { return s.SpawnPos }
+func (s *SpawnStmt) isStmt()             {}
+
+// TryStmt runs Body and, if it raises a runtime error, Catch with the
+// error bound to Var.
+type TryStmt struct {
+	TryPos token.Position
+	Body   *BlockStmt
+	Var    string
+	VarPos token.Position
+	Catch  *BlockStmt
+}
+
+func (s *TryStmt) Pos() token.Position { return s.TryPos }
+func (s *TryStmt) isStmt()             {}
+
 type ExprStmt struct {
 	ExprPos token.Position
 	X       Expr
@@ -182,6 +310,18 @@ type CallExpr struct {
 func (e *CallExpr) Pos() token.Position { return e.Lparen }
 func (e *CallExpr) isExpr()             {}
 
+// MethodCallExpr is x.name(args), calling the method of the type of x.
+type MethodCallExpr struct {
+	Dot     token.Position
+	X       Expr
+	Name    string
+	NamePos token.Position
+	Args    []Expr
+}
+
+func (e *MethodCallExpr) Pos() token.Position { return e.Dot }
+func (e *MethodCallExpr) isExpr()             {}
+
 type IfExpr struct {
 	IfPos token.Position
 	Cond  Expr
@@ -239,11 +379,92 @@ type ArrayLit struct {
 func (e *ArrayLit) Pos() token.Position { return e.Lbrack }
 func (e *ArrayLit) isExpr()             {}
 
+// ChanExpr makes a channel of Elem values buffering up to Cap of them;
+// a nil Cap makes an unbuffered one.
+type ChanExpr struct {
+	ChanPos token.Position
+	Elem    *TypeRef
+	Cap     Expr
+}
+
+func (e *ChanExpr) Pos() token.Position { return e.ChanPos }
+func (e *ChanExpr) isExpr()             {}
+
+// IndexExpr is x[i], or x?.[i] when Safe, which is null when x is.
 type IndexExpr struct {
 	Lbrack token.Position
 	X      Expr
 	Index  Expr
+	Safe   bool
 }
 
 func (e *IndexExpr) Pos() token.Position { return e.Lbrack }
 func (e *IndexExpr) isExpr()             {}
+
+// PropagateExpr is x?: the value of an ok Result x, or a return of x
+// from the enclosing function if it is an err.
+type PropagateExpr struct {
+	X    Expr
+	QPos token.Position
+}
+
+func (e *PropagateExpr) Pos() token.Position { return e.QPos }
+func (e *PropagateExpr) isExpr()             {}
+
+// MatchExpr evaluates the Body of the first arm whose pattern matches X
+// and whose Guard, if any, holds.
+type MatchExpr struct {
+	MatchPos token.Position
+	X        Expr
+	Arms     []MatchArm
+}
+
+func (e *MatchExpr) Pos() token.Position { return e.MatchPos }
+func (e *MatchExpr) isExpr()             {}
+
+type MatchArm struct {
+	Pat   Pattern
+	Guard Expr
+	Body  Expr
+}
+
+type Pattern interface {
+	Node
+	isPattern()
+}
+
+// WildcardPat is _, matching anything.
+type WildcardPat struct {
+	Pos0 token.Position
+}
+
+func (p *WildcardPat) Pos() token.Position { return p.Pos0 }
+func (p *WildcardPat) isPattern()          {}
+
+// NamePat is a bare name: the variant of that name if there is one,
+// otherwise a binding of the matched value.
+type NamePat struct {
+	NamePos token.Position
+	Name    string
+}
+
+func (p *NamePat) Pos() token.Position { return p.NamePos }
+func (p *NamePat) isPattern()          {}
+
+// VariantPat matches a variant and its values against Args.
+type VariantPat struct {
+	NamePos token.Position
+	Name    string
+	Args    []Pattern
+}
+
+func (p *VariantPat) Pos() token.Position { return p.NamePos }
+func (p *VariantPat) isPattern()          {}
+
+// LitPat matches values equal to a literal.
+type LitPat struct {
+	Value Expr
+}
+
+func (p *LitPat) Pos() token.Position { return p.Value.Pos() }
+func (p *LitPat) isPattern()          {}
@@ -1,10 +1,23 @@
 package bytecode
 
-import "io"
+import (
+	"io"
+	"sort"
+)
 
 type Chunk struct {
 	Code      []byte
 	Constants []Value
+	Lines     []LineStart
+	// Handlers are the try blocks of the code, inner ones first.
+	Handlers []Handler
+}
+
+// LineStart records that the code from PC up to the next entry was
+// compiled from source line Line.
+type LineStart struct {
+	PC   int
+	Line int
 }
 
 func (c *Chunk) Write(op OpCode) {
@@ -34,6 +47,56 @@ func (c *Chunk) AddConstant(v Value) int {
 	return len(c.Constants) - 1
 }
 
+// MarkLine attributes the code written from now on to source line line.
+func (c *Chunk) MarkLine(line int) {
+	if line <= 0 {
+		return
+	}
+	pc := len(c.Code)
+	if n := len(c.Lines); n > 0 {
+		last := &c.Lines[n-1]
+		if last.Line == line {
+			return
+		}
+		if last.PC == pc {
+			c.Lines = c.Lines[:n-1]
+			if n > 1 && c.Lines[n-2].Line == line {
+				return
+			}
+		}
+	}
+	c.Lines = append(c.Lines, LineStart{PC: pc, Line: line})
+}
+
+// LineAt returns the source line of the instruction at pc, or 0 if unknown.
+func (c *Chunk) LineAt(pc int) int {
+	i := sort.Search(len(c.Lines), func(i int) bool { return c.Lines[i].PC > pc })
+	if i == 0 {
+		return 0
+	}
+	return c.Lines[i-1].Line
+}
+
+// Handler catches the errors raised by the code from Start up to End: the
+// operand stack is cut back to Depth values, the error is pushed and the
+// code continues at Target.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// HandlerAt returns the innermost handler covering pc, if any.
+func (c *Chunk) HandlerAt(pc int) *Handler {
+	for i := range c.Handlers {
+		if h := &c.Handlers[i]; h.Start <= pc && pc < h.End {
+			return h
+		}
+	}
+	return nil
+}
+
 // Дополнительные методы для удобства
 func (c *Chunk) WriteInstruction(op OpCode, args ...byte) {
 	c.Write(op)
@@ -51,4 +114,6 @@ func (c *Chunk) GetConstantCount() int {
 func (c *Chunk) Clear() {
 	c.Code = nil
 	c.Constants = nil
+	c.Lines = nil
+	c.Handlers = nil
 }
\ No newline at end of file
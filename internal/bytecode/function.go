@@ -7,6 +7,9 @@ type FunctionInfo struct {
 	ReturnType TypeKind
 	Chunk      Chunk
 	NumLocals  int
+	// IsGenerator marks a gen fn: calling it makes a generator that runs
+	// the body on demand.
+	IsGenerator bool
 }
 
 func CreateFunction(name string, paramCount int) *FunctionInfo {
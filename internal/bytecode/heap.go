@@ -1,14 +1,34 @@
 package bytecode
 
 type Object struct {
-	Mark  bool
-	Type  ObjectType
-	Next  *Object
-	Items []Value
+	Mark       bool
+	Old        bool
+	Remembered bool
+	Type       ObjectType
+	Size       int
+	Next       *Object
+	Items      []Value
+	// State is what the runtime keeps for objects other than arrays: the
+	// suspended frame of a generator, nil once it has finished, the state
+	// of a channel, whose buffered values are its Items, or the *Variant
+	// of a variant, whose values are its Items.
+	State any
 }
 
+// Heap keeps objects in two intrusive lists: the nursery (Young) holds
+// objects allocated since the last collection, Head the old generation.
 type Heap struct {
 	Head       *Object
+	Young      *Object
 	NumObjects int
+	// MaxObjects, when non-zero, also triggers a collection once that many
+	// objects are alive.
 	MaxObjects int
+
+	YoungBytes int
+	OldBytes   int
+	NextMajor  int
+
+	// Remembered lists old objects that may point into the nursery.
+	Remembered []*Object
 }
\ No newline at end of file
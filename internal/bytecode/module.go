@@ -3,13 +3,74 @@ package bytecode
 type Module struct {
 	Name      string
 	Functions map[string]*FunctionInfo
+	Natives   map[string]*Native
+	// Variants lists the variants of the program's enums; OpVariant and
+	// OpIsVariant refer to them by index.
+	Variants []*Variant
+	// VTables maps the name of each type with methods to the functions
+	// implementing them, by method name. OpInvoke looks methods up here
+	// by the type of the receiver: an enum's name, or int, float, bool,
+	// string or char.
+	VTables map[string]map[string]string
+}
+
+// Variant describes one variant of an enum. Objects of it keep it as their
+// State and their values as Items.
+type Variant struct {
+	Enum  string
+	Name  string
+	Arity int
+}
+
+// OkVariant and ErrVariant are the variants of every Result, and
+// ErrorVariant the one variant of the Error a catch receives: its message
+// and source line. They are listed first in the Variants of compiled
+// modules.
+var (
+	OkVariant    = &Variant{Enum: "Result", Name: "ok", Arity: 1}
+	ErrVariant   = &Variant{Enum: "Result", Name: "err", Arity: 1}
+	ErrorVariant = &Variant{Enum: "Error", Name: "Error", Arity: 2}
+)
+
+// Native is a function implemented in Go that scripts call like any other.
+type Native struct {
+	Name       string
+	ParamTypes []TypeKind
+	ReturnType TypeKind
+	Fn         func(args []Value) (Value, error)
 }
 
 func CreateModule(name string) *Module {
 	return &Module{
 		Name:      name,
 		Functions: make(map[string]*FunctionInfo),
+		Natives:   make(map[string]*Native),
+		VTables:   make(map[string]map[string]string),
+	}
+}
+
+// Clone copies the module deeply enough that optimizing the copy's code
+// leaves the original untouched. Natives, variants and vtables are shared.
+func (m *Module) Clone() *Module {
+	out := &Module{
+		Name:      m.Name,
+		Functions: make(map[string]*FunctionInfo, len(m.Functions)),
+		Natives:   m.Natives,
+		Variants:  m.Variants,
+		VTables:   m.VTables,
+	}
+	for name, fn := range m.Functions {
+		cp := *fn
+		cp.ParamTypes = append([]TypeKind(nil), fn.ParamTypes...)
+		cp.Chunk = Chunk{
+			Code:      append([]byte(nil), fn.Chunk.Code...),
+			Constants: append([]Value(nil), fn.Chunk.Constants...),
+			Lines:     append([]LineStart(nil), fn.Chunk.Lines...),
+			Handlers:  append([]Handler(nil), fn.Chunk.Handlers...),
+		}
+		out.Functions[name] = &cp
 	}
+	return out
 }
 
 func (m *Module) AddFunction(fn *FunctionInfo) error {
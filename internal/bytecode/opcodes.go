@@ -6,6 +6,8 @@ const (
 	OpConst OpCode = iota
 	OpLoadLocal
 	OpStoreLocal
+	OpLoadLocal2 // two OpLoadLocal fused into one dispatch
+	OpTeeLocal   // store top of stack into a local without popping it
 	OpAdd
 	OpSub
 	OpMul
@@ -25,6 +27,7 @@ const (
 
 	OpJump
 	OpJumpIfFalse
+	OpJumpIfTrue
 	OpPop
 
 	OpCall
@@ -36,6 +39,36 @@ const (
 
 	OpArraySwapJit
 
+	OpArrayGetUnchecked
+	OpArraySetUnchecked
+
 	OpPrint
 	OpPrintLn
+	OpEPrintLn
+	OpReadLine
+	OpReadInt
+
+	OpYield    // suspend the generator, handing the top of stack to its caller
+	OpNext     // resume the generator on top of stack; fails once it has finished
+	OpIterNext // like OpNext, also receiving from channels; jumps to its operand once the iteration is over
+
+	OpSpawn   // start the function named by the constant operand as a new task
+	OpChanNew // make a channel buffering up to the top of stack values
+	OpSend    // send the top of stack on the channel below it
+	OpRecv    // receive from the channel on top of stack
+	OpClose   // close the channel on top of stack
+	OpWait    // block until the tasks spawned by the running one have finished
+
+	OpVariant      // make the variant its operand indexes in Module.Variants from the values on top of stack
+	OpIsVariant    // test whether the top of stack is the variant its operand indexes
+	OpVariantField // replace the variant on top of stack with the value its operand indexes
+
+	OpParseInt   // replace the string on top of stack with an ok int, or an err message if it is not one
+	OpParseFloat // like OpParseInt for floats
+
+	OpPanic // raise a runtime error with the string on top of stack as its message
+
+	OpInvoke // call the method named by the constant operand on the receiver below its second operand's count of arguments
+
+	OpIntToFloat // replace the int on top of stack with the float of the same value
 )
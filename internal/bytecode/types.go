@@ -12,8 +12,60 @@ const (
 	TypeVoid
 	TypeNull
 	TypeArray
+	TypeIter
+	TypeChan
+	// TypeNullable is the checker's T?, a T or null. Values of it have
+	// T's kind at run time.
+	TypeNullable
+	TypeEnum
+	// TypeResult is the checker's Result<T, E>, whose values are the ok
+	// and err variants.
+	TypeResult
+	// TypeParam is the checker's type parameter of a generic fn or enum,
+	// which stands for whatever type a use of it is given.
+	TypeParam
+	// TypeTrait is the checker's trait type, whose values are those of
+	// the types implementing the trait.
+	TypeTrait
 )
 
+func (t TypeKind) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeBool:
+		return "bool"
+	case TypeString:
+		return "string"
+	case TypeChar:
+		return "char"
+	case TypeVoid:
+		return "void"
+	case TypeNull:
+		return "null"
+	case TypeArray:
+		return "array"
+	case TypeIter:
+		return "iter"
+	case TypeChan:
+		return "chan"
+	case TypeNullable:
+		return "nullable"
+	case TypeEnum:
+		return "enum"
+	case TypeResult:
+		return "result"
+	case TypeParam:
+		return "param"
+	case TypeTrait:
+		return "trait"
+	default:
+		return "invalid"
+	}
+}
+
 type ValueKind byte
 
 const (
@@ -26,8 +78,47 @@ const (
 	ValObject
 )
 
+func (k ValueKind) String() string {
+	switch k {
+	case ValInt:
+		return "int"
+	case ValFloat:
+		return "float"
+	case ValBool:
+		return "bool"
+	case ValString:
+		return "string"
+	case ValChar:
+		return "char"
+	case ValNull:
+		return "null"
+	case ValObject:
+		return "object"
+	default:
+		return "invalid"
+	}
+}
+
 type ObjectType byte
 
 const (
 	ObjArray ObjectType = iota
-)
\ No newline at end of file
+	ObjGenerator
+	ObjChan
+	ObjVariant
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case ObjArray:
+		return "array"
+	case ObjGenerator:
+		return "generator"
+	case ObjChan:
+		return "chan"
+	case ObjVariant:
+		return "variant"
+	default:
+		return "object"
+	}
+}
\ No newline at end of file
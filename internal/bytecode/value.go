@@ -6,6 +6,6 @@ type Value struct {
 	F    float64
 	B    bool
 	S    string
-	C    byte
+	C    rune
 	Obj  *Object
 }
\ No newline at end of file
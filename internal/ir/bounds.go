@@ -0,0 +1,289 @@
+package ir
+
+import "github.com/dunooo0ooo/lang/internal/bytecode"
+
+// maxOffset bounds the constants folded into a linear form, which keeps the
+// arithmetic the forms stand for far away from int64 overflow.
+const maxOffset = 1 << 20
+
+// linear is base + off; a nil base stands for the constant off.
+type linear struct {
+	base *Value
+	off  int64
+}
+
+func intConst(v *Value) (int64, bool) {
+	if v.Op != OpConst || v.Const.Kind != bytecode.ValInt {
+		return 0, false
+	}
+	return v.Const.I, true
+}
+
+func smallConst(v *Value) (int64, bool) {
+	c, ok := intConst(v)
+	return c, ok && c > -maxOffset && c < maxOffset
+}
+
+func linearOf(v *Value) linear {
+	switch v.Op {
+	case OpConst:
+		if c, ok := intConst(v); ok {
+			return linear{off: c}
+		}
+	case OpAdd:
+		if c, ok := smallConst(v.Args[1]); ok {
+			l := linearOf(v.Args[0])
+			return linear{l.base, l.off + c}
+		}
+		if c, ok := smallConst(v.Args[0]); ok {
+			l := linearOf(v.Args[1])
+			return linear{l.base, l.off + c}
+		}
+	case OpSub:
+		if c, ok := smallConst(v.Args[1]); ok {
+			l := linearOf(v.Args[0])
+			return linear{l.base, l.off - c}
+		}
+	}
+	return linear{base: v}
+}
+
+// lessThan records that x < y + slack holds where the fact is known.
+type lessThan struct {
+	x, y  *Value
+	slack int64
+}
+
+// edgeFacts translates the outcome of a branch on cond into facts.
+func edgeFacts(cond *Value, taken bool) []lessThan {
+	if cond.Op == OpNot {
+		return edgeFacts(cond.Args[0], !taken)
+	}
+	if len(cond.Args) != 2 {
+		return nil
+	}
+	a, b := cond.Args[0], cond.Args[1]
+	op := cond.Op
+	if !taken {
+		switch op {
+		case OpLt:
+			op = OpGe
+		case OpLe:
+			op = OpGt
+		case OpGt:
+			op = OpLe
+		case OpGe:
+			op = OpLt
+		case OpNe:
+			op = OpEq
+		default:
+			return nil
+		}
+	}
+	switch op {
+	case OpLt:
+		return []lessThan{{a, b, 0}}
+	case OpLe:
+		return []lessThan{{a, b, 1}}
+	case OpGt:
+		return []lessThan{{b, a, 0}}
+	case OpGe:
+		return []lessThan{{b, a, 1}}
+	case OpEq:
+		return []lessThan{{a, b, 1}, {b, a, 1}}
+	}
+	return nil
+}
+
+type boundsProver struct {
+	dom   *domTree
+	kinds valueKinds
+	lower map[*Value]int64
+	facts map[*Block][]lessThan
+}
+
+// eliminateBoundsChecks turns array accesses into their unchecked forms
+// where the array is a known allocation and the index provably lies in
+// [0, length). Upper bounds come from the branch conditions dominating the
+// access, lower bounds from a range analysis over induction variables.
+func eliminateBoundsChecks(f *Func, kinds valueKinds) bool {
+	bp := &boundsProver{
+		dom:   dominators(f),
+		kinds: kinds,
+		lower: lowerBounds(f),
+		facts: make(map[*Block][]lessThan),
+	}
+	changed := false
+	for _, b := range f.Blocks {
+		for _, v := range b.Instrs {
+			if v.Op != OpArrayGet && v.Op != OpArraySet {
+				continue
+			}
+			if !bp.inBounds(b, v.Args[0], v.Args[1]) {
+				continue
+			}
+			if v.Op == OpArrayGet {
+				v.Op = OpArrayGetUnchecked
+			} else {
+				v.Op = OpArraySetUnchecked
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (bp *boundsProver) inBounds(b *Block, arr, idx *Value) bool {
+	if arr.Op != OpArrayNew || !bp.isInt(idx) || !bp.isInt(arr.Args[0]) {
+		return false
+	}
+	if lo, ok := bp.lower[idx]; !ok || lo < 0 {
+		return false
+	}
+
+	i := linearOf(idx)
+	n := linearOf(arr.Args[0])
+	if i.base == n.base && i.off < n.off {
+		return true
+	}
+	for _, fact := range bp.factsAt(b) {
+		x := linearOf(fact.x)
+		if x.base != i.base || !bp.isInt(fact.y) {
+			continue
+		}
+		// i.base + x.off < y + slack, so idx < y + slack - x.off + i.off.
+		y := linearOf(fact.y)
+		if y.base == n.base && y.off+fact.slack-x.off+i.off <= n.off {
+			return true
+		}
+	}
+	return false
+}
+
+func (bp *boundsProver) isInt(v *Value) bool {
+	k := bp.kinds[v]
+	return k.state == kindKnown && k.kind == bytecode.ValInt
+}
+
+// factsAt collects the facts of every branch edge that must have been
+// taken to reach b: the edge into a single-predecessor block dominating b.
+func (bp *boundsProver) factsAt(b *Block) []lessThan {
+	if facts, ok := bp.facts[b]; ok {
+		return facts
+	}
+	var facts []lessThan
+	if d := bp.dom.idom[b]; d != nil && d != b {
+		facts = append(facts, bp.factsAt(d)...)
+	}
+	if len(b.Preds) == 1 {
+		p := b.Preds[0]
+		if p.Kind == TermBranch && p.Succs[0] != p.Succs[1] {
+			facts = append(facts, edgeFacts(p.Control, p.Succs[0] == b)...)
+		}
+	}
+	bp.facts[b] = facts
+	return facts
+}
+
+type boundState int
+
+const (
+	boundPending boundState = iota
+	boundKnown
+	boundUnknown
+)
+
+// lowerBounds computes constant lower bounds of integer values by iterating
+// to a fixpoint. Phis optimistically ignore operands not evaluated yet; a
+// phi whose bound keeps dropping is widened to unknown, so only induction
+// variables that never decrease keep their initial bound. Once that
+// settles, the iteration reruns treating leftover pending operands as
+// unknown.
+func lowerBounds(f *Func) map[*Value]int64 {
+	lower := make(map[*Value]int64)
+	state := make(map[*Value]boundState)
+	strict := false
+
+	shifted := func(v *Value, c int64) (int64, boundState) {
+		switch st := state[v]; {
+		case st != boundKnown:
+			return 0, st
+		case lower[v] <= -maxOffset || lower[v] >= maxOffset:
+			return 0, boundUnknown
+		}
+		return lower[v] + c, boundKnown
+	}
+	eval := func(v *Value) (int64, boundState) {
+		switch v.Op {
+		case OpConst:
+			if c, ok := intConst(v); ok {
+				return c, boundKnown
+			}
+		case OpAdd:
+			if c, ok := smallConst(v.Args[1]); ok {
+				return shifted(v.Args[0], c)
+			}
+			if c, ok := smallConst(v.Args[0]); ok {
+				return shifted(v.Args[1], c)
+			}
+		case OpSub:
+			if c, ok := smallConst(v.Args[1]); ok {
+				return shifted(v.Args[0], -c)
+			}
+		case OpPhi:
+			var lo int64
+			st := boundPending
+			for _, a := range v.Args {
+				switch state[a] {
+				case boundUnknown:
+					return 0, boundUnknown
+				case boundPending:
+					if strict {
+						return 0, boundUnknown
+					}
+				case boundKnown:
+					if st == boundPending || lower[a] < lo {
+						lo, st = lower[a], boundKnown
+					}
+				}
+			}
+			return lo, st
+		}
+		return 0, boundUnknown
+	}
+
+	for changed := true; changed || !strict; {
+		if !changed {
+			strict = true
+		}
+		changed = false
+		for _, b := range f.Blocks {
+			for _, v := range b.Instrs {
+				if state[v] == boundUnknown {
+					continue
+				}
+				lo, st := eval(v)
+				old, had := lower[v], state[v] == boundKnown
+				switch {
+				case st == boundPending:
+					continue
+				case st == boundUnknown, had && lo < old && v.Op == OpPhi:
+					state[v] = boundUnknown
+				case !had || lo != old:
+					lower[v], state[v] = lo, boundKnown
+				default:
+					continue
+				}
+				changed = true
+			}
+		}
+	}
+
+	out := make(map[*Value]int64)
+	for v, st := range state {
+		if st == boundKnown {
+			out[v] = lower[v]
+		}
+	}
+	return out
+}
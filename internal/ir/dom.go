@@ -0,0 +1,96 @@
+package ir
+
+func reversePostorder(entry *Block) []*Block {
+	var post []*Block
+	seen := make(map[*Block]bool)
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for _, s := range b.Succs {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(entry)
+
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// domTree holds immediate dominators computed with the Cooper-Harvey-Kennedy
+// iterative algorithm over f.Blocks, which must be in reverse postorder.
+type domTree struct {
+	idom     map[*Block]*Block
+	order    map[*Block]int
+	children map[*Block][]*Block
+}
+
+func dominators(f *Func) *domTree {
+	t := &domTree{
+		idom:     make(map[*Block]*Block, len(f.Blocks)),
+		order:    make(map[*Block]int, len(f.Blocks)),
+		children: make(map[*Block][]*Block),
+	}
+	for i, b := range f.Blocks {
+		t.order[b] = i
+	}
+	t.idom[f.Entry] = f.Entry
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks[1:] {
+			var idom *Block
+			for _, p := range b.Preds {
+				if _, ok := t.idom[p]; !ok {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = t.intersect(p, idom)
+				}
+			}
+			if idom != nil && t.idom[b] != idom {
+				t.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range f.Blocks[1:] {
+		if d, ok := t.idom[b]; ok {
+			t.children[d] = append(t.children[d], b)
+		}
+	}
+	return t
+}
+
+func (t *domTree) intersect(a, b *Block) *Block {
+	for a != b {
+		for t.order[a] > t.order[b] {
+			a = t.idom[a]
+		}
+		for t.order[b] > t.order[a] {
+			b = t.idom[b]
+		}
+	}
+	return a
+}
+
+func (t *domTree) dominates(a, b *Block) bool {
+	for {
+		if a == b {
+			return true
+		}
+		d := t.idom[b]
+		if d == b {
+			return false
+		}
+		b = d
+	}
+}
@@ -0,0 +1,10 @@
+package ir
+
+// Loops returns the blocks of each loop of f.
+func Loops(f *Func) []map[*Block]bool {
+	var out []map[*Block]bool
+	for _, lp := range findLoops(f, dominators(f)) {
+		out = append(out, lp.blocks)
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+// Package ir is an SSA intermediate representation for function bodies.
+//
+// A bytecode function is lifted into a control-flow graph of basic blocks
+// whose locals and operand-stack slots are renamed into SSA values joined
+// by phi nodes, optimized, and lowered back into a bytecode.Chunk.
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+type Op int
+
+const (
+	OpInvalid Op = iota
+	OpConst
+	OpParam
+	OpPhi
+	OpCopy
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpNeg
+	OpNot
+
+	OpCall
+	OpArrayNew
+	OpArrayGet
+	OpArraySet
+	OpArraySwap
+	OpArrayGetUnchecked
+	OpArraySetUnchecked
+	OpPrint
+	OpPrintLn
+	OpEPrintLn
+	OpReadLine
+	OpReadInt
+	OpIntToFloat
+)
+
+var opNames = [...]string{
+	OpInvalid: "invalid", OpConst: "const", OpParam: "param", OpPhi: "phi", OpCopy: "copy",
+	OpAdd: "add", OpSub: "sub", OpMul: "mul", OpDiv: "div", OpMod: "mod", OpPow: "pow",
+	OpEq: "eq", OpNe: "ne", OpLt: "lt", OpLe: "le", OpGt: "gt", OpGe: "ge",
+	OpNeg: "neg", OpNot: "not",
+	OpCall: "call", OpArrayNew: "array_new", OpArrayGet: "array_get", OpArraySet: "array_set",
+	OpArraySwap: "array_swap", OpArrayGetUnchecked: "array_get_unchecked",
+	OpArraySetUnchecked: "array_set_unchecked", OpPrint: "print", OpPrintLn: "println",
+	OpEPrintLn: "eprintln", OpReadLine: "read_line", OpReadInt: "read_int",
+	OpIntToFloat: "int_to_float",
+}
+
+func (o Op) String() string {
+	if int(o) < len(opNames) {
+		return opNames[o]
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// simpleOps are the bytecode instructions that pop a fixed number of
+// operands and push exactly one result.
+var simpleOps = map[bytecode.OpCode]struct {
+	op    Op
+	arity int
+}{
+	bytecode.OpAdd: {OpAdd, 2}, bytecode.OpSub: {OpSub, 2}, bytecode.OpMul: {OpMul, 2},
+	bytecode.OpDiv: {OpDiv, 2}, bytecode.OpMod: {OpMod, 2}, bytecode.OpPow: {OpPow, 2},
+	bytecode.OpEq: {OpEq, 2}, bytecode.OpNe: {OpNe, 2},
+	bytecode.OpLt: {OpLt, 2}, bytecode.OpLe: {OpLe, 2}, bytecode.OpGt: {OpGt, 2}, bytecode.OpGe: {OpGe, 2},
+	bytecode.OpNeg: {OpNeg, 1}, bytecode.OpNot: {OpNot, 1},
+	bytecode.OpArrayNew: {OpArrayNew, 1}, bytecode.OpArrayGet: {OpArrayGet, 2},
+	bytecode.OpArraySet: {OpArraySet, 3}, bytecode.OpArraySwapJit: {OpArraySwap, 2},
+	bytecode.OpArrayGetUnchecked: {OpArrayGetUnchecked, 2}, bytecode.OpArraySetUnchecked: {OpArraySetUnchecked, 3},
+	bytecode.OpPrint: {OpPrint, 1}, bytecode.OpPrintLn: {OpPrintLn, 1}, bytecode.OpEPrintLn: {OpEPrintLn, 1},
+	bytecode.OpReadLine: {OpReadLine, 0}, bytecode.OpReadInt: {OpReadInt, 0},
+	bytecode.OpIntToFloat: {OpIntToFloat, 1},
+}
+
+var bytecodeOf = func() map[Op]bytecode.OpCode {
+	m := make(map[Op]bytecode.OpCode, len(simpleOps))
+	for bc, s := range simpleOps {
+		m[s.op] = bc
+	}
+	return m
+}()
+
+// pure ops have no side effects and, for well-typed programs, never fail.
+func (o Op) pure() bool {
+	switch o {
+	case OpConst, OpAdd, OpSub, OpMul, OpPow, OpEq, OpNe, OpLt, OpLe, OpGt, OpGe, OpNeg, OpNot, OpIntToFloat:
+		return true
+	default:
+		return false
+	}
+}
+
+type Value struct {
+	ID    int
+	Op    Op
+	Args  []*Value
+	Block *Block
+
+	// Const holds the constant of OpConst; Name the callee of OpCall;
+	// Index the parameter number of OpParam.
+	Const bytecode.Value
+	Name  string
+	Index int
+
+	// Line is the source line of the instruction the value came from.
+	Line int
+}
+
+func (v *Value) String() string { return fmt.Sprintf("v%d", v.ID) }
+
+type TermKind int
+
+const (
+	TermJump TermKind = iota
+	TermBranch
+	TermReturn
+)
+
+type Block struct {
+	ID     int
+	Instrs []*Value
+	Preds  []*Block
+	Succs  []*Block
+
+	// Kind is the terminator. A branch goes to Succs[0] when Control is
+	// true and to Succs[1] otherwise; a return returns Control.
+	Kind    TermKind
+	Control *Value
+}
+
+type Func struct {
+	Name      string
+	NumParams int
+	Entry     *Block
+	Blocks    []*Block
+
+	nextValue int
+	nextBlock int
+}
+
+func (f *Func) newBlock() *Block {
+	b := &Block{ID: f.nextBlock}
+	f.nextBlock++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+func (f *Func) newValue(b *Block, op Op, args ...*Value) *Value {
+	v := &Value{ID: f.nextValue, Op: op, Args: args, Block: b}
+	f.nextValue++
+	return v
+}
+
+func (b *Block) predIndex(p *Block) int {
+	for i, q := range b.Preds {
+		if q == p {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *Func) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func %s(%d):\n", f.Name, f.NumParams)
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "b%d:", b.ID)
+		if len(b.Preds) > 0 {
+			sb.WriteString(" <-")
+			for _, p := range b.Preds {
+				fmt.Fprintf(&sb, " b%d", p.ID)
+			}
+		}
+		sb.WriteString("\n")
+		for _, v := range b.Instrs {
+			fmt.Fprintf(&sb, "  %s = %s", v, v.Op)
+			switch v.Op {
+			case OpConst:
+				fmt.Fprintf(&sb, " %v", v.Const)
+			case OpCall:
+				fmt.Fprintf(&sb, " %s", v.Name)
+			case OpParam:
+				fmt.Fprintf(&sb, " %d", v.Index)
+			}
+			for _, a := range v.Args {
+				fmt.Fprintf(&sb, " %s", a)
+			}
+			sb.WriteString("\n")
+		}
+		switch b.Kind {
+		case TermJump:
+			fmt.Fprintf(&sb, "  jump b%d\n", b.Succs[0].ID)
+		case TermBranch:
+			fmt.Fprintf(&sb, "  branch %s b%d b%d\n", b.Control, b.Succs[0].ID, b.Succs[1].ID)
+		case TermReturn:
+			fmt.Fprintf(&sb, "  return %s\n", b.Control)
+		}
+	}
+	return sb.String()
+}
+
+// forEachUse calls fn for every operand slot that refers to a value,
+// including terminator controls.
+func (f *Func) forEachUse(fn func(use **Value)) {
+	for _, b := range f.Blocks {
+		for _, v := range b.Instrs {
+			for i := range v.Args {
+				fn(&v.Args[i])
+			}
+		}
+		if b.Control != nil {
+			fn(&b.Control)
+		}
+	}
+}
+
+func (f *Func) useCounts() map[*Value]int {
+	n := make(map[*Value]int)
+	f.forEachUse(func(u **Value) { n[*u]++ })
+	return n
+}
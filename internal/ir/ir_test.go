@@ -0,0 +1,238 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/ir"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/runtime"
+	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+var differentialPrograms = []struct {
+	name string
+	src  string
+}{
+	{"recursion", `
+fn fact(n: int) -> int {
+    if n <= 1 { return 1; }
+    return n * fact(n - 1);
+}
+fn main() -> int { return fact(12); }
+`},
+	{"nested loops with invariants", `
+fn main() -> int {
+    let n: int = 30;
+    let sum: int = 0;
+    let i: int = 0;
+    while i < n * 2 {
+        let j: int = 0;
+        while j < n - 1 {
+            sum = sum + (n * 3) + (i * j) % 7;
+            j = j + 1;
+        }
+        i = i + 1;
+    }
+    return sum;
+}
+`},
+	{"common subexpressions", `
+fn f(a: int, b: int) -> int {
+    let x: int = (a + b) * (a + b);
+    let y: int = (b + a) * 2;
+    if a * b > 10 { x = x + a * b; }
+    return x + y + a * b;
+}
+fn main() -> int { return f(3, 5) + f(1, 2); }
+`},
+	{"logic and if expressions", `
+fn g(x: int) -> bool { return x % 3 == 0 || x % 5 == 0 && !(x > 50); }
+fn main() -> int {
+    let c: int = 0;
+    for let i: int = 0; i < 100; i = i + 1 {
+        c = c + if g(i) { 2 } else { 1 };
+    }
+    return c;
+}
+`},
+	{"arrays", `
+fn fill(a: []int, n: int) -> void {
+    let i: int = 0;
+    while i < n {
+        set(a, i, (i * 7919) % 101);
+        i = i + 1;
+    }
+}
+fn bubbleSort(arr: []int, n: int) -> void {
+    let pass: int = 0;
+    while pass < n {
+        let j: int = 0;
+        while j < n - 1 {
+            if get(arr, j) > get(arr, j + 1) {
+                let tmp: int = get(arr, j);
+                set(arr, j, get(arr, j + 1));
+                set(arr, j + 1, tmp);
+            }
+            j = j + 1;
+        }
+        pass = pass + 1;
+    }
+}
+fn main() -> int {
+    let n: int = 40;
+    let a: []int = array(n);
+    fill(a, n);
+    bubbleSort(a, n);
+    let b: []int = [a[0], a[n - 1], a[n / 2]];
+    return b[0] * 10000 + b[1] * 100 + b[2];
+}
+`},
+	{"floats and swaps", `
+fn main() -> float {
+    let x: float = 1.0;
+    let y: float = 2.0;
+    let i: int = 0;
+    while i < 10 {
+        let t: float = x;
+        x = y;
+        y = t + y * 0.5;
+        i = i + 1;
+    }
+    return x - y;
+}
+`},
+	{"bounds checks", `
+fn main() -> int {
+    let n: int = 50;
+    let a: []int = array(n);
+    for let i: int = 0; i < n; i = i + 1 { set(a, i, i * i); }
+    let s: int = 0;
+    let j: int = n - 1;
+    while j >= 0 {
+        s = s + get(a, j) % 13;
+        j = j - 1;
+    }
+    let k: int = 0;
+    while k + 2 < n {
+        s = s + get(a, k + 2) - get(a, k);
+        k = k + 3;
+    }
+    return s + a[0] + a[n - 1];
+}
+`},
+	{"early return from endless loop", `
+fn h(n: int) -> int {
+    let r: int = 1;
+    while true {
+        if n > 100 { return n; }
+        n = n * 2 + r;
+    }
+    return 0;
+}
+fn main() -> int { return h(3); }
+`},
+}
+
+func TestDifferentialAgainstUnoptimizedVM(t *testing.T) {
+	for _, tt := range differentialPrograms {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := runtime.NewVM(compile(t, tt.src), false).Call("main", nil)
+			if err != nil {
+				t.Fatalf("unoptimized run: %v", err)
+			}
+
+			mod := compile(t, tt.src)
+			for _, fn := range mod.Functions {
+				if err := ir.Optimize(mod, fn); err != nil {
+					t.Fatalf("optimize %s: %v", fn.Name, err)
+				}
+			}
+			got, err := runtime.NewVM(mod, false).Call("main", nil)
+			if err != nil {
+				t.Fatalf("optimized run: %v", err)
+			}
+			if got != want {
+				t.Fatalf("optimized result %#v, unoptimized %#v", got, want)
+			}
+
+			jitted, err := runtime.NewVM(compile(t, tt.src), true).Call("main", nil)
+			if err != nil {
+				t.Fatalf("jit run: %v", err)
+			}
+			if jitted != want {
+				t.Fatalf("jit result %#v, unoptimized %#v", jitted, want)
+			}
+		})
+	}
+}
+
+func TestDifferentialRuntimeErrors(t *testing.T) {
+	src := `
+fn main() -> int {
+    let a: []int = array(3);
+    let i: int = 0;
+    let z: int = 0;
+    while i < 10 {
+        let q: int = 10 / z;
+        i = i + 1;
+    }
+    return get(a, 5);
+}
+`
+	mod := compile(t, src)
+	for _, fn := range mod.Functions {
+		if err := ir.Optimize(mod, fn); err != nil {
+			t.Fatalf("optimize %s: %v", fn.Name, err)
+		}
+	}
+	if _, err := runtime.NewVM(mod, false).Call("main", nil); err == nil {
+		t.Fatal("division by zero disappeared after optimization")
+	}
+}
+
+func compile(t *testing.T, src string) *bytecode.Module {
+	t.Helper()
+
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := sema.New()
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("sema errors: %v", c.Errors())
+	}
+	mod, err := compilation.NewCompiler().CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	return mod
+}
+
+func TestOutOfBoundsSurvivesOptimization(t *testing.T) {
+	src := `
+fn main() -> int {
+    let n: int = 8;
+    let a: []int = array(n);
+    let i: int = 0;
+    while i <= n {
+        set(a, i, i);
+        i = i + 1;
+    }
+    return 0;
+}
+`
+	mod := compile(t, src)
+	for _, fn := range mod.Functions {
+		if err := ir.Optimize(mod, fn); err != nil {
+			t.Fatalf("optimize %s: %v", fn.Name, err)
+		}
+	}
+	if _, err := runtime.NewVM(mod, false).Call("main", nil); err == nil {
+		t.Fatal("out of range write disappeared after optimization")
+	}
+}
@@ -0,0 +1,128 @@
+package ir
+
+import "github.com/dunooo0ooo/lang/internal/bytecode"
+
+type kindState int
+
+const (
+	kindPending kindState = iota
+	kindKnown
+	kindVarying
+)
+
+type kindInfo struct {
+	state kindState
+	kind  bytecode.ValueKind
+}
+
+// valueKinds infers the runtime kind of values where it is fixed: constants,
+// typed parameters and what is computed from them. A value whose kind is
+// known cannot hit the VM's "mixed types" or "non-bool" failures.
+type valueKinds map[*Value]kindInfo
+
+func inferKinds(f *Func, paramTypes []bytecode.TypeKind) valueKinds {
+	k := make(valueKinds)
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, v := range b.Instrs {
+				next := k.transfer(v, paramTypes)
+				if next != k[v] {
+					k[v] = next
+					changed = true
+				}
+			}
+		}
+	}
+	return k
+}
+
+func (k valueKinds) transfer(v *Value, paramTypes []bytecode.TypeKind) kindInfo {
+	known := func(kind bytecode.ValueKind) kindInfo { return kindInfo{state: kindKnown, kind: kind} }
+	varying := kindInfo{state: kindVarying}
+
+	switch v.Op {
+	case OpConst:
+		return known(v.Const.Kind)
+	case OpParam:
+		if v.Index < len(paramTypes) {
+			switch paramTypes[v.Index] {
+			case bytecode.TypeInt:
+				return known(bytecode.ValInt)
+			case bytecode.TypeFloat:
+				return known(bytecode.ValFloat)
+			case bytecode.TypeBool:
+				return known(bytecode.ValBool)
+			}
+		}
+		return varying
+	case OpPhi:
+		out := kindInfo{}
+		for _, a := range v.Args {
+			ak := k[a]
+			switch {
+			case ak.state == kindPending:
+			case ak.state == kindVarying:
+				return varying
+			case out.state == kindPending:
+				out = ak
+			case out.kind != ak.kind:
+				return varying
+			}
+		}
+		return out
+	case OpAdd, OpSub, OpMul, OpPow, OpNeg:
+		return k.numeric(v.Args)
+	case OpLt, OpLe, OpGt, OpGe:
+		if r := k.numeric(v.Args); r.state != kindKnown {
+			return r
+		}
+		return known(bytecode.ValBool)
+	case OpEq, OpNe:
+		return known(bytecode.ValBool)
+	case OpNot:
+		if a := k[v.Args[0]]; a.state != kindKnown || a.kind != bytecode.ValBool {
+			return kindInfo{state: a.state}
+		}
+		return known(bytecode.ValBool)
+	case OpIntToFloat:
+		if a := k[v.Args[0]]; a.state != kindKnown || a.kind != bytecode.ValInt {
+			return kindInfo{state: a.state}
+		}
+		return known(bytecode.ValFloat)
+	case OpReadLine:
+		return known(bytecode.ValString)
+	case OpReadInt:
+		return known(bytecode.ValInt)
+	default:
+		return varying
+	}
+}
+
+func (k valueKinds) numeric(args []*Value) kindInfo {
+	out := kindInfo{}
+	for _, a := range args {
+		ak := k[a]
+		switch {
+		case ak.state == kindPending:
+			return ak
+		case ak.state == kindVarying:
+			return ak
+		case ak.kind != bytecode.ValInt && ak.kind != bytecode.ValFloat:
+			return kindInfo{state: kindVarying}
+		case out.state == kindKnown && out.kind != ak.kind:
+			return kindInfo{state: kindVarying}
+		}
+		out = ak
+	}
+	return out
+}
+
+// cannotFail reports whether evaluating the pure value v never fails.
+func (k valueKinds) cannotFail(v *Value) bool {
+	switch v.Op {
+	case OpConst, OpEq, OpNe:
+		return true
+	}
+	return v.Op.pure() && k[v].state == kindKnown
+}
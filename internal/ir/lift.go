@@ -0,0 +1,481 @@
+package ir
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	jit "github.com/dunooo0ooo/lang/internal/runtime/compilation/jit_optimization"
+)
+
+// Lift builds the SSA form of fn. Locals and operand-stack slots are both
+// treated as variables, so every block must be entered with the same stack
+// depth from all of its predecessors.
+func Lift(mod *bytecode.Module, fn *bytecode.FunctionInfo) (*Func, error) {
+	if fn.IsGenerator {
+		// A suspended generator keeps its operand stack, which SSA form
+		// does not.
+		return nil, fmt.Errorf("ir: %s: generator bodies are not lifted", fn.Name)
+	}
+	if len(fn.Chunk.Handlers) > 0 {
+		// Any instruction of a try block may continue at its catch.
+		return nil, fmt.Errorf("ir: %s: bodies with try blocks are not lifted", fn.Name)
+	}
+	l := &lifter{
+		mod:        mod,
+		fn:         fn,
+		f:          &Func{Name: fn.Name, NumParams: fn.ParamCount},
+		byAddr:     make(map[int]*Block),
+		defs:       make(map[*Block]map[int]*Value),
+		incomplete: make(map[*Block]map[int]*Value),
+		sealed:     make(map[*Block]bool),
+		filled:     make(map[*Block]bool),
+	}
+	if err := l.buildCFG(); err != nil {
+		return nil, err
+	}
+	if err := l.computeDepths(); err != nil {
+		return nil, err
+	}
+	l.fill()
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.f, nil
+}
+
+type lifter struct {
+	mod *bytecode.Module
+	fn  *bytecode.FunctionInfo
+	f   *Func
+
+	instrs []jit.Instruction
+	byAddr map[int]*Block
+	start  map[*Block]int // index into instrs of the first instruction
+	depth  map[*Block]int // operand-stack depth on entry
+
+	defs       map[*Block]map[int]*Value
+	incomplete map[*Block]map[int]*Value
+	sealed     map[*Block]bool
+	filled     map[*Block]bool
+
+	err error
+}
+
+func (l *lifter) failf(format string, args ...any) {
+	if l.err == nil {
+		l.err = fmt.Errorf("ir: %s: %s", l.fn.Name, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *lifter) buildCFG() error {
+	code := l.fn.Chunk.Code
+	l.instrs = jit.DecodeAll(code)
+	size := 0
+	for _, in := range l.instrs {
+		size += in.Size
+	}
+	if size != len(code) {
+		return fmt.Errorf("ir: %s: truncated instruction at %d", l.fn.Name, size)
+	}
+
+	leaders := map[int]bool{0: true}
+	for _, in := range l.instrs {
+		if _, ok := simpleOps[in.OpCode]; ok {
+			continue
+		}
+		switch {
+		case jit.IsJump(in.OpCode):
+			if in.Argument > len(code) {
+				return fmt.Errorf("ir: %s: jump target %d out of range", l.fn.Name, in.Argument)
+			}
+			leaders[in.Argument] = true
+			leaders[in.Address+in.Size] = true
+		case in.OpCode == bytecode.OpReturn:
+			leaders[in.Address+in.Size] = true
+		}
+	}
+
+	addrs := make([]int, 0, len(leaders))
+	for a := range leaders {
+		addrs = append(addrs, a)
+	}
+	sort.Ints(addrs)
+
+	// A synthetic entry block keeps the real entry free of predecessors
+	// even when address 0 is a loop header.
+	l.f.Entry = l.f.newBlock()
+	l.start = make(map[*Block]int)
+	for _, a := range addrs {
+		b := l.f.newBlock()
+		l.byAddr[a] = b
+	}
+	for i, in := range l.instrs {
+		if b, ok := l.byAddr[in.Address]; ok {
+			l.start[b] = i
+		}
+	}
+	if end, ok := l.byAddr[len(code)]; ok {
+		l.start[end] = len(l.instrs)
+	}
+	l.connect(l.f.Entry, l.byAddr[0])
+
+	for _, a := range addrs {
+		b := l.byAddr[a]
+		last := l.lastInstr(b)
+		if last == nil {
+			// Falling off the end of the code returns null.
+			b.Kind = TermReturn
+			continue
+		}
+		next := last.Address + last.Size
+		switch last.OpCode {
+		case bytecode.OpJump:
+			b.Kind = TermJump
+			l.connect(b, l.byAddr[last.Argument])
+		case bytecode.OpJumpIfFalse:
+			b.Kind = TermBranch
+			l.connect(b, l.byAddr[next])
+			l.connect(b, l.byAddr[last.Argument])
+		case bytecode.OpJumpIfTrue:
+			b.Kind = TermBranch
+			l.connect(b, l.byAddr[last.Argument])
+			l.connect(b, l.byAddr[next])
+		case bytecode.OpReturn:
+			b.Kind = TermReturn
+		default:
+			b.Kind = TermJump
+			nb, ok := l.byAddr[next]
+			if !ok {
+				nb = l.f.newBlock()
+				l.byAddr[next] = nb
+				l.start[nb] = len(l.instrs)
+				nb.Kind = TermReturn
+			}
+			l.connect(b, nb)
+		}
+	}
+
+	l.pruneUnreachable()
+	return nil
+}
+
+func (l *lifter) connect(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// body returns the instructions of b; the terminator, if any, is the last.
+func (l *lifter) body(b *Block) []jit.Instruction {
+	i := l.start[b]
+	if i >= len(l.instrs) {
+		return nil
+	}
+	j := i + 1
+	for j < len(l.instrs) {
+		if _, leader := l.byAddr[l.instrs[j].Address]; leader {
+			break
+		}
+		j++
+	}
+	return l.instrs[i:j]
+}
+
+func (l *lifter) lastInstr(b *Block) *jit.Instruction {
+	body := l.body(b)
+	if len(body) == 0 {
+		return nil
+	}
+	return &body[len(body)-1]
+}
+
+func (l *lifter) pruneUnreachable() {
+	order := reversePostorder(l.f.Entry)
+	reach := make(map[*Block]bool, len(order))
+	for _, b := range order {
+		reach[b] = true
+	}
+	for _, b := range order {
+		preds := b.Preds[:0]
+		for _, p := range b.Preds {
+			if reach[p] {
+				preds = append(preds, p)
+			}
+		}
+		b.Preds = preds
+	}
+	l.f.Blocks = order
+}
+
+func (l *lifter) computeDepths() error {
+	l.depth = map[*Block]int{l.f.Entry: 0}
+	for _, b := range l.f.Blocks {
+		d, ok := l.depth[b]
+		if !ok {
+			return fmt.Errorf("ir: %s: block b%d has no known stack depth", l.fn.Name, b.ID)
+		}
+		if b != l.f.Entry {
+			for _, in := range l.body(b) {
+				pop, push, err := l.stackEffect(in)
+				if err != nil {
+					return err
+				}
+				if d < pop {
+					return fmt.Errorf("ir: %s: stack underflow at %d", l.fn.Name, in.Address)
+				}
+				d += push - pop
+			}
+		}
+		for _, s := range b.Succs {
+			if sd, ok := l.depth[s]; ok && sd != d {
+				return fmt.Errorf("ir: %s: inconsistent stack depth at b%d (%d vs %d)", l.fn.Name, s.ID, sd, d)
+			}
+			l.depth[s] = d
+		}
+	}
+	return nil
+}
+
+func (l *lifter) stackEffect(in jit.Instruction) (pop, push int, err error) {
+	if s, ok := simpleOps[in.OpCode]; ok {
+		return s.arity, 1, nil
+	}
+	switch in.OpCode {
+	case bytecode.OpConst, bytecode.OpLoadLocal:
+		return 0, 1, nil
+	case bytecode.OpLoadLocal2:
+		return 0, 2, nil
+	case bytecode.OpStoreLocal, bytecode.OpPop:
+		return 1, 0, nil
+	case bytecode.OpTeeLocal:
+		return 1, 1, nil
+	case bytecode.OpJump, bytecode.OpReturn:
+		return 0, 0, nil
+	case bytecode.OpJumpIfFalse, bytecode.OpJumpIfTrue:
+		return 1, 1, nil
+	case bytecode.OpCall:
+		_, arity, err := l.callee(in)
+		if err != nil {
+			return 0, 0, err
+		}
+		return arity, 1, nil
+	default:
+		return 0, 0, fmt.Errorf("ir: %s: unsupported opcode %d", l.fn.Name, in.OpCode)
+	}
+}
+
+// callee returns the name and arity of the function a call instruction
+// calls, which is either compiled or native.
+func (l *lifter) callee(in jit.Instruction) (string, int, error) {
+	consts := l.fn.Chunk.Constants
+	if in.Argument >= len(consts) || consts[in.Argument].Kind != bytecode.ValString {
+		return "", 0, fmt.Errorf("ir: %s: bad call operand at %d", l.fn.Name, in.Address)
+	}
+	name := consts[in.Argument].S
+	if callee, ok := l.mod.Functions[name]; ok {
+		return name, callee.ParamCount, nil
+	}
+	if native, ok := l.mod.Natives[name]; ok {
+		return name, len(native.ParamTypes), nil
+	}
+	return "", 0, fmt.Errorf("ir: %s: unknown function %q", l.fn.Name, name)
+}
+
+// Variables 0..NumLocals-1 are locals; NumLocals+k is operand-stack slot k.
+func (l *lifter) stackVar(k int) int { return l.fn.NumLocals + k }
+
+func (l *lifter) fill() {
+	entry := l.f.Entry
+	for i := 0; i < l.fn.ParamCount; i++ {
+		p := l.f.newValue(entry, OpParam)
+		p.Index = i
+		entry.Instrs = append(entry.Instrs, p)
+		l.write(i, entry, p)
+	}
+	entry.Kind = TermJump
+
+	for _, b := range l.f.Blocks {
+		l.trySeal(b)
+		if b != entry {
+			l.fillBlock(b)
+		}
+		l.filled[b] = true
+		for _, s := range b.Succs {
+			l.trySeal(s)
+		}
+	}
+}
+
+func (l *lifter) trySeal(b *Block) {
+	if l.sealed[b] {
+		return
+	}
+	for _, p := range b.Preds {
+		if !l.filled[p] {
+			return
+		}
+	}
+	for v, phi := range l.incomplete[b] {
+		l.addPhiOperands(v, phi)
+	}
+	delete(l.incomplete, b)
+	l.sealed[b] = true
+}
+
+func (l *lifter) fillBlock(b *Block) {
+	consts := l.fn.Chunk.Constants
+	d := l.depth[b]
+
+	push := func(v *Value) {
+		l.write(l.stackVar(d), b, v)
+		d++
+	}
+	pop := func() *Value {
+		d--
+		return l.read(l.stackVar(d), b)
+	}
+	line := 0
+	emit := func(op Op, args ...*Value) *Value {
+		v := l.f.newValue(b, op, args...)
+		v.Line = line
+		b.Instrs = append(b.Instrs, v)
+		return v
+	}
+	constant := func(c bytecode.Value) *Value {
+		v := emit(OpConst)
+		v.Const = c
+		return v
+	}
+
+	for _, in := range l.body(b) {
+		line = l.fn.Chunk.LineAt(in.Address)
+		if s, ok := simpleOps[in.OpCode]; ok {
+			args := make([]*Value, s.arity)
+			for i := s.arity - 1; i >= 0; i-- {
+				args[i] = pop()
+			}
+			push(emit(s.op, args...))
+			continue
+		}
+
+		switch in.OpCode {
+		case bytecode.OpConst:
+			if in.Argument >= len(consts) {
+				l.failf("const index out of range: %d", in.Argument)
+				return
+			}
+			push(constant(consts[in.Argument]))
+		case bytecode.OpLoadLocal:
+			push(l.readLocal(in.Argument, b))
+		case bytecode.OpLoadLocal2:
+			push(l.readLocal(in.Argument, b))
+			push(l.readLocal(in.Argument2, b))
+		case bytecode.OpStoreLocal:
+			l.writeLocal(in.Argument, b, pop())
+		case bytecode.OpTeeLocal:
+			v := pop()
+			push(v)
+			l.writeLocal(in.Argument, b, v)
+		case bytecode.OpPop:
+			_ = pop()
+		case bytecode.OpCall:
+			name, arity, err := l.callee(in)
+			if err != nil {
+				l.failf("%v", err)
+				return
+			}
+			args := make([]*Value, arity)
+			for i := len(args) - 1; i >= 0; i-- {
+				args[i] = pop()
+			}
+			call := emit(OpCall, args...)
+			call.Name = name
+			push(call)
+		case bytecode.OpJump:
+		case bytecode.OpJumpIfFalse, bytecode.OpJumpIfTrue:
+			b.Control = l.read(l.stackVar(d-1), b)
+		case bytecode.OpReturn:
+			if d == 0 {
+				b.Control = constant(bytecode.Value{Kind: bytecode.ValNull})
+			} else {
+				b.Control = l.read(l.stackVar(d-1), b)
+			}
+		}
+	}
+
+	if b.Kind == TermReturn && b.Control == nil {
+		b.Control = constant(bytecode.Value{Kind: bytecode.ValNull})
+	}
+}
+
+func (l *lifter) readLocal(slot int, b *Block) *Value {
+	if slot >= l.fn.NumLocals {
+		l.failf("bad local slot %d", slot)
+		return l.f.newValue(b, OpConst)
+	}
+	return l.read(slot, b)
+}
+
+func (l *lifter) writeLocal(slot int, b *Block, v *Value) {
+	if slot >= l.fn.NumLocals {
+		l.failf("bad local slot %d", slot)
+		return
+	}
+	l.write(slot, b, v)
+}
+
+func (l *lifter) write(variable int, b *Block, v *Value) {
+	m := l.defs[b]
+	if m == nil {
+		m = make(map[int]*Value)
+		l.defs[b] = m
+	}
+	m[variable] = v
+}
+
+func (l *lifter) read(variable int, b *Block) *Value {
+	if v, ok := l.defs[b][variable]; ok {
+		return v
+	}
+	return l.readRecursive(variable, b)
+}
+
+func (l *lifter) readRecursive(variable int, b *Block) *Value {
+	var v *Value
+	switch {
+	case !l.sealed[b]:
+		v = l.newPhi(b)
+		if l.incomplete[b] == nil {
+			l.incomplete[b] = make(map[int]*Value)
+		}
+		l.incomplete[b][variable] = v
+	case len(b.Preds) == 0:
+		// Only the entry block has no predecessors. Locals start out as
+		// the zero Value, exactly like the VM's freshly made frame.
+		if variable >= l.fn.NumLocals {
+			l.failf("operand stack underflow")
+		}
+		v = l.f.newValue(b, OpConst)
+		b.Instrs = append(b.Instrs, v)
+	case len(b.Preds) == 1:
+		v = l.read(variable, b.Preds[0])
+	default:
+		v = l.newPhi(b)
+		l.write(variable, b, v)
+		l.addPhiOperands(variable, v)
+	}
+	l.write(variable, b, v)
+	return v
+}
+
+func (l *lifter) newPhi(b *Block) *Value {
+	phi := l.f.newValue(b, OpPhi)
+	b.Instrs = append([]*Value{phi}, b.Instrs...)
+	return phi
+}
+
+func (l *lifter) addPhiOperands(variable int, phi *Value) {
+	for _, p := range phi.Block.Preds {
+		phi.Args = append(phi.Args, l.read(variable, p))
+	}
+}
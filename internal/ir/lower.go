@@ -0,0 +1,396 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	jit "github.com/dunooo0ooo/lang/internal/runtime/compilation/jit_optimization"
+)
+
+const maxLocals = 256
+
+// Lower turns f back into bytecode. Values that feed the very next
+// instruction stay on the operand stack; every other value lives in a local
+// slot, with slots shared between values whose live ranges do not overlap.
+// Constants are re-emitted at each use.
+func Lower(f *Func) (bytecode.Chunk, int, error) {
+	lw := &lowerer{
+		f:        f,
+		uses:     f.useCounts(),
+		stacked:  make(map[*Value]bool),
+		roots:    make(map[*Block][]*Value),
+		slot:     make(map[*Value]int),
+		constIdx: make(map[bytecode.Value]int),
+		labels:   make(map[*Block]int),
+	}
+	if err := lw.check(); err != nil {
+		return bytecode.Chunk{}, 0, err
+	}
+	lw.stackify()
+	numLocals, err := lw.allocate()
+	if err != nil {
+		return bytecode.Chunk{}, 0, err
+	}
+	lw.emit()
+	return lw.chunk, numLocals, nil
+}
+
+type lowerer struct {
+	f       *Func
+	uses    map[*Value]int
+	stacked map[*Value]bool
+	roots   map[*Block][]*Value
+	slot    map[*Value]int
+
+	chunk    bytecode.Chunk
+	constIdx map[bytecode.Value]int
+	labels   map[*Block]int
+	fixups   []fixup
+}
+
+type fixup struct {
+	at     int
+	target *Block
+}
+
+func (lw *lowerer) check() error {
+	for _, b := range lw.f.Blocks {
+		seen := make(map[*Block]bool)
+		for _, p := range b.Preds {
+			if seen[p] && len(phis(b)) > 0 {
+				return fmt.Errorf("ir: %s: duplicate edge into b%d", lw.f.Name, b.ID)
+			}
+			seen[p] = true
+		}
+		for _, v := range b.Instrs {
+			if v.Op == OpCopy || v.Op == OpInvalid {
+				return fmt.Errorf("ir: %s: cannot lower %s", lw.f.Name, v.Op)
+			}
+		}
+	}
+	return nil
+}
+
+func phis(b *Block) []*Value {
+	n := 0
+	for n < len(b.Instrs) && b.Instrs[n].Op == OpPhi {
+		n++
+	}
+	return b.Instrs[:n]
+}
+
+// stackify picks, for every block, the values that are computed right
+// before their only user and can therefore be left on the operand stack.
+// What remains are the roots, emitted in their original order.
+func (lw *lowerer) stackify() {
+	for _, b := range lw.f.Blocks {
+		var seq []*Value
+		for _, v := range b.Instrs {
+			switch v.Op {
+			case OpPhi, OpParam, OpConst:
+			default:
+				seq = append(seq, v)
+			}
+		}
+
+		pos := len(seq) - 1
+		var claim func(args []*Value)
+		claim = func(args []*Value) {
+			for i := len(args) - 1; i >= 0; i-- {
+				a := args[i]
+				if pos >= 0 && seq[pos] == a && lw.uses[a] == 1 {
+					lw.stacked[a] = true
+					pos--
+					claim(a.Args)
+				}
+			}
+		}
+
+		if b.Control != nil {
+			claim([]*Value{b.Control})
+		}
+		var roots []*Value
+		for pos >= 0 {
+			r := seq[pos]
+			pos--
+			roots = append(roots, r)
+			claim(r.Args)
+		}
+		for i, j := 0, len(roots)-1; i < j; i, j = i+1, j-1 {
+			roots[i], roots[j] = roots[j], roots[i]
+		}
+		lw.roots[b] = roots
+	}
+}
+
+func (lw *lowerer) needsSlot(v *Value) bool {
+	return !lw.stacked[v] && v.Op != OpConst && lw.uses[v] > 0
+}
+
+// loads appends the slot values read while evaluating the operand v.
+func (lw *lowerer) loads(dst []*Value, v *Value) []*Value {
+	if lw.stacked[v] {
+		for _, a := range v.Args {
+			dst = lw.loads(dst, a)
+		}
+		return dst
+	}
+	if lw.needsSlot(v) {
+		dst = append(dst, v)
+	}
+	return dst
+}
+
+func (lw *lowerer) treeLoads(r *Value) []*Value {
+	var dst []*Value
+	for _, a := range r.Args {
+		dst = lw.loads(dst, a)
+	}
+	return dst
+}
+
+type valueSet map[*Value]bool
+
+func (lw *lowerer) liveOut(b *Block, liveIn map[*Block]valueSet) valueSet {
+	out := make(valueSet)
+	for _, s := range b.Succs {
+		for v := range liveIn[s] {
+			out[v] = true
+		}
+		idx := s.predIndex(b)
+		for _, phi := range phis(s) {
+			if a := phi.Args[idx]; lw.needsSlot(a) {
+				out[a] = true
+			}
+		}
+	}
+	return out
+}
+
+// scan walks b backwards from its live-out set. def is called for every
+// value defined in b together with the values live right after it.
+func (lw *lowerer) scan(b *Block, out valueSet, def func(v *Value, live valueSet)) valueSet {
+	live := make(valueSet, len(out))
+	for v := range out {
+		live[v] = true
+	}
+	if b.Control != nil {
+		for _, v := range lw.loads(nil, b.Control) {
+			live[v] = true
+		}
+	}
+
+	roots := lw.roots[b]
+	for i := len(roots) - 1; i >= 0; i-- {
+		r := roots[i]
+		if lw.needsSlot(r) {
+			def(r, live)
+			delete(live, r)
+		}
+		for _, v := range lw.treeLoads(r) {
+			live[v] = true
+		}
+	}
+
+	var top []*Value
+	top = append(top, phis(b)...)
+	if b == lw.f.Entry {
+		for _, v := range b.Instrs {
+			if v.Op == OpParam {
+				top = append(top, v)
+			}
+		}
+	}
+	for _, v := range top {
+		if lw.needsSlot(v) {
+			live[v] = true
+		}
+	}
+	for _, v := range top {
+		if lw.needsSlot(v) {
+			def(v, live)
+		}
+	}
+	for _, v := range top {
+		delete(live, v)
+	}
+	return live
+}
+
+func (lw *lowerer) allocate() (int, error) {
+	blocks := lw.f.Blocks
+	liveIn := make(map[*Block]valueSet, len(blocks))
+	for changed := true; changed; {
+		changed = false
+		for i := len(blocks) - 1; i >= 0; i-- {
+			b := blocks[i]
+			in := lw.scan(b, lw.liveOut(b, liveIn), func(*Value, valueSet) {})
+			if len(in) != len(liveIn[b]) {
+				changed = true
+			}
+			liveIn[b] = in
+		}
+	}
+
+	conflicts := make(map[*Value]valueSet)
+	addEdge := func(a, b *Value) {
+		if a == b {
+			return
+		}
+		if conflicts[a] == nil {
+			conflicts[a] = make(valueSet)
+		}
+		if conflicts[b] == nil {
+			conflicts[b] = make(valueSet)
+		}
+		conflicts[a][b] = true
+		conflicts[b][a] = true
+	}
+
+	var order []*Value
+	for _, b := range blocks {
+		lw.scan(b, lw.liveOut(b, liveIn), func(v *Value, live valueSet) {
+			order = append(order, v)
+			for x := range live {
+				addEdge(v, x)
+			}
+		})
+	}
+
+	numLocals := lw.f.NumParams
+	for _, v := range order {
+		if v.Op == OpParam {
+			lw.slot[v] = v.Index
+		}
+	}
+	for _, v := range order {
+		if v.Op == OpParam {
+			continue
+		}
+		taken := make(map[int]bool)
+		for x := range conflicts[v] {
+			if s, ok := lw.slot[x]; ok {
+				taken[s] = true
+			}
+			if x.Op == OpParam {
+				taken[x.Index] = true
+			}
+		}
+		s := 0
+		for taken[s] {
+			s++
+		}
+		if s >= maxLocals {
+			return 0, fmt.Errorf("ir: %s: too many live values (max %d)", lw.f.Name, maxLocals)
+		}
+		lw.slot[v] = s
+		if s+1 > numLocals {
+			numLocals = s + 1
+		}
+	}
+	return numLocals, nil
+}
+
+func (lw *lowerer) emit() {
+	blocks := lw.f.Blocks
+	for i, b := range blocks {
+		var next *Block
+		if i+1 < len(blocks) {
+			next = blocks[i+1]
+		}
+		lw.labels[b] = len(lw.chunk.Code)
+
+		for _, r := range lw.roots[b] {
+			lw.emitTree(r)
+			if lw.needsSlot(r) {
+				lw.op(bytecode.OpStoreLocal, lw.slot[r])
+			} else {
+				lw.op(bytecode.OpPop)
+			}
+		}
+
+		switch b.Kind {
+		case TermJump:
+			lw.emitEdge(b, b.Succs[0], next)
+		case TermBranch:
+			lw.emitOperand(b.Control)
+			lw.op(bytecode.OpJumpIfFalse, 0)
+			elseJump := len(lw.chunk.Code) - 2
+			lw.op(bytecode.OpPop)
+			lw.emitEdge(b, b.Succs[0], nil)
+			_ = lw.chunk.PatchUint16(elseJump, uint16(len(lw.chunk.Code)))
+			lw.op(bytecode.OpPop)
+			lw.emitEdge(b, b.Succs[1], next)
+		case TermReturn:
+			lw.emitOperand(b.Control)
+			lw.op(bytecode.OpReturn)
+		}
+	}
+
+	for _, fx := range lw.fixups {
+		_ = lw.chunk.PatchUint16(fx.at, uint16(lw.labels[fx.target]))
+	}
+}
+
+// emitEdge performs the parallel copy into the phis of s and jumps to s
+// unless it is laid out next.
+func (lw *lowerer) emitEdge(b, s, next *Block) {
+	idx := s.predIndex(b)
+	var dst []*Value
+	for _, phi := range phis(s) {
+		if !lw.needsSlot(phi) {
+			continue
+		}
+		src := phi.Args[idx]
+		if lw.needsSlot(src) && lw.slot[src] == lw.slot[phi] {
+			continue
+		}
+		lw.emitOperand(src)
+		dst = append(dst, phi)
+	}
+	for i := len(dst) - 1; i >= 0; i-- {
+		lw.op(bytecode.OpStoreLocal, lw.slot[dst[i]])
+	}
+
+	if s == next {
+		return
+	}
+	lw.op(bytecode.OpJump, 0)
+	lw.fixups = append(lw.fixups, fixup{at: len(lw.chunk.Code) - 2, target: s})
+}
+
+func (lw *lowerer) emitOperand(v *Value) {
+	switch {
+	case v.Op == OpConst:
+		lw.op(bytecode.OpConst, lw.constant(v.Const))
+	case lw.stacked[v]:
+		lw.emitTree(v)
+	default:
+		lw.op(bytecode.OpLoadLocal, lw.slot[v])
+	}
+}
+
+func (lw *lowerer) emitTree(v *Value) {
+	for _, a := range v.Args {
+		lw.emitOperand(a)
+	}
+	lw.chunk.MarkLine(v.Line)
+	if v.Op == OpCall {
+		lw.op(bytecode.OpCall, lw.constant(bytecode.Value{Kind: bytecode.ValString, S: v.Name}))
+		return
+	}
+	lw.op(bytecodeOf[v.Op])
+}
+
+func (lw *lowerer) op(op bytecode.OpCode, args ...int) {
+	lw.chunk.Code = jit.Encode(lw.chunk.Code, op, args...)
+}
+
+func (lw *lowerer) constant(c bytecode.Value) int {
+	if idx, ok := lw.constIdx[c]; ok {
+		return idx
+	}
+	idx := lw.chunk.AddConstant(c)
+	lw.constIdx[c] = idx
+	return idx
+}
@@ -0,0 +1,59 @@
+package ir
+
+import "github.com/dunooo0ooo/lang/internal/bytecode"
+
+// Optimize rewrites fn through the SSA form. If fn uses something the IR
+// cannot represent, it is left untouched and the reason is returned.
+func Optimize(mod *bytecode.Module, fn *bytecode.FunctionInfo) error {
+	f, err := Lift(mod, fn)
+	if err != nil {
+		return err
+	}
+
+	f.Optimize(fn.ParamTypes)
+
+	chunk, numLocals, err := Lower(f)
+	if err != nil {
+		return err
+	}
+	fn.Chunk = chunk
+	fn.NumLocals = numLocals
+	return nil
+}
+
+// Optimize runs copy propagation, common subexpression elimination,
+// loop-invariant code motion, bounds-check elimination and dead code
+// elimination. paramTypes are the declared parameter types of the function.
+func (f *Func) Optimize(paramTypes []bytecode.TypeKind) {
+	propagateCopies(f)
+	if eliminateCommonSubexpressions(f) {
+		propagateCopies(f)
+	}
+	kinds := inferKinds(f, paramTypes)
+	hoistLoopInvariants(f, kinds)
+	eliminateBoundsChecks(f, kinds)
+	eliminateDeadCode(f, kinds)
+}
+
+// EliminateBoundsChecks makes the array accesses of fn that provably stay
+// in bounds unchecked, without the rest of Optimize. fn is rewritten only
+// if some access was, which it reports.
+func EliminateBoundsChecks(mod *bytecode.Module, fn *bytecode.FunctionInfo) bool {
+	f, err := Lift(mod, fn)
+	if err != nil {
+		return false
+	}
+
+	propagateCopies(f)
+	if !eliminateBoundsChecks(f, inferKinds(f, fn.ParamTypes)) {
+		return false
+	}
+
+	chunk, numLocals, err := Lower(f)
+	if err != nil {
+		return false
+	}
+	fn.Chunk = chunk
+	fn.NumLocals = numLocals
+	return true
+}
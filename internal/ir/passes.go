@@ -0,0 +1,322 @@
+package ir
+
+import (
+	"sort"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// propagateCopies replaces every use of a copy or of a trivial phi (one whose
+// operands are all the same value, or the phi itself) by its source.
+func propagateCopies(f *Func) bool {
+	repl := make(map[*Value]*Value)
+	resolve := func(v *Value) *Value {
+		for {
+			r, ok := repl[v]
+			if !ok {
+				return v
+			}
+			v = r
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, v := range b.Instrs {
+				if _, done := repl[v]; done {
+					continue
+				}
+				switch v.Op {
+				case OpCopy:
+					repl[v] = resolve(v.Args[0])
+					changed = true
+				case OpPhi:
+					var same *Value
+					trivial := true
+					for _, a := range v.Args {
+						a = resolve(a)
+						if a == v || a == same {
+							continue
+						}
+						if same != nil {
+							trivial = false
+							break
+						}
+						same = a
+					}
+					if trivial && same != nil {
+						repl[v] = same
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	if len(repl) == 0 {
+		return false
+	}
+	f.forEachUse(func(u **Value) { *u = resolve(*u) })
+	for _, b := range f.Blocks {
+		b.Instrs = filterValues(b.Instrs, func(v *Value) bool {
+			_, gone := repl[v]
+			return !gone
+		})
+	}
+	return true
+}
+
+type cseKey struct {
+	op    Op
+	a, b  int
+	konst bytecode.Value
+}
+
+// eliminateCommonSubexpressions turns a pure value into a copy of an
+// identical value computed in a dominating position.
+func eliminateCommonSubexpressions(f *Func) bool {
+	dom := dominators(f)
+	avail := make(map[cseKey]*Value)
+	changed := false
+
+	source := func(v *Value) *Value {
+		for v.Op == OpCopy {
+			v = v.Args[0]
+		}
+		return v
+	}
+
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		var added []cseKey
+		for _, v := range b.Instrs {
+			if !v.Op.pure() || v.Op == OpConst {
+				continue
+			}
+			k := cseKey{op: v.Op, a: -1, b: -1}
+			if len(v.Args) > 0 {
+				k.a = source(v.Args[0]).ID
+			}
+			if len(v.Args) > 1 {
+				k.b = source(v.Args[1]).ID
+			}
+			if commutative(v.Op) && k.a > k.b {
+				k.a, k.b = k.b, k.a
+			}
+
+			if w, ok := avail[k]; ok {
+				v.Op = OpCopy
+				v.Args = []*Value{w}
+				changed = true
+				continue
+			}
+			avail[k] = v
+			added = append(added, k)
+		}
+		for _, c := range dom.children[b] {
+			walk(c)
+		}
+		for _, k := range added {
+			delete(avail, k)
+		}
+	}
+	walk(f.Entry)
+	return changed
+}
+
+func commutative(op Op) bool {
+	switch op {
+	case OpAdd, OpMul, OpEq, OpNe:
+		return true
+	default:
+		return false
+	}
+}
+
+// hoistLoopInvariants moves pure values whose operands are defined outside
+// a loop into the loop's preheader. Values that may still fail at runtime
+// are only hoisted from the header, which runs whenever the loop is entered.
+func hoistLoopInvariants(f *Func, kinds valueKinds) bool {
+	insertPreheaders(f)
+	dom := dominators(f)
+	loops := findLoops(f, dom)
+	changed := false
+
+	for _, lp := range loops {
+		pre := lp.preheader()
+		if pre == nil {
+			continue
+		}
+		for _, b := range f.Blocks {
+			if !lp.blocks[b] {
+				continue
+			}
+			b.Instrs = filterValues(b.Instrs, func(v *Value) bool {
+				if !v.Op.pure() {
+					return true
+				}
+				if b != lp.header && !kinds.cannotFail(v) {
+					return true
+				}
+				for _, a := range v.Args {
+					if lp.blocks[a.Block] {
+						return true
+					}
+				}
+				v.Block = pre
+				pre.Instrs = append(pre.Instrs, v)
+				changed = true
+				return false
+			})
+		}
+	}
+	return changed
+}
+
+type loop struct {
+	header *Block
+	blocks map[*Block]bool
+}
+
+func (lp *loop) preheader() *Block {
+	var pre *Block
+	for _, p := range lp.header.Preds {
+		if lp.blocks[p] {
+			continue
+		}
+		if pre != nil {
+			return nil
+		}
+		pre = p
+	}
+	if pre == nil || len(pre.Succs) != 1 {
+		return nil
+	}
+	return pre
+}
+
+// findLoops returns the natural loops of f, innermost first.
+func findLoops(f *Func, dom *domTree) []*loop {
+	byHeader := make(map[*Block]*loop)
+	var loops []*loop
+	for _, b := range f.Blocks {
+		for _, h := range b.Succs {
+			if !dom.dominates(h, b) {
+				continue
+			}
+			lp := byHeader[h]
+			if lp == nil {
+				lp = &loop{header: h, blocks: map[*Block]bool{h: true}}
+				byHeader[h] = lp
+				loops = append(loops, lp)
+			}
+			work := []*Block{b}
+			for len(work) > 0 {
+				n := work[len(work)-1]
+				work = work[:len(work)-1]
+				if lp.blocks[n] {
+					continue
+				}
+				lp.blocks[n] = true
+				work = append(work, n.Preds...)
+			}
+		}
+	}
+	sort.SliceStable(loops, func(i, j int) bool { return len(loops[i].blocks) < len(loops[j].blocks) })
+	return loops
+}
+
+// insertPreheaders splits the entry edge of every loop whose single
+// outside predecessor also branches elsewhere.
+func insertPreheaders(f *Func) {
+	dom := dominators(f)
+	split := false
+	for _, lp := range findLoops(f, dom) {
+		var outside []*Block
+		for _, p := range lp.header.Preds {
+			if !lp.blocks[p] {
+				outside = append(outside, p)
+			}
+		}
+		if len(outside) != 1 || len(outside[0].Succs) == 1 {
+			continue
+		}
+		o := outside[0]
+		idx := -1
+		for i, s := range o.Succs {
+			if s == lp.header {
+				if idx >= 0 {
+					idx = -1
+					break
+				}
+				idx = i
+			}
+		}
+		if idx < 0 {
+			continue
+		}
+
+		pre := f.newBlock()
+		pre.Kind = TermJump
+		pre.Preds = []*Block{o}
+		pre.Succs = []*Block{lp.header}
+		o.Succs[idx] = pre
+		lp.header.Preds[lp.header.predIndex(o)] = pre
+		split = true
+	}
+	if split {
+		f.Blocks = reversePostorder(f.Entry)
+	}
+}
+
+// eliminateDeadCode removes values that have no side effect, cannot fail
+// and do not feed a value that is kept.
+func eliminateDeadCode(f *Func, kinds valueKinds) bool {
+	live := make(map[*Value]bool)
+	var work []*Value
+	mark := func(v *Value) {
+		if !live[v] {
+			live[v] = true
+			work = append(work, v)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, v := range b.Instrs {
+			switch {
+			case kinds.cannotFail(v), v.Op == OpPhi, v.Op == OpCopy, v.Op == OpParam:
+			default:
+				mark(v)
+			}
+		}
+		if b.Control != nil {
+			mark(b.Control)
+		}
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, a := range v.Args {
+			mark(a)
+		}
+	}
+
+	changed := false
+	for _, b := range f.Blocks {
+		n := len(b.Instrs)
+		b.Instrs = filterValues(b.Instrs, func(v *Value) bool { return live[v] })
+		changed = changed || len(b.Instrs) != n
+	}
+	return changed
+}
+
+func filterValues(vs []*Value, keep func(*Value) bool) []*Value {
+	out := vs[:0]
+	for _, v := range vs {
+		if keep(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
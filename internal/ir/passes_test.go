@@ -0,0 +1,82 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/ir"
+)
+
+func TestLoopInvariantsLeaveLoops(t *testing.T) {
+	src := `
+fn f(n: int) -> int {
+    let sum: int = 0;
+    let i: int = 0;
+    while i < n * 2 {
+        sum = sum + n * 3 + (i * 5) % 7;
+        i = i + 1;
+    }
+    return sum;
+}
+fn main() -> int { return f(10); }
+`
+	f := liftOptimized(t, src, "f")
+
+	loops := ir.Loops(f)
+	if len(loops) != 1 {
+		t.Fatalf("expected one loop, got %d\n%s", len(loops), f)
+	}
+	for b := range loops[0] {
+		for _, v := range b.Instrs {
+			if v.Op == ir.OpMul && v.Args[0].Op == ir.OpParam {
+				t.Fatalf("invariant %s stayed in the loop\n%s", v, f)
+			}
+		}
+	}
+}
+
+func TestBoundsChecksNeedProof(t *testing.T) {
+	src := `
+fn f(n: int) -> int {
+    let a: []int = array(n);
+    let s: int = 0;
+    let i: int = 0;
+    while i < n - 1 {
+        s = s + get(a, i + 1) + get(a, i + 2) + get(a, i - 1);
+        set(a, i, s);
+        i = i + 1;
+    }
+    return s;
+}
+fn main() -> int { return f(10); }
+`
+	f := liftOptimized(t, src, "f")
+
+	checked, unchecked := 0, 0
+	for _, b := range f.Blocks {
+		for _, v := range b.Instrs {
+			switch v.Op {
+			case ir.OpArrayGet, ir.OpArraySet:
+				checked++
+			case ir.OpArrayGetUnchecked, ir.OpArraySetUnchecked:
+				unchecked++
+			}
+		}
+	}
+	if checked != 2 || unchecked != 2 {
+		t.Fatalf("got %d checked and %d unchecked accesses, want 2 and 2\n%s", checked, unchecked, f)
+	}
+}
+
+func liftOptimized(t *testing.T, src, name string) *ir.Func {
+	t.Helper()
+
+	mod := compile(t, src)
+	fn := mod.Functions[name]
+
+	f, err := ir.Lift(mod, fn)
+	if err != nil {
+		t.Fatalf("lift: %v", err)
+	}
+	f.Optimize(fn.ParamTypes)
+	return f
+}
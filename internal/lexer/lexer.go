@@ -1,21 +1,36 @@
 package lexer
 
-import "github.com/dunooo0ooo/lang/internal/token"
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dunooo0ooo/lang/internal/token"
+)
 
 type Lexer struct {
 	src []byte
 
+	// ch is the rune starting at src[i]; next is where the rune after it
+	// starts. Invalid UTF-8 reads as utf8.RuneError, one byte at a time.
 	i    int
 	next int
-	ch   byte
+	ch   rune
 
 	pos token.Position
+
+	comments []Comment
+}
+
+// Comment is a // comment; Text is what follows the slashes.
+type Comment struct {
+	Pos  token.Position
+	Text string
 }
 
 func New(input string) *Lexer {
 	l := &Lexer{
 		src: []byte(input),
-		pos: token.Position{Offset: 0, Line: 1, Col: 1},
+		pos: token.Position{Offset: 0, Line: 1, Col: 0},
 	}
 	l.readChar()
 	return l
@@ -51,7 +66,7 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '/' {
 			l.readChar()
 			l.readChar()
-			l.skipLineComment()
+			l.comments = append(l.comments, Comment{Pos: tokPos, Text: l.readLineComment()})
 			return l.NextToken()
 		}
 		if l.peekChar() == '*' {
@@ -75,6 +90,11 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			return token.Token{Type: token.EQ, Lit: "==", Pos: tokPos}
 		}
+		if l.peekChar() == '>' {
+			l.readChar()
+			l.readChar()
+			return token.Token{Type: token.FATARROW, Lit: "=>", Pos: tokPos}
+		}
 		l.readChar()
 		return token.Token{Type: token.ASSIGN, Lit: "=", Pos: tokPos}
 
@@ -123,6 +143,20 @@ func (l *Lexer) NextToken() token.Token {
 		l.readChar()
 		return token.Token{Type: token.ILLEGAL, Lit: "|", Pos: tokPos}
 
+	case '?':
+		switch l.peekChar() {
+		case '?':
+			l.readChar()
+			l.readChar()
+			return token.Token{Type: token.QQ, Lit: "??", Pos: tokPos}
+		case '.':
+			l.readChar()
+			l.readChar()
+			return token.Token{Type: token.QDOT, Lit: "?.", Pos: tokPos}
+		}
+		l.readChar()
+		return token.Token{Type: token.QUESTION, Lit: "?", Pos: tokPos}
+
 	case '(':
 		l.readChar()
 		return token.Token{Type: token.LPAREN, Lit: "(", Pos: tokPos}
@@ -144,6 +178,9 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		l.readChar()
 		return token.Token{Type: token.COLON, Lit: ":", Pos: tokPos}
+	case '.':
+		l.readChar()
+		return token.Token{Type: token.DOT, Lit: ".", Pos: tokPos}
 	case '[':
 		l.readChar()
 		return token.Token{Type: token.LBRACKET, Lit: "[", Pos: tokPos}
@@ -166,6 +203,10 @@ func (l *Lexer) NextToken() token.Token {
 		return token.Token{Type: token.CHAR, Lit: lit, Pos: tokPos}
 	}
 
+	if l.ch == utf8.RuneError && l.next-l.i == 1 {
+		l.readChar()
+		return token.Token{Type: token.ILLEGAL, Lit: "invalid UTF-8", Pos: tokPos}
+	}
 	if isLetter(l.ch) {
 		lit := l.readIdent()
 		return token.Token{Type: token.LookupIdent(lit), Lit: lit, Pos: tokPos}
@@ -178,36 +219,43 @@ func (l *Lexer) NextToken() token.Token {
 		return token.Token{Type: token.INT, Lit: lit, Pos: tokPos}
 	}
 
-	ill := string([]byte{l.ch})
+	ill := string(l.ch)
 	l.readChar()
 	return token.Token{Type: token.ILLEGAL, Lit: ill, Pos: tokPos}
 }
 
+// readChar moves on to the next rune. Columns count runes, so a
+// multi-byte character takes up one column.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.pos.Line++
+		l.pos.Col = 0
+	}
 	if l.next >= len(l.src) {
+		// EOF sits just past the last rune.
+		if l.i < len(l.src) || l.pos.Col == 0 {
+			l.pos.Col++
+		}
 		l.ch = 0
 		l.i = l.next
+		l.pos.Offset = l.i
 		return
 	}
 
-	l.ch = l.src[l.next]
+	r, size := utf8.DecodeRune(l.src[l.next:])
+	l.ch = r
 	l.i = l.next
-	l.next++
-
-	if l.ch == '\n' {
-		l.pos.Line++
-		l.pos.Col = 1
-	} else {
-		l.pos.Col++
-	}
+	l.next += size
+	l.pos.Col++
 	l.pos.Offset = l.i
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.next >= len(l.src) {
 		return 0
 	}
-	return l.src[l.next]
+	r, _ := utf8.DecodeRune(l.src[l.next:])
+	return r
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -235,9 +283,9 @@ func (l *Lexer) readCharLit() (string, bool) {
 		if l.ch == 0 {
 			return "", false
 		}
-		lit = `\` + string([]byte{l.ch})
+		lit = `\` + string(l.ch)
 	} else {
-		lit = string([]byte{l.ch})
+		lit = string(l.ch)
 	}
 	l.readChar()
 	if l.ch != '\'' {
@@ -263,12 +311,17 @@ func (l *Lexer) readNumber() (string, bool) {
 	return string(l.src[start:l.i]), isFloat
 }
 
-func (l *Lexer) skipLineComment() {
+func (l *Lexer) readLineComment() string {
+	start := l.i
 	for l.ch != 0 && l.ch != '\n' {
 		l.readChar()
 	}
+	return string(l.src[start:l.i])
 }
 
+// Comments returns the // comments of the tokens read so far.
+func (l *Lexer) Comments() []Comment { return l.comments }
+
 func (l *Lexer) skipBlockComment() bool {
 	for {
 		if l.ch == 0 {
@@ -306,10 +359,11 @@ func (l *Lexer) readString() (string, bool) {
 	}
 }
 
-func isLetter(ch byte) bool {
-	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+func isLetter(ch rune) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return ch >= '0' && ch <= '9'
 }
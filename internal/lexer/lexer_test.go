@@ -24,3 +24,77 @@ fn fact(n: int) -> int {
 		}
 	}
 }
+
+func TestLexerNullOperators(t *testing.T) {
+	l := New(`s? a ?? b a?.[0]`)
+	want := []token.Type{
+		token.IDENT, token.QUESTION,
+		token.IDENT, token.QQ, token.IDENT,
+		token.IDENT, token.QDOT, token.LBRACKET, token.INT, token.RBRACKET,
+		token.EOF,
+	}
+	for i, w := range want {
+		if tok := l.NextToken(); tok.Type != w {
+			t.Fatalf("token %d: got %v (%q), want %v", i, tok.Type, tok.Lit, w)
+		}
+	}
+}
+
+func TestLexerEnumAndMatch(t *testing.T) {
+	l := New(`enum match x => y == z = w`)
+	want := []token.Type{
+		token.ENUM, token.MATCH,
+		token.IDENT, token.FATARROW, token.IDENT, token.EQ, token.IDENT, token.ASSIGN, token.IDENT,
+		token.EOF,
+	}
+	for i, w := range want {
+		if tok := l.NextToken(); tok.Type != w {
+			t.Fatalf("token %d: got %v (%q), want %v", i, tok.Type, tok.Lit, w)
+		}
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	l := New("a // one\n/* two */ b //three")
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type != token.IDENT {
+			t.Fatalf("got %v (%q), want identifiers", tok.Type, tok.Lit)
+		}
+	}
+	got := l.Comments()
+	if len(got) != 2 || got[0].Text != " one" || got[1].Text != "three" || got[1].Pos.Line != 2 {
+		t.Fatalf("comments = %+v", got)
+	}
+}
+
+func TestLexerUnicode(t *testing.T) {
+	l := New("let héllo = \"日本\";\n  'é' '\\n' 世")
+	want := []struct {
+		typ       token.Type
+		lit       string
+		line, col int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "héllo", 1, 5},
+		{token.ASSIGN, "=", 1, 11},
+		{token.STRING, "日本", 1, 13},
+		{token.SEMICOLON, ";", 1, 17},
+		{token.CHAR, "é", 2, 3},
+		{token.CHAR, `\n`, 2, 7},
+		{token.IDENT, "世", 2, 12},
+		{token.EOF, "", 2, 13},
+	}
+	for i, w := range want {
+		tok := l.NextToken()
+		if tok.Type != w.typ || tok.Lit != w.lit || tok.Pos.Line != w.line || tok.Pos.Col != w.col {
+			t.Fatalf("token %d: got %v %q at %d:%d, want %v %q at %d:%d",
+				i, tok.Type, tok.Lit, tok.Pos.Line, tok.Pos.Col, w.typ, w.lit, w.line, w.col)
+		}
+	}
+
+	l = New("a \xff")
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.ILLEGAL || tok.Lit != "invalid UTF-8" {
+		t.Fatalf("got %v %q, want invalid UTF-8", tok.Type, tok.Lit)
+	}
+}
@@ -0,0 +1,286 @@
+package lint
+
+import (
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/sema"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// All are the lints, in the order they run.
+var All = []*Analyzer{
+	Unused, UnusedFn, Shadow, SelfAssign, ConstCompare, IntDiv, EmptyLoop,
+	MissingReturn, Unassigned, Unreachable,
+}
+
+// Lookup returns the lint with the given ID.
+func Lookup(id string) (*Analyzer, bool) {
+	for _, a := range All {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+var Unused = &Analyzer{
+	ID:       "unused",
+	Doc:      "lets and parameters that are never read; names starting with _ are exempt",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		impls := implFns(p.Prog)
+		for _, l := range p.Resolved.Locals {
+			switch {
+			case l.Reads > 0 || strings.HasPrefix(l.Name, "_"):
+			case l.Kind == sema.LocalLet:
+				p.Reportf(l.Pos, "variable %q is never used", l.Name)
+			case l.Kind == sema.LocalParam && !impls[l.Fn.Name]:
+				// The parameters of a method are the trait's to choose.
+				p.Reportf(l.Pos, "parameter %q is never used", l.Name)
+			}
+		}
+	},
+}
+
+var UnusedFn = &Analyzer{
+	ID:       "unused-fn",
+	Doc:      "functions other than main that the program never calls; the host may still call them",
+	Severity: Info,
+	Run: func(p *Pass) {
+		called := make(map[string]bool)
+		for _, it := range p.Prog.Items {
+			fn, _ := it.(*ast.FnDecl)
+			inspect(it, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				// A function calling itself is no use of it.
+				if vr, ok := call.Callee.(*ast.VarRef); ok && (fn == nil || vr.Name != fn.Name) {
+					called[vr.Name] = true
+				}
+				return true
+			})
+		}
+		for _, it := range p.Prog.Items {
+			if fn, ok := it.(*ast.FnDecl); ok && fn.Name != "main" && !called[fn.Name] {
+				p.Reportf(fn.FnPos, "function %q is never called", fn.Name)
+			}
+		}
+	},
+}
+
+var Shadow = &Analyzer{
+	ID:       "shadow",
+	Doc:      "locals hiding a local of the same name in an enclosing scope",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		for _, l := range p.Resolved.Locals {
+			if l.Shadows != nil && !strings.HasPrefix(l.Name, "_") {
+				p.Reportf(l.Pos, "%q shadows the %s declared at line %d", l.Name, kindName(l.Shadows.Kind), l.Shadows.Pos.Line)
+			}
+		}
+	},
+}
+
+var SelfAssign = &Analyzer{
+	ID:       "self-assign",
+	Doc:      "assignments of a variable to itself",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		inspectProgram(p.Prog, func(n ast.Node) bool {
+			if s, ok := n.(*ast.AssignStmt); ok {
+				if vr, ok := s.Value.(*ast.VarRef); ok && vr.Name == s.Name {
+					p.Reportf(s.NamePos, "self-assignment of %q", s.Name)
+				}
+			}
+			return true
+		})
+	},
+}
+
+var ConstCompare = &Analyzer{
+	ID:       "const-compare",
+	Doc:      "comparisons whose result does not depend on the values compared",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		inspectProgram(p.Prog, func(n ast.Node) bool {
+			if b, ok := n.(*ast.BinaryExpr); ok {
+				if v, why, ok := constCompare(p, b); ok {
+					p.Reportf(b.OpPos, "comparison is always %v: %s", v, why)
+				}
+			}
+			return true
+		})
+	},
+}
+
+var IntDiv = &Analyzer{
+	ID:       "int-div",
+	Doc:      "int divisions converted to float, which drop the fractional part first",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		inspectProgram(p.Prog, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				return true
+			}
+			if vr, ok := call.Callee.(*ast.VarRef); !ok || vr.Name != "float" {
+				return true
+			}
+			for _, b := range intDivs(p, call.Args[0]) {
+				p.Reportf(b.OpPos, "int division drops the fractional part before the conversion to float; convert the operands instead")
+			}
+			return true
+		})
+	},
+}
+
+// intDivs returns the int divisions whose result the int arithmetic e is
+// computed from, leaving out constant ones that leave no remainder.
+func intDivs(p *Pass, e ast.Expr) []*ast.BinaryExpr {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		if e.Op != token.PLUS && e.Op != token.MINUS && e.Op != token.STAR && e.Op != token.SLASH ||
+			p.Types[e].Kind != bytecode.TypeInt {
+			return nil
+		}
+		out := append(intDivs(p, e.L), intDivs(p, e.R)...)
+		if e.Op != token.SLASH {
+			return out
+		}
+		if l, ok := e.L.(*ast.IntLit); ok {
+			if r, ok := e.R.(*ast.IntLit); ok && r.Value != 0 && l.Value%r.Value == 0 {
+				return out
+			}
+		}
+		return append(out, e)
+	case *ast.UnaryExpr:
+		if e.Op == token.MINUS {
+			return intDivs(p, e.X)
+		}
+	}
+	return nil
+}
+
+var EmptyLoop = &Analyzer{
+	ID:       "empty-loop",
+	Doc:      "loops whose body does nothing",
+	Severity: Warning,
+	Run: func(p *Pass) {
+		inspectProgram(p.Prog, func(n ast.Node) bool {
+			var body *ast.BlockStmt
+			switch n := n.(type) {
+			case *ast.WhileStmt:
+				body = n.Body
+			case *ast.ForStmt:
+				body = n.Body
+			case *ast.ForInStmt:
+				body = n.Body
+			}
+			if body != nil && len(body.Stmts) == 0 && body.Tail == nil {
+				p.Reportf(n.Pos(), "empty loop body")
+			}
+			return true
+		})
+	},
+}
+
+// The lints the checker finds itself.
+var (
+	MissingReturn = checkerLint("missing-return", "functions with a result that can end without a return")
+	Unassigned    = checkerLint("unassigned", "reads of a variable that may not have a value yet")
+	Unreachable   = checkerLint("unreachable", "code that never runs")
+)
+
+func checkerLint(id, doc string) *Analyzer {
+	return &Analyzer{ID: id, Doc: doc, Severity: Warning, Run: func(p *Pass) {
+		for _, w := range p.Warnings {
+			if w.ID == id {
+				p.Reportf(w.Pos, "%s", w.Msg)
+			}
+		}
+	}}
+}
+
+// implFns returns the names of the functions implementing the methods of
+// the impls of prog.
+func implFns(prog *ast.Program) map[string]bool {
+	out := make(map[string]bool)
+	for _, it := range prog.Items {
+		if im, ok := it.(*ast.ImplDecl); ok {
+			for _, m := range im.Methods {
+				out[ast.MethodName(im.For.Name, m.Name)] = true
+			}
+		}
+	}
+	return out
+}
+
+func kindName(k sema.LocalKind) string {
+	switch k {
+	case sema.LocalParam:
+		return "parameter"
+	case sema.LocalLet:
+		return "variable"
+	}
+	return "binding"
+}
+
+// constCompare works out the result of b, if it is a comparison whose
+// result is known without running it, and why it is.
+func constCompare(p *Pass, b *ast.BinaryExpr) (result bool, why string, ok bool) {
+	switch b.Op {
+	case token.EQ, token.NEQ, token.LT, token.LTE, token.GT, token.GTE:
+	default:
+		return false, "", false
+	}
+	reflexive := b.Op == token.EQ || b.Op == token.LTE || b.Op == token.GTE
+
+	// Comparing a variable with itself, unless it may be NaN.
+	if l, ok := b.L.(*ast.VarRef); ok {
+		r, ok := b.R.(*ast.VarRef)
+		lv, lok := p.Resolved.Vars[l]
+		rv, rok := p.Resolved.Vars[r]
+		if ok && lok && rok && lv.Decl == rv.Decl && p.Types[l].NonNull().Kind != bytecode.TypeFloat {
+			return reflexive, l.Name + " is compared with itself", true
+		}
+	}
+
+	if l, ok := b.L.(*ast.IntLit); ok {
+		if r, ok := b.R.(*ast.IntLit); ok {
+			return compareInts(b.Op, l.Value, r.Value), "both sides are constants", true
+		}
+	}
+
+	// Comparing with null a value that cannot be null.
+	x := b.L
+	if _, ok := b.L.(*ast.NullLit); ok {
+		x = b.R
+	} else if _, ok := b.R.(*ast.NullLit); !ok {
+		return false, "", false
+	}
+	if t, ok := p.Types[x]; ok && (b.Op == token.EQ || b.Op == token.NEQ) && !t.IsNullable() &&
+		t.Kind != bytecode.TypeNull && t.Kind != bytecode.TypeInvalid && t.Kind != bytecode.TypeParam {
+		return b.Op == token.NEQ, "a " + t.String() + " is never null", true
+	}
+	return false, "", false
+}
+
+func compareInts(op token.Type, a, b int64) bool {
+	switch op {
+	case token.EQ:
+		return a == b
+	case token.NEQ:
+		return a != b
+	case token.LT:
+		return a < b
+	case token.LTE:
+		return a <= b
+	case token.GT:
+		return a > b
+	}
+	return a >= b
+}
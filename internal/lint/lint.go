@@ -0,0 +1,168 @@
+// Package lint finds code that compiles but is likely wrong or dead.
+//
+// Each lint is an Analyzer with an ID. Run checks a program and runs the
+// analyzers over its syntax tree and what the checker and the resolver
+// found out about it. A file turns lints off with a comment anywhere in it:
+//
+//	// lang:allow(unused, shadow)
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/sema"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// Severity is how much a finding matters. Off turns a lint off.
+type Severity int
+
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+var severityNames = []string{"off", "info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return "<?>"
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity parses the name String gives a severity.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return Off, fmt.Errorf("unknown severity %q; want off, info, warning or error", name)
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *Severity) UnmarshalText(b []byte) error {
+	v, err := ParseSeverity(string(b))
+	*s = v
+	return err
+}
+
+// Diagnostic is one finding of a lint.
+type Diagnostic struct {
+	ID       string   `json:"id"`
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line"`
+	Col      int      `json:"col"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s [%s]", d.Line, d.Col, d.Severity, d.Message, d.ID)
+}
+
+// Analyzer is a lint.
+type Analyzer struct {
+	ID  string
+	Doc string
+	// Severity is the severity of its findings unless Config says
+	// otherwise.
+	Severity Severity
+	Run      func(*Pass)
+}
+
+// Pass is what an analyzer gets to look at.
+type Pass struct {
+	Prog *ast.Program
+	// Types are the types the checker gave the expressions of Prog.
+	Types map[ast.Expr]sema.Type
+	// Resolved maps the uses of variables to their declarations.
+	Resolved sema.ResolveResult
+	// Warnings are what the checker warned about.
+	Warnings []*sema.Warning
+
+	report func(pos token.Position, msg string)
+}
+
+func (p *Pass) Reportf(pos token.Position, format string, args ...any) {
+	p.report(pos, fmt.Sprintf(format, args...))
+}
+
+// Config changes the severities of lints by ID.
+type Config map[string]Severity
+
+// Setup lets a program call functions Run does not know about, such as
+// the natives of its host, by declaring them to the checker.
+type Setup func(*sema.Checker) error
+
+// Run checks src and runs analyzers over it. It returns the errors that
+// stop src from compiling instead, if there are any.
+func Run(src string, analyzers []*Analyzer, cfg Config, setup Setup) ([]Diagnostic, []error) {
+	lx := lexer.New(src)
+	p := parser.New(lx)
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, p.Errors()
+	}
+	c := sema.New()
+	if setup != nil {
+		if err := setup(c); err != nil {
+			return nil, []error{err}
+		}
+	}
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		return nil, c.Errors()
+	}
+	r := sema.NewResolver(c.ExprType)
+	r.Resolve(prog)
+
+	allowed := allowedIn(lx.Comments())
+	var out []Diagnostic
+	for _, a := range analyzers {
+		sev, ok := cfg[a.ID]
+		if !ok {
+			sev = a.Severity
+		}
+		if sev == Off || allowed[a.ID] {
+			continue
+		}
+		pass := &Pass{Prog: prog, Types: c.ExprType, Resolved: r.Result(), Warnings: c.Warnings()}
+		pass.report = func(pos token.Position, msg string) {
+			out = append(out, Diagnostic{ID: a.ID, Severity: sev, Line: pos.Line, Col: pos.Col, Message: msg})
+		}
+		a.Run(pass)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Line != out[j].Line {
+			return out[i].Line < out[j].Line
+		}
+		return out[i].Col < out[j].Col
+	})
+	return out, nil
+}
+
+// allowedIn returns the IDs of the lints the lang:allow comments among
+// comments turn off.
+func allowedIn(comments []lexer.Comment) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, c := range comments {
+		text := strings.TrimSpace(c.Text)
+		if !strings.HasPrefix(text, "lang:allow(") || !strings.HasSuffix(text, ")") {
+			continue
+		}
+		ids := strings.TrimSuffix(strings.TrimPrefix(text, "lang:allow("), ")")
+		for _, id := range strings.Split(ids, ",") {
+			allowed[strings.TrimSpace(id)] = true
+		}
+	}
+	return allowed
+}
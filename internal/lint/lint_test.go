@@ -0,0 +1,167 @@
+package lint
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string, cfg Config) []string {
+	t.Helper()
+	diags, errs := Run(src, All, cfg, nil)
+	if len(errs) != 0 {
+		t.Fatalf("compile errors: %v", errs)
+	}
+	out := make([]string, len(diags))
+	for i, d := range diags {
+		out[i] = d.String()
+	}
+	return out
+}
+
+func TestLints(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "unused",
+			src: `fn f(a: int, _b: int) -> int { let x = 1; let _y = 2; return 0; }
+fn main() -> int { return f(1, 2); }`,
+			want: []string{
+				`1:6: warning: parameter "a" is never used [unused]`,
+				`1:32: warning: variable "x" is never used [unused]`,
+			},
+		},
+		{
+			name: "unused method parameters",
+			src: `trait T { fn f(self, k: int) -> int; }
+impl T for int { fn f(self, k: int) -> int { return 1; } }
+fn main() -> int { let n = 1; return n.f(2); }`,
+			want: nil,
+		},
+		{
+			name: "unused-fn",
+			src: `fn rec(n: int) -> int { return rec(n); }
+fn used() -> int { return 1; }
+fn main() -> int { return used(); }`,
+			want: []string{`1:1: info: function "rec" is never called [unused-fn]`},
+		},
+		{
+			name: "shadow",
+			src: `fn main() -> int {
+    let a = 1;
+    if a > 0 { let a = 2; return a; }
+    return a;
+}`,
+			want: []string{`3:16: warning: "a" shadows the variable declared at line 2 [shadow]`},
+		},
+		{
+			name: "self-assign",
+			src:  `fn main() -> int { let a = 1; a = a; return a; }`,
+			want: []string{`1:31: warning: self-assignment of "a" [self-assign]`},
+		},
+		{
+			name: "const-compare",
+			src: `fn main() -> int {
+    let a = 1;
+    let f = 0.5;
+    let s = "x";
+    if a == a { return 1; }
+    if f == f { return 2; }
+    if 1 < 2 { return 3; }
+    if s != null { return 4; }
+    return 0;
+}`,
+			want: []string{
+				`5:10: warning: comparison is always true: a is compared with itself [const-compare]`,
+				`7:10: warning: comparison is always true: both sides are constants [const-compare]`,
+				`8:10: warning: comparison is always true: a string is never null [const-compare]`,
+			},
+		},
+		{
+			name: "int-div",
+			src: `fn main() -> int {
+    let total = 7;
+    let count = 2;
+    let half: int = total / count;
+    print(float(8 / 2) + float(total) / float(count));
+    print(float(total / count) + float(-(half + total / 2)));
+    return half;
+}`,
+			want: []string{
+				`6:23: warning: int division drops the fractional part before the conversion to float; convert the operands instead [int-div]`,
+				`6:55: warning: int division drops the fractional part before the conversion to float; convert the operands instead [int-div]`,
+			},
+		},
+		{
+			name: "empty-loop",
+			src:  `fn main() -> int { let i = 0; while i > 0 { } for ;i < 3; i = i + 1 { println(i); } return i; }`,
+			want: []string{`1:31: warning: empty loop body [empty-loop]`},
+		},
+		{
+			name: "checker warnings",
+			src: `fn main() -> int {
+    let x: int;
+    return x;
+    println(1);
+}`,
+			want: []string{
+				`3:12: warning: variable "x" may be used before assignment [unassigned]`,
+				`4:5: warning: unreachable code after return [unreachable]`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := run(t, tt.src, nil)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestAllowComments(t *testing.T) {
+	src := `// lang:allow(unused, self-assign)
+fn main() -> int { let x = 1; let a = 1; a = a; if a == a { return 1; } return a; }`
+	got := run(t, src, nil)
+	if len(got) != 1 || !strings.HasSuffix(got[0], "[const-compare]") {
+		t.Fatalf("got %q, want only const-compare", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	src := `fn main() -> int { let x = 1; let a = 1; a = a; return a; }`
+	got := run(t, src, Config{"unused": Error, "self-assign": Off})
+	if len(got) != 1 || got[0] != `1:20: error: variable "x" is never used [unused]` {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	diags, errs := Run(`fn main() -> int { return true; }`, All, nil, nil)
+	if len(diags) != 0 || len(errs) != 1 {
+		t.Fatalf("got %v, %v; want one compile error", diags, errs)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	d := Diagnostic{ID: "unused", Severity: Warning, Line: 3, Col: 5, Message: "m"}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"unused","severity":"warning","line":3,"col":5,"message":"m"}`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	var back Diagnostic
+	if err := json.Unmarshal(b, &back); err != nil || back != d {
+		t.Fatalf("round trip gave %+v, %v", back, err)
+	}
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Fatal("ParseSeverity accepted an unknown name")
+	}
+}
@@ -0,0 +1,122 @@
+package lint
+
+import "github.com/dunooo0ooo/lang/internal/ast"
+
+// inspectProgram inspects the items of prog.
+func inspectProgram(prog *ast.Program, f func(ast.Node) bool) {
+	for _, it := range prog.Items {
+		inspect(it, f)
+	}
+}
+
+// inspect calls f on n and, while f returns true, on the nodes under n, in
+// the order they appear in the source. The methods of an impl are visited
+// once, as its Methods.
+func inspect(n ast.Node, f func(ast.Node) bool) {
+	if n == nil || !f(n) {
+		return
+	}
+	switch n := n.(type) {
+	case *ast.StmtItem:
+		inspect(n.S, f)
+	case *ast.FnDecl:
+		inspect(n.Body, f)
+	case *ast.ImplDecl:
+		for _, m := range n.Methods {
+			inspect(m, f)
+		}
+
+	case *ast.BlockStmt:
+		for _, s := range n.Stmts {
+			inspect(s, f)
+		}
+		if n.Tail != nil {
+			inspect(n.Tail, f)
+		}
+	case *ast.LetStmt:
+		if n.Init != nil {
+			inspect(n.Init, f)
+		}
+	case *ast.AssignStmt:
+		inspect(n.Value, f)
+	case *ast.ReturnStmt:
+		if n.Value != nil {
+			inspect(n.Value, f)
+		}
+	case *ast.IfStmt:
+		inspect(n.Cond, f)
+		inspect(n.Then, f)
+		if n.Else != nil {
+			inspect(n.Else, f)
+		}
+	case *ast.WhileStmt:
+		inspect(n.Cond, f)
+		inspect(n.Body, f)
+	case *ast.ForStmt:
+		if n.Init != nil {
+			inspect(n.Init, f)
+		}
+		if n.Cond != nil {
+			inspect(n.Cond, f)
+		}
+		if n.Post != nil {
+			inspect(n.Post, f)
+		}
+		inspect(n.Body, f)
+	case *ast.ForInStmt:
+		inspect(n.Iter, f)
+		inspect(n.Body, f)
+	case *ast.YieldStmt:
+		inspect(n.Value, f)
+	case *ast.SpawnStmt:
+		inspect(n.Call, f)
+	case *ast.TryStmt:
+		inspect(n.Body, f)
+		inspect(n.Catch, f)
+	case *ast.ExprStmt:
+		inspect(n.X, f)
+
+	case *ast.UnaryExpr:
+		inspect(n.X, f)
+	case *ast.BinaryExpr:
+		inspect(n.L, f)
+		inspect(n.R, f)
+	case *ast.CallExpr:
+		inspect(n.Callee, f)
+		for _, a := range n.Args {
+			inspect(a, f)
+		}
+	case *ast.MethodCallExpr:
+		inspect(n.X, f)
+		for _, a := range n.Args {
+			inspect(a, f)
+		}
+	case *ast.IfExpr:
+		inspect(n.Cond, f)
+		inspect(n.Then, f)
+		inspect(n.Else, f)
+	case *ast.BlockExpr:
+		inspect(n.Block, f)
+	case *ast.ArrayLit:
+		for _, el := range n.Elems {
+			inspect(el, f)
+		}
+	case *ast.IndexExpr:
+		inspect(n.X, f)
+		inspect(n.Index, f)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			inspect(n.Cap, f)
+		}
+	case *ast.PropagateExpr:
+		inspect(n.X, f)
+	case *ast.MatchExpr:
+		inspect(n.X, f)
+		for _, arm := range n.Arms {
+			if arm.Guard != nil {
+				inspect(arm.Guard, f)
+			}
+			inspect(arm.Body, f)
+		}
+	}
+}
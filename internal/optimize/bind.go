@@ -0,0 +1,178 @@
+package optimize
+
+import "github.com/dunooo0ooo/lang/internal/ast"
+
+type bindScope struct {
+	parent *bindScope
+	names  map[string]*binding
+}
+
+func newBindScope(parent *bindScope) *bindScope {
+	return &bindScope{parent: parent, names: make(map[string]*binding)}
+}
+
+func (s *bindScope) declare(name string, b *binding) {
+	s.names[name] = b
+}
+
+func (s *bindScope) lookup(name string) *binding {
+	for sc := s; sc != nil; sc = sc.parent {
+		if b, ok := sc.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// binder links every let, assignment and variable reference to the local it
+// names, mirroring the scoping rules of sema.
+type binder struct {
+	f     *Folder
+	scope *bindScope
+}
+
+func (b *binder) block(blk *ast.BlockStmt) {
+	old := b.scope
+	b.scope = newBindScope(old)
+	for _, s := range blk.Stmts {
+		b.stmt(s)
+	}
+	if blk.Tail != nil {
+		b.expr(blk.Tail)
+	}
+	b.scope = old
+}
+
+func (b *binder) stmt(s ast.Stmt) {
+	switch n := s.(type) {
+	case *ast.BlockStmt:
+		b.block(n)
+	case *ast.LetStmt:
+		if n.Init != nil {
+			b.expr(n.Init)
+		}
+		bd := &binding{}
+		b.f.lets[n] = bd
+		b.scope.declare(n.Name, bd)
+	case *ast.AssignStmt:
+		b.expr(n.Value)
+		if bd := b.scope.lookup(n.Name); bd != nil {
+			bd.assigned = true
+		}
+	case *ast.ReturnStmt:
+		if n.Value != nil {
+			b.expr(n.Value)
+		}
+	case *ast.ExprStmt:
+		b.expr(n.X)
+	case *ast.IfStmt:
+		b.expr(n.Cond)
+		b.block(n.Then)
+		if n.Else != nil {
+			b.stmt(n.Else)
+		}
+	case *ast.WhileStmt:
+		b.expr(n.Cond)
+		b.block(n.Body)
+	case *ast.ForStmt:
+		old := b.scope
+		b.scope = newBindScope(old)
+		if n.Init != nil {
+			b.stmt(n.Init)
+		}
+		if n.Cond != nil {
+			b.expr(n.Cond)
+		}
+		if n.Post != nil {
+			b.stmt(n.Post)
+		}
+		b.block(n.Body)
+		b.scope = old
+	case *ast.ForInStmt:
+		b.expr(n.Iter)
+		old := b.scope
+		b.scope = newBindScope(old)
+		b.scope.declare(n.Var, &binding{assigned: true})
+		b.block(n.Body)
+		b.scope = old
+	case *ast.YieldStmt:
+		b.expr(n.Value)
+	case *ast.SpawnStmt:
+		b.expr(n.Call)
+	case *ast.TryStmt:
+		b.block(n.Body)
+		old := b.scope
+		b.scope = newBindScope(old)
+		b.scope.declare(n.Var, &binding{assigned: true})
+		b.block(n.Catch)
+		b.scope = old
+	}
+}
+
+func (b *binder) expr(e ast.Expr) {
+	switch n := e.(type) {
+	case *ast.VarRef:
+		if bd := b.scope.lookup(n.Name); bd != nil {
+			b.f.refs[n] = bd
+		}
+	case *ast.UnaryExpr:
+		b.expr(n.X)
+	case *ast.BinaryExpr:
+		b.expr(n.L)
+		b.expr(n.R)
+	case *ast.CallExpr:
+		for _, a := range n.Args {
+			b.expr(a)
+		}
+	case *ast.MethodCallExpr:
+		b.expr(n.X)
+		for _, a := range n.Args {
+			b.expr(a)
+		}
+	case *ast.ArrayLit:
+		for _, el := range n.Elems {
+			b.expr(el)
+		}
+	case *ast.IndexExpr:
+		b.expr(n.X)
+		b.expr(n.Index)
+	case *ast.BlockExpr:
+		b.block(n.Block)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			b.expr(n.Cap)
+		}
+	case *ast.IfExpr:
+		b.expr(n.Cond)
+		b.block(n.Then)
+		b.expr(n.Else)
+	case *ast.PropagateExpr:
+		b.expr(n.X)
+	case *ast.MatchExpr:
+		b.expr(n.X)
+		for _, arm := range n.Arms {
+			old := b.scope
+			b.scope = newBindScope(old)
+			b.pattern(arm.Pat)
+			if arm.Guard != nil {
+				b.expr(arm.Guard)
+			}
+			b.expr(arm.Body)
+			b.scope = old
+		}
+	}
+}
+
+// pattern declares the names p binds. A name may also be a variant, which
+// binds nothing, but declaring it anyway only hides the outer name from
+// propagation.
+func (b *binder) pattern(p ast.Pattern) {
+	switch n := p.(type) {
+	case *ast.NamePat:
+		b.scope.declare(n.Name, &binding{assigned: true})
+	case *ast.VariantPat:
+		for _, a := range n.Args {
+			b.pattern(a)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package optimize
+
+import (
+	"strconv"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// Folder rewrites a type-checked program in place: it folds constant
+// expressions over literals, drops branches whose condition is known,
+// removes statements after a return and propagates literals bound by
+// let locals that are never reassigned.
+//
+// Folding never hides a runtime error: integer division or modulo by a
+// constant zero is left for the VM to report.
+type Folder struct {
+	lets   map[*ast.LetStmt]*binding
+	refs   map[*ast.VarRef]*binding
+	consts map[*binding]ast.Expr
+}
+
+type binding struct {
+	assigned bool
+}
+
+func NewFolder() *Folder {
+	return &Folder{
+		lets:   make(map[*ast.LetStmt]*binding),
+		refs:   make(map[*ast.VarRef]*binding),
+		consts: make(map[*binding]ast.Expr),
+	}
+}
+
+func (f *Folder) Fold(prog *ast.Program) {
+	b := &binder{f: f}
+	for _, it := range prog.Items {
+		switch n := it.(type) {
+		case *ast.FnDecl:
+			b.scope = newBindScope(nil)
+			for _, p := range n.Params {
+				b.scope.declare(p.Name, &binding{assigned: true})
+			}
+			b.block(n.Body)
+		case *ast.ImplDecl:
+			for _, m := range n.Methods {
+				b.scope = newBindScope(nil)
+				for _, p := range m.Params {
+					b.scope.declare(p.Name, &binding{assigned: true})
+				}
+				b.block(m.Body)
+			}
+		case *ast.StmtItem:
+			b.scope = newBindScope(nil)
+			b.stmt(n.S)
+		}
+	}
+
+	for _, it := range prog.Items {
+		switch n := it.(type) {
+		case *ast.FnDecl:
+			f.foldBlock(n.Body)
+		case *ast.ImplDecl:
+			for _, m := range n.Methods {
+				f.foldBlock(m.Body)
+			}
+		case *ast.StmtItem:
+			if s := f.foldStmt(n.S); s != nil {
+				n.S = s
+			} else {
+				n.S = &ast.BlockStmt{Lbrace: n.S.Pos()}
+			}
+		}
+	}
+}
+
+func (f *Folder) foldBlock(b *ast.BlockStmt) {
+	out := b.Stmts[:0]
+	for _, s := range b.Stmts {
+		s = f.foldStmt(s)
+		if s == nil {
+			continue
+		}
+		out = append(out, s)
+		if terminates(s) {
+			b.Stmts = out
+			b.Tail = nil
+			return
+		}
+	}
+	b.Stmts = out
+
+	if b.Tail != nil {
+		b.Tail = f.foldExpr(b.Tail)
+	}
+}
+
+// foldStmt returns the folded statement, or nil if it can be dropped.
+func (f *Folder) foldStmt(s ast.Stmt) ast.Stmt {
+	switch n := s.(type) {
+	case *ast.BlockStmt:
+		f.foldBlock(n)
+	case *ast.LetStmt:
+		if n.Init != nil {
+			n.Init = f.foldExpr(n.Init)
+			if bd := f.lets[n]; bd != nil && !bd.assigned && isLiteral(n.Init) {
+				f.consts[bd] = n.Init
+			}
+		}
+	case *ast.AssignStmt:
+		n.Value = f.foldExpr(n.Value)
+	case *ast.ReturnStmt:
+		if n.Value != nil {
+			n.Value = f.foldExpr(n.Value)
+		}
+	case *ast.ExprStmt:
+		n.X = f.foldExpr(n.X)
+	case *ast.IfStmt:
+		n.Cond = f.foldExpr(n.Cond)
+		if c, ok := n.Cond.(*ast.BoolLit); ok {
+			if c.Value {
+				f.foldBlock(n.Then)
+				return n.Then
+			}
+			if n.Else == nil {
+				return nil
+			}
+			return f.foldStmt(n.Else)
+		}
+		f.foldBlock(n.Then)
+		if n.Else != nil {
+			n.Else = f.foldStmt(n.Else)
+		}
+	case *ast.WhileStmt:
+		n.Cond = f.foldExpr(n.Cond)
+		if c, ok := n.Cond.(*ast.BoolLit); ok && !c.Value {
+			return nil
+		}
+		f.foldBlock(n.Body)
+	case *ast.ForStmt:
+		if n.Init != nil {
+			n.Init = f.foldStmt(n.Init)
+		}
+		if n.Cond != nil {
+			n.Cond = f.foldExpr(n.Cond)
+			if c, ok := n.Cond.(*ast.BoolLit); ok && !c.Value {
+				if n.Init == nil {
+					return nil
+				}
+				return &ast.BlockStmt{Lbrace: n.ForPos, Stmts: []ast.Stmt{n.Init}}
+			}
+		}
+		if n.Post != nil {
+			n.Post = f.foldStmt(n.Post)
+		}
+		f.foldBlock(n.Body)
+	case *ast.ForInStmt:
+		n.Iter = f.foldExpr(n.Iter)
+		f.foldBlock(n.Body)
+	case *ast.YieldStmt:
+		n.Value = f.foldExpr(n.Value)
+	case *ast.SpawnStmt:
+		f.foldExpr(n.Call)
+	case *ast.TryStmt:
+		f.foldBlock(n.Body)
+		f.foldBlock(n.Catch)
+	}
+	return s
+}
+
+func (f *Folder) foldExpr(e ast.Expr) ast.Expr {
+	switch n := e.(type) {
+	case *ast.VarRef:
+		if bd := f.refs[n]; bd != nil {
+			if lit, ok := f.consts[bd]; ok {
+				return relocate(lit, n.NamePos)
+			}
+		}
+	case *ast.UnaryExpr:
+		n.X = f.foldExpr(n.X)
+		if v := foldUnary(n); v != nil {
+			return v
+		}
+	case *ast.BinaryExpr:
+		n.L = f.foldExpr(n.L)
+		n.R = f.foldExpr(n.R)
+		if v := foldBinary(n); v != nil {
+			return v
+		}
+	case *ast.CallExpr:
+		for i := range n.Args {
+			n.Args[i] = f.foldExpr(n.Args[i])
+		}
+	case *ast.MethodCallExpr:
+		n.X = f.foldExpr(n.X)
+		for i := range n.Args {
+			n.Args[i] = f.foldExpr(n.Args[i])
+		}
+	case *ast.ArrayLit:
+		for i := range n.Elems {
+			n.Elems[i] = f.foldExpr(n.Elems[i])
+		}
+	case *ast.IndexExpr:
+		n.X = f.foldExpr(n.X)
+		n.Index = f.foldExpr(n.Index)
+	case *ast.BlockExpr:
+		f.foldBlock(n.Block)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			n.Cap = f.foldExpr(n.Cap)
+		}
+	case *ast.IfExpr:
+		n.Cond = f.foldExpr(n.Cond)
+		if c, ok := n.Cond.(*ast.BoolLit); ok {
+			if c.Value {
+				f.foldBlock(n.Then)
+				return &ast.BlockExpr{Block: n.Then}
+			}
+			return f.foldExpr(n.Else)
+		}
+		f.foldBlock(n.Then)
+		n.Else = f.foldExpr(n.Else)
+	case *ast.PropagateExpr:
+		n.X = f.foldExpr(n.X)
+	case *ast.MatchExpr:
+		n.X = f.foldExpr(n.X)
+		for i := range n.Arms {
+			if n.Arms[i].Guard != nil {
+				n.Arms[i].Guard = f.foldExpr(n.Arms[i].Guard)
+			}
+			n.Arms[i].Body = f.foldExpr(n.Arms[i].Body)
+		}
+	}
+	return e
+}
+
+func foldUnary(u *ast.UnaryExpr) ast.Expr {
+	switch x := u.X.(type) {
+	case *ast.IntLit:
+		if u.Op == token.MINUS {
+			return intLit(u.OpPos, -x.Value)
+		}
+	case *ast.FloatLit:
+		if u.Op == token.MINUS {
+			return floatLit(u.OpPos, -x.Value)
+		}
+	case *ast.BoolLit:
+		if u.Op == token.BANG {
+			return &ast.BoolLit{BoolPos: u.OpPos, Value: !x.Value}
+		}
+	}
+	return nil
+}
+
+func foldBinary(b *ast.BinaryExpr) ast.Expr {
+	pos := b.L.Pos()
+
+	if b.Op == token.AND || b.Op == token.OR {
+		l, ok := b.L.(*ast.BoolLit)
+		if !ok {
+			return nil
+		}
+		// false && x, true || x: x is never evaluated.
+		if l.Value == (b.Op == token.OR) {
+			return &ast.BoolLit{BoolPos: pos, Value: l.Value}
+		}
+		return b.R
+	}
+
+	if b.Op == token.QQ {
+		if _, ok := b.L.(*ast.NullLit); ok {
+			return b.R
+		}
+		return nil
+	}
+
+	switch l := b.L.(type) {
+	case *ast.IntLit:
+		r, ok := b.R.(*ast.IntLit)
+		if !ok {
+			return nil
+		}
+		return foldInt(pos, b.Op, l.Value, r.Value)
+	case *ast.FloatLit:
+		r, ok := b.R.(*ast.FloatLit)
+		if !ok {
+			return nil
+		}
+		return foldFloat(pos, b.Op, l.Value, r.Value)
+	case *ast.BoolLit:
+		r, ok := b.R.(*ast.BoolLit)
+		if !ok {
+			return nil
+		}
+		switch b.Op {
+		case token.EQ:
+			return &ast.BoolLit{BoolPos: pos, Value: l.Value == r.Value}
+		case token.NEQ:
+			return &ast.BoolLit{BoolPos: pos, Value: l.Value != r.Value}
+		}
+	case *ast.StringLit:
+		r, ok := b.R.(*ast.StringLit)
+		if !ok {
+			return nil
+		}
+		switch b.Op {
+		case token.EQ:
+			return &ast.BoolLit{BoolPos: pos, Value: l.Value == r.Value}
+		case token.NEQ:
+			return &ast.BoolLit{BoolPos: pos, Value: l.Value != r.Value}
+		}
+	}
+	return nil
+}
+
+func foldInt(pos token.Position, op token.Type, a, b int64) ast.Expr {
+	switch op {
+	case token.PLUS:
+		return intLit(pos, a+b)
+	case token.MINUS:
+		return intLit(pos, a-b)
+	case token.STAR:
+		return intLit(pos, a*b)
+	case token.SLASH:
+		if b == 0 {
+			return nil
+		}
+		return intLit(pos, a/b)
+	case token.PERCENT:
+		if b == 0 {
+			return nil
+		}
+		return intLit(pos, a%b)
+	case token.EQ:
+		return &ast.BoolLit{BoolPos: pos, Value: a == b}
+	case token.NEQ:
+		return &ast.BoolLit{BoolPos: pos, Value: a != b}
+	case token.LT:
+		return &ast.BoolLit{BoolPos: pos, Value: a < b}
+	case token.LTE:
+		return &ast.BoolLit{BoolPos: pos, Value: a <= b}
+	case token.GT:
+		return &ast.BoolLit{BoolPos: pos, Value: a > b}
+	case token.GTE:
+		return &ast.BoolLit{BoolPos: pos, Value: a >= b}
+	}
+	return nil
+}
+
+func foldFloat(pos token.Position, op token.Type, a, b float64) ast.Expr {
+	switch op {
+	case token.PLUS:
+		return floatLit(pos, a+b)
+	case token.MINUS:
+		return floatLit(pos, a-b)
+	case token.STAR:
+		return floatLit(pos, a*b)
+	case token.SLASH:
+		return floatLit(pos, a/b)
+	case token.EQ:
+		return &ast.BoolLit{BoolPos: pos, Value: a == b}
+	case token.NEQ:
+		return &ast.BoolLit{BoolPos: pos, Value: a != b}
+	case token.LT:
+		return &ast.BoolLit{BoolPos: pos, Value: a < b}
+	case token.LTE:
+		return &ast.BoolLit{BoolPos: pos, Value: a <= b}
+	case token.GT:
+		return &ast.BoolLit{BoolPos: pos, Value: a > b}
+	case token.GTE:
+		return &ast.BoolLit{BoolPos: pos, Value: a >= b}
+	}
+	return nil
+}
+
+func intLit(pos token.Position, v int64) *ast.IntLit {
+	return &ast.IntLit{IntPos: pos, Value: v, Raw: strconv.FormatInt(v, 10)}
+}
+
+func floatLit(pos token.Position, v float64) *ast.FloatLit {
+	return &ast.FloatLit{Pos0: pos, Value: v, Raw: strconv.FormatFloat(v, 'g', -1, 64)}
+}
+
+func isLiteral(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.IntLit, *ast.FloatLit, *ast.BoolLit, *ast.StringLit:
+		return true
+	default:
+		return false
+	}
+}
+
+// relocate returns a copy of the literal lit positioned at pos.
+func relocate(lit ast.Expr, pos token.Position) ast.Expr {
+	switch l := lit.(type) {
+	case *ast.IntLit:
+		return &ast.IntLit{IntPos: pos, Value: l.Value, Raw: l.Raw}
+	case *ast.FloatLit:
+		return &ast.FloatLit{Pos0: pos, Value: l.Value, Raw: l.Raw}
+	case *ast.BoolLit:
+		return &ast.BoolLit{BoolPos: pos, Value: l.Value}
+	case *ast.StringLit:
+		return &ast.StringLit{Pos0: pos, Value: l.Value}
+	default:
+		return lit
+	}
+}
+
+// terminates reports whether control never falls through s.
+func terminates(s ast.Stmt) bool {
+	switch n := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		return len(n.Stmts) > 0 && terminates(n.Stmts[len(n.Stmts)-1])
+	case *ast.IfStmt:
+		return n.Else != nil && terminates(n.Then) && terminates(n.Else)
+	case *ast.TryStmt:
+		return terminates(n.Body) && terminates(n.Catch)
+	default:
+		return false
+	}
+}
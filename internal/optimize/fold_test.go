@@ -0,0 +1,122 @@
+package optimize
+
+import (
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+func foldMain(t *testing.T, src string) *ast.BlockStmt {
+	t.Helper()
+
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := sema.New()
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("sema errors: %v", c.Errors())
+	}
+
+	NewFolder().Fold(prog)
+
+	for _, it := range prog.Items {
+		if fn, ok := it.(*ast.FnDecl); ok && fn.Name == "main" {
+			return fn.Body
+		}
+	}
+	t.Fatal("no main")
+	return nil
+}
+
+func returnedValue(t *testing.T, body *ast.BlockStmt) ast.Expr {
+	t.Helper()
+
+	ret, ok := body.Stmts[len(body.Stmts)-1].(*ast.ReturnStmt)
+	if !ok {
+		t.Fatalf("last stmt is %T, want return", body.Stmts[len(body.Stmts)-1])
+	}
+	return ret.Value
+}
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want any
+	}{
+		{"int arithmetic", `fn main() -> int { return 2 + 3 * 4 - 10 / 2; }`, int64(9)},
+		{"float arithmetic", `fn main() -> float { return 1.5 * 2.0; }`, 3.0},
+		{"comparison and logic", `fn main() -> bool { return 1 < 2 && !(3 == 4); }`, true},
+		{"string equality", `fn main() -> bool { return "a" != "b"; }`, true},
+		{"if expr", `fn main() -> int { return if 1 < 2 { 10 } else { 20 }; }`, nil},
+		{"let propagation", `fn main() -> int { let x: int = 4; let y: int = x * x; return y + 1; }`, int64(17)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := returnedValue(t, foldMain(t, tt.src))
+			switch want := tt.want.(type) {
+			case int64:
+				lit, ok := v.(*ast.IntLit)
+				if !ok || lit.Value != want {
+					t.Fatalf("got %#v, want int %d", v, want)
+				}
+			case float64:
+				lit, ok := v.(*ast.FloatLit)
+				if !ok || lit.Value != want {
+					t.Fatalf("got %#v, want float %v", v, want)
+				}
+			case bool:
+				lit, ok := v.(*ast.BoolLit)
+				if !ok || lit.Value != want {
+					t.Fatalf("got %#v, want bool %v", v, want)
+				}
+			case nil:
+				blk, ok := v.(*ast.BlockExpr)
+				if !ok || len(blk.Block.Stmts) != 0 {
+					t.Fatalf("got %#v, want then-block", v)
+				}
+			}
+		})
+	}
+}
+
+func TestFoldKeepsRuntimeErrorsAndReassignedLocals(t *testing.T) {
+	body := foldMain(t, `
+fn main() -> int {
+    let x: int = 1;
+    x = x + 1;
+    return x / 0;
+}
+`)
+	bin, ok := returnedValue(t, body).(*ast.BinaryExpr)
+	if !ok {
+		t.Fatalf("division by zero was folded: %#v", returnedValue(t, body))
+	}
+	if _, ok := bin.L.(*ast.VarRef); !ok {
+		t.Fatalf("reassigned local was propagated: %#v", bin.L)
+	}
+}
+
+func TestFoldDeadCode(t *testing.T) {
+	body := foldMain(t, `
+fn main() -> int {
+    while false { println(1); }
+    if 2 < 1 { return 1; } else { println(2); }
+    return 3;
+    println(4);
+}
+`)
+	if len(body.Stmts) != 2 {
+		t.Fatalf("got %d stmts, want else-block and return", len(body.Stmts))
+	}
+	if _, ok := body.Stmts[0].(*ast.BlockStmt); !ok {
+		t.Fatalf("stmt 0 is %T, want else block", body.Stmts[0])
+	}
+}
@@ -44,6 +44,26 @@ func (p *Parser) parseItem() ast.Item {
 	if p.cur.Type == token.FN {
 		return p.parseFnDecl()
 	}
+	if p.cur.Type == token.ENUM {
+		return p.parseEnumDecl()
+	}
+	if p.cur.Type == token.TRAIT {
+		return p.parseTraitDecl()
+	}
+	if p.cur.Type == token.IMPL {
+		return p.parseImplDecl()
+	}
+	if p.cur.Type == token.GEN {
+		genPos := p.cur.Pos
+		p.advance()
+		fn := p.parseFnDecl()
+		if fn == nil {
+			return nil
+		}
+		fn.FnPos = genPos
+		fn.IsGen = true
+		return fn
+	}
 	s := p.parseStmt()
 	if s == nil {
 		return nil
@@ -56,8 +76,34 @@ func (p *Parser) parseFnDecl() *ast.FnDecl {
 	p.expect(token.FN)
 
 	nameTok := p.expect(token.IDENT)
+	typeParams := p.parseTypeParams()
 	p.expect(token.LPAREN)
+	params := p.parseParams()
+	p.expect(token.RPAREN)
+
+	var ret *ast.TypeRef
+	if p.cur.Type == token.ARROW {
+		p.advance()
+		ret = p.parseTypeRef()
+	}
+
+	body := p.parseBlockStmt()
+	if body == nil {
+		p.errorf(fnPos, "expected function body")
+		return nil
+	}
+
+	return &ast.FnDecl{
+		FnPos:      fnPos,
+		Name:       nameTok.Lit,
+		TypeParams: typeParams,
+		Params:     params,
+		RetType:    ret,
+		Body:       body,
+	}
+}
 
+func (p *Parser) parseParams() []ast.Param {
 	var params []ast.Param
 	if p.cur.Type != token.RPAREN {
 		for {
@@ -72,42 +118,193 @@ func (p *Parser) parseFnDecl() *ast.FnDecl {
 			p.advance()
 		}
 	}
-	p.expect(token.RPAREN)
+	return params
+}
 
-	var ret *ast.TypeRef
+// parseMethodHead parses fn name(self, params) -> ret, up to the body.
+func (p *Parser) parseMethodHead() (name token.Token, self token.Position, params []ast.Param, ret *ast.TypeRef) {
+	p.expect(token.FN)
+	name = p.expect(token.IDENT)
+	p.expect(token.LPAREN)
+	selfTok := p.expect(token.IDENT)
+	if selfTok.Lit != "self" {
+		p.errorf(selfTok.Pos, "first parameter of method %q must be self", name.Lit)
+	}
+	if p.cur.Type == token.COMMA {
+		p.advance()
+		params = p.parseParams()
+	}
+	p.expect(token.RPAREN)
 	if p.cur.Type == token.ARROW {
 		p.advance()
 		ret = p.parseTypeRef()
 	}
+	return name, selfTok.Pos, params, ret
+}
 
-	body := p.parseBlockStmt()
-	if body == nil {
-		p.errorf(fnPos, "expected function body")
+func (p *Parser) parseTraitDecl() *ast.TraitDecl {
+	d := &ast.TraitDecl{TraitPos: p.cur.Pos}
+	p.expect(token.TRAIT)
+	d.Name = p.expect(token.IDENT).Lit
+	p.expect(token.LBRACE)
+	for p.cur.Type == token.FN {
+		name, _, params, ret := p.parseMethodHead()
+		p.expect(token.SEMICOLON)
+		d.Methods = append(d.Methods, ast.TraitMethod{Name: name.Lit, Params: params, RetType: ret, Pos: name.Pos})
+	}
+	p.expect(token.RBRACE)
+	return d
+}
+
+func (p *Parser) parseImplDecl() *ast.ImplDecl {
+	d := &ast.ImplDecl{ImplPos: p.cur.Pos}
+	p.expect(token.IMPL)
+	traitTok := p.expect(token.IDENT)
+	d.Trait, d.TraitPos = traitTok.Lit, traitTok.Pos
+	p.expect(token.FOR)
+	d.For = *p.parseTypeRef()
+	p.expect(token.LBRACE)
+	for p.cur.Type == token.FN {
+		fnPos := p.cur.Pos
+		name, self, params, ret := p.parseMethodHead()
+		body := p.parseBlockStmt()
+		params = append([]ast.Param{{Name: "self", Type: d.For, Pos: self}}, params...)
+		d.Methods = append(d.Methods, &ast.FnDecl{FnPos: fnPos, Name: name.Lit, Params: params, RetType: ret, Body: body})
+	}
+	p.expect(token.RBRACE)
+	return d
+}
+
+// parseTypeParams parses the optional <T: Bound, U> of a generic fn or
+// enum.
+func (p *Parser) parseTypeParams() []ast.TypeParam {
+	if p.cur.Type != token.LT {
 		return nil
 	}
+	p.advance()
+	var out []ast.TypeParam
+	for p.cur.Type != token.GT && p.cur.Type != token.EOF {
+		id := p.expect(token.IDENT)
+		tp := ast.TypeParam{Name: id.Lit, Pos: id.Pos}
+		if p.cur.Type == token.COLON {
+			p.advance()
+			tp.Bound = p.expect(token.IDENT).Lit
+		}
+		out = append(out, tp)
+		if p.cur.Type != token.COMMA {
+			break
+		}
+		p.advance()
+	}
+	p.expect(token.GT)
+	return out
+}
 
-	return &ast.FnDecl{
-		FnPos:   fnPos,
-		Name:    nameTok.Lit,
-		Params:  params,
-		RetType: ret,
-		Body:    body,
+// parseTypeRef parses a type with an optional trailing '?'. The '?' makes
+// the whole type nullable, so []int? is a nullable array; [](int?) holds
+// nullable elements.
+func (p *Parser) parseEnumDecl() *ast.EnumDecl {
+	pos := p.cur.Pos
+	p.expect(token.ENUM)
+	nameTok := p.expect(token.IDENT)
+	typeParams := p.parseTypeParams()
+	p.expect(token.LBRACE)
+
+	var variants []ast.Variant
+	for p.cur.Type != token.RBRACE && p.cur.Type != token.EOF {
+		vTok := p.expect(token.IDENT)
+		v := ast.Variant{Name: vTok.Lit, Pos: vTok.Pos}
+		if p.cur.Type == token.LPAREN {
+			p.advance()
+			for p.cur.Type != token.RPAREN && p.cur.Type != token.EOF {
+				v.Fields = append(v.Fields, *p.parseTypeRef())
+				if p.cur.Type != token.COMMA {
+					break
+				}
+				p.advance()
+			}
+			p.expect(token.RPAREN)
+		}
+		variants = append(variants, v)
+		if p.cur.Type != token.COMMA {
+			break
+		}
+		p.advance()
 	}
+	p.expect(token.RBRACE)
+	return &ast.EnumDecl{EnumPos: pos, Name: nameTok.Lit, TypeParams: typeParams, Variants: variants}
 }
 
 func (p *Parser) parseTypeRef() *ast.TypeRef {
+	ty := p.parseBaseType()
+	if p.cur.Type == token.QUESTION {
+		p.advance()
+		ty.Nullable = true
+	}
+	return ty
+}
+
+func (p *Parser) parseBaseType() *ast.TypeRef {
 	tok := p.cur
 
 	if tok.Type == token.LBRACKET && p.peek.Type == token.RBRACKET {
 		lpos := tok.Pos
 		p.advance()
 		p.advance()
-		elem := p.parseTypeRef()
+		elem := p.parseBaseType()
 		return &ast.TypeRef{Name: "array", Elem: elem, Pos: lpos}
 	}
 
+	if tok.Type == token.LPAREN {
+		p.advance()
+		ty := p.parseTypeRef()
+		p.expect(token.RPAREN)
+		return ty
+	}
+
+	if tok.Type == token.IDENT && tok.Lit == "iter" && p.peek.Type == token.LT {
+		p.advance()
+		p.advance()
+		elem := p.parseTypeRef()
+		p.expect(token.GT)
+		return &ast.TypeRef{Name: "iter", Elem: elem, Pos: tok.Pos}
+	}
+
+	if tok.Type == token.IDENT && tok.Lit == "Result" && p.peek.Type == token.LT {
+		p.advance()
+		p.advance()
+		elem := p.parseTypeRef()
+		p.expect(token.COMMA)
+		errTy := p.parseTypeRef()
+		p.expect(token.GT)
+		return &ast.TypeRef{Name: "Result", Elem: elem, Err: errTy, Pos: tok.Pos}
+	}
+
+	if tok.Type == token.CHAN {
+		p.advance()
+		p.expect(token.LT)
+		elem := p.parseTypeRef()
+		p.expect(token.GT)
+		return &ast.TypeRef{Name: "chan", Elem: elem, Pos: tok.Pos}
+	}
+
+	if tok.Type == token.IDENT && p.peek.Type == token.LT {
+		p.advance()
+		p.advance()
+		ty := &ast.TypeRef{Name: tok.Lit, Pos: tok.Pos}
+		for p.cur.Type != token.GT && p.cur.Type != token.EOF {
+			ty.Args = append(ty.Args, *p.parseTypeRef())
+			if p.cur.Type != token.COMMA {
+				break
+			}
+			p.advance()
+		}
+		p.expect(token.GT)
+		return ty
+	}
+
 	switch tok.Type {
-	case token.INT_T, token.BOOL_T, token.FLOAT_T, token.STRING_T, token.CHAR_T, token.VOID_T:
+	case token.INT_T, token.BOOL_T, token.FLOAT_T, token.STRING_T, token.CHAR_T, token.VOID_T, token.IDENT:
 		p.advance()
 		return &ast.TypeRef{Name: tok.Lit, Pos: tok.Pos}
 	default:
@@ -131,6 +328,12 @@ func (p *Parser) parseStmt() ast.Stmt {
 		return p.parseWhileStmt()
 	case token.FOR:
 		return p.parseForStmt()
+	case token.YIELD:
+		return p.parseYieldStmt()
+	case token.SPAWN:
+		return p.parseSpawnStmt()
+	case token.TRY:
+		return p.parseTryStmt()
 	default:
 		return p.parseExprOrAssignStmt()
 	}
@@ -145,7 +348,7 @@ func (p *Parser) parseBlockStmt() *ast.BlockStmt {
 
 	for p.cur.Type != token.RBRACE && p.cur.Type != token.EOF {
 		switch p.cur.Type {
-		case token.LBRACE, token.LET, token.RETURN, token.IF, token.WHILE, token.FOR:
+		case token.LBRACE, token.LET, token.RETURN, token.IF, token.WHILE, token.FOR, token.YIELD, token.SPAWN, token.TRY:
 			s := p.parseStmt()
 			if s != nil {
 				stmts = append(stmts, s)
@@ -180,6 +383,12 @@ func (p *Parser) parseBlockStmt() *ast.BlockStmt {
 				break
 			}
 
+			// A match used as a statement needs no ';', like if.
+			if _, ok := x.(*ast.MatchExpr); ok {
+				stmts = append(stmts, &ast.ExprStmt{ExprPos: exprPos, X: x})
+				continue
+			}
+
 			p.errorf(p.cur.Pos, "expected ';' or '}', got %v", p.cur.Type)
 			if p.cur.Type != token.EOF {
 				p.advance()
@@ -199,12 +408,14 @@ func (p *Parser) startsExpr(t token.Type) bool {
 	switch t {
 	case token.INT, token.FLOAT, token.STRING, token.CHAR, token.NULL,
 		token.TRUE, token.FALSE,
-		token.IDENT,
+		token.IDENT, token.FLOAT_T,
 		token.MINUS, token.BANG,
 		token.LPAREN,
 		token.LBRACE,
 		token.IF,
-		token.LBRACKET:
+		token.LBRACKET,
+		token.CHAN,
+		token.MATCH:
 		return true
 	default:
 		return false
@@ -232,7 +443,7 @@ func (p *Parser) parseLetStmt(withSemi bool) *ast.LetStmt {
 		p.expect(token.SEMICOLON)
 	}
 
-	return &ast.LetStmt{LetPos: letPos, Name: nameTok.Lit, Type: ty, Init: init}
+	return &ast.LetStmt{LetPos: letPos, NamePos: nameTok.Pos, Name: nameTok.Lit, Type: ty, Init: init}
 }
 
 func (p *Parser) parseReturnStmt() *ast.ReturnStmt {
@@ -248,6 +459,40 @@ func (p *Parser) parseReturnStmt() *ast.ReturnStmt {
 	return &ast.ReturnStmt{RetPos: pos, Value: val}
 }
 
+func (p *Parser) parseYieldStmt() *ast.YieldStmt {
+	pos := p.cur.Pos
+	p.expect(token.YIELD)
+
+	val := p.parseExpr(precLowest)
+	p.expect(token.SEMICOLON)
+	return &ast.YieldStmt{YieldPos: pos, Value: val}
+}
+
+func (p *Parser) parseSpawnStmt() ast.Stmt {
+	pos := p.cur.Pos
+	p.expect(token.SPAWN)
+
+	x := p.parseExpr(precLowest)
+	p.expect(token.SEMICOLON)
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		p.errorf(x.Pos(), "spawn expects a function call")
+		return nil
+	}
+	return &ast.SpawnStmt{SpawnPos: pos, Call: call}
+}
+
+func (p *Parser) parseTryStmt() *ast.TryStmt {
+	pos := p.cur.Pos
+	p.expect(token.TRY)
+
+	body := p.parseBlockStmt()
+	p.expect(token.CATCH)
+	nameTok := p.expect(token.IDENT)
+	catch := p.parseBlockStmt()
+	return &ast.TryStmt{TryPos: pos, Body: body, Var: nameTok.Lit, VarPos: nameTok.Pos, Catch: catch}
+}
+
 func (p *Parser) parseIfStmt() *ast.IfStmt {
 	pos := p.cur.Pos
 	p.expect(token.IF)
@@ -277,10 +522,19 @@ func (p *Parser) parseWhileStmt() *ast.WhileStmt {
 	return &ast.WhileStmt{WhilePos: pos, Cond: cond, Body: body}
 }
 
-func (p *Parser) parseForStmt() *ast.ForStmt {
+func (p *Parser) parseForStmt() ast.Stmt {
 	pos := p.cur.Pos
 	p.expect(token.FOR)
 
+	if p.cur.Type == token.IDENT && p.peek.Type == token.IN {
+		nameTok := p.cur
+		p.advance()
+		p.advance()
+		iter := p.parseExpr(precLowest)
+		body := p.parseBlockStmt()
+		return &ast.ForInStmt{ForPos: pos, Var: nameTok.Lit, VarPos: nameTok.Pos, Iter: iter, Body: body}
+	}
+
 	var init ast.Stmt
 	if p.cur.Type != token.SEMICOLON {
 		if p.cur.Type == token.LET {
@@ -364,6 +618,13 @@ func (p *Parser) parseExpr(min prec) ast.Expr {
 	case token.IDENT:
 		left = &ast.VarRef{NamePos: p.cur.Pos, Name: p.cur.Lit}
 		p.advance()
+	case token.FLOAT_T:
+		// float(n) converts an int; the type name is the builtin's.
+		left = &ast.VarRef{NamePos: p.cur.Pos, Name: "float"}
+		p.advance()
+		if p.cur.Type != token.LPAREN {
+			p.errorf(p.cur.Pos, "expected ( after float in expression, got %v", p.cur.Type)
+		}
 	case token.MINUS, token.BANG:
 		opTok := p.cur
 		p.advance()
@@ -380,6 +641,10 @@ func (p *Parser) parseExpr(min prec) ast.Expr {
 		left = p.parseIfExpr()
 	case token.LBRACKET:
 		left = p.parseArrayLit()
+	case token.CHAN:
+		left = p.parseChanExpr()
+	case token.MATCH:
+		left = p.parseMatchExpr()
 	default:
 		p.errorf(p.cur.Pos, "unexpected token in expression: %v", p.cur.Type)
 		p.advance()
@@ -399,11 +664,26 @@ func (p *Parser) parseExpr(min prec) ast.Expr {
 		case token.LBRACKET:
 
 			left = p.parseIndex(left)
+		case token.QDOT:
+			p.advance()
+			ix := p.parseIndex(left).(*ast.IndexExpr)
+			ix.Safe = true
+			left = ix
+		case token.QUESTION:
+			left = &ast.PropagateExpr{X: left, QPos: p.cur.Pos}
+			p.advance()
+		case token.DOT:
+			left = p.parseMethodCall(left)
 		default:
 
 			opTok := p.cur
 			p.advance()
-			right := p.parseExpr(opPrec + 1)
+			// ?? groups to the right: a ?? b ?? c is a ?? (b ?? c).
+			next := opPrec + 1
+			if opTok.Type == token.QQ {
+				next = opPrec
+			}
+			right := p.parseExpr(next)
 			left = &ast.BinaryExpr{OpPos: opTok.Pos, Op: opTok.Type, L: left, R: right}
 		}
 	}
@@ -458,6 +738,94 @@ func (p *Parser) parseArrayLit() ast.Expr {
 	return &ast.ArrayLit{Lbrack: lb, Elems: elems}
 }
 
+func (p *Parser) parseChanExpr() ast.Expr {
+	pos := p.cur.Pos
+	p.expect(token.CHAN)
+	p.expect(token.LT)
+	elem := p.parseTypeRef()
+	p.expect(token.GT)
+
+	p.expect(token.LPAREN)
+	var capacity ast.Expr
+	if p.cur.Type != token.RPAREN {
+		capacity = p.parseExpr(precLowest)
+	}
+	p.expect(token.RPAREN)
+	return &ast.ChanExpr{ChanPos: pos, Elem: elem, Cap: capacity}
+}
+
+func (p *Parser) parseMatchExpr() ast.Expr {
+	pos := p.cur.Pos
+	p.expect(token.MATCH)
+	x := p.parseExpr(precLowest)
+	p.expect(token.LBRACE)
+
+	var arms []ast.MatchArm
+	for p.cur.Type != token.RBRACE && p.cur.Type != token.EOF {
+		arm := ast.MatchArm{Pat: p.parsePattern()}
+		if p.cur.Type == token.IF {
+			p.advance()
+			arm.Guard = p.parseExpr(precLowest)
+		}
+		p.expect(token.FATARROW)
+		arm.Body = p.parseExpr(precLowest)
+		arms = append(arms, arm)
+
+		if p.cur.Type == token.COMMA {
+			p.advance()
+			continue
+		}
+		if _, ok := arm.Body.(*ast.BlockExpr); !ok && p.cur.Type != token.RBRACE {
+			p.errorf(p.cur.Pos, "expected ',' or '}' after match arm, got %v", p.cur.Type)
+			break
+		}
+	}
+	p.expect(token.RBRACE)
+	return &ast.MatchExpr{MatchPos: pos, X: x, Arms: arms}
+}
+
+func (p *Parser) parsePattern() ast.Pattern {
+	tok := p.cur
+	switch tok.Type {
+	case token.IDENT:
+		p.advance()
+		if tok.Lit == "_" {
+			return &ast.WildcardPat{Pos0: tok.Pos}
+		}
+		if p.cur.Type != token.LPAREN {
+			return &ast.NamePat{NamePos: tok.Pos, Name: tok.Lit}
+		}
+		p.advance()
+		var args []ast.Pattern
+		for p.cur.Type != token.RPAREN && p.cur.Type != token.EOF {
+			args = append(args, p.parsePattern())
+			if p.cur.Type != token.COMMA {
+				break
+			}
+			p.advance()
+		}
+		p.expect(token.RPAREN)
+		return &ast.VariantPat{NamePos: tok.Pos, Name: tok.Lit, Args: args}
+
+	case token.INT, token.FLOAT, token.STRING, token.CHAR, token.TRUE, token.FALSE, token.NULL:
+		return &ast.LitPat{Value: p.parseExpr(precCall)}
+
+	case token.MINUS:
+		if p.peek.Type == token.INT || p.peek.Type == token.FLOAT {
+			p.advance()
+			switch lit := p.parseExpr(precCall).(type) {
+			case *ast.IntLit:
+				return &ast.LitPat{Value: &ast.IntLit{IntPos: tok.Pos, Value: -lit.Value, Raw: "-" + lit.Raw}}
+			case *ast.FloatLit:
+				return &ast.LitPat{Value: &ast.FloatLit{Pos0: tok.Pos, Value: -lit.Value, Raw: "-" + lit.Raw}}
+			}
+		}
+	}
+	p.errorf(tok.Pos, "expected pattern, got %v", tok.Type)
+	p.advance()
+	return &ast.WildcardPat{Pos0: tok.Pos}
+}
+
 func (p *Parser) parseIndex(x ast.Expr) ast.Expr {
 	lb := p.cur.Pos
 	p.expect(token.LBRACKET)
@@ -485,6 +853,14 @@ func (p *Parser) parseCall(callee ast.Expr) ast.Expr {
 	return &ast.CallExpr{Lparen: lp, Callee: callee, Args: args}
 }
 
+func (p *Parser) parseMethodCall(x ast.Expr) ast.Expr {
+	dot := p.cur.Pos
+	p.expect(token.DOT)
+	name := p.expect(token.IDENT)
+	call := p.parseCall(x).(*ast.CallExpr)
+	return &ast.MethodCallExpr{Dot: dot, X: x, Name: name.Lit, NamePos: name.Pos, Args: call.Args}
+}
+
 func (p *Parser) parseIfExpr() ast.Expr {
 	pos := p.cur.Pos
 	p.expect(token.IF)
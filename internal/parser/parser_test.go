@@ -119,6 +119,81 @@ fn f() -> int {
     let x: int = { let y: int = 2; y + 3 };
     return x;
 }
+`,
+		},
+		{
+			name: "nullable types and operators",
+			src: `
+fn f(xs: []int?, ys: [](int?), c: chan<string?>) -> string? {
+    let n: int = xs?.[0] ?? ys[0] ?? 0;
+    return recv(c);
+}
+`,
+		},
+		{
+			name: "results and propagation",
+			src: `
+fn f(s: string) -> Result<[]int?, Result<int, string>> {
+    let n: int = parse_int(s)? + g()?;
+    return ok([n]);
+}
+`,
+		},
+		{
+			name: "try and catch",
+			src: `
+fn f(a: int) -> int {
+    try {
+        try { return 10 / a; } catch inner { panic("again"); }
+    } catch e {
+        println(e);
+    }
+    return 0;
+}
+`,
+		},
+		{
+			name: "enums and match",
+			src: `
+enum Shape { Circle(float), Rect(float, float), Empty }
+fn area(s: Shape?) -> float {
+    let a: float = match s {
+        Circle(r) => 3.0 * r * r,
+        Rect(w, h) if w > h => { w * h }
+        Rect(_, h) => h,
+        null => 0.0,
+        Empty => 0.0,
+    };
+    match 1 { -1 => println("neg"), 'c' => {}, _ => {} }
+    return a;
+}
+`,
+		},
+		{
+			name: "generics",
+			src: `
+enum Pair<A, B: Eq> { Of(A, B) }
+fn max<T: Ord>(a: T, b: T) -> T {
+    if a > b { return a; }
+    return b;
+}
+fn first<A, B: Eq>(p: Pair<A, B>?, xs: [](Pair<[]A, B>)) -> A? {
+    return null;
+}
+`,
+		},
+		{
+			name: "traits",
+			src: `
+trait Show {
+    fn show(self) -> string;
+    fn pad(self, n: int, c: char) -> string;
+}
+impl Show for int {
+    fn show(self) -> string { return "n"; }
+    fn pad(self, n: int, c: char) -> string { return self.show().len(); }
+}
+fn f(xs: []Show) -> int { return xs[0].pad(1, 'x').len() + (2).show().len(); }
 `,
 		},
 	}
@@ -6,6 +6,7 @@ type prec int
 
 const (
 	precLowest prec = iota
+	precNullish
 	precOr
 	precAnd
 	precEq
@@ -17,6 +18,8 @@ const (
 )
 
 var precedences = map[token.Type]prec{
+	token.QQ: precNullish,
+
 	token.OR:  precOr,
 	token.AND: precAnd,
 
@@ -37,4 +40,7 @@ var precedences = map[token.Type]prec{
 
 	token.LPAREN:   precCall,
 	token.LBRACKET: precCall,
+	token.QDOT:     precCall,
+	token.DOT:      precCall,
+	token.QUESTION: precCall,
 }
@@ -1,9 +1,24 @@
 package compilation
 
 import (
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
 	"github.com/dunooo0ooo/lang/internal/bytecode"
 )
 
+const maxLocals = 256
+
+// LimitError reports a function needing more of something than bytecode
+// can address, such as more than 256 locals. CompileProgram returns it;
+// every other panic while compiling is a bug of the compiler.
+type LimitError struct {
+	Fn  string
+	Msg string
+}
+
+func (e *LimitError) Error() string { return fmt.Sprintf("function %q: %s", e.Fn, e.Msg) }
+
 type localVar struct {
 	name string
 	slot int
@@ -14,16 +29,42 @@ type Compiler struct {
 	mod    *bytecode.Module
 	fn     *bytecode.FunctionInfo
 	locals []localVar
+	// scopeBase is the first of locals the body being compiled can see;
+	// those below it belong to the caller an inlined body is expanded in.
+	scopeBase int
 
 	breakStack    [][]int
 	continueStack [][]int
+
+	inline      bool
+	decls       map[string]*ast.FnDecl
+	inlinable   map[string]*fnSummary
+	inlineExits [][]int
+
+	// variants maps each enum variant to its index in mod.Variants.
+	variants map[string]int
 }
 
 func NewCompiler() *Compiler {
 	functions := make(map[string]*bytecode.FunctionInfo)
-	module := &bytecode.Module{Functions: functions}
+	module := &bytecode.Module{Functions: functions, Natives: make(map[string]*bytecode.Native), VTables: make(map[string]map[string]string)}
+
+	module.Variants = []*bytecode.Variant{bytecode.OkVariant, bytecode.ErrVariant, bytecode.ErrorVariant}
+	variants := map[string]int{"ok": 0, "err": 1, "Error": 2}
+
+	return &Compiler{mod: module, inline: true, variants: variants}
+}
+
+// SetInlining turns inlining of small non-recursive functions on or off.
+// It is on by default.
+func (c *Compiler) SetInlining(enabled bool) {
+	c.inline = enabled
+}
 
-	return &Compiler{mod: module}
+// DeclareNative makes a Go function callable from the program. It must be
+// called before CompileProgram.
+func (c *Compiler) DeclareNative(n *bytecode.Native) {
+	c.mod.Natives[n.Name] = n
 }
 
 func (c *Compiler) chunk() *bytecode.Chunk {
@@ -36,8 +77,8 @@ func (c *Compiler) Module() *bytecode.Module {
 
 func (c *Compiler) addLocal(name string, typ bytecode.TypeKind) int {
 	slot := len(c.locals)
-	if slot >= 256 {
-		panic("too many locals (max 256)")
+	if slot >= maxLocals {
+		panic(&LimitError{Fn: c.fn.Name, Msg: fmt.Sprintf("too many locals (max %d)", maxLocals)})
 	}
 
 	c.locals = append(c.locals, localVar{name: name, slot: slot, typ: typ})
@@ -49,7 +90,7 @@ func (c *Compiler) addLocal(name string, typ bytecode.TypeKind) int {
 }
 
 func (c *Compiler) resolveLocal(name string) (int, bool) {
-	for i := len(c.locals) - 1; i >= 0; i-- {
+	for i := len(c.locals) - 1; i >= c.scopeBase; i-- {
 		if c.locals[i].name == name {
 			return c.locals[i].slot, true
 		}
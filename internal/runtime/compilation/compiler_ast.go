@@ -2,57 +2,133 @@ package compilation
 
 import (
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/dunooo0ooo/lang/internal/ast"
 	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/ir"
 	"github.com/dunooo0ooo/lang/internal/token"
 )
 
-func (c *Compiler) CompileProgram(p *ast.Program) (*bytecode.Module, error) {
+func (c *Compiler) CompileProgram(p *ast.Program) (mod *bytecode.Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			lerr, ok := r.(*LimitError)
+			if !ok {
+				panic(r)
+			}
+			mod, err = nil, lerr
+		}
+	}()
+	c.planInlining(p)
+
 	for _, it := range p.Items {
-		fn, ok := it.(*ast.FnDecl)
+		en, ok := it.(*ast.EnumDecl)
 		if !ok {
 			continue
 		}
+		for _, v := range en.Variants {
+			if _, exists := c.variants[v.Name]; exists {
+				return nil, fmt.Errorf("duplicate variant: %s", v.Name)
+			}
+			c.variants[v.Name] = len(c.mod.Variants)
+			c.mod.Variants = append(c.mod.Variants, &bytecode.Variant{Enum: en.Name, Name: v.Name, Arity: len(v.Fields)})
+		}
+	}
+
+	// The methods of impls are functions of their own, which vtables
+	// name.
+	var fns []*ast.FnDecl
+	for _, it := range p.Items {
+		switch n := it.(type) {
+		case *ast.FnDecl:
+			fns = append(fns, n)
+		case *ast.ImplDecl:
+			vt := c.mod.VTables[n.For.Name]
+			if vt == nil {
+				vt = make(map[string]string)
+				c.mod.VTables[n.For.Name] = vt
+			}
+			for i, fn := range n.Fns() {
+				vt[n.Methods[i].Name] = fn.Name
+				fns = append(fns, fn)
+			}
+		}
+	}
+
+	for _, fn := range fns {
 		if _, exists := c.mod.Functions[fn.Name]; exists {
 			return nil, fmt.Errorf("duplicate function: %s", fn.Name)
 		}
+		if _, exists := c.mod.Natives[fn.Name]; exists {
+			return nil, fmt.Errorf("function %s redeclares a native function", fn.Name)
+		}
 
 		bfn := bytecode.CreateFunction(fn.Name, len(fn.Params))
 
 		for _, par := range fn.Params {
-			bfn.AddParameter(mapTypeRef(&par.Type))
+			bfn.AddParameter(signatureType(fn, &par.Type))
 		}
 
 		ret := bytecode.TypeVoid
 		if fn.RetType != nil {
-			ret = mapTypeRef(fn.RetType)
+			ret = signatureType(fn, fn.RetType)
+		}
+		if fn.IsGen {
+			ret = bytecode.TypeIter
 		}
 		bfn.SetReturnType(ret)
+		bfn.IsGenerator = fn.IsGen
 
 		c.mod.Functions[bfn.Name] = bfn
 	}
 
-	for _, it := range p.Items {
-		fn, ok := it.(*ast.FnDecl)
-		if !ok {
-			continue
-		}
+	for _, fn := range fns {
 		if err := c.compileFunction(fn); err != nil {
 			return nil, err
 		}
 	}
 
+	// Array accesses the range analysis proves in bounds skip their checks
+	// whether or not the JIT runs later.
+	for _, fn := range c.mod.Functions {
+		ir.EliminateBoundsChecks(c.mod, fn)
+	}
+
 	return c.mod, nil
 }
 
+// signatureType is mapTypeRef for the parameter and result types of fn.
+// A generic fn has one body for every type its type parameters stand for,
+// so those do not say what kind its values have.
+func signatureType(fn *ast.FnDecl, t *ast.TypeRef) bytecode.TypeKind {
+	for _, tp := range fn.TypeParams {
+		if tp.Name == t.Name {
+			return bytecode.TypeInvalid
+		}
+	}
+	return mapTypeRef(t)
+}
+
 func mapTypeRef(t *ast.TypeRef) bytecode.TypeKind {
 	if t == nil {
 		return bytecode.TypeVoid
 	}
+	if t.Nullable {
+		return bytecode.TypeNullable
+	}
 	if t.Name == "array" {
 		return bytecode.TypeArray
 	}
+	if t.Name == "iter" {
+		return bytecode.TypeIter
+	}
+	if t.Name == "chan" {
+		return bytecode.TypeChan
+	}
+	if t.Name == "Result" {
+		return bytecode.TypeResult
+	}
 	switch t.Name {
 	case "int":
 		return bytecode.TypeInt
@@ -68,8 +144,10 @@ func mapTypeRef(t *ast.TypeRef) bytecode.TypeKind {
 		return bytecode.TypeVoid
 	case "null":
 		return bytecode.TypeNull
-	default:
+	case "":
 		return bytecode.TypeInvalid
+	default:
+		return bytecode.TypeEnum
 	}
 }
 
@@ -94,7 +172,7 @@ func (c *Compiler) compileFunction(fn *ast.FnDecl) error {
 	c.emitNull()
 	c.chunk().Write(bytecode.OpReturn)
 
-	return nil
+	return c.setHandlerDepths()
 }
 
 func (c *Compiler) compileBlock(b *ast.BlockStmt, asExpr bool) {
@@ -116,6 +194,8 @@ func (c *Compiler) compileBlock(b *ast.BlockStmt, asExpr bool) {
 }
 
 func (c *Compiler) compileStmt(s ast.Stmt) {
+	c.chunk().MarkLine(s.Pos().Line)
+
 	switch st := s.(type) {
 	case *ast.BlockStmt:
 		c.compileBlock(st, false)
@@ -127,13 +207,6 @@ func (c *Compiler) compileStmt(s ast.Stmt) {
 		c.compileAssign(st)
 
 	case *ast.ExprStmt:
-		if call, ok := st.X.(*ast.CallExpr); ok {
-			if id, ok := call.Callee.(*ast.VarRef); ok && id.Name == "set" {
-				c.compileExpr(st.X)
-				return
-			}
-		}
-
 		c.compileExpr(st.X)
 		c.chunk().Write(bytecode.OpPop)
 	case *ast.ReturnStmt:
@@ -148,6 +221,20 @@ func (c *Compiler) compileStmt(s ast.Stmt) {
 	case *ast.ForStmt:
 		c.compileFor(st)
 
+	case *ast.ForInStmt:
+		c.compileForIn(st)
+
+	case *ast.YieldStmt:
+		c.compileExpr(st.Value)
+		c.chunk().MarkLine(st.YieldPos.Line)
+		c.chunk().Write(bytecode.OpYield)
+
+	case *ast.SpawnStmt:
+		c.compileSpawn(st)
+
+	case *ast.TryStmt:
+		c.compileTry(st)
+
 	default:
 		panic(fmt.Sprintf("unknown stmt %T", st))
 	}
@@ -165,6 +252,8 @@ func (c *Compiler) compileLet(s *ast.LetStmt) {
 	typ := bytecode.TypeInvalid
 	if s.Type != nil {
 		typ = mapTypeRef(s.Type)
+	} else if s.Inferred != nil {
+		typ = mapTypeRef(s.Inferred)
 	}
 
 	slot := c.addLocal(s.Name, typ)
@@ -194,6 +283,12 @@ func (c *Compiler) compileReturn(s *ast.ReturnStmt) {
 	} else {
 		c.emitNull()
 	}
+	if n := len(c.inlineExits); n > 0 {
+		ch.Write(bytecode.OpJump)
+		c.inlineExits[n-1] = append(c.inlineExits[n-1], len(ch.Code))
+		ch.WriteUint16(0)
+		return
+	}
 	ch.Write(bytecode.OpReturn)
 }
 
@@ -306,6 +401,42 @@ func (c *Compiler) compileFor(s *ast.ForStmt) {
 	c.endLoop(continueTarget, afterLoop)
 }
 
+// compileForIn resumes the generator, kept in a hidden local, once per
+// iteration; OpIterNext leaves the loop when the generator finishes.
+func (c *Compiler) compileForIn(s *ast.ForInStmt) {
+	ch := c.chunk()
+
+	c.compileExpr(s.Iter)
+	itSlot := c.addLocal("$iter", bytecode.TypeIter)
+	ch.Write(bytecode.OpStoreLocal)
+	_ = ch.WriteByte(byte(itSlot))
+
+	loopStart := len(ch.Code)
+	c.beginLoop()
+
+	ch.Write(bytecode.OpLoadLocal)
+	_ = ch.WriteByte(byte(itSlot))
+	ch.MarkLine(s.ForPos.Line)
+	ch.Write(bytecode.OpIterNext)
+	exitJump := len(ch.Code)
+	ch.WriteUint16(0)
+
+	base := len(c.locals)
+	slot := c.addLocal(s.Var, bytecode.TypeInvalid)
+	ch.Write(bytecode.OpStoreLocal)
+	_ = ch.WriteByte(byte(slot))
+
+	c.compileBlock(s.Body, false)
+	c.locals = c.locals[:base]
+
+	ch.Write(bytecode.OpJump)
+	ch.WriteUint16(uint16(loopStart))
+
+	afterLoop := len(ch.Code)
+	_ = ch.PatchUint16(exitJump, uint16(afterLoop))
+	c.endLoop(loopStart, afterLoop)
+}
+
 func (c *Compiler) compileExpr(e ast.Expr) {
 	switch ex := e.(type) {
 	case *ast.VarRef:
@@ -324,17 +455,15 @@ func (c *Compiler) compileExpr(e ast.Expr) {
 		c.emitString(ex.Value)
 
 	case *ast.CharLit:
-		var b byte
-		if len(ex.Raw) > 0 {
-			b = ex.Raw[0]
-		}
-		c.emitChar(b)
+		r, _ := utf8.DecodeRuneInString(ex.Raw)
+		c.emitChar(r)
 
 	case *ast.NullLit:
 		c.emitNull()
 
 	case *ast.UnaryExpr:
 		c.compileExpr(ex.X)
+		c.chunk().MarkLine(ex.OpPos.Line)
 		switch ex.Op {
 		case token.MINUS:
 			c.chunk().Write(bytecode.OpNeg)
@@ -350,18 +479,45 @@ func (c *Compiler) compileExpr(e ast.Expr) {
 	case *ast.CallExpr:
 		c.compileCall(ex)
 
+	case *ast.MethodCallExpr:
+		c.compileMethodCall(ex)
+
 	case *ast.BlockExpr:
 		c.compileBlock(ex.Block, true)
 
 	case *ast.IfExpr:
 		c.compileIfExpr(ex)
 
+	case *ast.MatchExpr:
+		c.compileMatch(ex)
+
+	case *ast.PropagateExpr:
+		c.compilePropagate(ex)
+
 	case *ast.ArrayLit:
 		c.compileArrayLit(ex)
 
+	case *ast.ChanExpr:
+		if ex.Cap != nil {
+			c.compileExpr(ex.Cap)
+		} else {
+			c.emitInt(0)
+		}
+		c.chunk().MarkLine(ex.ChanPos.Line)
+		c.chunk().Write(bytecode.OpChanNew)
+
 	case *ast.IndexExpr:
+		if ex.Safe {
+			c.compileNullish(ex.X, c.emitNull, func() {
+				c.compileExpr(ex.Index)
+				c.chunk().MarkLine(ex.Lbrack.Line)
+				c.chunk().Write(bytecode.OpArrayGet)
+			})
+			return
+		}
 		c.compileExpr(ex.X)
 		c.compileExpr(ex.Index)
+		c.chunk().MarkLine(ex.Lbrack.Line)
 		c.chunk().Write(bytecode.OpArrayGet)
 
 	default:
@@ -387,6 +543,10 @@ func (c *Compiler) compileBinary(e *ast.BinaryExpr) {
 		_ = ch.PatchUint16(jumpToEnd, uint16(end))
 		return
 
+	case token.QQ:
+		c.compileNullish(e.L, func() { c.compileExpr(e.R) }, func() {})
+		return
+
 	case token.OR:
 		c.compileExpr(e.L)
 
@@ -411,6 +571,7 @@ func (c *Compiler) compileBinary(e *ast.BinaryExpr) {
 
 	c.compileExpr(e.L)
 	c.compileExpr(e.R)
+	ch.MarkLine(e.OpPos.Line)
 
 	switch e.Op {
 	case token.PLUS:
@@ -494,20 +655,98 @@ func (c *Compiler) compileCall(e *ast.CallExpr) {
 		ch.Write(bytecode.OpPrint)
 		return
 
+	case "eprintln":
+		if len(e.Args) != 1 {
+			panic(fmt.Sprintf("eprintln expects 1 argument, got %d", len(e.Args)))
+		}
+		c.compileExpr(e.Args[0])
+		ch.Write(bytecode.OpEPrintLn)
+		return
+
+	case "parse_int", "parse_float":
+		if len(e.Args) != 1 {
+			panic(fmt.Sprintf("%s expects 1 argument, got %d", name, len(e.Args)))
+		}
+		c.compileExpr(e.Args[0])
+		ch.MarkLine(e.Lparen.Line)
+		if name == "parse_int" {
+			ch.Write(bytecode.OpParseInt)
+		} else {
+			ch.Write(bytecode.OpParseFloat)
+		}
+		return
+
+	case "float":
+		if len(e.Args) != 1 {
+			panic(fmt.Sprintf("float expects 1 argument, got %d", len(e.Args)))
+		}
+		c.compileExpr(e.Args[0])
+		ch.Write(bytecode.OpIntToFloat)
+		return
+
+	case "panic":
+		if len(e.Args) != 1 {
+			panic(fmt.Sprintf("panic expects 1 argument, got %d", len(e.Args)))
+		}
+		c.compileExpr(e.Args[0])
+		ch.MarkLine(e.Lparen.Line)
+		ch.Write(bytecode.OpPanic)
+		return
+
+	case "read_line", "read_int":
+		if len(e.Args) != 0 {
+			panic(fmt.Sprintf("%s expects no arguments, got %d", name, len(e.Args)))
+		}
+		ch.MarkLine(e.Lparen.Line)
+		if name == "read_line" {
+			ch.Write(bytecode.OpReadLine)
+		} else {
+			ch.Write(bytecode.OpReadInt)
+		}
+		return
+
 	case "array":
 		if len(e.Args) != 1 {
 			panic(fmt.Sprintf("array expects 1 argument, got %d", len(e.Args)))
 		}
 		c.compileExpr(e.Args[0])
+		ch.MarkLine(e.Lparen.Line)
 		ch.Write(bytecode.OpArrayNew)
 		return
 
+	case "next":
+		if len(e.Args) != 1 {
+			panic(fmt.Sprintf("next expects 1 argument, got %d", len(e.Args)))
+		}
+		c.compileExpr(e.Args[0])
+		ch.MarkLine(e.Lparen.Line)
+		ch.Write(bytecode.OpNext)
+		return
+
+	case "send", "recv", "close", "wait":
+		for _, arg := range e.Args {
+			c.compileExpr(arg)
+		}
+		ch.MarkLine(e.Lparen.Line)
+		switch name {
+		case "send":
+			ch.Write(bytecode.OpSend)
+		case "recv":
+			ch.Write(bytecode.OpRecv)
+		case "close":
+			ch.Write(bytecode.OpClose)
+		default:
+			ch.Write(bytecode.OpWait)
+		}
+		return
+
 	case "get":
 		if len(e.Args) != 2 {
 			panic(fmt.Sprintf("get expects 2 arguments, got %d", len(e.Args)))
 		}
 		c.compileExpr(e.Args[0])
 		c.compileExpr(e.Args[1])
+		ch.MarkLine(e.Lparen.Line)
 		ch.Write(bytecode.OpArrayGet)
 		return
 
@@ -518,6 +757,7 @@ func (c *Compiler) compileCall(e *ast.CallExpr) {
 		c.compileExpr(e.Args[0])
 		c.compileExpr(e.Args[1])
 		c.compileExpr(e.Args[2])
+		ch.MarkLine(e.Lparen.Line)
 		ch.Write(bytecode.OpArraySet)
 		return
 	}
@@ -525,15 +765,62 @@ func (c *Compiler) compileCall(e *ast.CallExpr) {
 	for _, arg := range e.Args {
 		c.compileExpr(arg)
 	}
-	if _, ok := c.mod.Functions[name]; !ok {
+	if idx, ok := c.variants[name]; ok {
+		ch.MarkLine(e.Lparen.Line)
+		ch.Write(bytecode.OpVariant)
+		ch.WriteUint16(uint16(idx))
+		return
+	}
+	_, isFn := c.mod.Functions[name]
+	if _, isNative := c.mod.Natives[name]; !isFn && !isNative {
 		panic("unknown function: " + name)
 	}
+	if c.canInline(name) {
+		c.inlineCall(e, name)
+		return
+	}
 
+	ch.MarkLine(e.Lparen.Line)
 	ch.Write(bytecode.OpCall)
 	idx := ch.AddConstant(bytecode.Value{Kind: bytecode.ValString, S: name})
 	ch.WriteUint16(uint16(idx))
 }
 
+// compileMethodCall pushes the receiver and the arguments and calls the
+// method of the receiver's type, which the VM looks up when it runs.
+func (c *Compiler) compileMethodCall(e *ast.MethodCallExpr) {
+	ch := c.chunk()
+	c.compileExpr(e.X)
+	for _, arg := range e.Args {
+		c.compileExpr(arg)
+	}
+	ch.MarkLine(e.NamePos.Line)
+	ch.Write(bytecode.OpInvoke)
+	idx := ch.AddConstant(bytecode.Value{Kind: bytecode.ValString, S: e.Name})
+	ch.WriteUint16(uint16(idx))
+	_ = ch.WriteByte(byte(len(e.Args)))
+}
+
+// compileSpawn pushes the arguments and starts the callee as a task,
+// which takes them off the stack. Spawned calls are never inlined.
+func (c *Compiler) compileSpawn(s *ast.SpawnStmt) {
+	ch := c.chunk()
+	id, ok := s.Call.Callee.(*ast.VarRef)
+	if !ok {
+		panic("spawn target must be identifier")
+	}
+	if _, isFn := c.mod.Functions[id.Name]; !isFn {
+		panic("cannot spawn: " + id.Name)
+	}
+	for _, arg := range s.Call.Args {
+		c.compileExpr(arg)
+	}
+	ch.MarkLine(s.SpawnPos.Line)
+	ch.Write(bytecode.OpSpawn)
+	idx := ch.AddConstant(bytecode.Value{Kind: bytecode.ValString, S: id.Name})
+	ch.WriteUint16(uint16(idx))
+}
+
 func (c *Compiler) compileIdent(e *ast.VarRef) {
 	ch := c.chunk()
 	if slot, ok := c.resolveLocal(e.Name); ok {
@@ -541,9 +828,188 @@ func (c *Compiler) compileIdent(e *ast.VarRef) {
 		_ = ch.WriteByte(byte(slot))
 		return
 	}
+	if idx, ok := c.variants[e.Name]; ok {
+		ch.Write(bytecode.OpVariant)
+		ch.WriteUint16(uint16(idx))
+		return
+	}
 	panic("unknown variable: " + e.Name)
 }
 
+// compilePropagate leaves the value of an ok on the stack and returns an
+// err as it is, which is also a valid result of the function.
+func (c *Compiler) compilePropagate(e *ast.PropagateExpr) {
+	ch := c.chunk()
+
+	c.compileExpr(e.X)
+	base := len(c.locals)
+	slot := c.addLocal("$result", bytecode.TypeResult)
+	ch.Write(bytecode.OpTeeLocal)
+	_ = ch.WriteByte(byte(slot))
+	ch.Write(bytecode.OpIsVariant)
+	ch.WriteUint16(uint16(c.variants["ok"]))
+
+	ch.Write(bytecode.OpJumpIfFalse)
+	jumpErr := len(ch.Code)
+	ch.WriteUint16(0)
+
+	ch.Write(bytecode.OpPop)
+	ch.Write(bytecode.OpLoadLocal)
+	_ = ch.WriteByte(byte(slot))
+	ch.Write(bytecode.OpVariantField)
+	_ = ch.WriteByte(0)
+	ch.Write(bytecode.OpJump)
+	jumpEnd := len(ch.Code)
+	ch.WriteUint16(0)
+
+	_ = ch.PatchUint16(jumpErr, uint16(len(ch.Code)))
+	ch.Write(bytecode.OpPop)
+	ch.Write(bytecode.OpLoadLocal)
+	_ = ch.WriteByte(byte(slot))
+	ch.MarkLine(e.QPos.Line)
+	ch.Write(bytecode.OpReturn)
+
+	_ = ch.PatchUint16(jumpEnd, uint16(len(ch.Code)))
+	c.locals = c.locals[:base]
+}
+
+// compileMatch keeps the matched value in a hidden local and tries the
+// arms in order. Every test of an arm leaves a bool that is popped on both
+// of its paths, so a failing test jumps to the next arm with just that
+// bool to drop.
+func (c *Compiler) compileMatch(e *ast.MatchExpr) {
+	ch := c.chunk()
+
+	c.compileExpr(e.X)
+	base := len(c.locals)
+	slot := c.addLocal("$match", bytecode.TypeInvalid)
+	ch.Write(bytecode.OpStoreLocal)
+	_ = ch.WriteByte(byte(slot))
+
+	var ends []int
+	for _, arm := range e.Arms {
+		armBase := len(c.locals)
+		var fails []int
+		c.compilePattern(arm.Pat, slot, &fails)
+		if arm.Guard != nil {
+			c.compileExpr(arm.Guard)
+			c.emitTest(&fails)
+		}
+		c.compileExpr(arm.Body)
+		ch.Write(bytecode.OpJump)
+		ends = append(ends, len(ch.Code))
+		ch.WriteUint16(0)
+
+		if len(fails) > 0 {
+			for _, pos := range fails {
+				_ = ch.PatchUint16(pos, uint16(len(ch.Code)))
+			}
+			ch.Write(bytecode.OpPop)
+		}
+		c.locals = c.locals[:armBase]
+	}
+	c.emitNull()
+
+	for _, pos := range ends {
+		_ = ch.PatchUint16(pos, uint16(len(ch.Code)))
+	}
+	c.locals = c.locals[:base]
+}
+
+// compilePattern tests the value in slot against p, adding the jumps taken
+// when it does not match to fails, and stores the values p binds.
+func (c *Compiler) compilePattern(p ast.Pattern, slot int, fails *[]int) {
+	ch := c.chunk()
+	load := func() {
+		ch.Write(bytecode.OpLoadLocal)
+		_ = ch.WriteByte(byte(slot))
+	}
+
+	switch p := p.(type) {
+	case *ast.WildcardPat:
+
+	case *ast.NamePat:
+		if idx, ok := c.variants[p.Name]; ok {
+			load()
+			ch.Write(bytecode.OpIsVariant)
+			ch.WriteUint16(uint16(idx))
+			c.emitTest(fails)
+			return
+		}
+		load()
+		bind := c.addLocal(p.Name, bytecode.TypeInvalid)
+		ch.Write(bytecode.OpStoreLocal)
+		_ = ch.WriteByte(byte(bind))
+
+	case *ast.VariantPat:
+		load()
+		ch.Write(bytecode.OpIsVariant)
+		ch.WriteUint16(uint16(c.variants[p.Name]))
+		c.emitTest(fails)
+		for i, arg := range p.Args {
+			if _, ok := arg.(*ast.WildcardPat); ok {
+				continue
+			}
+			load()
+			ch.Write(bytecode.OpVariantField)
+			_ = ch.WriteByte(byte(i))
+			field := c.addLocal("$field", bytecode.TypeInvalid)
+			ch.Write(bytecode.OpStoreLocal)
+			_ = ch.WriteByte(byte(field))
+			c.compilePattern(arg, field, fails)
+		}
+
+	case *ast.LitPat:
+		load()
+		c.compileExpr(p.Value)
+		ch.Write(bytecode.OpEq)
+		c.emitTest(fails)
+	}
+}
+
+// emitTest jumps to a fail label, added to fails, unless the bool on the
+// stack is true.
+func (c *Compiler) emitTest(fails *[]int) {
+	ch := c.chunk()
+	ch.Write(bytecode.OpJumpIfFalse)
+	*fails = append(*fails, len(ch.Code))
+	ch.WriteUint16(0)
+	ch.Write(bytecode.OpPop)
+}
+
+// compileNullish evaluates x into a hidden local and runs ifNull when it
+// is null; otherwise ifNonNull runs with x on the stack.
+func (c *Compiler) compileNullish(x ast.Expr, ifNull, ifNonNull func()) {
+	ch := c.chunk()
+
+	c.compileExpr(x)
+	base := len(c.locals)
+	slot := c.addLocal("$nullish", bytecode.TypeInvalid)
+	ch.Write(bytecode.OpTeeLocal)
+	_ = ch.WriteByte(byte(slot))
+	c.emitNull()
+	ch.Write(bytecode.OpEq)
+
+	ch.Write(bytecode.OpJumpIfFalse)
+	jumpNonNull := len(ch.Code)
+	ch.WriteUint16(0)
+
+	ch.Write(bytecode.OpPop)
+	ifNull()
+	ch.Write(bytecode.OpJump)
+	jumpEnd := len(ch.Code)
+	ch.WriteUint16(0)
+
+	_ = ch.PatchUint16(jumpNonNull, uint16(len(ch.Code)))
+	ch.Write(bytecode.OpPop)
+	ch.Write(bytecode.OpLoadLocal)
+	_ = ch.WriteByte(byte(slot))
+	ifNonNull()
+
+	_ = ch.PatchUint16(jumpEnd, uint16(len(ch.Code)))
+	c.locals = c.locals[:base]
+}
+
 func (c *Compiler) compileArrayLit(a *ast.ArrayLit) {
 	ch := c.chunk()
 
@@ -561,6 +1027,7 @@ func (c *Compiler) compileArrayLit(a *ast.ArrayLit) {
 		c.emitInt(int64(i))
 		c.compileExpr(el)
 		ch.Write(bytecode.OpArraySet)
+		ch.Write(bytecode.OpPop)
 	}
 
 	ch.Write(bytecode.OpLoadLocal)
@@ -595,10 +1062,10 @@ func (c *Compiler) emitString(s string) {
 	ch.WriteUint16(uint16(idx))
 }
 
-func (c *Compiler) emitChar(b byte) {
+func (c *Compiler) emitChar(r rune) {
 	ch := c.chunk()
 	ch.Write(bytecode.OpConst)
-	idx := ch.AddConstant(bytecode.Value{Kind: bytecode.ValChar, C: b})
+	idx := ch.AddConstant(bytecode.Value{Kind: bytecode.ValChar, C: r})
 	ch.WriteUint16(uint16(idx))
 }
 
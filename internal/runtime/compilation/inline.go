@@ -0,0 +1,273 @@
+package compilation
+
+import (
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+const (
+	// maxInlineSize bounds the number of AST nodes in an inlined body.
+	maxInlineSize = 40
+	// maxInlineSizeOnce is the larger bound for functions with a single
+	// call site, whose inlining does not grow the code.
+	maxInlineSizeOnce = 200
+	// maxInlineDepth bounds inlining into already inlined code.
+	maxInlineDepth = 4
+)
+
+// fnSummary is what the inliner needs to know about a function body.
+type fnSummary struct {
+	size int
+	// locals bounds the slots the body takes: its parameters, lets and
+	// bindings and the hidden temporaries the compiler adds for it.
+	locals int
+	calls  []string
+	// exprReturn is set when a return sits inside an expression, where
+	// the operand stack is not empty and a jump out would unbalance it.
+	exprReturn bool
+}
+
+func summarize(fn *ast.FnDecl) *fnSummary {
+	s := &fnSummary{locals: len(fn.Params)}
+	s.block(fn.Body, false)
+	return s
+}
+
+func (s *fnSummary) block(b *ast.BlockStmt, inExpr bool) {
+	for _, st := range b.Stmts {
+		s.stmt(st, inExpr)
+	}
+	if b.Tail != nil {
+		s.expr(b.Tail)
+	}
+}
+
+func (s *fnSummary) stmt(st ast.Stmt, inExpr bool) {
+	s.size++
+	switch st := st.(type) {
+	case *ast.BlockStmt:
+		s.block(st, inExpr)
+	case *ast.LetStmt:
+		s.locals++
+		if st.Init != nil {
+			s.expr(st.Init)
+		}
+	case *ast.AssignStmt:
+		s.expr(st.Value)
+	case *ast.ExprStmt:
+		s.expr(st.X)
+	case *ast.ReturnStmt:
+		if inExpr {
+			s.exprReturn = true
+		}
+		if st.Value != nil {
+			s.expr(st.Value)
+		}
+	case *ast.IfStmt:
+		s.expr(st.Cond)
+		s.block(st.Then, inExpr)
+		if st.Else != nil {
+			s.stmt(st.Else, inExpr)
+		}
+	case *ast.WhileStmt:
+		s.expr(st.Cond)
+		s.block(st.Body, inExpr)
+	case *ast.ForStmt:
+		if st.Init != nil {
+			s.stmt(st.Init, inExpr)
+		}
+		if st.Cond != nil {
+			s.expr(st.Cond)
+		}
+		if st.Post != nil {
+			s.stmt(st.Post, inExpr)
+		}
+		s.block(st.Body, inExpr)
+	case *ast.ForInStmt:
+		s.locals += 2
+		s.expr(st.Iter)
+		s.block(st.Body, inExpr)
+	case *ast.YieldStmt:
+		s.expr(st.Value)
+	case *ast.SpawnStmt:
+		s.expr(st.Call)
+	case *ast.TryStmt:
+		s.locals++
+		s.block(st.Body, inExpr)
+		s.block(st.Catch, inExpr)
+	}
+}
+
+func (s *fnSummary) expr(e ast.Expr) {
+	s.size++
+	switch e := e.(type) {
+	case *ast.UnaryExpr:
+		s.expr(e.X)
+	case *ast.BinaryExpr:
+		if e.Op == token.QQ {
+			s.locals++
+		}
+		s.expr(e.L)
+		s.expr(e.R)
+	case *ast.CallExpr:
+		if id, ok := e.Callee.(*ast.VarRef); ok {
+			s.calls = append(s.calls, id.Name)
+		}
+		for _, a := range e.Args {
+			s.expr(a)
+		}
+	case *ast.MethodCallExpr:
+		s.expr(e.X)
+		for _, a := range e.Args {
+			s.expr(a)
+		}
+	case *ast.IfExpr:
+		s.expr(e.Cond)
+		s.block(e.Then, true)
+		s.expr(e.Else)
+	case *ast.BlockExpr:
+		s.block(e.Block, true)
+	case *ast.ArrayLit:
+		s.locals++
+		for _, el := range e.Elems {
+			s.expr(el)
+		}
+	case *ast.IndexExpr:
+		if e.Safe {
+			s.locals++
+		}
+		s.expr(e.X)
+		s.expr(e.Index)
+	case *ast.ChanExpr:
+		if e.Cap != nil {
+			s.expr(e.Cap)
+		}
+	case *ast.PropagateExpr:
+		// An err returns from the middle of the expression x? is in.
+		s.exprReturn = true
+		s.locals++
+		s.expr(e.X)
+	case *ast.MatchExpr:
+		s.locals++
+		s.expr(e.X)
+		for _, arm := range e.Arms {
+			s.pattern(arm.Pat)
+			if arm.Guard != nil {
+				s.expr(arm.Guard)
+			}
+			s.expr(arm.Body)
+		}
+	}
+}
+
+// pattern counts a local for every name and value a pattern stores.
+func (s *fnSummary) pattern(p ast.Pattern) {
+	s.size++
+	switch p := p.(type) {
+	case *ast.NamePat:
+		s.locals++
+	case *ast.VariantPat:
+		for _, a := range p.Args {
+			s.locals++
+			s.pattern(a)
+		}
+	}
+}
+
+// planInlining picks the functions of p that calls may be replaced with:
+// small or singly called ones that cannot reach themselves through the
+// call graph. Calling a gen fn makes a generator, so those are never
+// inlined.
+func (c *Compiler) planInlining(p *ast.Program) {
+	c.decls = make(map[string]*ast.FnDecl)
+	summaries := make(map[string]*fnSummary)
+	for _, it := range p.Items {
+		if fn, ok := it.(*ast.FnDecl); ok {
+			c.decls[fn.Name] = fn
+			summaries[fn.Name] = summarize(fn)
+		}
+	}
+
+	callSites := make(map[string]int)
+	for _, s := range summaries {
+		for _, callee := range s.calls {
+			callSites[callee]++
+		}
+	}
+
+	c.inlinable = make(map[string]*fnSummary)
+	for name, s := range summaries {
+		small := s.size <= maxInlineSize || callSites[name] == 1 && s.size <= maxInlineSizeOnce
+		if small && !s.exprReturn && !c.decls[name].IsGen && !recursive(summaries, name) {
+			c.inlinable[name] = s
+		}
+	}
+}
+
+func recursive(summaries map[string]*fnSummary, name string) bool {
+	seen := make(map[string]bool)
+	work := append([]string(nil), summaries[name].calls...)
+	for len(work) > 0 {
+		callee := work[len(work)-1]
+		work = work[:len(work)-1]
+		if callee == name {
+			return true
+		}
+		if seen[callee] {
+			continue
+		}
+		seen[callee] = true
+		if s, ok := summaries[callee]; ok {
+			work = append(work, s.calls...)
+		}
+	}
+	return false
+}
+
+func (c *Compiler) canInline(name string) bool {
+	if !c.inline || len(c.inlineExits) >= maxInlineDepth {
+		return false
+	}
+	// The expansion takes its slots above the caller's; a call it would
+	// push past the limit stays a call.
+	s, ok := c.inlinable[name]
+	return ok && len(c.locals)+s.locals <= maxLocals
+}
+
+// inlineCall expands a call to name in place. The arguments are already on
+// the stack and are moved into fresh local slots that act as the callee's
+// parameters; returns jump to the end of the expansion with their value.
+// The slots are released afterwards, so later locals reuse them. The
+// caller's locals are hidden from the body, whose names mean what they do
+// in the callee.
+func (c *Compiler) inlineCall(e *ast.CallExpr, name string) {
+	ch := c.chunk()
+	fn := c.decls[name]
+
+	base := len(c.locals)
+	for _, p := range fn.Params {
+		c.addLocal(p.Name, mapTypeRef(&p.Type))
+	}
+	for i := len(fn.Params) - 1; i >= 0; i-- {
+		ch.Write(bytecode.OpStoreLocal)
+		_ = ch.WriteByte(byte(base + i))
+	}
+
+	outer := c.scopeBase
+	c.scopeBase = base
+	c.inlineExits = append(c.inlineExits, nil)
+	c.compileBlock(fn.Body, false)
+	c.emitNull()
+	c.scopeBase = outer
+
+	n := len(c.inlineExits) - 1
+	end := len(ch.Code)
+	for _, pos := range c.inlineExits[n] {
+		_ = ch.PatchUint16(pos, uint16(end))
+	}
+	c.inlineExits = c.inlineExits[:n]
+	c.locals = c.locals[:base]
+
+	ch.MarkLine(e.Lparen.Line)
+}
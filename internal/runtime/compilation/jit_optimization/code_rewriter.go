@@ -2,16 +2,46 @@ package jit_optimization
 
 import "github.com/dunooo0ooo/lang/internal/bytecode"
 
+// codePatch replaces original[startAddress:endAddress] with newCode.
+// Jump operands inside newCode refer to addresses in the original code and
+// are remapped together with the rest of the function.
 type codePatch struct {
 	startAddress int
 	endAddress   int
 	newCode      []byte
 }
 
-func rewriteBytecode(originalCode []byte, patches []codePatch) []byte {
+func rewriteBytecode(originalCode []byte, patches []codePatch) ([]byte, map[int]int, bool) {
 	addressMapping := buildAddressMapping(originalCode, patches)
 
-	return rebuildCode(originalCode, patches, addressMapping)
+	code, ok := rebuildCode(originalCode, patches, addressMapping)
+	return code, addressMapping, ok
+}
+
+// remapLines moves a line table onto rewritten code. An entry that pointed
+// into the middle of a patch takes effect after the patch, which keeps the
+// line of its first instruction.
+func remapLines(lines []bytecode.LineStart, patches []codePatch, addressMapping map[int]int) []bytecode.LineStart {
+	out := make([]bytecode.LineStart, 0, len(lines))
+	patchIndex := 0
+	for _, l := range lines {
+		pc, ok := addressMapping[l.PC]
+		if !ok {
+			for patchIndex < len(patches) && patches[patchIndex].endAddress <= l.PC {
+				patchIndex++
+			}
+			if patchIndex == len(patches) {
+				continue
+			}
+			pc = addressMapping[patches[patchIndex].endAddress]
+		}
+		if n := len(out); n > 0 && out[n-1].PC == pc {
+			out[n-1].Line = l.Line
+			continue
+		}
+		out = append(out, bytecode.LineStart{PC: pc, Line: l.Line})
+	}
+	return out
 }
 
 func buildAddressMapping(original []byte, patches []codePatch) map[int]int {
@@ -28,67 +58,61 @@ func buildAddressMapping(original []byte, patches []codePatch) map[int]int {
 			continue
 		}
 
-		op := bytecode.OpCode(original[oldAddress])
-		size := GetInstructionSize(op)
-		if size <= 0 || oldAddress+size > len(original) {
+		instr, ok := Decode(original, oldAddress)
+		if !ok {
 			break
 		}
 
 		mapping[oldAddress] = newAddress
-		newAddress += size
-		oldAddress += size
+		newAddress += instr.Size
+		oldAddress += instr.Size
 	}
 
 	mapping[len(original)] = newAddress
 	return mapping
 }
 
-func rebuildCode(original []byte, patches []codePatch, addressMapping map[int]int) []byte {
+func rebuildCode(original []byte, patches []codePatch, addressMapping map[int]int) ([]byte, bool) {
 	result := make([]byte, 0, len(original))
 	patchIndex := 0
 
 	for ip := 0; ip < len(original); {
 		if patchIndex < len(patches) && ip == patches[patchIndex].startAddress {
-			result = append(result, patches[patchIndex].newCode...)
+			var ok bool
+			result, ok = appendRemapped(result, patches[patchIndex].newCode, addressMapping)
+			if !ok {
+				return original, false
+			}
 			ip = patches[patchIndex].endAddress
 			patchIndex++
 			continue
 		}
 
-		op := bytecode.OpCode(original[ip])
-		ip++
-		result = append(result, byte(op))
-
-		switch op {
-		case bytecode.OpConst, bytecode.OpCall:
-			if ip+1 >= len(original) {
-				return original
-			}
-			result = append(result, original[ip], original[ip+1])
-			ip += 2
-
-		case bytecode.OpJump, bytecode.OpJumpIfFalse:
-			if ip+1 >= len(original) {
-				return original
-			}
-			oldTarget := int(uint16(original[ip])<<8 | uint16(original[ip+1]))
-			ip += 2
-
-			newTarget, exists := addressMapping[oldTarget]
-			if !exists {
-				return original
-			}
+		instr, ok := Decode(original, ip)
+		if !ok {
+			return original, false
+		}
+		result, ok = appendRemapped(result, original[ip:ip+instr.Size], addressMapping)
+		if !ok {
+			return original, false
+		}
+		ip += instr.Size
+	}
 
-			result = append(result, byte(uint16(newTarget)>>8), byte(uint16(newTarget)))
+	return result, true
+}
 
-		case bytecode.OpLoadLocal, bytecode.OpStoreLocal:
-			if ip >= len(original) {
-				return original
-			}
-			result = append(result, original[ip])
-			ip++
+func appendRemapped(dst, code []byte, addressMapping map[int]int) ([]byte, bool) {
+	for _, instr := range DecodeAll(code) {
+		if !IsJump(instr.OpCode) {
+			dst = append(dst, code[instr.Address:instr.Address+instr.Size]...)
+			continue
+		}
+		newTarget, exists := addressMapping[instr.Argument]
+		if !exists {
+			return nil, false
 		}
+		dst = Encode(dst, instr.OpCode, newTarget)
 	}
-
-	return result
+	return dst, true
 }
@@ -2,38 +2,87 @@ package jit_optimization
 
 import "github.com/dunooo0ooo/lang/internal/bytecode"
 
+// OperandWidths returns the encoded byte width of each operand of op.
+func OperandWidths(op bytecode.OpCode) []int {
+	switch op {
+	case bytecode.OpConst, bytecode.OpJump, bytecode.OpJumpIfFalse, bytecode.OpJumpIfTrue, bytecode.OpCall, bytecode.OpIterNext, bytecode.OpSpawn,
+		bytecode.OpVariant, bytecode.OpIsVariant:
+		return []int{2}
+	case bytecode.OpLoadLocal, bytecode.OpStoreLocal, bytecode.OpTeeLocal, bytecode.OpVariantField:
+		return []int{1}
+	case bytecode.OpLoadLocal2:
+		return []int{1, 1}
+	case bytecode.OpInvoke:
+		return []int{2, 1}
+	default:
+		return nil
+	}
+}
+
 func Decode(code []byte, ip int) (Instruction, bool) {
 	if ip >= len(code) {
 		return Instruction{}, false
 	}
 
 	opCode := bytecode.OpCode(code[ip])
-	switch opCode {
-	case bytecode.OpConst, bytecode.OpJump, bytecode.OpJumpIfFalse, bytecode.OpCall:
-		if ip+2 >= len(code) {
-			return Instruction{}, false
-		}
-		argument := int(uint16(code[ip+1])<<8 | uint16(code[ip+2]))
-		return Instruction{OpCode: opCode, Argument: argument, Size: 3}, true
+	size := GetInstructionSize(opCode)
+	if ip+size > len(code) {
+		return Instruction{}, false
+	}
 
-	case bytecode.OpLoadLocal, bytecode.OpStoreLocal:
-		if ip+1 >= len(code) {
-			return Instruction{}, false
+	instr := Instruction{OpCode: opCode, Size: size, Address: ip}
+	pos := ip + 1
+	for k, w := range OperandWidths(opCode) {
+		v := int(code[pos])
+		if w == 2 {
+			v = int(uint16(code[pos])<<8 | uint16(code[pos+1]))
 		}
-		return Instruction{OpCode: opCode, Argument: int(code[ip+1]), Size: 2}, true
-
-	default:
-		return Instruction{OpCode: opCode, Argument: 0, Size: 1}, true
+		if k == 0 {
+			instr.Argument = v
+		} else {
+			instr.Argument2 = v
+		}
+		pos += w
 	}
+	return instr, true
 }
 
 func GetInstructionSize(op bytecode.OpCode) int {
-	switch op {
-	case bytecode.OpConst, bytecode.OpJump, bytecode.OpJumpIfFalse, bytecode.OpCall:
-		return 3
-	case bytecode.OpLoadLocal, bytecode.OpStoreLocal:
-		return 2
-	default:
-		return 1
+	size := 1
+	for _, w := range OperandWidths(op) {
+		size += w
+	}
+	return size
+}
+
+// Encode appends op with its operands to dst.
+func Encode(dst []byte, op bytecode.OpCode, args ...int) []byte {
+	dst = append(dst, byte(op))
+	for k, w := range OperandWidths(op) {
+		v := 0
+		if k < len(args) {
+			v = args[k]
+		}
+		if w == 2 {
+			dst = append(dst, byte(uint16(v)>>8), byte(uint16(v)))
+		} else {
+			dst = append(dst, byte(v))
+		}
+	}
+	return dst
+}
+
+// DecodeAll decodes every instruction in code. It stops at the first
+// truncated instruction.
+func DecodeAll(code []byte) []Instruction {
+	var out []Instruction
+	for ip := 0; ip < len(code); {
+		instr, ok := Decode(code, ip)
+		if !ok {
+			break
+		}
+		out = append(out, instr)
+		ip += instr.Size
 	}
+	return out
 }
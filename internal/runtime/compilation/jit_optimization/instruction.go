@@ -3,7 +3,21 @@ package jit_optimization
 import "github.com/dunooo0ooo/lang/internal/bytecode"
 
 type Instruction struct {
-	OpCode   bytecode.OpCode
-	Argument int
-	Size     int
-}
\ No newline at end of file
+	OpCode    bytecode.OpCode
+	Argument  int
+	Argument2 int
+	Size      int
+	Address   int
+}
+
+// Operand returns the k-th operand of the instruction (0 or 1).
+func (i Instruction) Operand(k int) int {
+	if k == 0 {
+		return i.Argument
+	}
+	return i.Argument2
+}
+
+func IsJump(op bytecode.OpCode) bool {
+	return op == bytecode.OpJump || op == bytecode.OpJumpIfFalse || op == bytecode.OpJumpIfTrue || op == bytecode.OpIterNext
+}
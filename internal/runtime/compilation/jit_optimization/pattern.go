@@ -0,0 +1,99 @@
+package jit_optimization
+
+import "github.com/dunooo0ooo/lang/internal/bytecode"
+
+type operandKind int
+
+const (
+	operandAny operandKind = iota
+	operandBind
+	operandSame
+	operandLit
+	operandConstInt
+)
+
+// Operand constrains one operand of a pattern step.
+type Operand struct {
+	kind  operandKind
+	name  string
+	value int64
+}
+
+// Any matches every operand value.
+func Any() Operand { return Operand{kind: operandAny} }
+
+// Bind matches every operand value and records it under name.
+func Bind(name string) Operand { return Operand{kind: operandBind, name: name} }
+
+// Same matches only the value previously recorded under name.
+func Same(name string) Operand { return Operand{kind: operandSame, name: name} }
+
+// Lit matches only the raw operand value v.
+func Lit(v int) Operand { return Operand{kind: operandLit, value: int64(v)} }
+
+// ConstInt matches an OpConst operand whose constant is the int v.
+func ConstInt(v int64) Operand { return Operand{kind: operandConstInt, value: v} }
+
+// Step is one instruction of a pattern.
+type Step struct {
+	Op   bytecode.OpCode
+	Args []Operand
+}
+
+func Op(op bytecode.OpCode, args ...Operand) Step {
+	return Step{Op: op, Args: args}
+}
+
+// TemplateArg produces an operand of an emitted instruction.
+type TemplateArg struct {
+	name  string
+	value int
+}
+
+// From emits the operand recorded under name.
+func From(name string) TemplateArg { return TemplateArg{name: name} }
+
+// Imm emits the literal operand v.
+func Imm(v int) TemplateArg { return TemplateArg{value: v} }
+
+// Template is one instruction of a replacement.
+type Template struct {
+	Op   bytecode.OpCode
+	Args []TemplateArg
+}
+
+func Emit(op bytecode.OpCode, args ...TemplateArg) Template {
+	return Template{Op: op, Args: args}
+}
+
+// Rule rewrites every window of code matching Pattern into Replace.
+// Guard, if set, can reject a match after the pattern itself matched.
+type Rule struct {
+	Name    string
+	Pattern []Step
+	Replace []Template
+	Guard   func(m *Match) bool
+}
+
+// Match describes a matched window [Start, End) of a chunk.
+type Match struct {
+	Start    int
+	End      int
+	Instrs   []Instruction
+	Bindings map[string]int
+
+	chunk *bytecode.Chunk
+}
+
+// OpAt returns the opcode at addr, or false if addr is outside the code.
+func (m *Match) OpAt(addr int) (bytecode.OpCode, bool) {
+	if addr < 0 || addr >= len(m.chunk.Code) {
+		return 0, false
+	}
+	return bytecode.OpCode(m.chunk.Code[addr]), true
+}
+
+func (m *Match) opIs(addr int, op bytecode.OpCode) bool {
+	got, ok := m.OpAt(addr)
+	return ok && got == op
+}
@@ -2,115 +2,89 @@ package jit_optimization
 
 import "github.com/dunooo0ooo/lang/internal/bytecode"
 
-func DetectSwapPattern(code []byte, start int) (bool, []byte, int) {
-	reader := CodeReader{code: code, ip: start}
-
-	// Чтение arr[j]
-	arraySlot, ok := reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok {
-		return false, nil, 0
-	}
-	indexSlot, ok := reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || !reader.ExpectInstruction(bytecode.OpArrayGet) {
-		return false, nil, 0
-	}
-
-	// Чтение arr[j+1]
-	temp, ok := reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != arraySlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != indexSlot ||
-		!reader.ExpectInstruction(bytecode.OpConst) ||
-		!reader.ExpectInstruction(bytecode.OpAdd) ||
-		!reader.ExpectInstruction(bytecode.OpArrayGet) {
-		return false, nil, 0
-	}
-
-	// Проверка условия arr[j] > arr[j+1]
-	if !reader.ExpectInstruction(bytecode.OpGt) {
-		return false, nil, 0
-	}
-	skipAddress, ok := reader.ExpectArgument(bytecode.OpJumpIfFalse)
-	if !ok || !reader.ExpectInstruction(bytecode.OpPop) {
-		return false, nil, 0
-	}
-
-	// Чтение tmp = arr[j]
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != arraySlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != indexSlot || !reader.ExpectInstruction(bytecode.OpArrayGet) {
-		return false, nil, 0
-	}
-	tempSlot, ok := reader.ExpectArgument(bytecode.OpStoreLocal)
-	if !ok {
-		return false, nil, 0
-	}
-
-	// Чтение arr[j] = arr[j+1]
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != arraySlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != indexSlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != arraySlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != indexSlot ||
-		!reader.ExpectInstruction(bytecode.OpConst) ||
-		!reader.ExpectInstruction(bytecode.OpAdd) ||
-		!reader.ExpectInstruction(bytecode.OpArrayGet) ||
-		!reader.ExpectInstruction(bytecode.OpArraySet) {
-		return false, nil, 0
+func (r *Rule) match(ch *bytecode.Chunk, start int) (*Match, bool) {
+	if len(r.Pattern) == 0 {
+		return nil, false
 	}
+	m := &Match{Start: start, Bindings: make(map[string]int), chunk: ch}
 
-	// Чтение arr[j+1] = tmp
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != arraySlot {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != indexSlot || !reader.ExpectInstruction(bytecode.OpConst) || !reader.ExpectInstruction(bytecode.OpAdd) {
-		return false, nil, 0
-	}
-	temp, ok = reader.ExpectArgument(bytecode.OpLoadLocal)
-	if !ok || temp != tempSlot || !reader.ExpectInstruction(bytecode.OpArraySet) {
-		return false, nil, 0
-	}
+	ip := start
+	for _, step := range r.Pattern {
+		instr, ok := Decode(ch.Code, ip)
+		if !ok || instr.OpCode != step.Op {
+			return nil, false
+		}
+		for k, c := range step.Args {
+			if !c.accept(m, instr.Operand(k)) {
+				return nil, false
+			}
+		}
+		m.Instrs = append(m.Instrs, instr)
+		ip += instr.Size
+	}
+	m.End = ip
 
-	// Проверка прыжка в конец
-	endAddress, ok := reader.ExpectArgument(bytecode.OpJump)
-	if !ok {
-		return false, nil, 0
+	if r.Guard != nil && !r.Guard(m) {
+		return nil, false
 	}
+	return m, true
+}
 
-	// Валидация паттерна
-	if skipAddress < 0 || skipAddress >= len(code) ||
-		bytecode.OpCode(code[skipAddress]) != bytecode.OpPop ||
-		endAddress != skipAddress+1 {
-		return false, nil, 0
+func (o Operand) accept(m *Match, v int) bool {
+	switch o.kind {
+	case operandAny:
+		return true
+	case operandBind:
+		m.Bindings[o.name] = v
+		return true
+	case operandSame:
+		prev, ok := m.Bindings[o.name]
+		return ok && prev == v
+	case operandLit:
+		return int64(v) == o.value
+	case operandConstInt:
+		if v >= len(m.chunk.Constants) {
+			return false
+		}
+		c := m.chunk.Constants[v]
+		return c.Kind == bytecode.ValInt && c.I == o.value
+	default:
+		return false
 	}
+}
 
-	// Генерация оптимизированного кода
-	optimizedCode := []byte{
-		byte(bytecode.OpLoadLocal), byte(arraySlot),
-		byte(bytecode.OpLoadLocal), byte(indexSlot),
-		byte(bytecode.OpArraySwapJit),
-	}
+func (r *Rule) emit(m *Match) []byte {
+	out := []byte{}
+	for _, t := range r.Replace {
+		args := make([]int, len(t.Args))
+		for k, a := range t.Args {
+			if a.name != "" {
+				args[k] = m.Bindings[a.name]
+			} else {
+				args[k] = a.value
+			}
+		}
+		out = Encode(out, t.Op, args...)
+	}
+	return out
+}
 
-	patternLength := reader.IP() - start
-	if patternLength <= 0 || start+patternLength > len(code) {
-		return false, nil, 0
-	}
+// crossesJumpTarget reports whether some jump lands strictly inside the window.
+func (m *Match) crossesJumpTarget(targets map[int]bool) bool {
+	for _, instr := range m.Instrs[1:] {
+		if targets[instr.Address] {
+			return true
+		}
+	}
+	return false
+}
 
-	return true, optimizedCode, patternLength
+func jumpTargets(code []byte) map[int]bool {
+	targets := make(map[int]bool)
+	for _, instr := range DecodeAll(code) {
+		if IsJump(instr.OpCode) {
+			targets[instr.Argument] = true
+		}
+	}
+	return targets
 }
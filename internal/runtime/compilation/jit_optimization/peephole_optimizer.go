@@ -4,34 +4,97 @@ import (
 	"github.com/dunooo0ooo/lang/internal/bytecode"
 )
 
+const maxPeepholePasses = 8
+
+type Optimizer struct {
+	rules []Rule
+	hits  map[string]int
+}
+
+func NewOptimizer(rules []Rule) *Optimizer {
+	return &Optimizer{rules: rules, hits: make(map[string]int)}
+}
+
+// Hits returns how many times each rule has fired.
+func (o *Optimizer) Hits() map[string]int {
+	out := make(map[string]int, len(o.hits))
+	for name, n := range o.hits {
+		out[name] = n
+	}
+	return out
+}
+
 func OptimizePeephole(fn *bytecode.FunctionInfo) {
-	chunk := &fn.Chunk
+	NewOptimizer(DefaultRules()).Optimize(fn)
+}
+
+// Optimize rewrites fn until no rule fires any more.
+func (o *Optimizer) Optimize(fn *bytecode.FunctionInfo) {
+	for pass := 0; pass < maxPeepholePasses; pass++ {
+		if !o.runPass(&fn.Chunk) {
+			return
+		}
+	}
+}
+
+func (o *Optimizer) runPass(chunk *bytecode.Chunk) bool {
 	originalCode := chunk.Code
+	targets := jumpTargets(originalCode)
+	for _, h := range chunk.Handlers {
+		targets[h.Start], targets[h.End], targets[h.Target] = true, true, true
+	}
 
 	var patches []codePatch
+	var fired []string
 
 	for instructionPointer := 0; instructionPointer < len(originalCode); {
-		op := bytecode.OpCode(originalCode[instructionPointer])
-		size := GetInstructionSize(op)
-
-		if size <= 0 || instructionPointer+size > len(originalCode) {
+		instr, ok := Decode(originalCode, instructionPointer)
+		if !ok {
 			break
 		}
 
-		if found, optimized, patternSize := DetectSwapPattern(originalCode, instructionPointer); found {
+		if rule, m := o.matchAt(chunk, instructionPointer, targets); m != nil {
 			patches = append(patches, codePatch{
-				startAddress: instructionPointer,
-				endAddress:   instructionPointer + patternSize,
-				newCode:      optimized,
+				startAddress: m.Start,
+				endAddress:   m.End,
+				newCode:      rule.emit(m),
 			})
-			instructionPointer += patternSize
+			fired = append(fired, rule.Name)
+			instructionPointer = m.End
 			continue
 		}
 
-		instructionPointer += size
+		instructionPointer += instr.Size
+	}
+
+	if len(patches) == 0 {
+		return false
+	}
+
+	code, addressMapping, ok := rewriteBytecode(originalCode, patches)
+	if !ok {
+		return false
+	}
+	chunk.Code = code
+	chunk.Lines = remapLines(chunk.Lines, patches, addressMapping)
+	for i := range chunk.Handlers {
+		h := &chunk.Handlers[i]
+		h.Start, h.End, h.Target = addressMapping[h.Start], addressMapping[h.End], addressMapping[h.Target]
+	}
+	for _, name := range fired {
+		o.hits[name]++
 	}
+	return true
+}
 
-	if len(patches) > 0 {
-		chunk.Code = rewriteBytecode(originalCode, patches)
+func (o *Optimizer) matchAt(chunk *bytecode.Chunk, ip int, targets map[int]bool) (*Rule, *Match) {
+	for i := range o.rules {
+		rule := &o.rules[i]
+		m, ok := rule.match(chunk, ip)
+		if !ok || m.crossesJumpTarget(targets) {
+			continue
+		}
+		return rule, m
 	}
+	return nil, nil
 }
@@ -0,0 +1,114 @@
+package jit_optimization
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+func fnWithCode(consts []bytecode.Value, code ...[]byte) *bytecode.FunctionInfo {
+	fn := bytecode.CreateFunction("f", 0)
+	fn.Chunk.Constants = consts
+	for _, c := range code {
+		fn.Chunk.Code = append(fn.Chunk.Code, c...)
+	}
+	return fn
+}
+
+func TestPeepholeRules(t *testing.T) {
+	zero := []bytecode.Value{{Kind: bytecode.ValInt, I: 0}}
+
+	tests := []struct {
+		name   string
+		consts []bytecode.Value
+		code   [][]byte
+		want   []byte
+		rule   string
+	}{
+		{
+			name: "load-load",
+			code: [][]byte{Encode(nil, bytecode.OpLoadLocal, 1), Encode(nil, bytecode.OpLoadLocal, 2), Encode(nil, bytecode.OpReturn)},
+			want: append(Encode(nil, bytecode.OpLoadLocal2, 1, 2), byte(bytecode.OpReturn)),
+			rule: "load-load",
+		},
+		{
+			name: "store-load same slot",
+			code: [][]byte{Encode(nil, bytecode.OpStoreLocal, 3), Encode(nil, bytecode.OpLoadLocal, 3), Encode(nil, bytecode.OpReturn)},
+			want: append(Encode(nil, bytecode.OpTeeLocal, 3), byte(bytecode.OpReturn)),
+			rule: "store-load",
+		},
+		{
+			name:   "add zero",
+			consts: zero,
+			code:   [][]byte{Encode(nil, bytecode.OpConst, 0), Encode(nil, bytecode.OpAdd), Encode(nil, bytecode.OpReturn)},
+			want:   []byte{byte(bytecode.OpReturn)},
+			rule:   "add-zero",
+		},
+		{
+			name: "double neg",
+			code: [][]byte{Encode(nil, bytecode.OpNeg), Encode(nil, bytecode.OpNeg), Encode(nil, bytecode.OpReturn)},
+			want: []byte{byte(bytecode.OpReturn)},
+			rule: "double-neg",
+		},
+		{
+			name: "not before jump",
+			code: [][]byte{
+				Encode(nil, bytecode.OpNot),            // 0
+				Encode(nil, bytecode.OpJumpIfFalse, 6), // 1
+				Encode(nil, bytecode.OpPop),            // 4
+				Encode(nil, bytecode.OpReturn),         // 5
+				Encode(nil, bytecode.OpPop),            // 6
+			},
+			want: concat(
+				Encode(nil, bytecode.OpJumpIfTrue, 5),
+				Encode(nil, bytecode.OpPop),
+				Encode(nil, bytecode.OpReturn),
+				Encode(nil, bytecode.OpPop),
+			),
+			rule: "not-jump-inversion",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := fnWithCode(tt.consts, tt.code...)
+			opt := NewOptimizer(DefaultRules())
+			opt.Optimize(fn)
+
+			if !bytes.Equal(fn.Chunk.Code, tt.want) {
+				t.Fatalf("code = %v, want %v", fn.Chunk.Code, tt.want)
+			}
+			if opt.Hits()[tt.rule] != 1 {
+				t.Fatalf("hits = %v, want %s fired once", opt.Hits(), tt.rule)
+			}
+		})
+	}
+}
+
+func TestPeepholeSkipsWindowWithJumpTarget(t *testing.T) {
+	fn := fnWithCode(nil,
+		Encode(nil, bytecode.OpNeg),     // 0
+		Encode(nil, bytecode.OpNeg),     // 1 <- jump target
+		Encode(nil, bytecode.OpJump, 1), // 2
+	)
+	want := append([]byte(nil), fn.Chunk.Code...)
+
+	opt := NewOptimizer(DefaultRules())
+	opt.Optimize(fn)
+
+	if !bytes.Equal(fn.Chunk.Code, want) {
+		t.Fatalf("window containing a jump target was rewritten: %v", fn.Chunk.Code)
+	}
+	if len(opt.Hits()) != 0 {
+		t.Fatalf("unexpected hits: %v", opt.Hits())
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -1,32 +0,0 @@
-package jit_optimization
-
-import "github.com/dunooo0ooo/lang/internal/bytecode"
-
-type CodeReader struct {
-	code []byte
-	ip   int
-}
-
-func (r *CodeReader) GetNextInstruction() (Instruction, bool) {
-	instr, ok := Decode(r.code, r.ip)
-	if !ok {
-		return Instruction{}, false
-	}
-	r.ip += instr.Size
-	return instr, true
-}
-
-func (r *CodeReader) ExpectInstruction(opCode bytecode.OpCode) bool {
-	instr, ok := r.GetNextInstruction()
-	return ok && instr.OpCode == opCode
-}
-
-func (r *CodeReader) ExpectArgument(opCode bytecode.OpCode) (int, bool) {
-	instr, ok := r.GetNextInstruction()
-	if !ok || instr.OpCode != opCode {
-		return 0, false
-	}
-	return instr.Argument, true
-}
-
-func (r *CodeReader) IP() int { return r.ip }
@@ -0,0 +1,82 @@
+package jit_optimization
+
+import "github.com/dunooo0ooo/lang/internal/bytecode"
+
+// DefaultRules returns the standard rule set. Longer rules come first so
+// that they win over the shorter ones they overlap with.
+func DefaultRules() []Rule {
+	return []Rule{
+		arraySwapRule(),
+		{
+			Name:    "not-jump-inversion",
+			Pattern: []Step{Op(bytecode.OpNot), Op(bytecode.OpJumpIfFalse, Bind("target"))},
+			Replace: []Template{Emit(bytecode.OpJumpIfTrue, From("target"))},
+			// The condition stays on the stack after the jump, so inverting is
+			// only sound when both successors discard it immediately.
+			Guard: func(m *Match) bool {
+				return m.opIs(m.End, bytecode.OpPop) && m.opIs(m.Bindings["target"], bytecode.OpPop)
+			},
+		},
+		{
+			Name:    "add-zero",
+			Pattern: []Step{Op(bytecode.OpConst, ConstInt(0)), Op(bytecode.OpAdd)},
+		},
+		{
+			Name:    "double-neg",
+			Pattern: []Step{Op(bytecode.OpNeg), Op(bytecode.OpNeg)},
+		},
+		{
+			Name:    "store-load",
+			Pattern: []Step{Op(bytecode.OpStoreLocal, Bind("slot")), Op(bytecode.OpLoadLocal, Same("slot"))},
+			Replace: []Template{Emit(bytecode.OpTeeLocal, From("slot"))},
+		},
+		{
+			Name:    "load-load",
+			Pattern: []Step{Op(bytecode.OpLoadLocal, Bind("a")), Op(bytecode.OpLoadLocal, Bind("b"))},
+			Replace: []Template{Emit(bytecode.OpLoadLocal2, From("a"), From("b"))},
+		},
+	}
+}
+
+// arraySwapRule matches the bubble-sort idiom
+//
+//	if get(arr, j) > get(arr, j + 1) {
+//	    let tmp: int = get(arr, j);
+//	    set(arr, j, get(arr, j + 1));
+//	    set(arr, j + 1, tmp);
+//	}
+//
+// and replaces it with a single OpArraySwapJit.
+func arraySwapRule() Rule {
+	arr, j := Same("arr"), Same("j")
+	return Rule{
+		Name: "array-swap",
+		Pattern: []Step{
+			// arr[j] > arr[j+1]
+			Op(bytecode.OpLoadLocal, Bind("arr")), Op(bytecode.OpLoadLocal, Bind("j")), Op(bytecode.OpArrayGet),
+			Op(bytecode.OpLoadLocal, arr), Op(bytecode.OpLoadLocal, j), Op(bytecode.OpConst, ConstInt(1)), Op(bytecode.OpAdd), Op(bytecode.OpArrayGet),
+			Op(bytecode.OpGt), Op(bytecode.OpJumpIfFalse, Bind("skip")), Op(bytecode.OpPop),
+			// tmp = arr[j]
+			Op(bytecode.OpLoadLocal, arr), Op(bytecode.OpLoadLocal, j), Op(bytecode.OpArrayGet), Op(bytecode.OpStoreLocal, Bind("tmp")),
+			// arr[j] = arr[j+1]
+			Op(bytecode.OpLoadLocal, arr), Op(bytecode.OpLoadLocal, j),
+			Op(bytecode.OpLoadLocal, arr), Op(bytecode.OpLoadLocal, j), Op(bytecode.OpConst, ConstInt(1)), Op(bytecode.OpAdd), Op(bytecode.OpArrayGet),
+			Op(bytecode.OpArraySet), Op(bytecode.OpPop),
+			// arr[j+1] = tmp
+			Op(bytecode.OpLoadLocal, arr), Op(bytecode.OpLoadLocal, j), Op(bytecode.OpConst, ConstInt(1)), Op(bytecode.OpAdd),
+			Op(bytecode.OpLoadLocal, Same("tmp")), Op(bytecode.OpArraySet), Op(bytecode.OpPop),
+			Op(bytecode.OpJump, Bind("end")),
+		},
+		Replace: []Template{
+			Emit(bytecode.OpLoadLocal, From("arr")),
+			Emit(bytecode.OpLoadLocal, From("j")),
+			Emit(bytecode.OpArraySwapJit),
+		},
+		// The else branch must be empty: it only pops the condition, and the
+		// swap's null result falls through into that pop.
+		Guard: func(m *Match) bool {
+			skip := m.Bindings["skip"]
+			return skip == m.End && m.opIs(skip, bytecode.OpPop) && m.Bindings["end"] == skip+1
+		},
+	}
+}
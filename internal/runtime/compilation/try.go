@@ -0,0 +1,126 @@
+package compilation
+
+import (
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	jit "github.com/dunooo0ooo/lang/internal/runtime/compilation/jit_optimization"
+)
+
+// compileTry covers the code of the body with a handler whose target
+// stores the error in the catch variable. Handlers are added once their
+// body is compiled, so inner ones come first.
+func (c *Compiler) compileTry(s *ast.TryStmt) {
+	ch := c.chunk()
+
+	start := len(ch.Code)
+	c.compileBlock(s.Body, false)
+	end := len(ch.Code)
+
+	ch.Write(bytecode.OpJump)
+	jumpEnd := len(ch.Code)
+	ch.WriteUint16(0)
+
+	target := len(ch.Code)
+	base := len(c.locals)
+	slot := c.addLocal(s.Var, bytecode.TypeEnum)
+	ch.Write(bytecode.OpStoreLocal)
+	_ = ch.WriteByte(byte(slot))
+	c.compileBlock(s.Catch, false)
+	c.locals = c.locals[:base]
+
+	_ = ch.PatchUint16(jumpEnd, uint16(len(ch.Code)))
+	ch.Handlers = append(ch.Handlers, bytecode.Handler{Start: start, End: end, Target: target})
+}
+
+// setHandlerDepths works out how many values are on the operand stack
+// when each try block of the function starts, which is not known while
+// the block is compiled: a try may sit in a block expression with operands
+// of the enclosing expression below it. The code is walked in order; an
+// instruction following a jump or a return is reached by some jump that
+// was already seen.
+func (c *Compiler) setHandlerDepths() error {
+	ch := c.chunk()
+	if len(ch.Handlers) == 0 {
+		return nil
+	}
+	depths := make(map[int]int)
+	d := 0
+	reachable := true
+	for _, in := range jit.DecodeAll(ch.Code) {
+		if !reachable {
+			d = depths[in.Address]
+		}
+		for i := range ch.Handlers {
+			if h := &ch.Handlers[i]; h.Start == in.Address {
+				h.Depth = d
+				depths[h.Target] = d + 1
+			}
+		}
+		pop, push, err := c.stackEffect(ch, in)
+		if err != nil {
+			return err
+		}
+		d += push - pop
+		switch in.OpCode {
+		case bytecode.OpJump, bytecode.OpJumpIfFalse, bytecode.OpJumpIfTrue:
+			depths[in.Argument] = d
+		case bytecode.OpIterNext:
+			depths[in.Argument] = d - 1
+		}
+		reachable = in.OpCode != bytecode.OpJump && in.OpCode != bytecode.OpReturn
+	}
+	return nil
+}
+
+// stackEffect returns how many values in takes off the operand stack and
+// how many it leaves there when control goes on to the next instruction.
+func (c *Compiler) stackEffect(ch *bytecode.Chunk, in jit.Instruction) (pop, push int, err error) {
+	switch in.OpCode {
+	case bytecode.OpConst, bytecode.OpLoadLocal, bytecode.OpReadLine, bytecode.OpReadInt, bytecode.OpWait:
+		return 0, 1, nil
+	case bytecode.OpLoadLocal2:
+		return 0, 2, nil
+	case bytecode.OpStoreLocal, bytecode.OpPop, bytecode.OpYield:
+		return 1, 0, nil
+	case bytecode.OpJump, bytecode.OpReturn:
+		return 0, 0, nil
+	case bytecode.OpTeeLocal, bytecode.OpNeg, bytecode.OpNot, bytecode.OpJumpIfFalse, bytecode.OpJumpIfTrue,
+		bytecode.OpArrayNew, bytecode.OpPrint, bytecode.OpPrintLn, bytecode.OpEPrintLn, bytecode.OpNext, bytecode.OpIterNext,
+		bytecode.OpChanNew, bytecode.OpRecv, bytecode.OpClose, bytecode.OpIsVariant, bytecode.OpVariantField,
+		bytecode.OpParseInt, bytecode.OpParseFloat, bytecode.OpPanic, bytecode.OpIntToFloat:
+		return 1, 1, nil
+	case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv, bytecode.OpMod, bytecode.OpPow,
+		bytecode.OpEq, bytecode.OpNe, bytecode.OpLt, bytecode.OpLe, bytecode.OpGt, bytecode.OpGe,
+		bytecode.OpArrayGet, bytecode.OpArrayGetUnchecked, bytecode.OpArraySwapJit, bytecode.OpSend:
+		return 2, 1, nil
+	case bytecode.OpArraySet, bytecode.OpArraySetUnchecked:
+		return 3, 1, nil
+	case bytecode.OpVariant:
+		if in.Argument >= len(c.mod.Variants) {
+			return 0, 0, fmt.Errorf("function %s: bad variant %d", c.fn.Name, in.Argument)
+		}
+		return c.mod.Variants[in.Argument].Arity, 1, nil
+	case bytecode.OpCall, bytecode.OpSpawn:
+		if in.Argument >= len(ch.Constants) {
+			return 0, 0, fmt.Errorf("function %s: bad constant %d", c.fn.Name, in.Argument)
+		}
+		name := ch.Constants[in.Argument].S
+		n := 0
+		if fn, ok := c.mod.Functions[name]; ok {
+			n = fn.ParamCount
+		} else if nat, ok := c.mod.Natives[name]; ok {
+			n = len(nat.ParamTypes)
+		} else {
+			return 0, 0, fmt.Errorf("function %s: unknown callee %q", c.fn.Name, name)
+		}
+		if in.OpCode == bytecode.OpSpawn {
+			return n, 0, nil
+		}
+		return n, 1, nil
+	case bytecode.OpInvoke:
+		return in.Argument2 + 1, 1, nil
+	}
+	return 0, 0, fmt.Errorf("function %s: unknown opcode %d", c.fn.Name, in.OpCode)
+}
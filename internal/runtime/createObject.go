@@ -3,20 +3,63 @@ package runtime
 import "github.com/dunooo0ooo/lang/internal/bytecode"
 
 func (vm *VM) newObject(t bytecode.ObjectType) *bytecode.Object {
-	if vm.heap.MaxObjects == 0 {
-		vm.heap.MaxObjects = 8
+	return vm.allocate(t, 0)
+}
+
+func (vm *VM) newArray(n int) *bytecode.Object {
+	obj := vm.allocate(bytecode.ObjArray, n)
+	obj.Items = make([]bytecode.Value, n)
+	return obj
+}
+
+// newVariant makes a variant holding a copy of items.
+func (vm *VM) newVariant(desc *bytecode.Variant, items []bytecode.Value) *bytecode.Object {
+	obj := vm.allocate(bytecode.ObjVariant, len(items))
+	obj.State = desc
+	obj.Items = make([]bytecode.Value, len(items))
+	for i, it := range items {
+		vm.writeBarrier(obj, it)
+		obj.Items[i] = it
 	}
+	return obj
+}
 
-	if vm.heap.NumObjects >= vm.heap.MaxObjects {
-		vm.gc()
+// NewArray allocates an array holding items, to be passed into a call. It
+// stays reachable until the next call into the VM returns.
+func (vm *VM) NewArray(items []bytecode.Value) (bytecode.Value, error) {
+	if err := vm.reserve(len(items)); err != nil {
+		return bytecode.Value{}, err
+	}
+	obj := vm.newArray(len(items))
+	for i, it := range items {
+		vm.writeBarrier(obj, it)
+		obj.Items[i] = it
 	}
+	v := bytecode.Value{Kind: bytecode.ValObject, Obj: obj}
+	vm.pinned = append(vm.pinned, v)
+	return v, nil
+}
+
+// allocate makes room for an object with the given number of items and
+// links it into the nursery, or straight into the old generation if it is
+// large enough to make copying it through minor collections pointless.
+func (vm *VM) allocate(t bytecode.ObjectType, items int) *bytecode.Object {
+	size := objectSize(items)
+	vm.collectBefore(size)
 
-	obj := &bytecode.Object{
-		Type: t,
-		Next: vm.heap.Head,
+	obj := &bytecode.Object{Type: t, Size: size}
+	if size >= vm.nurseryBytes/4 {
+		obj.Old = true
+		obj.Next = vm.heap.Head
+		vm.heap.Head = obj
+		vm.heap.OldBytes += size
+	} else {
+		obj.Next = vm.heap.Young
+		vm.heap.Young = obj
+		vm.heap.YoungBytes += size
 	}
-	vm.heap.Head = obj
 	vm.heap.NumObjects++
+	vm.countAlloc(obj)
 
 	return obj
 }
@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// RuntimeError is a failure raised by an instruction, tagged with the
+// source line it was compiled from. Code inlined from another function
+// keeps the line of the inlined function.
+type RuntimeError struct {
+	Line int
+	Err  error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+func withLine(err error, line int) error {
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		return err
+	}
+	return &RuntimeError{Line: line, Err: err}
+}
+
+// catchable reports whether a try block may catch err. Running out of the
+// limits the host set, and deadlocks, end the call.
+func catchable(err error) bool {
+	for _, stop := range []error{context.Canceled, context.DeadlineExceeded, ErrBudgetExceeded, ErrHeapLimit, ErrDeadlock} {
+		if errors.Is(err, stop) {
+			return false
+		}
+	}
+	return true
+}
+
+// errorValue makes the Error a catch receives for err, raised by an
+// instruction compiled from source line line.
+func (vm *VM) errorValue(err error, line int) bytecode.Value {
+	items := []bytecode.Value{
+		{Kind: bytecode.ValString, S: err.Error()},
+		{Kind: bytecode.ValInt, I: int64(line)},
+	}
+	return bytecode.Value{Kind: bytecode.ValObject, Obj: vm.newVariant(bytecode.ErrorVariant, items)}
+}
@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+var errGeneratorFinished = errors.New("next: generator has finished")
+
+// frame is the activation of a bytecode function. Frames live on vm.frames
+// rather than the Go stack, so a generator's frame can be suspended as
+// part of a heap object and resumed later.
+type frame struct {
+	fn     *bytecode.FunctionInfo
+	ip     int
+	locals []bytecode.Value
+	stack  []bytecode.Value
+
+	// gen is the generator whose body the frame runs, if any. Once the
+	// body finishes its caller continues at doneIP, or fails when doneIP
+	// is negative.
+	gen     *bytecode.Object
+	doneIP  int
+	running bool
+}
+
+func newFrame(fn *bytecode.FunctionInfo, args []bytecode.Value) *frame {
+	locals := make([]bytecode.Value, fn.NumLocals)
+	copy(locals, args)
+	return &frame{fn: fn, locals: locals, stack: make([]bytecode.Value, 0, 16)}
+}
+
+func (vm *VM) pushFrame(fr *frame) {
+	vm.frames = append(vm.frames, fr)
+	vm.roots = append(vm.roots, rootSet{fn: fr.fn, locals: &fr.locals, stack: &fr.stack})
+}
+
+func (vm *VM) popFrame() {
+	n := len(vm.frames) - 1
+	vm.frames[n] = nil
+	vm.frames = vm.frames[:n]
+	vm.roots = vm.roots[:len(vm.roots)-1]
+}
+
+// unwind drops the frames above base after a failed run. Generators whose
+// bodies were running are left finished.
+func (vm *VM) unwind(base int) {
+	for len(vm.frames) > base {
+		if fr := vm.frames[len(vm.frames)-1]; fr.gen != nil {
+			fr.gen.State = nil
+		}
+		vm.popFrame()
+	}
+}
+
+// newGenerator makes a generator that runs fn on args once resumed. args
+// may live on an operand stack: they are copied before anything else can
+// overwrite them and stay rooted there while the generator is allocated.
+func (vm *VM) newGenerator(fn *bytecode.FunctionInfo, args []bytecode.Value) (*bytecode.Object, error) {
+	if err := vm.reserve(fn.NumLocals); err != nil {
+		return nil, err
+	}
+	gen := vm.allocate(bytecode.ObjGenerator, fn.NumLocals)
+	fr := newFrame(fn, args)
+	fr.gen = gen
+	gen.State = fr
+	return gen, nil
+}
+
+// Next resumes the generator gen until it yields a value, which it returns
+// with true, or finishes, when it returns false. Both the value and gen
+// stay pinned until the next call, so a host can keep draining gen.
+func (vm *VM) Next(ctx context.Context, gen bytecode.Value) (result bytecode.Value, ok bool, err error) {
+	vm.startCall(ctx)
+	defer func() {
+		if ferr := vm.flush(); err == nil && ferr != nil {
+			err = fmt.Errorf("flush output: %v", ferr)
+		}
+		vm.pinned = append(vm.pinned[:0], result, gen)
+	}()
+
+	if gen.Kind != bytecode.ValObject || gen.Obj == nil || gen.Obj.Type != bytecode.ObjGenerator {
+		return bytecode.Value{}, false, fmt.Errorf("next: value is not a generator")
+	}
+	fr, _ := gen.Obj.State.(*frame)
+	if fr == nil {
+		return bytecode.Value{Kind: bytecode.ValNull}, false, nil
+	}
+	if fr.running {
+		return bytecode.Value{}, false, fmt.Errorf("next: generator is already running")
+	}
+	// The host may hold the only reference to gen.
+	vm.pinned = append(vm.pinned, gen)
+	fr.running = true
+	result, ok, err = vm.run(fr)
+	if !ok {
+		result = bytecode.Value{Kind: bytecode.ValNull}
+	}
+	return result, ok, err
+}
@@ -1,56 +1,241 @@
 package runtime
 
-import "github.com/dunooo0ooo/lang/internal/bytecode"
+import (
+	"unsafe"
 
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const (
+	defaultGCPercent    = 100
+	defaultNurseryBytes = 256 << 10
+	minNextMajorBytes   = 4 << 20
+
+	// gcStressMajorEvery is how often a stress collection is a major one.
+	gcStressMajorEvery = 8
+)
+
+var (
+	objectHeaderBytes = int(unsafe.Sizeof(bytecode.Object{}))
+	valueBytes        = int(unsafe.Sizeof(bytecode.Value{}))
+)
+
+func objectSize(items int) int {
+	return objectHeaderBytes + items*valueBytes
+}
+
+// SetGCPercent sets how far the old generation may grow past the bytes
+// that survived the last major collection before the next one starts, in
+// percent, and returns the previous setting. A negative percent turns the
+// collector off.
+func (vm *VM) SetGCPercent(percent int) int {
+	prev := vm.gcPercent
+	vm.gcPercent = percent
+	vm.paceMajor()
+	return prev
+}
+
+func (vm *VM) paceMajor() {
+	next := vm.heap.OldBytes + vm.heap.OldBytes*vm.gcPercent/100
+	if next < minNextMajorBytes {
+		next = minNextMajorBytes
+	}
+	vm.heap.NextMajor = next
+}
+
+// collectBefore runs the collections due before size more bytes are
+// allocated: a minor one when the nursery is full, a major one when the
+// old generation has grown past its goal.
+func (vm *VM) collectBefore(size int) {
+	h := &vm.heap
+
+	if vm.gcStress {
+		vm.stressCount++
+		if vm.stressCount%gcStressMajorEvery == 0 {
+			vm.gc()
+		} else {
+			vm.minorGC()
+		}
+		return
+	}
+	if vm.gcPercent < 0 {
+		return
+	}
+
+	if h.MaxObjects > 0 && h.NumObjects >= h.MaxObjects {
+		vm.gc()
+		h.MaxObjects = h.NumObjects*2 + 8
+		return
+	}
+	if h.YoungBytes+size > vm.nurseryBytes {
+		vm.minorGC()
+	}
+	if h.OldBytes >= h.NextMajor {
+		vm.gc()
+	}
+}
+
+// gc is a full collection of both generations. Surviving nursery objects
+// are promoted.
 func (vm *VM) gc() {
-	vm.markFromRoots()
+	defer vm.beginCycle(true)()
+
+	vm.markRoots(false)
+	vm.drain(false)
 
-	vm.sweep()
+	vm.sweepOld()
+	vm.sweepYoung()
+	vm.forgetRemembered()
+
+	vm.paceMajor()
+}
+
+// minorGC collects the nursery only. Old objects are assumed live; the
+// remembered ones are scanned for pointers into the nursery.
+func (vm *VM) minorGC() {
+	defer vm.beginCycle(false)()
+
+	vm.markRoots(true)
+	for _, obj := range vm.heap.Remembered {
+		vm.scanObject(obj, true)
+	}
+	vm.drain(true)
+
+	vm.sweepYoung()
+	vm.forgetRemembered()
+}
+
+// writeBarrier must run before v is stored into arr. It remembers old
+// arrays that start to point into the nursery.
+func (vm *VM) writeBarrier(arr *bytecode.Object, v bytecode.Value) {
+	if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Old {
+		return
+	}
+	vm.remember(arr)
+}
 
-	if vm.heap.MaxObjects < 8 {
-		vm.heap.MaxObjects = 8
+// remember adds obj to the remembered set if it is old, for objects that
+// may have come to point into the nursery.
+func (vm *VM) remember(obj *bytecode.Object) {
+	if !obj.Old || obj.Remembered {
+		return
 	}
-	vm.heap.MaxObjects = vm.heap.NumObjects*2 + 8
+	obj.Remembered = true
+	vm.heap.Remembered = append(vm.heap.Remembered, obj)
 }
 
-func (vm *VM) markFromRoots() {
-	for _, rs := range vm.roots {
+func (vm *VM) markRoots(youngOnly bool) {
+	vm.walkRoots(func(_ rootRef, v bytecode.Value) {
+		vm.markValue(v, youngOnly)
+	})
+}
+
+// rootRef tells where a root found by walkRoots lives, in the terms of
+// SnapshotRoot.
+type rootRef struct {
+	kind  string
+	task  int
+	frame int
+	fn    *bytecode.FunctionInfo
+	slot  int
+}
+
+// walkRoots calls visit with every value the collector starts marking
+// from: the pinned values, the generators whose bodies are running, the
+// locals and operand stacks of the running frames and those of the tasks
+// waiting for their turn.
+func (vm *VM) walkRoots(visit func(rootRef, bytecode.Value)) {
+	for slot, v := range vm.pinned {
+		visit(rootRef{kind: "pinned", frame: -1, slot: slot}, v)
+	}
+	for i, fr := range vm.frames {
+		if fr.gen != nil {
+			visit(rootRef{kind: "generator", frame: i, fn: fr.fn}, bytecode.Value{Kind: bytecode.ValObject, Obj: fr.gen})
+		}
+	}
+	if vm.liveStack != nil && len(vm.frames) > 0 {
+		top := len(vm.frames) - 1
+		for slot, v := range *vm.liveStack {
+			visit(rootRef{kind: "stack", frame: top, fn: vm.frames[top].fn, slot: slot}, v)
+		}
+	}
+	if vm.sched != nil {
+		for _, t := range vm.sched.tasks {
+			for i, fr := range t.frames {
+				if fr.gen != nil {
+					visit(rootRef{kind: "generator", task: t.id, frame: i, fn: fr.fn}, bytecode.Value{Kind: bytecode.ValObject, Obj: fr.gen})
+				}
+				for slot, v := range fr.locals {
+					visit(rootRef{kind: "task", task: t.id, frame: i, fn: fr.fn, slot: slot}, v)
+				}
+				for slot, v := range fr.stack {
+					visit(rootRef{kind: "task", task: t.id, frame: i, fn: fr.fn, slot: len(fr.locals) + slot}, v)
+				}
+			}
+		}
+	}
+	for frame, rs := range vm.roots {
 		if rs.locals != nil {
-			for i := range *rs.locals {
-				vm.markValue((*rs.locals)[i])
+			for slot, v := range *rs.locals {
+				visit(rootRef{kind: "local", frame: frame, fn: rs.fn, slot: slot}, v)
 			}
 		}
 		if rs.stack != nil {
-			for i := range *rs.stack {
-				vm.markValue((*rs.stack)[i])
+			for slot, v := range *rs.stack {
+				visit(rootRef{kind: "stack", frame: frame, fn: rs.fn, slot: slot}, v)
 			}
 		}
 	}
 }
 
-func (vm *VM) markValue(v bytecode.Value) {
+func (vm *VM) markValue(v bytecode.Value, youngOnly bool) {
 	if v.Kind != bytecode.ValObject || v.Obj == nil {
 		return
 	}
-	vm.markObject(v.Obj)
+	vm.markObject(v.Obj, youngOnly)
 }
 
-func (vm *VM) markObject(obj *bytecode.Object) {
-	if obj == nil || obj.Mark {
+// markObject shades obj gray: marks it and queues it for scanning.
+func (vm *VM) markObject(obj *bytecode.Object, youngOnly bool) {
+	if obj == nil || obj.Mark || youngOnly && obj.Old {
 		return
 	}
 	obj.Mark = true
+	vm.gray = append(vm.gray, obj)
+}
+
+func (vm *VM) drain(youngOnly bool) {
+	for len(vm.gray) > 0 {
+		obj := vm.gray[len(vm.gray)-1]
+		vm.gray = vm.gray[:len(vm.gray)-1]
+		vm.scanObject(obj, youngOnly)
+	}
+}
 
+func (vm *VM) scanObject(obj *bytecode.Object, youngOnly bool) {
+	for _, vals := range references(obj) {
+		for i := range vals {
+			vm.markValue(vals[i], youngOnly)
+		}
+	}
+}
+
+// references returns the values obj holds: the items of an array or a
+// variant, the values buffered in a channel, or the locals and operand
+// stack of a suspended generator.
+func references(obj *bytecode.Object) [][]bytecode.Value {
 	switch obj.Type {
-	case bytecode.ObjArray:
-		for i := range obj.Items {
-			vm.markValue(obj.Items[i])
+	case bytecode.ObjArray, bytecode.ObjChan, bytecode.ObjVariant:
+		return [][]bytecode.Value{obj.Items}
+	case bytecode.ObjGenerator:
+		if fr, ok := obj.State.(*frame); ok {
+			return [][]bytecode.Value{fr.locals, fr.stack}
 		}
-	default:
 	}
+	return nil
 }
 
-func (vm *VM) sweep() {
+func (vm *VM) sweepOld() {
 	var prev *bytecode.Object
 	cur := vm.heap.Head
 
@@ -62,15 +247,53 @@ func (vm *VM) sweep() {
 			continue
 		}
 
-		vm.heap.NumObjects--
+		vm.heap.OldBytes -= cur.Size
+		next := cur.Next
+		vm.free(cur)
 
 		if prev == nil {
-			vm.heap.Head = cur.Next
-			cur = vm.heap.Head
-			continue
+			vm.heap.Head = next
+		} else {
+			prev.Next = next
 		}
+		cur = next
+	}
+}
+
+// sweepYoung frees the unmarked nursery objects and promotes the rest.
+func (vm *VM) sweepYoung() {
+	cur := vm.heap.Young
+	for cur != nil {
+		next := cur.Next
+		vm.heap.YoungBytes -= cur.Size
+
+		if cur.Mark {
+			cur.Mark = false
+			cur.Old = true
+			cur.Next = vm.heap.Head
+			vm.heap.Head = cur
+			vm.heap.OldBytes += cur.Size
+		} else {
+			vm.free(cur)
+		}
+		cur = next
+	}
+	vm.heap.Young = nil
+}
+
+// free drops obj's contents, so that a value still pointing at it after a
+// collection fails loudly instead of reading stale items.
+func (vm *VM) free(obj *bytecode.Object) {
+	vm.heap.NumObjects--
+	vm.countFree(obj)
+	obj.Items = nil
+	obj.State = nil
+	obj.Next = nil
+}
 
-		prev.Next = cur.Next
-		cur = prev.Next
+func (vm *VM) forgetRemembered() {
+	for _, obj := range vm.heap.Remembered {
+		obj.Remembered = false
 	}
+	vm.heap.Remembered = vm.heap.Remembered[:0]
 }
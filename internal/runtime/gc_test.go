@@ -4,6 +4,10 @@ import (
 	"testing"
 
 	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
+	"github.com/dunooo0ooo/lang/internal/sema"
 )
 
 func TestGC_DoesNotCrashAndFrees(t *testing.T) {
@@ -56,3 +60,284 @@ func TestGC_RespectsRoots(t *testing.T) {
 		t.Fatal("rooted object was collected")
 	}
 }
+
+var gcStressPrograms = []struct {
+	name string
+	src  string
+}{
+	{"nested literals", `
+fn main() -> int {
+    let s: int = 0;
+    for let i: int = 0; i < 200; i = i + 1 {
+        let grid: [][]int = [[i, i + 1], [i * 2, array(3)[0]], [7]];
+        s = s + grid[0][1] + grid[1][0] + grid[2][0];
+    }
+    return s;
+}
+`},
+	{"old array keeps young rows", `
+fn row(i: int) -> []int {
+    let r: []int = array(4);
+    set(r, 0, i);
+    set(r, 3, i * i);
+    return r;
+}
+fn main() -> int {
+    let rows: [][]int = [row(0), row(1), row(2), row(3), row(4), row(5), row(6), row(7)];
+    let junk: int = 0;
+    for let k: int = 0; k < 50; k = k + 1 { junk = junk + get(array(10), 0); }
+    let s: int = 0;
+    for let i: int = 0; i < 8; i = i + 1 { s = s + rows[i][0] + rows[i][3]; }
+    return s + junk;
+}
+`},
+	{"garbage in calls", `
+fn sum(a: []int, n: int) -> int {
+    let s: int = 0;
+    for let i: int = 0; i < n; i = i + 1 { s = s + get(a, i); }
+    return s;
+}
+fn build(n: int) -> []int {
+    let a: []int = array(n);
+    for let i: int = 0; i < n; i = i + 1 { set(a, i, i * 3); }
+    return a;
+}
+fn main() -> int {
+    let total: int = 0;
+    for let n: int = 1; n < 60; n = n + 1 {
+        total = total + sum(build(n), n);
+    }
+    return total;
+}
+`},
+	{"suspended generators", `
+gen fn rows(n: int) -> []int {
+    let keep: [][]int = [array(2), array(3)];
+    for let i: int = 0; i < n; i = i + 1 {
+        yield [i, i * i, keep[i % 2][0]];
+    }
+}
+gen fn held(n: int) -> int {
+    for let i: int = 0; i < n; i = i + 1 {
+        let mine: []int = [i + 1];
+        yield i;
+        yield mine[0];
+    }
+}
+fn main() -> int {
+    let a: iter<[]int> = rows(40);
+    let b: iter<[]int> = rows(40);
+    let s: int = 0;
+    for r in a {
+        let junk: []int = array(16);
+        let q: []int = next(b);
+        s = s + r[1] + q[0] + r[2] + get(junk, 3);
+    }
+    for x in held(20) {
+        let junk: []int = array(4);
+        s = s + x + get(junk, 0);
+    }
+    return s;
+}
+`},
+	{"parked tasks and channels", `
+fn pump(rows: chan<[]int>, n: int) {
+    let mine: []int = [n, n + 1];
+    for let i: int = 0; i < n; i = i + 1 {
+        send(rows, [i, mine[1], i * i]);
+    }
+    close(rows);
+}
+fn fold(rows: chan<[]int>, out: chan<[]int>) {
+    let acc: []int = array(1);
+    for r in rows {
+        let junk: []int = array(8);
+        set(acc, 0, get(acc, 0) + r[0] + r[1] + r[2] + get(junk, 2));
+    }
+    send(out, acc);
+}
+fn main() -> int {
+    let rows: chan<[]int> = chan<[]int>(3);
+    let out: chan<[]int> = chan<[]int>();
+    spawn pump(rows, 60);
+    spawn fold(rows, out);
+    let s: int = 0;
+    for let i: int = 0; i < 30; i = i + 1 {
+        let junk: []int = [i];
+        s = s + junk[0];
+    }
+    let acc: []int = recv(out);
+    wait();
+    return s + acc[0];
+}
+`},
+	{"variants holding arrays", `
+enum List { Cons([]int, List), Nil }
+fn build(n: int) -> List {
+    let l: List = Nil;
+    for let i: int = 0; i < n; i = i + 1 {
+        let junk: []int = array(8);
+        l = Cons([i, get(junk, 1)], l);
+    }
+    return l;
+}
+fn sum(l: List) -> int {
+    return match l { Cons(xs, rest) => xs[0] + xs[1] + sum(rest), Nil => 0 };
+}
+fn main() -> int {
+    let a: List = build(50);
+    let b: List = build(30);
+    return sum(a) * 1000 + sum(b);
+}
+`},
+}
+
+func TestGC_StressMatchesNormalRun(t *testing.T) {
+	for _, tt := range gcStressPrograms {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := NewVM(compileProgram(t, tt.src), false).Call("main", nil)
+			if err != nil {
+				t.Fatalf("normal run: %v", err)
+			}
+
+			for _, jit := range []bool{false, true} {
+				vm := NewVM(compileProgram(t, tt.src), jit)
+				vm.gcStress = true
+				got, err := vm.Call("main", nil)
+				if err != nil {
+					t.Fatalf("stress run (jit=%v): %v", jit, err)
+				}
+				if got != want {
+					t.Fatalf("stress run (jit=%v) returned %#v, want %#v", jit, got, want)
+				}
+				if vm.stressCount == 0 {
+					t.Fatal("stress run did not collect")
+				}
+			}
+		})
+	}
+}
+
+func TestGC_WriteBarrierKeepsYoungChildren(t *testing.T) {
+	mod := &bytecode.Module{Functions: map[string]*bytecode.FunctionInfo{}}
+	vm := NewVM(mod, false)
+
+	locals := make([]bytecode.Value, 1)
+	vm.roots = append(vm.roots, rootSet{locals: &locals})
+
+	parent := vm.newArray(1)
+	locals[0] = bytecode.Value{Kind: bytecode.ValObject, Obj: parent}
+	vm.minorGC()
+	if !parent.Old {
+		t.Fatal("surviving nursery object was not promoted")
+	}
+
+	child := vm.newArray(1)
+	childVal := bytecode.Value{Kind: bytecode.ValObject, Obj: child}
+	vm.writeBarrier(parent, childVal)
+	parent.Items[0] = childVal
+
+	vm.minorGC()
+	if !child.Old || vm.heap.NumObjects != 2 {
+		t.Fatalf("child reachable only from an old object was freed (objects=%d)", vm.heap.NumObjects)
+	}
+
+	locals[0] = bytecode.Value{}
+	vm.gc()
+	if vm.heap.NumObjects != 0 || vm.heap.OldBytes != 0 || vm.heap.YoungBytes != 0 {
+		t.Fatalf("heap not empty after full collection: objects=%d old=%d young=%d",
+			vm.heap.NumObjects, vm.heap.OldBytes, vm.heap.YoungBytes)
+	}
+}
+
+func TestGC_AccountsBytes(t *testing.T) {
+	mod := &bytecode.Module{Functions: map[string]*bytecode.FunctionInfo{}}
+	vm := NewVM(mod, false)
+
+	const n = 1 << 16
+	for i := 0; i < 200; i++ {
+		vm.newArray(n)
+	}
+	limit := 2*vm.heap.NextMajor + objectSize(n)
+	if used := vm.heap.OldBytes + vm.heap.YoungBytes; used > limit {
+		t.Fatalf("large garbage arrays are not collected: %d bytes in use, limit %d", used, limit)
+	}
+
+	vm.SetGCPercent(-1)
+	before := vm.heap.NumObjects
+	for i := 0; i < 200; i++ {
+		vm.newArray(n)
+	}
+	if got := vm.heap.NumObjects - before; got != 200 {
+		t.Fatalf("collector ran while off: %d of 200 objects left", got)
+	}
+}
+
+func TestStats_TracksCollectionsAndCallsHook(t *testing.T) {
+	var cycles []GCCycle
+	Debug.SetGCHook(func(c GCCycle) { cycles = append(cycles, c) })
+	defer Debug.SetGCHook(nil)
+
+	src := `
+fn main() -> int {
+    let keep: []int = array(100);
+    let s: int = 0;
+    for let i: int = 0; i < 3000; i = i + 1 {
+        let a: []int = array(16);
+        set(a, 0, i);
+        s = s + get(a, 0);
+    }
+    return s + get(keep, 0);
+}
+`
+	vm := NewVM(compileProgram(t, src), false)
+	if _, err := vm.Call("main", nil); err != nil {
+		t.Fatalf("vm call error: %v", err)
+	}
+	vm.gc()
+
+	st := vm.Stats()
+	if st.AllocatedObjects != 3001 || st.AllocatedBytes != objectSize(100)+3000*objectSize(16) {
+		t.Fatalf("allocated %d objects / %d bytes", st.AllocatedObjects, st.AllocatedBytes)
+	}
+	if st.LiveObjects != 0 || st.LiveBytes != 0 || st.FreedObjects != st.AllocatedObjects {
+		t.Fatalf("after the last collection: live %d (%d B), freed %d", st.LiveObjects, st.LiveBytes, st.FreedObjects)
+	}
+	if st.MinorCollections == 0 || st.MajorCollections != 1 || st.MaxPause > st.TotalPause {
+		t.Fatalf("unexpected collections: %+v", st)
+	}
+	if arrays := st.ByType[bytecode.ObjArray]; arrays != st.HeapCounts {
+		t.Fatalf("array counts %+v differ from totals %+v", arrays, st.HeapCounts)
+	}
+
+	if len(cycles) != st.MinorCollections+st.MajorCollections || !cycles[len(cycles)-1].Major {
+		t.Fatalf("hook saw %d cycles, want %d ending with a major one", len(cycles), st.MinorCollections+st.MajorCollections)
+	}
+	freed := 0
+	for _, c := range cycles {
+		freed += c.FreedObjects
+	}
+	if freed != st.FreedObjects {
+		t.Fatalf("hook reported %d freed objects, stats %d", freed, st.FreedObjects)
+	}
+}
+
+func compileProgram(t *testing.T, src string) *bytecode.Module {
+	t.Helper()
+
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := sema.New()
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("sema errors: %v", c.Errors())
+	}
+	mod, err := compilation.NewCompiler().CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	return mod
+}
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const generatorProgram = `
+gen fn count(from: int, to: int) -> int {
+    for let i: int = from; i < to; i = i + 1 {
+        yield i;
+    }
+}
+gen fn broken(n: int) -> int {
+    yield n;
+    yield 10 / (n - n);
+}
+fn deep(n: int) -> int {
+    if n == 0 {
+        let s: int = 0;
+        for x in count(0, 5) { s = s + x; }
+        return s;
+    }
+    return deep(n - 1) + 1;
+}
+fn drain(it: iter<int>) -> int {
+    let s: int = 0;
+    for x in it { s = s + x; }
+    return s;
+}
+`
+
+func TestGenerator_HostDrivesSuspendedFrame(t *testing.T) {
+	vm := NewVM(compileProgram(t, generatorProgram), false)
+	vm.gcStress = true
+
+	it, err := vm.Call("count", []bytecode.Value{intValue(3), intValue(6)})
+	if err != nil || it.Kind != bytecode.ValObject || it.Obj.Type != bytecode.ObjGenerator {
+		t.Fatalf("count = %#v, %v, want a generator", it, err)
+	}
+	var got []int64
+	for {
+		v, ok, err := vm.Next(context.Background(), it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, v.I)
+	}
+	if len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Fatalf("yielded %v, want [3 4 5]", got)
+	}
+	if len(vm.frames) != 0 || len(vm.roots) != 0 {
+		t.Fatalf("frames left behind: %d frames, %d roots", len(vm.frames), len(vm.roots))
+	}
+}
+
+func TestGenerator_RunsInsideDeepCallChains(t *testing.T) {
+	vm := NewVM(compileProgram(t, generatorProgram), false, MaxCallDepth(-1))
+	ret, err := vm.Call("deep", []bytecode.Value{intValue(200000)})
+	if err != nil || ret.I != 200010 {
+		t.Fatalf("deep = %v, %v, want 200010", ret, err)
+	}
+}
+
+func TestGenerator_ErrorsFinishTheGenerator(t *testing.T) {
+	vm := NewVM(compileProgram(t, generatorProgram), false)
+
+	it, err := vm.Call("broken", []bytecode.Value{intValue(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := vm.Next(context.Background(), it); err != nil || !ok || v.I != 4 {
+		t.Fatalf("first next = %v, %v, %v", v, ok, err)
+	}
+	_, _, err = vm.Next(context.Background(), it)
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || rerr.Line != 9 || !strings.Contains(err.Error(), "division by zero") {
+		t.Fatalf("got error %v, want division by zero at line 9", err)
+	}
+	if _, ok, err := vm.Next(context.Background(), it); ok || err != nil {
+		t.Fatalf("failed generator resumed: %v, %v", ok, err)
+	}
+
+	_, err = vm.Call("drain", []bytecode.Value{it})
+	if err != nil {
+		t.Fatalf("draining a finished generator: %v", err)
+	}
+}
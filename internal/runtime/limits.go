@@ -0,0 +1,99 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	defaultMaxCallDepth = 10000
+
+	// checkInterval is how many instructions run between checks of the
+	// call's context.
+	checkInterval = 1024
+)
+
+var (
+	ErrBudgetExceeded = errors.New("instruction budget exceeded")
+	ErrHeapLimit      = errors.New("heap limit exceeded")
+	ErrStackOverflow  = errors.New("stack overflow: call depth limit exceeded")
+)
+
+// InstructionBudget limits how many instructions a single call into the VM
+// may execute. Zero, the default, means no limit.
+func InstructionBudget(n int64) Option {
+	return func(vm *VM) { vm.budget = n }
+}
+
+// HeapLimit limits the bytes the heap may hold. An allocation that would
+// pass it after a full collection fails with ErrHeapLimit. Zero, the
+// default, means no limit.
+func HeapLimit(bytes int) Option {
+	return func(vm *VM) { vm.heapLimit = bytes }
+}
+
+// MaxCallDepth limits how deep calls may nest; the default is 10000. Zero
+// or less means no limit.
+func MaxCallDepth(n int) Option {
+	return func(vm *VM) { vm.maxCallDepth = n }
+}
+
+// refuel runs once the instructions granted at the last check are used up.
+// It fails when the context is done or the budget is spent.
+func (vm *VM) refuel() error {
+	if err := vm.ctx.Err(); err != nil {
+		return err
+	}
+	grant := int64(checkInterval)
+	s := vm.sched
+	if s != nil && vm.timeSlice > 0 && s.sliceLeft < grant {
+		grant = s.sliceLeft
+	}
+	if vm.budget > 0 {
+		if vm.budgetLeft == 0 {
+			return ErrBudgetExceeded
+		}
+		if vm.budgetLeft < grant {
+			grant = vm.budgetLeft
+		}
+		vm.budgetLeft -= grant
+	}
+	if s != nil && vm.timeSlice > 0 {
+		s.sliceLeft -= grant
+	}
+	vm.fuel = grant
+	return nil
+}
+
+// reserve checks that an object with the given number of items fits under
+// the heap limit, collecting garbage first if it would not.
+func (vm *VM) reserve(items int) error {
+	if vm.heapLimit <= 0 {
+		return nil
+	}
+	if items > vm.heapLimit/valueBytes {
+		return ErrHeapLimit
+	}
+	size := objectSize(items)
+	if vm.heap.YoungBytes+vm.heap.OldBytes+size <= vm.heapLimit {
+		return nil
+	}
+	vm.gc()
+	if vm.heap.OldBytes+size > vm.heapLimit {
+		return ErrHeapLimit
+	}
+	return nil
+}
+
+func (vm *VM) checkCallDepth() error {
+	if vm.maxCallDepth > 0 && len(vm.frames) >= vm.maxCallDepth {
+		return ErrStackOverflow
+	}
+	return nil
+}
+
+func (vm *VM) startCall(ctx context.Context) {
+	vm.ctx = ctx
+	vm.fuel = 0
+	vm.budgetLeft = vm.budget
+}
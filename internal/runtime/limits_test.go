@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+func intValue(i int64) bytecode.Value {
+	return bytecode.Value{Kind: bytecode.ValInt, I: i}
+}
+
+const limitsProgram = `
+fn spin() -> int {
+    let i: int = 0;
+    while true { i = i + 1; }
+    return i;
+}
+fn count(n: int) -> int {
+    let s: int = 0;
+    for let i: int = 0; i < n; i = i + 1 { s = s + i; }
+    return s;
+}
+fn down(n: int) -> int {
+    if n == 0 { return 0; }
+    return 1 + down(n - 1);
+}
+fn churn() -> int {
+    let s: int = 0;
+    for let i: int = 0; i < 200; i = i + 1 {
+        let a: []int = array(1000);
+        s = s + a[999] + 1;
+    }
+    return s;
+}
+fn hoard() -> int {
+    let a: []int = array(1000000);
+    return a[0];
+}
+`
+
+func TestLimits_BudgetStopsInfiniteLoop(t *testing.T) {
+	mod := compileProgram(t, limitsProgram)
+	for _, jit := range []bool{false, true} {
+		vm := NewVM(mod, jit, InstructionBudget(100000))
+
+		_, err := vm.Call("spin", nil)
+		var rerr *RuntimeError
+		if !errors.Is(err, ErrBudgetExceeded) || !errors.As(err, &rerr) || rerr.Line != 4 {
+			t.Fatalf("jit=%v: got %v, want budget exceeded at line 4", jit, err)
+		}
+
+		// The budget is per call, so a short call still succeeds afterwards.
+		ret, err := vm.Call("count", []bytecode.Value{intValue(100)})
+		if err != nil || ret.I != 4950 {
+			t.Fatalf("jit=%v: count = %v, %v", jit, ret, err)
+		}
+	}
+}
+
+func TestLimits_ContextCancelsCall(t *testing.T) {
+	mod := compileProgram(t, limitsProgram)
+	vm := NewVM(mod, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := vm.CallContext(ctx, "spin", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := vm.CallContext(ctx, "spin", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestLimits_CallDepth(t *testing.T) {
+	mod := compileProgram(t, limitsProgram)
+
+	vm := NewVM(mod, false, MaxCallDepth(50))
+	if ret, err := vm.Call("down", []bytecode.Value{intValue(49)}); err != nil || ret.I != 49 {
+		t.Fatalf("down(49) = %v, %v", ret, err)
+	}
+	if _, err := vm.Call("down", []bytecode.Value{intValue(50)}); !errors.Is(err, ErrStackOverflow) {
+		t.Fatalf("got %v, want stack overflow", err)
+	}
+
+	vm = NewVM(mod, false)
+	if _, err := vm.Call("down", []bytecode.Value{intValue(1 << 20)}); !errors.Is(err, ErrStackOverflow) {
+		t.Fatalf("default depth: got %v, want stack overflow", err)
+	}
+}
+
+func TestLimits_HeapLimit(t *testing.T) {
+	mod := compileProgram(t, limitsProgram)
+	limit := 10 * objectSize(1000)
+
+	vm := NewVM(mod, false, HeapLimit(limit))
+	if ret, err := vm.Call("churn", nil); err != nil || ret.I != 200 {
+		t.Fatalf("churn = %v, %v", ret, err)
+	}
+	if _, err := vm.Call("hoard", nil); !errors.Is(err, ErrHeapLimit) {
+		t.Fatalf("got %v, want heap limit", err)
+	}
+
+	errs := []error{ErrBudgetExceeded, ErrHeapLimit, ErrStackOverflow, context.Canceled}
+	for i, a := range errs {
+		for j, b := range errs {
+			if i != j && errors.Is(a, b) {
+				t.Fatalf("%v matches %v", a, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package runtime
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// typeName returns the name the vtables know the type of v by.
+func typeName(v bytecode.Value) string {
+	if v.Kind != bytecode.ValObject {
+		return v.Kind.String()
+	}
+	if v.Obj == nil {
+		return "null"
+	}
+	if vr, ok := v.Obj.State.(*bytecode.Variant); ok && v.Obj.Type == bytecode.ObjVariant {
+		return vr.Enum
+	}
+	return v.Obj.Type.String()
+}
+
+// builtinMethod calls the method name built into the language on recv,
+// for types without an impl giving it.
+func (vm *VM) builtinMethod(name string, recv bytecode.Value, args []bytecode.Value) (bytecode.Value, error) {
+	if name == "len" && len(args) == 0 {
+		switch {
+		case recv.Kind == bytecode.ValString:
+			return bytecode.Value{Kind: bytecode.ValInt, I: int64(len(recv.S))}, nil
+		case recv.Kind == bytecode.ValObject && recv.Obj != nil && recv.Obj.Type == bytecode.ObjArray:
+			return bytecode.Value{Kind: bytecode.ValInt, I: int64(len(recv.Obj.Items))}, nil
+		}
+	}
+	if name == "chars" && len(args) == 0 && recv.Kind == bytecode.ValString {
+		// Invalid UTF-8 gives a U+FFFD per bad byte, as ranging over a Go
+		// string does.
+		n := utf8.RuneCountInString(recv.S)
+		if err := vm.reserve(n); err != nil {
+			return bytecode.Value{}, err
+		}
+		obj := vm.newArray(n)
+		i := 0
+		for _, r := range recv.S {
+			obj.Items[i] = bytecode.Value{Kind: bytecode.ValChar, C: r}
+			i++
+		}
+		return bytecode.Value{Kind: bytecode.ValObject, Obj: obj}, nil
+	}
+	return bytecode.Value{}, fmt.Errorf("no method %q on %s value", name, typeName(recv))
+}
@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// callNative runs a Go function called from a script. A panic in it becomes
+// an error, and so does a result that does not match its declared type.
+func callNative(n *bytecode.Native, args []bytecode.Value) (ret bytecode.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("native %s panicked: %v", n.Name, r)
+		}
+	}()
+
+	ret, err = n.Fn(args)
+	if err != nil {
+		return bytecode.Value{}, fmt.Errorf("native %s: %w", n.Name, err)
+	}
+	if n.ReturnType == bytecode.TypeVoid {
+		return bytecode.Value{Kind: bytecode.ValNull}, nil
+	}
+	if !hasType(ret, n.ReturnType) {
+		return bytecode.Value{}, fmt.Errorf("native %s: returned %s, want %s",
+			n.Name, ret.Kind, n.ReturnType)
+	}
+	return ret, nil
+}
+
+func hasType(v bytecode.Value, t bytecode.TypeKind) bool {
+	switch t {
+	case bytecode.TypeInt:
+		return v.Kind == bytecode.ValInt
+	case bytecode.TypeFloat:
+		return v.Kind == bytecode.ValFloat
+	case bytecode.TypeBool:
+		return v.Kind == bytecode.ValBool
+	case bytecode.TypeString:
+		return v.Kind == bytecode.ValString
+	case bytecode.TypeChar:
+		return v.Kind == bytecode.ValChar
+	case bytecode.TypeArray:
+		return v.Kind == bytecode.ValObject && v.Obj != nil && v.Obj.Type == bytecode.ObjArray
+	case bytecode.TypeNull:
+		return v.Kind == bytecode.ValNull
+	}
+	return false
+}
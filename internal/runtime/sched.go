@@ -0,0 +1,262 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const defaultTimeSlice = 1000
+
+var (
+	ErrDeadlock = errors.New("deadlock: all tasks are blocked")
+
+	errSendClosed  = errors.New("send: channel is closed")
+	errRecvClosed  = errors.New("recv: channel is closed")
+	errCloseClosed = errors.New("close: channel is already closed")
+)
+
+// SchedulerSeed seeds the choices the scheduler makes between runnable
+// tasks. Runs of a program with the same seed and inputs switch tasks at
+// the same instructions.
+func SchedulerSeed(seed int64) Option {
+	return func(vm *VM) { vm.schedSeed = seed }
+}
+
+// TimeSlice sets how many instructions a task may run before the
+// scheduler switches to another one; the default is 1000. Zero or less
+// means tasks only switch when they block.
+func TimeSlice(n int) Option {
+	return func(vm *VM) { vm.timeSlice = n }
+}
+
+// task is a green thread started by spawn. The running task's frames are
+// on vm.frames; the others keep theirs until they are switched to.
+type task struct {
+	id     int
+	frames []*frame
+	parent *task
+	// live counts the tasks it spawned that have not finished yet.
+	live int
+
+	blocked bool
+	waiting bool
+	// doneIP is where a for-in blocked on a channel continues once the
+	// channel is closed; it is negative for a plain recv.
+	doneIP int
+	// err fails the task once it runs again.
+	err error
+}
+
+func (t *task) top() *frame { return t.frames[len(t.frames)-1] }
+
+// channel is the State of a channel object. Buffered values are the
+// object's Items, oldest first; a task blocked in send keeps the value on
+// top of its operand stack until a receiver takes it.
+type channel struct {
+	cap    int
+	closed bool
+	recvq  []*task
+	sendq  []*task
+}
+
+// scheduler keeps the tasks of one run. The task that made the call into
+// the VM is main; the run ends when main returns, dropping the rest.
+type scheduler struct {
+	tasks     []*task
+	cur       *task
+	main      *task
+	rng       *rand.Rand
+	nextID    int
+	sliceLeft int64
+}
+
+// scheduler returns the scheduler of the running call, starting one with
+// only the main task if there is none yet.
+func (vm *VM) scheduler() *scheduler {
+	if vm.sched == nil {
+		main := &task{}
+		vm.sched = &scheduler{
+			tasks:     []*task{main},
+			cur:       main,
+			main:      main,
+			rng:       rand.New(rand.NewSource(vm.schedSeed)),
+			nextID:    1,
+			sliceLeft: int64(vm.timeSlice),
+		}
+	}
+	return vm.sched
+}
+
+// spawn starts a task running fr, a child of the running task.
+func (vm *VM) spawn(fr *frame) {
+	s := vm.scheduler()
+	t := &task{id: s.nextID, frames: []*frame{fr}, parent: s.cur}
+	s.nextID++
+	s.cur.live++
+	s.tasks = append(s.tasks, t)
+}
+
+// pick chooses the task to run next among the runnable ones, or returns
+// nil if every task is blocked.
+func (s *scheduler) pick() *task {
+	var runnable []*task
+	for _, t := range s.tasks {
+		if !t.blocked {
+			runnable = append(runnable, t)
+		}
+	}
+	if len(runnable) == 0 {
+		return nil
+	}
+	return runnable[s.rng.Intn(len(runnable))]
+}
+
+// finish drops t, whose body has returned, and wakes its parent if that
+// was waiting for it.
+func (s *scheduler) finish(t *task) {
+	for i, u := range s.tasks {
+		if u == t {
+			s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+			break
+		}
+	}
+	if p := t.parent; p != nil {
+		p.live--
+		if p.waiting && p.live == 0 {
+			p.waiting, p.blocked = false, false
+		}
+	}
+}
+
+// dropTasks ends the run's scheduler. Tasks other than the running one
+// are abandoned, leaving the generators they were running finished.
+func (vm *VM) dropTasks() {
+	if vm.sched == nil {
+		return
+	}
+	for _, t := range vm.sched.tasks {
+		for _, fr := range t.frames {
+			if fr.gen != nil {
+				fr.gen.State = nil
+			}
+		}
+	}
+	vm.sched = nil
+}
+
+// block parks the running task; the caller then switches away from it.
+func (vm *VM) block() *task {
+	t := vm.scheduler().cur
+	t.blocked = true
+	return t
+}
+
+func (vm *VM) newChan(capacity int) *bytecode.Object {
+	obj := vm.allocate(bytecode.ObjChan, capacity)
+	obj.Items = make([]bytecode.Value, 0, capacity)
+	obj.State = &channel{cap: capacity}
+	return obj
+}
+
+func chanOf(v bytecode.Value, op string) (*bytecode.Object, *channel, error) {
+	if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjChan {
+		return nil, nil, fmt.Errorf("%s: value is not a channel", op)
+	}
+	c, ok := v.Obj.State.(*channel)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: value is not a channel", op)
+	}
+	return v.Obj, c, nil
+}
+
+// chanSend hands v to a blocked receiver or buffers it. It reports true
+// when the running task has to block instead, with v left for the caller
+// to keep on its stack.
+func (vm *VM) chanSend(obj *bytecode.Object, c *channel, v bytecode.Value) (bool, error) {
+	if c.closed {
+		return false, errSendClosed
+	}
+	if len(c.recvq) > 0 {
+		r := c.recvq[0]
+		c.recvq = c.recvq[1:]
+		fr := r.top()
+		fr.stack = append(fr.stack, v)
+		r.blocked = false
+		return false, nil
+	}
+	if len(obj.Items) < c.cap {
+		vm.writeBarrier(obj, v)
+		obj.Items = append(obj.Items, v)
+		return false, nil
+	}
+	c.sendq = append(c.sendq, vm.block())
+	return true, nil
+}
+
+// chanRecv takes the oldest value sent on the channel. It reports true
+// when the running task has to block until a value arrives, and fails
+// with errRecvClosed once the channel is closed and drained.
+func (vm *VM) chanRecv(obj *bytecode.Object, c *channel, doneIP int) (bytecode.Value, bool, error) {
+	if len(obj.Items) > 0 {
+		v := obj.Items[0]
+		n := copy(obj.Items, obj.Items[1:])
+		obj.Items[n] = bytecode.Value{}
+		obj.Items = obj.Items[:n]
+		// A sender blocked on the full buffer gets its value in now.
+		if len(c.sendq) > 0 {
+			sent := c.takeSent()
+			vm.writeBarrier(obj, sent)
+			obj.Items = append(obj.Items, sent)
+		}
+		return v, false, nil
+	}
+	if len(c.sendq) > 0 {
+		return c.takeSent(), false, nil
+	}
+	if c.closed {
+		return bytecode.Value{}, false, errRecvClosed
+	}
+
+	t := vm.block()
+	t.doneIP = doneIP
+	c.recvq = append(c.recvq, t)
+	return bytecode.Value{}, true, nil
+}
+
+// takeSent wakes the first blocked sender and returns the value it was
+// sending, leaving its send to complete with null.
+func (c *channel) takeSent() bytecode.Value {
+	s := c.sendq[0]
+	c.sendq = c.sendq[1:]
+	fr := s.top()
+	v := fr.stack[len(fr.stack)-1]
+	fr.stack[len(fr.stack)-1] = bytecode.Value{Kind: bytecode.ValNull}
+	s.blocked = false
+	return v
+}
+
+// chanClose closes the channel, waking every task blocked on it: pending
+// receivers see it closed, pending senders fail.
+func (vm *VM) chanClose(c *channel) error {
+	if c.closed {
+		return errCloseClosed
+	}
+	c.closed = true
+	for _, r := range c.recvq {
+		if r.doneIP >= 0 {
+			r.top().ip = r.doneIP
+		} else {
+			r.err = errRecvClosed
+		}
+		r.blocked = false
+	}
+	for _, s := range c.sendq {
+		s.err = errSendClosed
+		s.blocked = false
+	}
+	c.recvq, c.sendq = nil, nil
+	return nil
+}
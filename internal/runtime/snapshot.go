@@ -0,0 +1,285 @@
+package runtime
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const snapshotVersion = 1
+
+// Snapshot is the JSON document written by VM.HeapSnapshot:
+//
+//	{
+//	  "version": 1,
+//	  "objects": [
+//	    {"id": 1, "type": "array", "size": 1072, "old": true, "reachable": true,
+//	     "refs": [2, 2], "dominator": 0, "retained": 2144}
+//	  ],
+//	  "roots": [
+//	    {"frame": 0, "function": "main", "kind": "local", "slot": 1, "object": 1}
+//	  ]
+//	}
+//
+// Objects are numbered from 1 and list the ids they reference in item
+// order. Every root hangs off a virtual object 0. The dominator of an object
+// is the nearest object (or 0) that every path from the roots to it passes
+// through; its retained size is its own size plus that of the objects it
+// dominates, which is what collecting it would free. Unreachable objects
+// that have not been collected yet have dominator -1 and retain nothing.
+type Snapshot struct {
+	Version int              `json:"version"`
+	Objects []SnapshotObject `json:"objects"`
+	Roots   []SnapshotRoot   `json:"roots"`
+}
+
+type SnapshotObject struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	Size      int    `json:"size"`
+	Old       bool   `json:"old"`
+	Reachable bool   `json:"reachable"`
+	Refs      []int  `json:"refs"`
+	Dominator int    `json:"dominator"`
+	Retained  int    `json:"retained"`
+}
+
+// SnapshotRoot is a slot of a call frame holding an object. Frame 0 is the
+// outermost call; Kind is "local" for local variable slots and "stack" for
+// operand stack slots. A frame running a generator's body holds the
+// generator as a root of Kind "generator" and Slot 0. The frames of a task
+// waiting for its turn have Kind "task", with Task set to its id and the
+// operand stack numbered after the locals; main is task 0. Values the
+// embedding program holds, such as arrays made for arguments, have Kind
+// "pinned" and Frame -1.
+type SnapshotRoot struct {
+	Task     int    `json:"task,omitempty"`
+	Frame    int    `json:"frame"`
+	Function string `json:"function"`
+	Kind     string `json:"kind"`
+	Slot     int    `json:"slot"`
+	Object   int    `json:"object"`
+}
+
+// HeapSnapshot writes the object graph of the heap and the root sets that
+// hold it to w as a Snapshot. It does not collect garbage.
+func (vm *VM) HeapSnapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(vm.snapshot())
+}
+
+func (vm *VM) snapshot() *Snapshot {
+	ids := make(map[*bytecode.Object]int)
+	var objs []*bytecode.Object
+	for _, list := range []*bytecode.Object{vm.heap.Young, vm.heap.Head} {
+		for obj := list; obj != nil; obj = obj.Next {
+			objs = append(objs, obj)
+			ids[obj] = len(objs)
+		}
+	}
+
+	snap := &Snapshot{
+		Version: snapshotVersion,
+		Objects: make([]SnapshotObject, len(objs)),
+		Roots:   []SnapshotRoot{},
+	}
+	for i, obj := range objs {
+		so := SnapshotObject{ID: i + 1, Type: obj.Type.String(), Size: obj.Size, Old: obj.Old, Refs: []int{}}
+		for _, vals := range references(obj) {
+			for _, v := range vals {
+				if v.Kind == bytecode.ValObject && ids[v.Obj] != 0 {
+					so.Refs = append(so.Refs, ids[v.Obj])
+				}
+			}
+		}
+		snap.Objects[i] = so
+	}
+
+	seen := make(map[SnapshotRoot]bool)
+	vm.walkRoots(func(r rootRef, v bytecode.Value) {
+		if v.Kind != bytecode.ValObject || ids[v.Obj] == 0 {
+			return
+		}
+		root := SnapshotRoot{Task: r.task, Frame: r.frame, Kind: r.kind, Slot: r.slot, Object: ids[v.Obj]}
+		if r.fn != nil {
+			root.Function = r.fn.Name
+		}
+		if !seen[root] {
+			seen[root] = true
+			snap.Roots = append(snap.Roots, root)
+		}
+	})
+
+	snap.computeDominators()
+	return snap
+}
+
+// computeDominators fills in reachability, dominators and retained sizes
+// using the Cooper-Harvey-Kennedy iterative algorithm over the graph rooted
+// at the virtual object 0.
+func (s *Snapshot) computeDominators() {
+	n := len(s.Objects) + 1
+	succs := make([][]int, n)
+	for _, r := range s.Roots {
+		succs[0] = append(succs[0], r.Object)
+	}
+	for _, o := range s.Objects {
+		succs[o.ID] = o.Refs
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = -1
+	}
+	var post []int
+	type visit struct{ node, next int }
+	stack := []visit{{0, 0}}
+	order[0] = 0
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(succs[top.node]) {
+			m := succs[top.node][top.next]
+			top.next++
+			if order[m] < 0 {
+				order[m] = 0
+				stack = append(stack, visit{m, 0})
+			}
+			continue
+		}
+		post = append(post, top.node)
+		stack = stack[:len(stack)-1]
+	}
+	rpo := make([]int, len(post))
+	for i, node := range post {
+		rpo[len(post)-1-i] = node
+		order[node] = len(post) - 1 - i
+	}
+
+	preds := make([][]int, n)
+	for _, u := range rpo {
+		for _, v := range succs[u] {
+			preds[v] = append(preds[v], u)
+		}
+	}
+
+	idom := make([]int, n)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] > order[b] {
+				a = idom[a]
+			}
+			for order[b] > order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			d := -1
+			for _, p := range preds[b] {
+				if idom[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[b] {
+				idom[b] = d
+				changed = true
+			}
+		}
+	}
+
+	retained := make([]int, n)
+	for i := len(rpo) - 1; i > 0; i-- {
+		b := rpo[i]
+		retained[b] += s.Objects[b-1].Size
+		retained[idom[b]] += retained[b]
+	}
+	for i := range s.Objects {
+		o := &s.Objects[i]
+		o.Reachable = order[o.ID] >= 0
+		o.Dominator = idom[o.ID]
+		o.Retained = retained[o.ID]
+	}
+}
+
+// TypeTotals sums up the reachable objects of one type.
+type TypeTotals struct {
+	Count int
+	Bytes int
+	// Retained counts each object's retained size unless an object of the
+	// same type dominates it, so nested objects are not counted twice.
+	Retained int
+}
+
+// ByType totals the reachable objects of s per type.
+func (s *Snapshot) ByType() map[string]TypeTotals {
+	byID := make(map[int]*SnapshotObject, len(s.Objects))
+	for i := range s.Objects {
+		byID[s.Objects[i].ID] = &s.Objects[i]
+	}
+	covered := func(o *SnapshotObject) bool {
+		for d := byID[o.Dominator]; d != nil; d = byID[d.Dominator] {
+			if d.Type == o.Type {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := make(map[string]TypeTotals)
+	for i := range s.Objects {
+		o := &s.Objects[i]
+		if !o.Reachable {
+			continue
+		}
+		t := out[o.Type]
+		t.Count++
+		t.Bytes += o.Size
+		if !covered(o) {
+			t.Retained += o.Retained
+		}
+		out[o.Type] = t
+	}
+	return out
+}
+
+// TypeGrowth is the change of one object type between two snapshots.
+type TypeGrowth struct {
+	Type   string
+	Before TypeTotals
+	After  TypeTotals
+}
+
+// DiffSnapshots returns the types whose object count or retained size grew
+// from a to b, largest retained growth first.
+func DiffSnapshots(a, b *Snapshot) []TypeGrowth {
+	before, after := a.ByType(), b.ByType()
+	var out []TypeGrowth
+	for typ, at := range after {
+		bt := before[typ]
+		if at.Count > bt.Count || at.Retained > bt.Retained {
+			out = append(out, TypeGrowth{Type: typ, Before: bt, After: at})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		gi := out[i].After.Retained - out[i].Before.Retained
+		gj := out[j].After.Retained - out[j].Before.Retained
+		if gi != gj {
+			return gi > gj
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
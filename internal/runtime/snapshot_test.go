@@ -0,0 +1,217 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/lexer"
+	"github.com/dunooo0ooo/lang/internal/parser"
+	"github.com/dunooo0ooo/lang/internal/runtime/compilation"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+func objValue(obj *bytecode.Object) bytecode.Value {
+	return bytecode.Value{Kind: bytecode.ValObject, Obj: obj}
+}
+
+func takeSnapshot(t *testing.T, vm *VM) *Snapshot {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := vm.HeapSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("snapshot is not valid JSON: %v", err)
+	}
+	return &s
+}
+
+func TestHeapSnapshot_DominatorsAndRetainedSizes(t *testing.T) {
+	mod := &bytecode.Module{Functions: map[string]*bytecode.FunctionInfo{}}
+	vm := NewVM(mod, false)
+	vm.SetGCPercent(-1)
+
+	c := vm.newArray(1)
+	b := vm.newArray(1)
+	b.Items[0] = objValue(c)
+	a := vm.newArray(3)
+	a.Items[0], a.Items[1], a.Items[2] = objValue(b), objValue(b), objValue(c)
+	garbage := vm.newArray(2)
+
+	locals := []bytecode.Value{{Kind: bytecode.ValInt, I: 1}, objValue(a)}
+	stack := []bytecode.Value{objValue(c)}
+	fn := &bytecode.FunctionInfo{Name: "main"}
+	vm.roots = append(vm.roots, rootSet{fn: fn, locals: &locals, stack: &stack})
+
+	s := takeSnapshot(t, vm)
+	if s.Version != snapshotVersion || len(s.Objects) != 4 {
+		t.Fatalf("got version %d with %d objects", s.Version, len(s.Objects))
+	}
+	byObj := func(obj *bytecode.Object) SnapshotObject {
+		for _, o := range s.Objects {
+			if o.Size == obj.Size && o.Type == "array" && len(o.Refs) == countRefs(obj) {
+				return o
+			}
+		}
+		t.Fatalf("object of size %d missing", obj.Size)
+		return SnapshotObject{}
+	}
+	sa, sb, sc, sg := byObj(a), byObj(b), byObj(c), byObj(garbage)
+
+	if len(s.Roots) != 2 {
+		t.Fatalf("got roots %+v", s.Roots)
+	}
+	if r := s.Roots[0]; r.Function != "main" || r.Kind != "local" || r.Slot != 1 || r.Object != sa.ID {
+		t.Fatalf("local root = %+v", r)
+	}
+	if r := s.Roots[1]; r.Kind != "stack" || r.Slot != 0 || r.Object != sc.ID {
+		t.Fatalf("stack root = %+v", r)
+	}
+
+	if sa.Dominator != 0 || sb.Dominator != sa.ID || sc.Dominator != 0 {
+		t.Fatalf("dominators a=%d b=%d c=%d", sa.Dominator, sb.Dominator, sc.Dominator)
+	}
+	if sa.Retained != a.Size+b.Size || sb.Retained != b.Size || sc.Retained != c.Size {
+		t.Fatalf("retained a=%d b=%d c=%d", sa.Retained, sb.Retained, sc.Retained)
+	}
+	if sg.Reachable || sg.Dominator != -1 || sg.Retained != 0 {
+		t.Fatalf("garbage = %+v", sg)
+	}
+}
+
+func countRefs(obj *bytecode.Object) int {
+	n := 0
+	for _, v := range obj.Items {
+		if v.Kind == bytecode.ValObject {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDiffSnapshots_ReportsGrowingTypes(t *testing.T) {
+	mod := &bytecode.Module{Functions: map[string]*bytecode.FunctionInfo{}}
+	vm := NewVM(mod, false)
+	vm.SetGCPercent(-1)
+
+	list := vm.newArray(0)
+	locals := []bytecode.Value{objValue(list)}
+	vm.roots = append(vm.roots, rootSet{locals: &locals})
+
+	grow := func(n int) {
+		for i := 0; i < n; i++ {
+			list.Items = append(list.Items, objValue(vm.newArray(8)))
+		}
+	}
+	grow(2)
+	before := takeSnapshot(t, vm)
+	grow(3)
+	after := takeSnapshot(t, vm)
+
+	if d := DiffSnapshots(before, before); len(d) != 0 {
+		t.Fatalf("identical snapshots differ: %+v", d)
+	}
+	d := DiffSnapshots(before, after)
+	if len(d) != 1 || d[0].Type != "array" {
+		t.Fatalf("got %+v", d)
+	}
+	if d[0].Before.Count != 3 || d[0].After.Count != 6 {
+		t.Fatalf("counts %d -> %d", d[0].Before.Count, d[0].After.Count)
+	}
+	// The rows are dominated by the list, so only the list's retained size counts.
+	if want := list.Size + 5*objectSize(8); d[0].After.Retained != want {
+		t.Fatalf("retained = %d, want %d", d[0].After.Retained, want)
+	}
+}
+
+func TestHeapSnapshot_RootsInParkedTasksAndGenerators(t *testing.T) {
+	src := `
+fn hold(c: chan<int>, ready: chan<int>) {
+    let big = array(1000);
+    send(ready, 0);
+    set(big, 0, recv(c));
+}
+gen fn walk() -> int {
+    snap();
+    yield 1;
+}
+fn main() -> int {
+    let c = chan<int>();
+    let ready = chan<int>();
+    spawn hold(c, ready);
+    recv(ready);
+    snap();
+    send(c, 1);
+    wait();
+    let n = 0;
+    for x in walk() { n = n + x; }
+    return n;
+}
+`
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := sema.New()
+	if err := c.DeclareNative("snap", nil, sema.T(bytecode.TypeVoid)); err != nil {
+		t.Fatal(err)
+	}
+	c.Check(prog)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("sema errors: %v", c.Errors())
+	}
+	var vm *VM
+	var snaps []*Snapshot
+	comp := compilation.NewCompiler()
+	comp.DeclareNative(&bytecode.Native{Name: "snap", ReturnType: bytecode.TypeVoid,
+		Fn: func([]bytecode.Value) (bytecode.Value, error) {
+			snaps = append(snaps, takeSnapshot(t, vm))
+			return bytecode.Value{Kind: bytecode.ValNull}, nil
+		}})
+	mod, err := comp.CompileProgram(prog)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	vm = NewVM(mod, false)
+	vm.SetGCPercent(-1)
+	if ret, err := vm.Call("main", nil); err != nil || ret.I != 1 {
+		t.Fatalf("main = %v, %v, want 1", ret, err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("took %d snapshots, want 2", len(snaps))
+	}
+
+	s := snaps[0]
+	var big SnapshotObject
+	for _, o := range s.Objects {
+		if o.Type == "array" && o.Size == objectSize(1000) {
+			big = o
+		}
+	}
+	if !big.Reachable || big.Dominator != 0 || big.Retained != big.Size {
+		t.Fatalf("array held by the parked task = %+v", big)
+	}
+	found := false
+	for _, r := range s.Roots {
+		if r.Object == big.ID {
+			found = r.Kind == "task" && r.Task == 1 && r.Function == "hold"
+		}
+	}
+	if !found {
+		t.Fatalf("no task root for the array in %+v", s.Roots)
+	}
+
+	found = false
+	for _, r := range snaps[1].Roots {
+		if r.Kind == "generator" && r.Function == "walk" {
+			found = snaps[1].Objects[r.Object-1].Type == "generator"
+		}
+	}
+	if !found {
+		t.Fatalf("no generator root in %+v", snaps[1].Roots)
+	}
+}
@@ -0,0 +1,133 @@
+package runtime
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// Stats describes the work of a VM's garbage collector so far.
+type Stats struct {
+	MinorCollections int
+	MajorCollections int
+	TotalPause       time.Duration
+	MaxPause         time.Duration
+
+	HeapCounts
+	ByType map[bytecode.ObjectType]HeapCounts
+}
+
+// HeapCounts tallies objects and their bytes.
+type HeapCounts struct {
+	AllocatedObjects int
+	AllocatedBytes   int
+	FreedObjects     int
+	FreedBytes       int
+	LiveObjects      int
+	LiveBytes        int
+}
+
+func (c *HeapCounts) allocated(size int) {
+	c.AllocatedObjects++
+	c.AllocatedBytes += size
+	c.LiveObjects++
+	c.LiveBytes += size
+}
+
+func (c *HeapCounts) freed(size int) {
+	c.FreedObjects++
+	c.FreedBytes += size
+	c.LiveObjects--
+	c.LiveBytes -= size
+}
+
+// GCCycle is passed to the GC hook after every collection.
+type GCCycle struct {
+	Major        bool
+	Pause        time.Duration
+	FreedObjects int
+	FreedBytes   int
+	LiveObjects  int
+	LiveBytes    int
+}
+
+// DebugHooks holds callbacks shared by all VMs in the process.
+type DebugHooks struct {
+	mu     sync.Mutex
+	gcHook func(GCCycle)
+}
+
+// Debug is the process-wide set of debug hooks.
+var Debug = &DebugHooks{}
+
+// SetGCHook installs fn to be called after every collection of every VM,
+// on the goroutine running that VM. A nil fn removes the hook.
+func (d *DebugHooks) SetGCHook(fn func(GCCycle)) {
+	d.mu.Lock()
+	d.gcHook = fn
+	d.mu.Unlock()
+}
+
+func (d *DebugHooks) gc() func(GCCycle) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.gcHook
+}
+
+// Stats returns a snapshot of the VM's collector statistics.
+func (vm *VM) Stats() Stats {
+	s := vm.stats
+	s.ByType = make(map[bytecode.ObjectType]HeapCounts, len(vm.stats.ByType))
+	for t, c := range vm.stats.ByType {
+		s.ByType[t] = c
+	}
+	return s
+}
+
+func (vm *VM) countAlloc(obj *bytecode.Object) {
+	vm.stats.allocated(obj.Size)
+	if vm.stats.ByType == nil {
+		vm.stats.ByType = make(map[bytecode.ObjectType]HeapCounts)
+	}
+	c := vm.stats.ByType[obj.Type]
+	c.allocated(obj.Size)
+	vm.stats.ByType[obj.Type] = c
+}
+
+func (vm *VM) countFree(obj *bytecode.Object) {
+	vm.stats.freed(obj.Size)
+	c := vm.stats.ByType[obj.Type]
+	c.freed(obj.Size)
+	vm.stats.ByType[obj.Type] = c
+}
+
+// beginCycle starts timing a collection; the returned function ends it.
+func (vm *VM) beginCycle(major bool) func() {
+	start := time.Now()
+	freedObjects, freedBytes := vm.stats.FreedObjects, vm.stats.FreedBytes
+
+	return func() {
+		pause := time.Since(start)
+		if major {
+			vm.stats.MajorCollections++
+		} else {
+			vm.stats.MinorCollections++
+		}
+		vm.stats.TotalPause += pause
+		if pause > vm.stats.MaxPause {
+			vm.stats.MaxPause = pause
+		}
+
+		if hook := Debug.gc(); hook != nil {
+			hook(GCCycle{
+				Major:        major,
+				Pause:        pause,
+				FreedObjects: vm.stats.FreedObjects - freedObjects,
+				FreedBytes:   vm.stats.FreedBytes - freedBytes,
+				LiveObjects:  vm.stats.LiveObjects,
+				LiveBytes:    vm.stats.LiveBytes,
+			})
+		}
+	}
+}
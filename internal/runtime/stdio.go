@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Stdout sets where print and println write. Output is buffered and
+// flushed when a call into the VM returns.
+func Stdout(w io.Writer) Option {
+	return func(vm *VM) { vm.stdout = bufio.NewWriter(w) }
+}
+
+// Stderr sets where eprintln writes. Like Stdout it is flushed when a call
+// into the VM returns.
+func Stderr(w io.Writer) Option {
+	return func(vm *VM) { vm.stderr = bufio.NewWriter(w) }
+}
+
+// Stdin sets where read_line and read_int read from.
+func Stdin(r io.Reader) Option {
+	return func(vm *VM) { vm.stdin = bufio.NewReader(r) }
+}
+
+func (vm *VM) flush() error {
+	err := vm.stdout.Flush()
+	if err2 := vm.stderr.Flush(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// readLine reads a line without its line ending. A last line without one
+// is returned as it is; once everything has been read it fails, so that
+// the end of the input is not mistaken for an empty line.
+func (vm *VM) readLine() (string, error) {
+	if err := vm.stdout.Flush(); err != nil {
+		return "", err
+	}
+	line, err := vm.stdin.ReadString('\n')
+	if errors.Is(err, io.EOF) && line == "" {
+		return "", fmt.Errorf("read_line: unexpected end of input")
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read_line: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// readInt reads the next whitespace-separated word and parses it as an int.
+func (vm *VM) readInt() (int64, error) {
+	if err := vm.stdout.Flush(); err != nil {
+		return 0, err
+	}
+	var word []byte
+	for {
+		b, err := vm.stdin.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read_int: %v", err)
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			if len(word) > 0 {
+				break
+			}
+			continue
+		}
+		word = append(word, b)
+	}
+	if len(word) == 0 {
+		return 0, fmt.Errorf("read_int: unexpected end of input")
+	}
+	n, err := strconv.ParseInt(string(word), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("read_int: invalid int %q", word)
+	}
+	return n, nil
+}
+
+func defaultStdio(vm *VM) {
+	vm.stdout = bufio.NewWriter(os.Stdout)
+	vm.stderr = bufio.NewWriter(os.Stderr)
+	vm.stdin = bufio.NewReader(os.Stdin)
+}
@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const tasksProgram = `
+fn spin(tag: string, n: int) {
+    for let i: int = 0; i < n; i = i + 1 {
+        print(tag);
+        let x: int = 0;
+        for let k: int = 0; k < 50; k = k + 1 { x = x + k; }
+    }
+}
+fn race() -> int {
+    spawn spin("a", 20);
+    spawn spin("b", 20);
+    spawn spin("c", 20);
+    wait();
+    return 0;
+}
+fn forever(c: chan<int>) {
+    for let i: int = 0; true; i = i + 1 { send(c, i); }
+}
+fn leave_early() -> int {
+    let c: chan<int> = chan<int>();
+    spawn forever(c);
+    return recv(c) + recv(c) + recv(c);
+}
+fn stuck(c: chan<int>) -> int {
+    return recv(c);
+}
+fn deadlock() -> int {
+    let c: chan<int> = chan<int>(1);
+    spawn stuck(c);
+    spawn stuck(c);
+    send(c, 1);
+    wait();
+    return 0;
+}
+`
+
+func runTasks(t *testing.T, fn string, opts ...Option) (string, bytecode.Value, error) {
+	t.Helper()
+	var out strings.Builder
+	vm := NewVM(compileProgram(t, tasksProgram), false, append(opts, Stdout(&out))...)
+	ret, err := vm.Call(fn, nil)
+	if vm.sched != nil || len(vm.frames) != 0 {
+		t.Fatalf("%s left tasks or frames behind", fn)
+	}
+	return out.String(), ret, err
+}
+
+func TestTasks_SeedDeterminesInterleaving(t *testing.T) {
+	first, _, err := runTasks(t, "race", SchedulerSeed(42), TimeSlice(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, _ := runTasks(t, "race", SchedulerSeed(42), TimeSlice(100))
+	if again != first {
+		t.Fatalf("same seed gave different runs:\n%s\n%s", first, again)
+	}
+	for _, tag := range []string{"a ", "b ", "c "} {
+		if strings.Count(first, tag) != 20 {
+			t.Fatalf("output %q does not hold 20 of %q", first, tag)
+		}
+	}
+	if strings.HasPrefix(first, strings.Repeat("a ", 20)) {
+		t.Fatalf("tasks were not preempted: %q", first)
+	}
+
+	differs := false
+	for seed := int64(1); seed <= 5 && !differs; seed++ {
+		other, _, _ := runTasks(t, "race", SchedulerSeed(seed), TimeSlice(100))
+		differs = other != first
+	}
+	if !differs {
+		t.Fatal("every seed gave the same interleaving")
+	}
+
+	serial, _, _ := runTasks(t, "race", SchedulerSeed(42), TimeSlice(0))
+	tags := strings.Fields(serial)
+	switches := 0
+	for i := 1; i < len(tags); i++ {
+		if tags[i] != tags[i-1] {
+			switches++
+		}
+	}
+	if switches != 2 {
+		t.Fatalf("tasks without a time slice switched before finishing: %q", serial)
+	}
+}
+
+func TestTasks_DroppedWhenCallReturns(t *testing.T) {
+	_, ret, err := runTasks(t, "leave_early")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.I != 0+1+2 {
+		t.Fatalf("leave_early() = %d, want 3", ret.I)
+	}
+}
+
+func TestTasks_DeadlockIsReported(t *testing.T) {
+	_, _, err := runTasks(t, "deadlock")
+	if !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("err = %v, want a deadlock", err)
+	}
+}
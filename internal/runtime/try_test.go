@@ -0,0 +1,86 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+const tryProgram = `
+fn down(n: int) -> int {
+    if n == 0 { return 0; }
+    return 1 + down(n - 1);
+}
+fn deep(n: int) -> int {
+    try {
+        return down(n);
+    } catch e {
+        return -1;
+    }
+}
+fn spin() -> int {
+    let i: int = 0;
+    try {
+        while true { i = i + 1; }
+    } catch e {
+        return -1;
+    }
+    return i;
+}
+fn boom() -> int {
+    let s: int = 0;
+    try {
+        s = 1;
+    } catch e {
+        s = 2;
+    }
+    panic("boom");
+    return s;
+}
+`
+
+func TestTry_CatchesStackOverflowButNotLimits(t *testing.T) {
+	mod := compileProgram(t, tryProgram)
+	for _, jit := range []bool{false, true} {
+		vm := NewVM(mod, jit, MaxCallDepth(50), InstructionBudget(100000))
+		if ret, err := vm.Call("deep", []bytecode.Value{intValue(100)}); err != nil || ret.I != -1 {
+			t.Fatalf("jit=%v: deep(100) = %v, %v, want -1", jit, ret, err)
+		}
+		// The frames the overflow dropped are gone.
+		if ret, err := vm.Call("deep", []bytecode.Value{intValue(10)}); err != nil || ret.I != 10 {
+			t.Fatalf("jit=%v: deep(10) = %v, %v, want 10", jit, ret, err)
+		}
+		if _, err := vm.Call("spin", nil); !errors.Is(err, ErrBudgetExceeded) {
+			t.Fatalf("jit=%v: spin: got %v, want budget exceeded", jit, err)
+		}
+		var rerr *RuntimeError
+		if _, err := vm.Call("boom", nil); !errors.As(err, &rerr) || rerr.Line != 29 || rerr.Err.Error() != "boom" {
+			t.Fatalf("jit=%v: boom: got %v, want boom at line 29", jit, err)
+		}
+	}
+}
+
+func TestTry_InternalFailuresAreErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"not", []byte{byte(bytecode.OpConst), 0, 0, byte(bytecode.OpNot), byte(bytecode.OpReturn)}, "non-bool used in boolean context"},
+		{"jump", []byte{byte(bytecode.OpConst), 0, 0, byte(bytecode.OpJumpIfFalse), 0, 0, byte(bytecode.OpReturn)}, "non-bool used in boolean context"},
+		{"underflow", []byte{byte(bytecode.OpPop), byte(bytecode.OpReturn)}, "stack underflow"},
+	} {
+		fn := bytecode.CreateFunction(tc.name, 0)
+		fn.Chunk.Code = tc.code
+		fn.Chunk.AddConstant(intValue(1))
+		mod := bytecode.CreateModule("m")
+		mod.Functions[fn.Name] = fn
+
+		_, err := NewVM(mod, false).Call(tc.name, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: got %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
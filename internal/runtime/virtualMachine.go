@@ -1,15 +1,21 @@
 package runtime
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/ir"
 	jit "github.com/dunooo0ooo/lang/internal/runtime/compilation/jit_optimization"
 )
 
 type rootSet struct {
+	fn     *bytecode.FunctionInfo
 	locals *[]bytecode.Value
 	stack  *[]bytecode.Value
 }
@@ -17,19 +23,100 @@ type VM struct {
 	mod   *bytecode.Module
 	heap  bytecode.Heap
 	roots []rootSet
+	gray  []*bytecode.Object
+
+	// frames are the activations of the running call, innermost last.
+	frames    []*frame
+	liveStack *[]bytecode.Value
+
+	// sched runs the tasks spawned during the current call, if any.
+	sched     *scheduler
+	schedSeed int64
+	timeSlice int
+
+	stats Stats
+
+	gcPercent    int
+	nurseryBytes int
+	gcStress     bool
+	stressCount  int
+
+	stdout *bufio.Writer
+	stderr *bufio.Writer
+	stdin  *bufio.Reader
+
+	// pinned holds values made by NewArray and the last call's result,
+	// which stay reachable until the next call returns.
+	pinned []bytecode.Value
+
+	ctx          context.Context
+	fuel         int64
+	budget       int64
+	budgetLeft   int64
+	heapLimit    int
+	maxCallDepth int
 }
 
-func NewVM(mod *bytecode.Module, isActivatedJit bool) *VM {
-	if isActivatedJit {
-		for _, fn := range mod.Functions {
+// Option configures a VM created by NewVM.
+type Option func(*VM)
+
+// OptimizeModule returns an optimized copy of mod, leaving mod untouched.
+func OptimizeModule(mod *bytecode.Module) *bytecode.Module {
+	out := mod.Clone()
+	for _, fn := range out.Functions {
+		jit.OptimizePeephole(fn)
+		if err := ir.Optimize(out, fn); err == nil {
 			jit.OptimizePeephole(fn)
 		}
 	}
+	return out
+}
+
+// NewVM creates a VM running mod. The VM never modifies mod, so any number
+// of VMs may share it; with isActivatedJit set it runs an optimized copy.
+func NewVM(mod *bytecode.Module, isActivatedJit bool, opts ...Option) *VM {
+	if isActivatedJit {
+		mod = OptimizeModule(mod)
+	}
+
+	vm := &VM{
+		mod:          mod,
+		gcPercent:    defaultGCPercent,
+		nurseryBytes: defaultNurseryBytes,
+		maxCallDepth: defaultMaxCallDepth,
+		timeSlice:    defaultTimeSlice,
+		ctx:          context.Background(),
+	}
+	defaultStdio(vm)
+	for _, opt := range opts {
+		opt(vm)
+	}
+	vm.paceMajor()
+	return vm
+}
 
-	return &VM{mod: mod}
+// Reset drops everything the VM's heap holds from earlier calls, so that
+// the VM can be reused without keeping that memory alive.
+func (vm *VM) Reset() {
+	vm.pinned = vm.pinned[:0]
+	vm.gc()
 }
 
 func (vm *VM) Call(name string, args []bytecode.Value) (bytecode.Value, error) {
+	return vm.CallContext(context.Background(), name, args)
+}
+
+// CallContext calls the named function, stopping with ctx.Err() once ctx is
+// done. The instruction budget applies to each call separately.
+func (vm *VM) CallContext(ctx context.Context, name string, args []bytecode.Value) (result bytecode.Value, err error) {
+	vm.startCall(ctx)
+	defer func() {
+		if ferr := vm.flush(); err == nil && ferr != nil {
+			err = fmt.Errorf("flush output: %v", ferr)
+		}
+		vm.pinned = append(vm.pinned[:0], result)
+	}()
+
 	fn, ok := vm.mod.Functions[name]
 	if !ok {
 		return bytecode.Value{}, fmt.Errorf("unknown function %q", name)
@@ -38,27 +125,49 @@ func (vm *VM) Call(name string, args []bytecode.Value) (bytecode.Value, error) {
 		return bytecode.Value{}, fmt.Errorf("function %q: expected %d args, got %d",
 			name, fn.ParamCount, len(args))
 	}
-	return vm.runFunction(fn, args)
+	if fn.IsGenerator {
+		gen, err := vm.newGenerator(fn, args)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		return bytecode.Value{Kind: bytecode.ValObject, Obj: gen}, nil
+	}
+	result, _, err = vm.run(newFrame(fn, args))
+	return result, err
 }
 
-func (vm *VM) runFunction(fn *bytecode.FunctionInfo, args []bytecode.Value) (bytecode.Value, error) {
-	ch := &fn.Chunk
-
-	locals := make([]bytecode.Value, fn.NumLocals)
-	copy(locals, args)
-
-	stack := make([]bytecode.Value, 0, 256)
-
-	vm.roots = append(vm.roots, rootSet{
-		locals: &locals,
-		stack:  &stack,
-	})
+// run executes frames starting with first until first returns or, for a
+// generator's frame, yields; suspended reports the latter. Calls between
+// bytecode functions push frames onto vm.frames instead of recursing, so a
+// generator's frame can be set aside in the middle of a call chain. Tasks
+// spawned meanwhile take turns on vm.frames above base and are dropped
+// when the run ends.
+func (vm *VM) run(first *frame) (result bytecode.Value, suspended bool, err error) {
+	base := len(vm.frames)
+	vm.pushFrame(first)
+
+	fr := first
+	ch := &fr.fn.Chunk
+	locals := fr.locals
+	stack := fr.stack
+	ip := fr.ip
+	opStart := ip
+
+	// The running frame's operand stack is kept in stack and written back
+	// when another frame takes over; liveStack lets collections see it.
+	vm.liveStack = &stack
 	defer func() {
-		vm.roots = vm.roots[:len(vm.roots)-1]
+		vm.liveStack = nil
+		if err != nil {
+			if s := vm.sched; s != nil && s.cur != s.main && !errors.Is(err, ErrDeadlock) {
+				err = fmt.Errorf("task %d: %w", s.cur.id, err)
+			}
+			err = withLine(err, ch.LineAt(opStart))
+		}
+		vm.unwind(base)
+		vm.dropTasks()
 	}()
 
-	ip := 0
-
 	readUint16 := func() uint16 {
 		hi := uint16(ch.Code[ip])
 		lo := uint16(ch.Code[ip+1])
@@ -79,290 +188,821 @@ func (vm *VM) runFunction(fn *bytecode.FunctionInfo, args []bytecode.Value) (byt
 		stack = append(stack, v)
 	}
 
-	for {
-		if ip >= len(ch.Code) {
-			return bytecode.Value{Kind: bytecode.ValNull}, nil
+	enter := func(next *frame) {
+		fr.ip, fr.stack = ip, stack
+		vm.pushFrame(next)
+		fr, ch, locals, stack, ip = next, &next.fn.Chunk, next.locals, next.stack, next.ip
+	}
+
+	// leave pops the current frame and continues in its caller.
+	leave := func() {
+		vm.popFrame()
+		fr = vm.frames[len(vm.frames)-1]
+		ch, locals, stack, ip = &fr.fn.Chunk, fr.locals, fr.stack, fr.ip
+	}
+
+	// switchTask parks the running task and continues the one the
+	// scheduler picks, which may be the same one. It fails when every task
+	// is blocked, or with the error a task was woken up with.
+	switchTask := func() error {
+		s := vm.scheduler()
+		next := s.pick()
+		if next == nil {
+			return ErrDeadlock
+		}
+		// The next task starts a fresh time slice.
+		if vm.budget > 0 {
+			vm.budgetLeft += vm.fuel
+		}
+		vm.fuel = 0
+		s.sliceLeft = int64(vm.timeSlice)
+		if next == s.cur {
+			return nil
 		}
-		op := bytecode.OpCode(ch.Code[ip])
-		ip++
-
-		switch op {
-		case bytecode.OpConst:
-			idx := readUint16()
-			if int(idx) >= len(ch.Constants) {
-				return bytecode.Value{}, fmt.Errorf("const index out of range: %d", idx)
-			}
-			push(ch.Constants[idx])
 
-		case bytecode.OpLoadLocal:
-			slot := int(ch.Code[ip])
-			ip++
-			if slot < 0 || slot >= len(locals) {
-				return bytecode.Value{}, fmt.Errorf("load local: bad slot %d", slot)
-			}
-			push(locals[slot])
+		fr.ip, fr.stack = ip, stack
+		s.cur.frames = append(s.cur.frames[:0], vm.frames[base:]...)
+		for len(vm.frames) > base {
+			vm.popFrame()
+		}
+		for _, f := range next.frames {
+			vm.pushFrame(f)
+		}
+		clear(next.frames)
+		next.frames = next.frames[:0]
+		s.cur = next
+
+		fr = vm.frames[len(vm.frames)-1]
+		ch, locals, stack, ip = &fr.fn.Chunk, fr.locals, fr.stack, fr.ip
+		if next.err != nil {
+			err := next.err
+			next.err = nil
+			opStart = ip - 1
+			return err
+		}
+		return nil
+	}
 
-		case bytecode.OpStoreLocal:
-			slot := int(ch.Code[ip])
-			ip++
-			if slot < 0 || slot >= len(locals) {
-				return bytecode.Value{}, fmt.Errorf("store local: bad slot %d", slot)
-			}
-			v := pop()
-			locals[slot] = v
-
-		case bytecode.OpAdd:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("+", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
-			}
-			push(res)
-
-		case bytecode.OpSub:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("-", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
-			}
-			push(res)
-
-		case bytecode.OpMul:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("*", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
+	// exit returns v from the current frame and reports whether that
+	// ends the run. A spawned task's first frame returning ends the task.
+	// A generator's body has no result: finishing it sends its caller to
+	// doneIP, or fails the next() that resumed it.
+	exit := func(v bytecode.Value) (bool, error) {
+		done := fr
+		if done.gen != nil {
+			done.gen.State = nil
+		}
+		if len(vm.frames) == base+1 {
+			if s := vm.sched; s != nil && s.cur != s.main {
+				vm.popFrame()
+				s.finish(s.cur)
+				return false, switchTask()
 			}
-			push(res)
-
-		case bytecode.OpDiv:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("/", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
+			vm.popFrame()
+			result = v
+			return true, nil
+		}
+		leave()
+		switch {
+		case done.gen == nil:
+			push(v)
+		case done.doneIP >= 0:
+			ip = done.doneIP
+		default:
+			opStart = ip - 1
+			return false, errGeneratorFinished
+		}
+		return false, nil
+	}
+
+	// resume continues the generator held by v in a new frame on top of
+	// the current one. It reports false, leaving the current frame alone,
+	// if the generator has already finished.
+	resume := func(v bytecode.Value, doneIP int) (bool, error) {
+		if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjGenerator {
+			return false, fmt.Errorf("next: value is not a generator")
+		}
+		gf, _ := v.Obj.State.(*frame)
+		if gf == nil {
+			return false, nil
+		}
+		if gf.running {
+			return false, fmt.Errorf("next: generator is already running")
+		}
+		if err := vm.checkCallDepth(); err != nil {
+			return false, err
+		}
+		gf.running = true
+		gf.doneIP = doneIP
+		enter(gf)
+		return true, nil
+	}
+
+	// catch hands err to the innermost handler covering the failed
+	// instruction in the running task's frames, dropping the frames above
+	// the handler's. It reports false, changing nothing, if there is none.
+	catch := func(err error) bool {
+		if !catchable(err) {
+			return false
+		}
+		var h *bytecode.Handler
+		i := len(vm.frames) - 1
+		for pc := opStart; i >= base; i-- {
+			if i < len(vm.frames)-1 {
+				pc = vm.frames[i].ip - 1
 			}
-			push(res)
-
-		case bytecode.OpMod:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("%", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
+			if h = vm.frames[i].fn.Chunk.HandlerAt(pc); h != nil {
+				break
 			}
-			push(res)
-
-		case bytecode.OpPow:
-			b := pop()
-			a := pop()
-			res, err := vm.binaryNumberOp("^", a, b)
-			if err != nil {
-				return bytecode.Value{}, err
+		}
+		if h == nil || vm.reserve(bytecode.ErrorVariant.Arity) != nil {
+			return false
+		}
+		e := vm.errorValue(err, ch.LineAt(opStart))
+		for len(vm.frames) > i+1 {
+			if fr.gen != nil {
+				fr.gen.State = nil
 			}
-			push(res)
-
-		case bytecode.OpEq:
-			b := pop()
-			a := pop()
-			push(boolValue(vm.equal(a, b)))
-
-		case bytecode.OpNe:
-			b := pop()
-			a := pop()
-			push(boolValue(!vm.equal(a, b)))
-
-		case bytecode.OpLt, bytecode.OpLe, bytecode.OpGt, bytecode.OpGe:
-			b := pop()
-			a := pop()
-			res, err := vm.compareNumbers(op, a, b)
-			if err != nil {
-				return bytecode.Value{}, err
+			leave()
+		}
+		stack = append(stack[:h.Depth], e)
+		ip = h.Target
+		return true
+	}
+
+	// exec runs instructions until the run ends or one of them fails. A Go
+	// panic fails the instruction like any other error.
+	exec := func() (_ bytecode.Value, _ bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
 			}
-			push(boolValue(res))
+		}()
 
-		case bytecode.OpNeg:
-			v := pop()
-			if v.Kind != bytecode.ValFloat && v.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("unary - on non-number")
+		for {
+			if ip >= len(ch.Code) {
+				done, err := exit(bytecode.Value{Kind: bytecode.ValNull})
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if done {
+					return result, false, nil
+				}
+				continue
 			}
-			if v.Kind == bytecode.ValFloat {
-				v.F = -v.F
-			} else {
-				v.I = -v.I
+			opStart = ip
+			if vm.fuel == 0 {
+				if s := vm.sched; s != nil && s.sliceLeft == 0 && vm.timeSlice > 0 {
+					if err := switchTask(); err != nil {
+						return bytecode.Value{}, false, err
+					}
+				}
+				if err := vm.refuel(); err != nil {
+					return bytecode.Value{}, false, err
+				}
 			}
-			push(v)
+			vm.fuel--
+			op := bytecode.OpCode(ch.Code[ip])
+			ip++
 
-		case bytecode.OpNot:
-			v := pop()
-			push(boolValue(!vm.isTruthy(v)))
+			switch op {
+			case bytecode.OpConst:
+				idx := readUint16()
+				if int(idx) >= len(ch.Constants) {
+					return bytecode.Value{}, false, fmt.Errorf("const index out of range: %d", idx)
+				}
+				push(ch.Constants[idx])
 
-		case bytecode.OpJump:
-			target := int(readUint16())
-			if target < 0 || target > len(ch.Code) {
-				return bytecode.Value{}, fmt.Errorf("jump: bad target %d", target)
-			}
-			ip = target
+			case bytecode.OpLoadLocal:
+				slot := int(ch.Code[ip])
+				ip++
+				if slot < 0 || slot >= len(locals) {
+					return bytecode.Value{}, false, fmt.Errorf("load local: bad slot %d", slot)
+				}
+				push(locals[slot])
+
+			case bytecode.OpStoreLocal:
+				slot := int(ch.Code[ip])
+				ip++
+				if slot < 0 || slot >= len(locals) {
+					return bytecode.Value{}, false, fmt.Errorf("store local: bad slot %d", slot)
+				}
+				v := pop()
+				locals[slot] = v
+
+			case bytecode.OpLoadLocal2:
+				a, b := int(ch.Code[ip]), int(ch.Code[ip+1])
+				ip += 2
+				if a >= len(locals) || b >= len(locals) {
+					return bytecode.Value{}, false, fmt.Errorf("load local2: bad slots %d,%d", a, b)
+				}
+				push(locals[a])
+				push(locals[b])
+
+			case bytecode.OpTeeLocal:
+				slot := int(ch.Code[ip])
+				ip++
+				if slot < 0 || slot >= len(locals) {
+					return bytecode.Value{}, false, fmt.Errorf("tee local: bad slot %d", slot)
+				}
+				if len(stack) == 0 {
+					panic("stack underflow")
+				}
+				locals[slot] = stack[len(stack)-1]
+
+			case bytecode.OpAdd:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("+", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpSub:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("-", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpMul:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("*", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpDiv:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("/", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpMod:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("%", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpPow:
+				b := pop()
+				a := pop()
+				res, err := vm.binaryNumberOp("^", a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(res)
+
+			case bytecode.OpEq:
+				b := pop()
+				a := pop()
+				push(boolValue(vm.equal(a, b)))
+
+			case bytecode.OpNe:
+				b := pop()
+				a := pop()
+				push(boolValue(!vm.equal(a, b)))
+
+			case bytecode.OpLt, bytecode.OpLe, bytecode.OpGt, bytecode.OpGe:
+				b := pop()
+				a := pop()
+				res, err := vm.compareNumbers(op, a, b)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(boolValue(res))
+
+			case bytecode.OpNeg:
+				v := pop()
+				if v.Kind != bytecode.ValFloat && v.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("unary - on non-number")
+				}
+				if v.Kind == bytecode.ValFloat {
+					v.F = -v.F
+				} else {
+					v.I = -v.I
+				}
+				push(v)
+
+			case bytecode.OpIntToFloat:
+				v := pop()
+				if v.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("float: value is not int")
+				}
+				push(bytecode.Value{Kind: bytecode.ValFloat, F: float64(v.I)})
 
-		case bytecode.OpJumpIfFalse:
-			target := int(readUint16())
-			top := stack[len(stack)-1]
-			if !vm.isTruthy(top) {
+			case bytecode.OpNot:
+				b, err := vm.isTruthy(pop())
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(boolValue(!b))
+
+			case bytecode.OpJump:
+				target := int(readUint16())
 				if target < 0 || target > len(ch.Code) {
-					return bytecode.Value{}, fmt.Errorf("jump-if-false: bad target %d", target)
+					return bytecode.Value{}, false, fmt.Errorf("jump: bad target %d", target)
 				}
 				ip = target
-			}
 
-		case bytecode.OpPop:
-			_ = pop()
+			case bytecode.OpJumpIfFalse:
+				target := int(readUint16())
+				b, err := vm.isTruthy(stack[len(stack)-1])
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if !b {
+					if target < 0 || target > len(ch.Code) {
+						return bytecode.Value{}, false, fmt.Errorf("jump-if-false: bad target %d", target)
+					}
+					ip = target
+				}
 
-		case bytecode.OpCall:
-			idx := readUint16()
-			if int(idx) >= len(ch.Constants) {
-				return bytecode.Value{}, fmt.Errorf("call: const index out of range %d", idx)
-			}
-			constVal := ch.Constants[idx]
-			if constVal.Kind != bytecode.ValString {
-				return bytecode.Value{}, fmt.Errorf("call: const is not string (function name)")
-			}
-			calleeName := constVal.S
-			callee, ok := vm.mod.Functions[calleeName]
-			if !ok {
-				return bytecode.Value{}, fmt.Errorf("unknown function %q", calleeName)
-			}
+			case bytecode.OpJumpIfTrue:
+				target := int(readUint16())
+				b, err := vm.isTruthy(stack[len(stack)-1])
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if b {
+					if target < 0 || target > len(ch.Code) {
+						return bytecode.Value{}, false, fmt.Errorf("jump-if-true: bad target %d", target)
+					}
+					ip = target
+				}
 
-			n := callee.ParamCount
-			if len(stack) < n {
-				return bytecode.Value{}, fmt.Errorf("call %q: stack has %d values, want %d args",
-					calleeName, len(stack), n)
-			}
+			case bytecode.OpPop:
+				_ = pop()
 
-			argsVals := make([]bytecode.Value, n)
-			copy(argsVals, stack[len(stack)-n:])
-			stack = stack[:len(stack)-n]
+			case bytecode.OpCall:
+				idx := readUint16()
+				if int(idx) >= len(ch.Constants) {
+					return bytecode.Value{}, false, fmt.Errorf("call: const index out of range %d", idx)
+				}
+				constVal := ch.Constants[idx]
+				if constVal.Kind != bytecode.ValString {
+					return bytecode.Value{}, false, fmt.Errorf("call: const is not string (function name)")
+				}
+				calleeName := constVal.S
+				callee, ok := vm.mod.Functions[calleeName]
+				if !ok {
+					native, ok := vm.mod.Natives[calleeName]
+					if !ok {
+						return bytecode.Value{}, false, fmt.Errorf("unknown function %q", calleeName)
+					}
+					n := len(native.ParamTypes)
+					if len(stack) < n {
+						return bytecode.Value{}, false, fmt.Errorf("call %q: stack has %d values, want %d args",
+							calleeName, len(stack), n)
+					}
+					argsVals := make([]bytecode.Value, n)
+					copy(argsVals, stack[len(stack)-n:])
+					stack = stack[:len(stack)-n]
+
+					ret, err := callNative(native, argsVals)
+					if err != nil {
+						return bytecode.Value{}, false, err
+					}
+					push(ret)
+					continue
+				}
 
-			ret, err := vm.runFunction(callee, argsVals)
-			if err != nil {
-				return bytecode.Value{}, err
-			}
-			push(ret)
+				n := callee.ParamCount
+				if len(stack) < n {
+					return bytecode.Value{}, false, fmt.Errorf("call %q: stack has %d values, want %d args",
+						calleeName, len(stack), n)
+				}
+				args := stack[len(stack)-n:]
+
+				if callee.IsGenerator {
+					gen, err := vm.newGenerator(callee, args)
+					if err != nil {
+						return bytecode.Value{}, false, err
+					}
+					stack = stack[:len(stack)-n]
+					push(bytecode.Value{Kind: bytecode.ValObject, Obj: gen})
+					continue
+				}
 
-		case bytecode.OpPrint:
-			v := pop()
-			fmt.Print(formatValue(v) + " ")
-			push(bytecode.Value{Kind: bytecode.ValNull})
+				if err := vm.checkCallDepth(); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				next := newFrame(callee, args)
+				stack = stack[:len(stack)-n]
+				enter(next)
+
+			case bytecode.OpInvoke:
+				idx := readUint16()
+				argc := int(ch.Code[ip])
+				ip++
+				if int(idx) >= len(ch.Constants) || ch.Constants[idx].Kind != bytecode.ValString {
+					return bytecode.Value{}, false, fmt.Errorf("invoke: bad method name constant %d", idx)
+				}
+				name := ch.Constants[idx].S
+				n := argc + 1
+				if len(stack) < n {
+					return bytecode.Value{}, false, fmt.Errorf("invoke %q: stack has %d values, want %d", name, len(stack), n)
+				}
+				recv := stack[len(stack)-n]
+				callee, ok := vm.mod.Functions[vm.mod.VTables[typeName(recv)][name]]
+				if !ok {
+					ret, err := vm.builtinMethod(name, recv, stack[len(stack)-argc:])
+					if err != nil {
+						return bytecode.Value{}, false, err
+					}
+					stack = stack[:len(stack)-n]
+					push(ret)
+					continue
+				}
+				if callee.ParamCount != n {
+					return bytecode.Value{}, false, fmt.Errorf("invoke %q: method takes %d args, got %d", name, callee.ParamCount-1, argc)
+				}
+				if callee.IsGenerator {
+					gen, err := vm.newGenerator(callee, stack[len(stack)-n:])
+					if err != nil {
+						return bytecode.Value{}, false, err
+					}
+					stack = stack[:len(stack)-n]
+					push(bytecode.Value{Kind: bytecode.ValObject, Obj: gen})
+					continue
+				}
+				if err := vm.checkCallDepth(); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				next := newFrame(callee, stack[len(stack)-n:])
+				stack = stack[:len(stack)-n]
+				enter(next)
+
+			case bytecode.OpYield:
+				v := pop()
+				if fr.gen == nil {
+					return bytecode.Value{}, false, fmt.Errorf("yield outside a generator")
+				}
+				fr.ip, fr.stack = ip, stack
+				fr.running = false
+				// The suspended frame is reachable only through its generator
+				// now, which may already be old.
+				vm.remember(fr.gen)
+				if len(vm.frames) == base+1 {
+					vm.popFrame()
+					return v, true, nil
+				}
+				leave()
+				push(v)
 
-		case bytecode.OpPrintLn:
-			v := pop()
-			fmt.Println(formatValue(v))
-			push(bytecode.Value{Kind: bytecode.ValNull})
+			case bytecode.OpNext:
+				ok, err := resume(pop(), -1)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if !ok {
+					return bytecode.Value{}, false, errGeneratorFinished
+				}
 
-		case bytecode.OpArraySet:
-			val := pop()
-			idxVal := pop()
-			arrVal := pop()
+			case bytecode.OpIterNext:
+				target := int(readUint16())
+				it := pop()
+				if it.Kind == bytecode.ValObject && it.Obj != nil && it.Obj.Type == bytecode.ObjChan {
+					obj, c, err := chanOf(it, "recv")
+					if err != nil {
+						return bytecode.Value{}, false, err
+					}
+					v, blocked, err := vm.chanRecv(obj, c, target)
+					switch {
+					case errors.Is(err, errRecvClosed):
+						ip = target
+					case err != nil:
+						return bytecode.Value{}, false, err
+					case blocked:
+						if err := switchTask(); err != nil {
+							return bytecode.Value{}, false, err
+						}
+					default:
+						push(v)
+					}
+					continue
+				}
+				ok, err := resume(it, target)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if !ok {
+					ip = target
+				}
 
-			if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
-				return bytecode.Value{}, fmt.Errorf("array set: value is not array")
-			}
-			if idxVal.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("array set: index must be int")
-			}
-			idx := int(idxVal.I)
-			if idx < 0 || idx >= len(arrVal.Obj.Items) {
-				return bytecode.Value{}, fmt.Errorf("array set: index %d out of range [0,%d)", idx, len(arrVal.Obj.Items))
-			}
-			arrVal.Obj.Items[idx] = val
+			case bytecode.OpSpawn:
+				idx := readUint16()
+				if int(idx) >= len(ch.Constants) || ch.Constants[idx].Kind != bytecode.ValString {
+					return bytecode.Value{}, false, fmt.Errorf("spawn: bad function constant %d", idx)
+				}
+				name := ch.Constants[idx].S
+				callee, ok := vm.mod.Functions[name]
+				if !ok || callee.IsGenerator {
+					return bytecode.Value{}, false, fmt.Errorf("spawn: cannot spawn %q", name)
+				}
+				n := callee.ParamCount
+				if len(stack) < n {
+					return bytecode.Value{}, false, fmt.Errorf("spawn %q: stack has %d values, want %d args",
+						name, len(stack), n)
+				}
+				vm.spawn(newFrame(callee, stack[len(stack)-n:]))
+				stack = stack[:len(stack)-n]
 
-			push(bytecode.Value{Kind: bytecode.ValNull})
-		case bytecode.OpReturn:
-			if len(stack) == 0 {
-				return bytecode.Value{Kind: bytecode.ValNull}, nil
-			}
-			return stack[len(stack)-1], nil
+			case bytecode.OpVariant:
+				idx := int(readUint16())
+				if idx >= len(vm.mod.Variants) {
+					return bytecode.Value{}, false, fmt.Errorf("variant: bad index %d", idx)
+				}
+				desc := vm.mod.Variants[idx]
+				n := desc.Arity
+				if len(stack) < n {
+					return bytecode.Value{}, false, fmt.Errorf("variant %s: stack has %d values, want %d",
+						desc.Name, len(stack), n)
+				}
+				if err := vm.reserve(n); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				obj := vm.newVariant(desc, stack[len(stack)-n:])
+				stack = stack[:len(stack)-n]
+				push(bytecode.Value{Kind: bytecode.ValObject, Obj: obj})
+
+			case bytecode.OpIsVariant:
+				idx := int(readUint16())
+				if idx >= len(vm.mod.Variants) {
+					return bytecode.Value{}, false, fmt.Errorf("variant: bad index %d", idx)
+				}
+				v := pop()
+				push(boolValue(v.Kind == bytecode.ValObject && v.Obj != nil && v.Obj.Type == bytecode.ObjVariant &&
+					v.Obj.State == any(vm.mod.Variants[idx])))
+
+			case bytecode.OpVariantField:
+				i := int(ch.Code[ip])
+				ip++
+				v := pop()
+				if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjVariant || i >= len(v.Obj.Items) {
+					return bytecode.Value{}, false, fmt.Errorf("variant field %d: value is not a variant with that many values", i)
+				}
+				push(v.Obj.Items[i])
 
-		case bytecode.OpArrayNew:
-			lenVal := pop()
-			if lenVal.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("array new: length must be int")
-			}
-			if lenVal.I < 0 {
-				return bytecode.Value{}, fmt.Errorf("array new: length must be >= 0")
-			}
-			n := int(lenVal.I)
+			case bytecode.OpChanNew:
+				capVal := pop()
+				if capVal.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("chan: capacity must be int")
+				}
+				if capVal.I < 0 {
+					return bytecode.Value{}, false, fmt.Errorf("chan: capacity must be >= 0")
+				}
+				n := int(capVal.I)
+				if err := vm.reserve(n); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(bytecode.Value{Kind: bytecode.ValObject, Obj: vm.newChan(n)})
 
-			obj := vm.newObject(bytecode.ObjArray)
-			obj.Items = make([]bytecode.Value, n)
+			case bytecode.OpSend:
+				v := pop()
+				obj, c, err := chanOf(pop(), "send")
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				blocked, err := vm.chanSend(obj, c, v)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if !blocked {
+					push(bytecode.Value{Kind: bytecode.ValNull})
+					continue
+				}
+				// The value waits on the stack for a receiver, which leaves
+				// null in its place.
+				push(v)
+				if err := switchTask(); err != nil {
+					return bytecode.Value{}, false, err
+				}
 
-			push(bytecode.Value{
-				Kind: bytecode.ValObject,
-				Obj:  obj,
-			})
+			case bytecode.OpRecv:
+				obj, c, err := chanOf(pop(), "recv")
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				v, blocked, err := vm.chanRecv(obj, c, -1)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if !blocked {
+					push(v)
+					continue
+				}
+				if err := switchTask(); err != nil {
+					return bytecode.Value{}, false, err
+				}
 
-		case bytecode.OpArrayGet:
-			idxVal := pop()
-			arrVal := pop()
+			case bytecode.OpClose:
+				_, c, err := chanOf(pop(), "close")
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if err := vm.chanClose(c); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			case bytecode.OpWait:
+				push(bytecode.Value{Kind: bytecode.ValNull})
+				if s := vm.sched; s != nil && s.cur.live > 0 {
+					vm.block().waiting = true
+					if err := switchTask(); err != nil {
+						return bytecode.Value{}, false, err
+					}
+				}
 
-			if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
-				return bytecode.Value{}, fmt.Errorf("array get: value is not array")
-			}
-			if idxVal.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("array get: index must be int")
-			}
-			idx := int(idxVal.I)
-			if idx < 0 || idx >= len(arrVal.Obj.Items) {
-				return bytecode.Value{}, fmt.Errorf("array get: index %d out of range [0,%d)", idx, len(arrVal.Obj.Items))
-			}
+			case bytecode.OpPrint:
+				v := pop()
+				vm.stdout.WriteString(formatValue(v) + " ")
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			case bytecode.OpPrintLn:
+				v := pop()
+				vm.stdout.WriteString(formatValue(v) + "\n")
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			case bytecode.OpEPrintLn:
+				v := pop()
+				vm.stderr.WriteString(formatValue(v) + "\n")
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			case bytecode.OpReadLine:
+				line, err := vm.readLine()
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(bytecode.Value{Kind: bytecode.ValString, S: line})
 
-			push(arrVal.Obj.Items[idx])
+			case bytecode.OpReadInt:
+				n, err := vm.readInt()
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(bytecode.Value{Kind: bytecode.ValInt, I: n})
 
-		case bytecode.OpArraySwapJit:
-			idxVal := pop()
-			arrVal := pop()
+			case bytecode.OpParseInt, bytecode.OpParseFloat:
+				s := pop()
+				if s.Kind != bytecode.ValString {
+					return bytecode.Value{}, false, fmt.Errorf("parse: value is not string")
+				}
+				if err := vm.reserve(1); err != nil {
+					return bytecode.Value{}, false, err
+				}
+				push(vm.parseNumber(op, s.S))
 
-			if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
-				return bytecode.Value{}, fmt.Errorf("array swap: value is not array")
-			}
-			if idxVal.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("array swap: index must be int")
-			}
+			case bytecode.OpPanic:
+				msg := pop()
+				if msg.Kind != bytecode.ValString {
+					return bytecode.Value{}, false, fmt.Errorf("panic: value is not string")
+				}
+				return bytecode.Value{}, false, errors.New(msg.S)
 
-			j := int(idxVal.I)
-			items := arrVal.Obj.Items
-			if j < 0 || j+1 >= len(items) {
-				return bytecode.Value{}, fmt.Errorf("array swap: index %d out of range", j)
-			}
+			case bytecode.OpArraySet:
+				val := pop()
+				idxVal := pop()
+				arrVal := pop()
 
-			a := items[j]
-			b := items[j+1]
-			if a.Kind != bytecode.ValInt || b.Kind != bytecode.ValInt {
-				return bytecode.Value{}, fmt.Errorf("array swap: non-int elements")
-			}
+				if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
+					return bytecode.Value{}, false, fmt.Errorf("array set: value is not array")
+				}
+				if idxVal.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("array set: index must be int")
+				}
+				idx := int(idxVal.I)
+				if idx < 0 || idx >= len(arrVal.Obj.Items) {
+					return bytecode.Value{}, false, fmt.Errorf("array set: index %d out of range [0,%d)", idx, len(arrVal.Obj.Items))
+				}
+				vm.writeBarrier(arrVal.Obj, val)
+				arrVal.Obj.Items[idx] = val
+
+				push(bytecode.Value{Kind: bytecode.ValNull})
+			case bytecode.OpReturn:
+				v := bytecode.Value{Kind: bytecode.ValNull}
+				if len(stack) > 0 {
+					v = stack[len(stack)-1]
+				}
+				done, err := exit(v)
+				if err != nil {
+					return bytecode.Value{}, false, err
+				}
+				if done {
+					return result, false, nil
+				}
 
-			if a.I > b.I {
-				items[j] = b
-				items[j+1] = a
-			}
+			case bytecode.OpArrayNew:
+				lenVal := pop()
+				if lenVal.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("array new: length must be int")
+				}
+				if lenVal.I < 0 {
+					return bytecode.Value{}, false, fmt.Errorf("array new: length must be >= 0")
+				}
+				n := int(lenVal.I)
+				if err := vm.reserve(n); err != nil {
+					return bytecode.Value{}, false, err
+				}
 
-			push(bytecode.Value{Kind: bytecode.ValNull})
+				obj := vm.newArray(n)
 
-		default:
-			return bytecode.Value{}, fmt.Errorf("unknown opcode %d", op)
+				push(bytecode.Value{
+					Kind: bytecode.ValObject,
+					Obj:  obj,
+				})
+
+			case bytecode.OpArrayGet:
+				idxVal := pop()
+				arrVal := pop()
+
+				if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
+					return bytecode.Value{}, false, fmt.Errorf("array get: value is not array")
+				}
+				if idxVal.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("array get: index must be int")
+				}
+				idx := int(idxVal.I)
+				if idx < 0 || idx >= len(arrVal.Obj.Items) {
+					return bytecode.Value{}, false, fmt.Errorf("array get: index %d out of range [0,%d)", idx, len(arrVal.Obj.Items))
+				}
+
+				push(arrVal.Obj.Items[idx])
+
+			case bytecode.OpArrayGetUnchecked:
+				idxVal := pop()
+				arrVal := pop()
+				push(arrVal.Obj.Items[idxVal.I])
+
+			case bytecode.OpArraySetUnchecked:
+				val := pop()
+				idxVal := pop()
+				arrVal := pop()
+				vm.writeBarrier(arrVal.Obj, val)
+				arrVal.Obj.Items[idxVal.I] = val
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			case bytecode.OpArraySwapJit:
+				idxVal := pop()
+				arrVal := pop()
+
+				if arrVal.Kind != bytecode.ValObject || arrVal.Obj == nil || arrVal.Obj.Type != bytecode.ObjArray {
+					return bytecode.Value{}, false, fmt.Errorf("array swap: value is not array")
+				}
+				if idxVal.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("array swap: index must be int")
+				}
+
+				j := int(idxVal.I)
+				items := arrVal.Obj.Items
+				if j < 0 || j+1 >= len(items) {
+					return bytecode.Value{}, false, fmt.Errorf("array swap: index %d out of range", j)
+				}
+
+				a := items[j]
+				b := items[j+1]
+				if a.Kind != bytecode.ValInt || b.Kind != bytecode.ValInt {
+					return bytecode.Value{}, false, fmt.Errorf("array swap: non-int elements")
+				}
+
+				if a.I > b.I {
+					items[j] = b
+					items[j+1] = a
+				}
+
+				push(bytecode.Value{Kind: bytecode.ValNull})
+
+			default:
+				return bytecode.Value{}, false, fmt.Errorf("unknown opcode %d", op)
+			}
+		}
+	}
+
+	for {
+		v, suspended, err := exec()
+		if err == nil || !catch(err) {
+			return v, suspended, err
 		}
 	}
 }
 
-func (vm *VM) isTruthy(v bytecode.Value) bool {
-	switch v.Kind {
-	case bytecode.ValBool:
-		return v.B
-	default:
-		panic("non-bool used in boolean context")
+func (vm *VM) isTruthy(v bytecode.Value) (bool, error) {
+	if v.Kind != bytecode.ValBool {
+		return false, fmt.Errorf("non-bool used in boolean context")
 	}
+	return v.B, nil
 }
 
 func (vm *VM) equal(a, b bytecode.Value) bool {
@@ -472,6 +1112,9 @@ func (vm *VM) compareNumbers(op bytecode.OpCode, a, b bytecode.Value) (bool, err
 	case bytecode.ValFloat:
 		return compareFloat(op, a.F, b.F)
 
+	case bytecode.ValChar:
+		return compareInt(op, int64(a.C), int64(b.C))
+
 	default:
 		return false, fmt.Errorf("compare: not a number")
 	}
@@ -527,7 +1170,50 @@ func formatValue(v bytecode.Value) string {
 	case bytecode.ValNull:
 		return "null"
 
+	case bytecode.ValObject:
+		if desc, ok := v.Obj.State.(*bytecode.Variant); ok && v.Obj.Type == bytecode.ObjVariant {
+			return formatVariant(desc, v.Obj.Items)
+		}
+		return "<invalid>"
+
 	default:
 		return "<invalid>"
 	}
 }
+
+// formatVariant prints a variant as its name followed by its values, if
+// it has any.
+func formatVariant(desc *bytecode.Variant, items []bytecode.Value) string {
+	if len(items) == 0 {
+		return desc.Name
+	}
+	parts := make([]string, len(items))
+	for i, it := range items {
+		parts[i] = formatValue(it)
+	}
+	return desc.Name + "(" + strings.Join(parts, ", ") + ")"
+}
+
+// parseNumber makes the Result of parse_int or parse_float for s: ok with
+// the number, or err with a message saying why s is not one.
+func (vm *VM) parseNumber(op bytecode.OpCode, s string) bytecode.Value {
+	var v bytecode.Value
+	var err error
+	if op == bytecode.OpParseInt {
+		v.Kind = bytecode.ValInt
+		v.I, err = strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	} else {
+		v.Kind = bytecode.ValFloat
+		v.F, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	desc := bytecode.OkVariant
+	if err != nil {
+		desc = bytecode.ErrVariant
+		kind := "int"
+		if op == bytecode.OpParseFloat {
+			kind = "float"
+		}
+		v = bytecode.Value{Kind: bytecode.ValString, S: fmt.Sprintf("cannot parse %q as %s", s, kind)}
+	}
+	return bytecode.Value{Kind: bytecode.ValObject, Obj: vm.newVariant(desc, []bytecode.Value{v})}
+}
@@ -9,32 +9,106 @@ import (
 )
 
 type Checker struct {
-	errs []error
+	errs  []error
+	warns []*Warning
 
 	global *Scope
 	scope  *Scope
 
 	inFn    bool
 	fnRetTy Type
+	// inGen is set while checking a gen fn, which yields genElem values.
+	inGen   bool
+	genElem Type
+
+	nonNull facts
+
+	// enums maps each declared enum to the names of its variants, and
+	// enumParams to its type parameters.
+	enums      map[string][]string
+	enumParams map[string][]TypeParam
+	// bounds are the type parameters in scope.
+	bounds map[string]Bound
+
+	// traits maps each declared trait to its methods. impls and methods
+	// map the name of each type with impls to the traits it implements
+	// and to the functions of its methods.
+	traits  map[string][]Symbol
+	impls   map[string]map[string]bool
+	methods map[string]map[string]Symbol
 
 	ExprType map[ast.Expr]Type
 }
 
 func New() *Checker {
 	g := NewScope(nil)
+	// Error is the enum of the errors a catch receives: the message and
+	// the source line of the failure.
+	g.Declare(Symbol{Kind: SymVariant, Name: "Error", Params: []Type{T(bytecode.TypeString), T(bytecode.TypeInt)}, Ret: Enum("Error")})
 	return &Checker{
-		global:   g,
-		scope:    g,
-		ExprType: make(map[ast.Expr]Type),
+		global:     g,
+		scope:      g,
+		nonNull:    make(facts),
+		enums:      map[string][]string{"Error": {"Error"}},
+		enumParams: make(map[string][]TypeParam),
+		traits:     make(map[string][]Symbol),
+		impls:      make(map[string]map[string]bool),
+		methods:    make(map[string]map[string]Symbol),
+		ExprType:   make(map[ast.Expr]Type),
 	}
 }
 
 func (c *Checker) Errors() []error { return c.errs }
 
+// Warnings returns what Check found suspect in a program it accepts: code
+// that is never run, a fn that may end without returning its value and a
+// variable that may be used before it has one.
+func (c *Checker) Warnings() []*Warning { return c.warns }
+
 func (c *Checker) Check(prog *ast.Program) {
+	// Enum and trait names are known before any type is resolved, so
+	// declarations can refer to types declared after them.
+	var enums []*ast.EnumDecl
+	var traits []*ast.TraitDecl
 	for _, it := range prog.Items {
-		if fn, ok := it.(*ast.FnDecl); ok {
-			c.declareFn(fn)
+		switch n := it.(type) {
+		case *ast.EnumDecl:
+			if c.isType(n.Name) {
+				c.errorf(n.EnumPos, "redeclaration of type %q", n.Name)
+				continue
+			}
+			c.enums[n.Name] = nil
+			enums = append(enums, n)
+		case *ast.TraitDecl:
+			if c.isType(n.Name) {
+				c.errorf(n.TraitPos, "redeclaration of type %q", n.Name)
+				continue
+			}
+			c.traits[n.Name] = nil
+			traits = append(traits, n)
+		}
+	}
+	for _, en := range enums {
+		c.enumParams[en.Name] = c.typeParams(en.TypeParams)
+	}
+	for _, en := range enums {
+		c.declareEnum(en)
+	}
+	for _, tr := range traits {
+		c.declareTrait(tr)
+	}
+	implFns := make(map[*ast.ImplDecl][]*ast.FnDecl)
+	for _, it := range prog.Items {
+		switch n := it.(type) {
+		case *ast.FnDecl:
+			c.declareFn(n)
+		case *ast.ImplDecl:
+			implFns[n] = n.Fns()
+		}
+	}
+	for _, it := range prog.Items {
+		if im, ok := it.(*ast.ImplDecl); ok {
+			c.declareImpl(im, implFns[im])
 		}
 	}
 
@@ -42,13 +116,96 @@ func (c *Checker) Check(prog *ast.Program) {
 		switch n := it.(type) {
 		case *ast.FnDecl:
 			c.checkFn(n)
+		case *ast.ImplDecl:
+			for _, fn := range implFns[n] {
+				c.checkFn(fn)
+			}
 		case *ast.StmtItem:
 			c.checkStmt(n.S)
 		}
 	}
 }
 
+// isType reports whether name already names a type.
+func (c *Checker) isType(name string) bool {
+	_, isEnum := c.enums[name]
+	_, isTrait := c.traits[name]
+	return isEnum || isTrait || isTypeName(name)
+}
+
+var builtins = map[string]bool{
+	"print": true, "println": true, "eprintln": true, "read_line": true, "read_int": true,
+	"array": true, "get": true, "set": true, "next": true,
+	"send": true, "recv": true, "close": true, "wait": true,
+	"ok": true, "err": true, "parse_int": true, "parse_float": true,
+	"float": true, "panic": true,
+}
+
+// IsBuiltin reports whether name is a function built into the language.
+func IsBuiltin(name string) bool { return builtins[name] }
+
+// DeclareNative declares a function implemented outside the program. It
+// must be called before Check.
+func (c *Checker) DeclareNative(name string, params []Type, ret Type) error {
+	if IsBuiltin(name) {
+		return fmt.Errorf("native %q shadows a builtin", name)
+	}
+	if !c.global.Declare(Symbol{Kind: SymFn, Name: name, Params: params, Ret: ret, Native: true}) {
+		return fmt.Errorf("native %q declared twice", name)
+	}
+	return nil
+}
+
+// FuncType returns the parameter and result types of a declared function.
+func (c *Checker) FuncType(name string) (params []Type, ret Type, ok bool) {
+	sym, ok := c.global.Lookup(name)
+	if !ok || sym.Kind != SymFn {
+		return nil, Type{}, false
+	}
+	return sym.Params, sym.Ret, true
+}
+
+// TypeParams returns the type parameters of the generic fn name.
+func (c *Checker) TypeParams(name string) []TypeParam {
+	sym, ok := c.global.Lookup(name)
+	if !ok || sym.Kind != SymFn {
+		return nil
+	}
+	return sym.TypeParams
+}
+
+func (c *Checker) declareEnum(en *ast.EnumDecl) {
+	tps := c.enumParams[en.Name]
+	old := c.enter(tps)
+	defer func() { c.bounds = old }()
+
+	var args []Type
+	for _, tp := range tps {
+		args = append(args, Param(tp.Name))
+	}
+	ty := Enum(en.Name, args...)
+	for _, v := range en.Variants {
+		var fields []Type
+		for i := range v.Fields {
+			ft := c.typeFromRef(&v.Fields[i])
+			if ft.Kind == bytecode.TypeVoid || ft.Kind == bytecode.TypeNull {
+				c.errorf(v.Fields[i].Pos, "variant %q cannot carry %s", v.Name, ft)
+			}
+			fields = append(fields, ft)
+		}
+		if IsBuiltin(v.Name) || !c.global.Declare(Symbol{Kind: SymVariant, Name: v.Name, Pos: v.Pos, Params: fields, Ret: ty, TypeParams: tps}) {
+			c.errorf(v.Pos, "redeclaration of %q", v.Name)
+			continue
+		}
+		c.enums[en.Name] = append(c.enums[en.Name], v.Name)
+	}
+}
+
 func (c *Checker) declareFn(fn *ast.FnDecl) {
+	tps := c.typeParams(fn.TypeParams)
+	old := c.enter(tps)
+	defer func() { c.bounds = old }()
+
 	var params []Type
 	for _, p := range fn.Params {
 		params = append(params, c.typeFromRef(&p.Type))
@@ -57,13 +214,21 @@ func (c *Checker) declareFn(fn *ast.FnDecl) {
 	if fn.RetType != nil {
 		ret = c.typeFromRef(fn.RetType)
 	}
+	if fn.IsGen {
+		if ret.Kind == bytecode.TypeVoid {
+			c.errorf(fn.FnPos, "gen fn %q must declare the type it yields", fn.Name)
+		}
+		ret = Iter(ret)
+	}
 
 	if !c.global.Declare(Symbol{
-		Kind:   SymFn,
-		Name:   fn.Name,
-		Pos:    fn.FnPos,
-		Params: params,
-		Ret:    ret,
+		Kind:       SymFn,
+		Name:       fn.Name,
+		Pos:        fn.FnPos,
+		Params:     params,
+		Ret:        ret,
+		Gen:        fn.IsGen,
+		TypeParams: tps,
 	}) {
 		c.errorf(fn.FnPos, "redeclaration of function %q", fn.Name)
 	}
@@ -71,12 +236,22 @@ func (c *Checker) declareFn(fn *ast.FnDecl) {
 
 func (c *Checker) checkFn(fn *ast.FnDecl) {
 	sym, _ := c.global.Lookup(fn.Name)
+	oldBounds := c.enter(sym.TypeParams)
+	defer func() { c.bounds = oldBounds }()
 
 	oldScope := c.scope
 	c.scope = NewScope(c.global)
+	c.nonNull = make(facts)
 
 	oldInFn, oldRet := c.inFn, c.fnRetTy
 	c.inFn, c.fnRetTy = true, sym.Ret
+	c.inGen = fn.IsGen
+	if fn.IsGen {
+		c.fnRetTy = T(bytecode.TypeVoid)
+		if sym.Ret.Elem != nil {
+			c.genElem = *sym.Ret.Elem
+		}
+	}
 
 	for i, p := range fn.Params {
 		pt := sym.Params[i]
@@ -86,8 +261,10 @@ func (c *Checker) checkFn(fn *ast.FnDecl) {
 	}
 
 	c.checkBlock(fn.Body)
+	c.checkFlow(fn, c.fnRetTy)
 
 	c.inFn, c.fnRetTy = oldInFn, oldRet
+	c.inGen = false
 	c.scope = oldScope
 }
 
@@ -122,6 +299,14 @@ func (c *Checker) checkStmt(s ast.Stmt) {
 		c.checkWhile(n)
 	case *ast.ForStmt:
 		c.checkFor(n)
+	case *ast.ForInStmt:
+		c.checkForIn(n)
+	case *ast.YieldStmt:
+		c.checkYield(n)
+	case *ast.SpawnStmt:
+		c.checkSpawn(n)
+	case *ast.TryStmt:
+		c.checkTry(n)
 	case *ast.ExprStmt:
 		_ = c.checkExpr(n.X)
 	default:
@@ -130,27 +315,48 @@ func (c *Checker) checkStmt(s ast.Stmt) {
 }
 
 func (c *Checker) checkLet(s *ast.LetStmt) {
+	var annot Type
+	if s.Type != nil {
+		annot = c.typeFromRef(s.Type)
+	}
 	var initTy Type = T(bytecode.TypeVoid)
 	if s.Init != nil {
-		initTy = c.checkExpr(s.Init)
+		initTy = c.checkExprAs(s.Init, annot)
 	}
 
 	declTy := initTy
 	if s.Type != nil {
-		declTy = c.typeFromRef(s.Type)
+		declTy = annot
 	}
 
-	if declTy.Kind == bytecode.TypeVoid {
+	switch {
+	case declTy.Kind == bytecode.TypeVoid && s.Init != nil:
+		c.errorf(s.LetPos, "cannot initialize %q with a value of type void", s.Name)
+		declTy = T(bytecode.TypeInvalid)
+	case declTy.Kind == bytecode.TypeVoid:
 		c.errorf(s.LetPos, "let %q requires type or initializer", s.Name)
 		declTy = T(bytecode.TypeInvalid)
+	case declTy.Kind == bytecode.TypeNull:
+		c.errorf(s.LetPos, "cannot tell the type of %q from null; add a type annotation", s.Name)
+		declTy = T(bytecode.TypeInvalid)
+	case declTy.IsOpen():
+		c.errorf(s.LetPos, "cannot tell the type of %q from %s; add a type annotation", s.Name, declTy)
+		declTy = T(bytecode.TypeInvalid)
+	}
+	if s.Type == nil && declTy.Kind != bytecode.TypeInvalid {
+		s.Inferred = typeRef(declTy, s.LetPos)
 	}
 
-	if s.Init != nil && !c.assignable(declTy, initTy) && initTy.Kind != bytecode.TypeInvalid {
+	if s.Init != nil && !c.assignable(declTy, initTy) && initTy.Kind != bytecode.TypeInvalid && declTy.Kind != bytecode.TypeInvalid {
 		c.errorf(s.LetPos, "cannot assign %s to %s", initTy, declTy)
 	}
 
 	if !c.scope.Declare(Symbol{Kind: SymVar, Name: s.Name, Pos: s.LetPos, Ty: declTy}) {
 		c.errorf(s.LetPos, "redeclaration of variable %q", s.Name)
+		return
+	}
+	if declTy.IsNullable() && s.Init != nil {
+		c.track(varKey{scope: c.scope, name: s.Name}, initTy)
 	}
 }
 
@@ -161,10 +367,13 @@ func (c *Checker) checkAssign(s *ast.AssignStmt) {
 		_ = c.checkExpr(s.Value)
 		return
 	}
-	vty := c.checkExpr(s.Value)
+	vty := c.checkExprAs(s.Value, sym.Ty)
 	if !c.assignable(sym.Ty, vty) && vty.Kind != bytecode.TypeInvalid {
 		c.errorf(s.NamePos, "cannot assign %s to %s", vty, sym.Ty)
 	}
+	if k, ok := c.nullableVar(&ast.VarRef{NamePos: s.NamePos, Name: s.Name}); ok {
+		c.track(k, vty)
+	}
 }
 
 func (c *Checker) checkReturn(s *ast.ReturnStmt) {
@@ -178,7 +387,11 @@ func (c *Checker) checkReturn(s *ast.ReturnStmt) {
 
 	retTy := T(bytecode.TypeVoid)
 	if s.Value != nil {
-		retTy = c.checkExpr(s.Value)
+		retTy = c.checkExprAs(s.Value, c.fnRetTy)
+		if c.inGen {
+			c.errorf(s.RetPos, "gen fn cannot return a value")
+			return
+		}
 	}
 
 	if !c.assignable(c.fnRetTy, retTy) && retTy.Kind != bytecode.TypeInvalid {
@@ -186,23 +399,51 @@ func (c *Checker) checkReturn(s *ast.ReturnStmt) {
 	}
 }
 
+// checkIfStmt narrows the variables the condition tests against null in
+// each branch. Past the if, what both branches that fall through know
+// still holds.
 func (c *Checker) checkIfStmt(s *ast.IfStmt) {
 	cty := c.checkExpr(s.Cond)
 	if cty.Kind != bytecode.TypeBool && cty.Kind != bytecode.TypeInvalid {
 		c.errorf(s.IfPos, "if condition must be bool, got %s", cty)
 	}
+	onTrue, onFalse := c.condFacts(s.Cond)
+	entry := c.nonNull
+
+	c.nonNull = entry.clone()
+	c.narrow(onTrue)
 	c.checkBlock(s.Then)
+	thenOut := c.nonNull
+
+	c.nonNull = entry.clone()
+	c.narrow(onFalse)
 	if s.Else != nil {
 		c.checkStmt(s.Else)
 	}
+	elseOut := c.nonNull
+
+	switch {
+	case alwaysReturns(s.Then):
+		c.nonNull = elseOut
+	case s.Else != nil && alwaysReturns(s.Else):
+		c.nonNull = thenOut
+	default:
+		c.nonNull = thenOut.intersect(elseOut)
+	}
 }
 
 func (c *Checker) checkWhile(s *ast.WhileStmt) {
+	c.forget(s)
 	cty := c.checkExpr(s.Cond)
 	if cty.Kind != bytecode.TypeBool && cty.Kind != bytecode.TypeInvalid {
 		c.errorf(s.WhilePos, "while condition must be bool, got %s", cty)
 	}
+	onTrue, onFalse := c.condFacts(s.Cond)
+	entry := c.nonNull.clone()
+	c.narrow(onTrue)
 	c.checkBlock(s.Body)
+	c.nonNull = entry
+	c.narrow(onFalse)
 }
 
 func (c *Checker) checkFor(s *ast.ForStmt) {
@@ -212,18 +453,101 @@ func (c *Checker) checkFor(s *ast.ForStmt) {
 	if s.Init != nil {
 		c.checkStmt(s.Init)
 	}
+	c.forget(s.Body)
+	if s.Post != nil {
+		c.forget(s.Post)
+	}
+	var onTrue, onFalse []varKey
 	if s.Cond != nil {
 		cty := c.checkExpr(s.Cond)
 		if cty.Kind != bytecode.TypeBool && cty.Kind != bytecode.TypeInvalid {
 			c.errorf(s.ForPos, "for condition must be bool, got %s", cty)
 		}
+		onTrue, onFalse = c.condFacts(s.Cond)
 	}
+	entry := c.nonNull.clone()
+	c.narrow(onTrue)
+	c.checkBlock(s.Body)
 	if s.Post != nil {
 		c.checkStmt(s.Post)
 	}
+	c.nonNull = entry
+	c.narrow(onFalse)
+
+	c.scope = old
+}
+
+func (c *Checker) checkForIn(s *ast.ForInStmt) {
+	ity := c.checkExpr(s.Iter)
+	elem := T(bytecode.TypeInvalid)
+	if (ity.Kind == bytecode.TypeIter || ity.Kind == bytecode.TypeChan) && ity.Elem != nil {
+		elem = *ity.Elem
+	} else if ity.Kind != bytecode.TypeInvalid {
+		c.errorf(s.Iter.Pos(), "for-in expects an iter or a chan, got %s", ity)
+	}
+
+	c.forget(s.Body)
+	entry := c.nonNull.clone()
+	old := c.scope
+	c.scope = NewScope(old)
+	c.scope.Declare(Symbol{Kind: SymVar, Name: s.Var, Pos: s.VarPos, Ty: elem})
 	c.checkBlock(s.Body)
+	c.scope = old
+	c.nonNull = entry
+}
 
+func (c *Checker) checkYield(s *ast.YieldStmt) {
+	vty := c.checkExpr(s.Value)
+	if !c.inGen {
+		c.errorf(s.YieldPos, "yield outside gen fn")
+		return
+	}
+	if !c.assignable(c.genElem, vty) && vty.Kind != bytecode.TypeInvalid {
+		c.errorf(s.YieldPos, "cannot yield %s from gen fn yielding %s", vty, c.genElem)
+	}
+}
+
+// checkTry checks the catch block knowing only what held before the try
+// block, which may fail anywhere.
+func (c *Checker) checkTry(s *ast.TryStmt) {
+	entry := c.nonNull.clone()
+	c.checkBlock(s.Body)
+	bodyOut := c.nonNull
+
+	c.nonNull = entry
+	c.forget(s.Body)
+	old := c.scope
+	c.scope = NewScope(old)
+	c.scope.Declare(Symbol{Kind: SymVar, Name: s.Var, Pos: s.VarPos, Ty: Enum("Error")})
+	c.checkBlock(s.Catch)
 	c.scope = old
+	catchOut := c.nonNull
+
+	switch {
+	case alwaysReturns(s.Body):
+		c.nonNull = catchOut
+	case alwaysReturns(s.Catch):
+		c.nonNull = bodyOut
+	default:
+		c.nonNull = bodyOut.intersect(catchOut)
+	}
+}
+
+// checkSpawn checks s.Call as an ordinary call to a function of the
+// program; its result, if any, is dropped.
+func (c *Checker) checkSpawn(s *ast.SpawnStmt) {
+	if vr, ok := s.Call.Callee.(*ast.VarRef); ok {
+		sym, found := c.scope.Lookup(vr.Name)
+		switch {
+		case IsBuiltin(vr.Name) || found && sym.Native:
+			c.errorf(vr.NamePos, "cannot spawn builtin or native function %q", vr.Name)
+		case found && sym.Gen:
+			c.errorf(vr.NamePos, "cannot spawn gen fn %q", vr.Name)
+		case found && sym.Kind == SymVariant:
+			c.errorf(vr.NamePos, "cannot spawn variant %q", vr.Name)
+		}
+	}
+	_ = c.checkCall(s.Call)
 }
 
 func (c *Checker) checkExpr(e ast.Expr) Type {
@@ -250,8 +574,17 @@ func (c *Checker) checkExpr(e ast.Expr) Type {
 			ty = T(bytecode.TypeInvalid)
 		} else if sym.Kind == SymFn {
 			ty = T(bytecode.TypeInvalid)
+		} else if sym.Kind == SymVariant {
+			ty = instantiate(sym, nil, Hole()).Ret
+			if len(sym.Params) != 0 {
+				c.errorf(n.NamePos, "variant %q carries %d values", n.Name, len(sym.Params))
+				ty = T(bytecode.TypeInvalid)
+			}
 		} else {
 			ty = sym.Ty
+			if k, ok := c.nullableVar(n); ok && c.nonNull[k] {
+				ty = ty.NonNull()
+			}
 		}
 
 	case *ast.UnaryExpr:
@@ -263,6 +596,9 @@ func (c *Checker) checkExpr(e ast.Expr) Type {
 	case *ast.CallExpr:
 		ty = c.checkCall(n)
 
+	case *ast.MethodCallExpr:
+		ty = c.checkMethodCall(n)
+
 	case *ast.ArrayLit:
 		ty = c.checkArrayLit(n)
 
@@ -275,6 +611,15 @@ func (c *Checker) checkExpr(e ast.Expr) Type {
 	case *ast.IfExpr:
 		ty = c.checkIfExpr(n)
 
+	case *ast.ChanExpr:
+		ty = c.checkChanExpr(n)
+
+	case *ast.MatchExpr:
+		ty = c.checkMatch(n)
+
+	case *ast.PropagateExpr:
+		ty = c.checkPropagate(n)
+
 	default:
 		c.errorf(e.Pos(), "unknown expr")
 		ty = T(bytecode.TypeInvalid)
@@ -307,18 +652,21 @@ func (c *Checker) checkIfExpr(e *ast.IfExpr) Type {
 		c.errorf(e.IfPos, "if condition must be bool, got %s", cty)
 	}
 
+	onTrue, onFalse := c.condFacts(e.Cond)
+	entry := c.nonNull
+
+	c.nonNull = entry.clone()
+	c.narrow(onTrue)
 	thenTy := c.checkBlockExpr(e.Then)
-	elseTy := c.checkExpr(e.Else)
+	thenOut := c.nonNull
 
-	if thenTy.Equal(elseTy) {
-		return thenTy
-	}
+	c.nonNull = entry.clone()
+	c.narrow(onFalse)
+	elseTy := c.checkExpr(e.Else)
+	c.nonNull = thenOut.intersect(c.nonNull)
 
-	if thenTy.Kind == bytecode.TypeNull && IsRefType(elseTy) {
-		return elseTy
-	}
-	if elseTy.Kind == bytecode.TypeNull && IsRefType(thenTy) {
-		return thenTy
+	if t, ok := join(thenTy, elseTy); ok {
+		return t
 	}
 
 	if thenTy.Kind == bytecode.TypeVoid || elseTy.Kind == bytecode.TypeVoid {
@@ -335,7 +683,7 @@ func (c *Checker) checkUnary(u *ast.UnaryExpr) Type {
 
 	switch u.Op {
 	case token.MINUS:
-		if xt.Kind != bytecode.TypeInt && xt.Kind != bytecode.TypeFloat && xt.Kind != bytecode.TypeInvalid {
+		if xt.Kind != bytecode.TypeInt && xt.Kind != bytecode.TypeFloat && xt.Kind != bytecode.TypeInvalid && !c.bounded(xt, BoundNum) {
 			c.errorf(u.OpPos, "unary '-' expects int/float, got %s", xt)
 			return T(bytecode.TypeInvalid)
 		}
@@ -354,7 +702,22 @@ func (c *Checker) checkUnary(u *ast.UnaryExpr) Type {
 
 func (c *Checker) checkBinary(b *ast.BinaryExpr) Type {
 	lt := c.checkExpr(b.L)
-	rt := c.checkExpr(b.R)
+	var rt Type
+	if b.Op == token.AND || b.Op == token.OR {
+		// The right operand only runs once the left one is true (&&) or
+		// false (||).
+		onTrue, onFalse := c.condFacts(b.L)
+		entry := c.nonNull.clone()
+		if b.Op == token.AND {
+			c.narrow(onTrue)
+		} else {
+			c.narrow(onFalse)
+		}
+		rt = c.checkExpr(b.R)
+		c.nonNull = entry
+	} else {
+		rt = c.checkExpr(b.R)
+	}
 
 	if lt.Kind == bytecode.TypeInvalid || rt.Kind == bytecode.TypeInvalid {
 		return T(bytecode.TypeInvalid)
@@ -362,7 +725,7 @@ func (c *Checker) checkBinary(b *ast.BinaryExpr) Type {
 
 	switch b.Op {
 	case token.PLUS, token.MINUS, token.STAR, token.SLASH:
-		if (lt.Kind == bytecode.TypeInt || lt.Kind == bytecode.TypeFloat) && lt.Kind == rt.Kind {
+		if (lt.Kind == bytecode.TypeInt || lt.Kind == bytecode.TypeFloat) && lt.Kind == rt.Kind || c.bounded(lt, BoundNum) && lt.Equal(rt) {
 			return lt
 		}
 		c.errorf(b.OpPos, "arithmetic expects same numeric types, got %s,%s", lt, rt)
@@ -376,25 +739,50 @@ func (c *Checker) checkBinary(b *ast.BinaryExpr) Type {
 		return T(bytecode.TypeInvalid)
 
 	case token.LT, token.LTE, token.GT, token.GTE:
-		if (lt.Kind == bytecode.TypeInt || lt.Kind == bytecode.TypeFloat || lt.Kind == bytecode.TypeChar) && lt.Kind == rt.Kind {
+		if (lt.Kind == bytecode.TypeInt || lt.Kind == bytecode.TypeFloat || lt.Kind == bytecode.TypeChar) && lt.Kind == rt.Kind ||
+			c.bounded(lt, BoundOrd) && lt.Equal(rt) {
 			return T(bytecode.TypeBool)
 		}
 		c.errorf(b.OpPos, "comparison expects same comparable types, got %s,%s", lt, rt)
 		return T(bytecode.TypeInvalid)
 
 	case token.EQ, token.NEQ:
-		if lt.Equal(rt) {
+		switch lk, rk := lt.NonNull().Kind, rt.NonNull().Kind; {
+		case lk == rk && lk == bytecode.TypeTrait:
+			c.errorf(b.OpPos, "cannot compare %s values for equality", lt.NonNull())
+			return T(bytecode.TypeInvalid)
+		case lk == rk && (lk == bytecode.TypeEnum || lk == bytecode.TypeResult):
+			c.errorf(b.OpPos, "cannot compare %s values for equality; match on them instead", lt.NonNull())
+			return T(bytecode.TypeInvalid)
+		}
+		if u := lt.NonNull(); u.Kind == bytecode.TypeParam && !c.satisfies(u, BoundEq) {
+			c.errorf(b.OpPos, "cannot compare %s values for equality; bound %s by Eq", u, u)
+			return T(bytecode.TypeInvalid)
+		}
+		if lt.NonNull().Equal(rt.NonNull()) {
 			return T(bytecode.TypeBool)
 		}
-		if lt.Kind == bytecode.TypeNull && IsRefType(rt) {
+		if lt.Kind == bytecode.TypeNull && (rt.IsNullable() || IsRefType(rt)) {
 			return T(bytecode.TypeBool)
 		}
-		if rt.Kind == bytecode.TypeNull && IsRefType(lt) {
+		if rt.Kind == bytecode.TypeNull && (lt.IsNullable() || IsRefType(lt)) {
 			return T(bytecode.TypeBool)
 		}
 		c.errorf(b.OpPos, "equality expects same types (or null with ref), got %s,%s", lt, rt)
 		return T(bytecode.TypeInvalid)
 
+	case token.QQ:
+		switch {
+		case lt.Kind == bytecode.TypeNull:
+			return rt
+		case c.assignable(lt.NonNull(), rt):
+			return lt.NonNull()
+		case c.assignable(lt, rt):
+			return lt
+		}
+		c.errorf(b.OpPos, "?? operands have different types: %s,%s", lt, rt)
+		return T(bytecode.TypeInvalid)
+
 	case token.AND, token.OR:
 		if lt.Kind == bytecode.TypeBool && rt.Kind == bytecode.TypeBool {
 			return T(bytecode.TypeBool)
@@ -461,6 +849,113 @@ func (c *Checker) checkCall(call *ast.CallExpr) Type {
 
 		return T(bytecode.TypeVoid)
 
+	case "eprintln":
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+			return T(bytecode.TypeVoid)
+		}
+		if argTy := c.checkExpr(call.Args[0]); argTy.Kind == bytecode.TypeVoid {
+			c.errorf(call.Args[0].Pos(), "cannot print void")
+			return T(bytecode.TypeInvalid)
+		}
+		return T(bytecode.TypeVoid)
+
+	case "panic":
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+			return T(bytecode.TypeVoid)
+		}
+		if at := c.checkExpr(call.Args[0]); at.Kind != bytecode.TypeString && at.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[0].Pos(), "panic(msg): msg must be string, got %s", at)
+		}
+		return T(bytecode.TypeVoid)
+
+	case "float":
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+		} else if at := c.checkExpr(call.Args[0]); at.Kind != bytecode.TypeInt && at.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[0].Pos(), "float(n): n must be int, got %s", at)
+		}
+		return T(bytecode.TypeFloat)
+
+	case "read_line", "read_int":
+		if len(call.Args) != 0 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 0, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+		}
+		if vr.Name == "read_line" {
+			return T(bytecode.TypeString)
+		}
+		return T(bytecode.TypeInt)
+
+	case "next":
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+			return T(bytecode.TypeInvalid)
+		}
+		ity := c.checkExpr(call.Args[0])
+		if ity.Kind == bytecode.TypeIter && ity.Elem != nil {
+			return *ity.Elem
+		}
+		if ity.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[0].Pos(), "next(it): it must be an iter, got %s", ity)
+		}
+		return T(bytecode.TypeInvalid)
+
+	case "send", "recv", "close", "wait":
+		return c.checkChanCall(call, vr.Name)
+
+	case "ok", "err":
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+			return T(bytecode.TypeInvalid)
+		}
+		at := c.checkExpr(call.Args[0])
+		if at.Kind == bytecode.TypeVoid {
+			c.errorf(call.Args[0].Pos(), "%s cannot hold void", vr.Name)
+			return T(bytecode.TypeInvalid)
+		}
+		if at.Kind == bytecode.TypeInvalid {
+			return at
+		}
+		if vr.Name == "ok" {
+			return Result(&at, nil)
+		}
+		return Result(nil, &at)
+
+	case "parse_int", "parse_float":
+		str := T(bytecode.TypeString)
+		elem := T(bytecode.TypeInt)
+		if vr.Name == "parse_float" {
+			elem = T(bytecode.TypeFloat)
+		}
+		if len(call.Args) != 1 {
+			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 1, len(call.Args))
+			for _, a := range call.Args {
+				_ = c.checkExpr(a)
+			}
+		} else if at := c.checkExpr(call.Args[0]); at.Kind != bytecode.TypeString && at.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[0].Pos(), "%s(s): s must be string, got %s", vr.Name, at)
+		}
+		return Result(&elem, &str)
+
 	case "get":
 		if len(call.Args) != 2 {
 			c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, 2, len(call.Args))
@@ -494,25 +989,28 @@ func (c *Checker) checkCall(call *ast.CallExpr) Type {
 
 		tArr := c.checkExpr(call.Args[0])
 		tIdx := c.checkExpr(call.Args[1])
-		tVal := c.checkExpr(call.Args[2])
 
-		if !tArr.IsArray() || tArr.Elem == nil || tArr.Elem.Kind != bytecode.TypeInt {
-			c.errorf(call.Args[0].Pos(), "set(arr, i, v): arr must be []int, got %s", tArr)
+		if !tArr.IsArray() || tArr.Elem == nil {
+			_ = c.checkExpr(call.Args[2])
+			if tArr.Kind != bytecode.TypeInvalid {
+				c.errorf(call.Args[0].Pos(), "set(arr, i, v): arr must be an array, got %s", tArr)
+			}
 			return T(bytecode.TypeInvalid)
 		}
+		tVal := c.checkExprAs(call.Args[2], *tArr.Elem)
 		if tIdx.Kind != bytecode.TypeInt && tIdx.Kind != bytecode.TypeInvalid {
 			c.errorf(call.Args[1].Pos(), "set(arr, i, v): i must be int, got %s", tIdx)
 			return T(bytecode.TypeInvalid)
 		}
-		if tVal.Kind != bytecode.TypeInt && tVal.Kind != bytecode.TypeInvalid {
-			c.errorf(call.Args[2].Pos(), "set(arr, i, v): v must be int, got %s", tVal)
+		if !c.assignable(*tArr.Elem, tVal) && tVal.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[2].Pos(), "set(arr, i, v): v must be %s, got %s", *tArr.Elem, tVal)
 			return T(bytecode.TypeInvalid)
 		}
 		return T(bytecode.TypeVoid)
 	}
 
 	sym, ok := c.scope.Lookup(vr.Name)
-	if !ok || sym.Kind != SymFn {
+	if !ok || sym.Kind == SymVar {
 		c.errorf(vr.NamePos, "undefined function %q", vr.Name)
 		for _, a := range call.Args {
 			_ = c.checkExpr(a)
@@ -521,16 +1019,23 @@ func (c *Checker) checkCall(call *ast.CallExpr) Type {
 	}
 
 	if len(call.Args) != len(sym.Params) {
-		c.errorf(call.Pos(), "function %q expects %d args, got %d", vr.Name, len(sym.Params), len(call.Args))
+		what := "function"
+		if sym.Kind == SymVariant {
+			what = "variant"
+		}
+		c.errorf(call.Pos(), "%s %q expects %d args, got %d", what, vr.Name, len(sym.Params), len(call.Args))
 		for _, a := range call.Args {
 			_ = c.checkExpr(a)
 		}
-		return sym.Ret
+		return instantiate(sym, nil, T(bytecode.TypeInvalid)).Ret
+	}
+	if len(sym.TypeParams) != 0 {
+		return c.checkGenericCall(call, vr.Name, sym)
 	}
 
 	for i, a := range call.Args {
-		at := c.checkExpr(a)
 		pt := sym.Params[i]
+		at := c.checkExprAs(a, pt)
 		if !c.assignable(pt, at) && at.Kind != bytecode.TypeInvalid {
 			c.errorf(a.Pos(), "arg %d: expected %s, got %s", i, pt, at)
 		}
@@ -539,6 +1044,89 @@ func (c *Checker) checkCall(call *ast.CallExpr) Type {
 	return sym.Ret
 }
 
+// checkPropagate types x? as the ok value of x, checking that the
+// function x's errors return from can return them.
+func (c *Checker) checkPropagate(e *ast.PropagateExpr) Type {
+	xt := c.checkExpr(e.X)
+	if xt.Kind == bytecode.TypeInvalid {
+		return xt
+	}
+	if xt.Kind != bytecode.TypeResult {
+		c.errorf(e.QPos, "? expects a Result, got %s", xt)
+		return T(bytecode.TypeInvalid)
+	}
+	switch {
+	case !c.inFn || c.inGen:
+		c.errorf(e.QPos, "? outside a function returning a Result")
+	case c.fnRetTy.Kind != bytecode.TypeResult:
+		c.errorf(e.QPos, "? in a function returning %s, not a Result", c.fnRetTy)
+	case xt.Err != nil && c.fnRetTy.Err != nil && !c.assignable(*c.fnRetTy.Err, *xt.Err):
+		c.errorf(e.QPos, "? cannot return error %s from a function returning %s", *xt.Err, c.fnRetTy)
+	}
+	if xt.Elem == nil {
+		return T(bytecode.TypeInvalid)
+	}
+	return *xt.Elem
+}
+
+func (c *Checker) checkChanExpr(e *ast.ChanExpr) Type {
+	elem := c.typeFromRef(e.Elem)
+	if elem.Kind == bytecode.TypeVoid || elem.Kind == bytecode.TypeNull {
+		c.errorf(e.Elem.Pos, "chan cannot carry %s", elem)
+	}
+	if e.Cap != nil {
+		t := c.checkExpr(e.Cap)
+		if t.Kind != bytecode.TypeInt && t.Kind != bytecode.TypeInvalid {
+			c.errorf(e.Cap.Pos(), "chan capacity must be int, got %s", t)
+		}
+	}
+	return Chan(elem)
+}
+
+// checkChanCall checks the builtins that work on channels and tasks.
+func (c *Checker) checkChanCall(call *ast.CallExpr, name string) Type {
+	want := 1
+	switch name {
+	case "send":
+		want = 2
+	case "wait":
+		want = 0
+	}
+	ret := T(bytecode.TypeVoid)
+	if len(call.Args) != want {
+		c.errorf(call.Pos(), "function %q expects %d args, got %d", name, want, len(call.Args))
+		for _, a := range call.Args {
+			_ = c.checkExpr(a)
+		}
+		if name == "recv" {
+			ret = T(bytecode.TypeInvalid)
+		}
+		return ret
+	}
+	if name == "wait" {
+		return ret
+	}
+
+	cty := c.checkExpr(call.Args[0])
+	elem := T(bytecode.TypeInvalid)
+	if cty.Kind == bytecode.TypeChan && cty.Elem != nil {
+		elem = *cty.Elem
+	} else if cty.Kind != bytecode.TypeInvalid {
+		c.errorf(call.Args[0].Pos(), "%s: first argument must be a chan, got %s", name, cty)
+	}
+
+	switch name {
+	case "send":
+		vty := c.checkExpr(call.Args[1])
+		if elem.Kind != bytecode.TypeInvalid && vty.Kind != bytecode.TypeInvalid && !c.assignable(elem, vty) {
+			c.errorf(call.Args[1].Pos(), "cannot send %s on %s", vty, cty)
+		}
+	case "recv":
+		ret = elem
+	}
+	return ret
+}
+
 func (c *Checker) checkArrayLit(a *ast.ArrayLit) Type {
 	if len(a.Elems) == 0 {
 		c.errorf(a.Lbrack, "empty array literal requires type annotation")
@@ -552,7 +1140,12 @@ func (c *Checker) checkArrayLit(a *ast.ArrayLit) Type {
 
 	for i := 1; i < len(a.Elems); i++ {
 		t := c.checkExpr(a.Elems[i])
-		if !elemTy.Equal(t) && t.Kind != bytecode.TypeInvalid {
+		if t.Kind == bytecode.TypeInvalid {
+			continue
+		}
+		if j, ok := join(elemTy, t); ok {
+			elemTy = j
+		} else {
 			c.errorf(a.Elems[i].Pos(), "array element %d: expected %s, got %s", i, elemTy, t)
 		}
 	}
@@ -573,6 +1166,18 @@ func (c *Checker) checkIndex(ix *ast.IndexExpr) Type {
 		c.errorf(ix.Index.Pos(), "index must be int, got %s", iTy)
 	}
 
+	if ix.Safe && xTy.IsNullable() {
+		if base := xTy.NonNull(); base.Kind == bytecode.TypeArray && base.Elem != nil {
+			return Nullable(*base.Elem)
+		}
+	} else if xTy.IsNullable() {
+		c.errorf(ix.Lbrack, "cannot index %s that may be null; check it against null or use ?.[", xTy)
+		return T(bytecode.TypeInvalid)
+	}
+
+	if xTy.Kind == bytecode.TypeInvalid {
+		return xTy
+	}
 	if xTy.Kind == bytecode.TypeArray {
 		if xTy.Elem == nil {
 			return T(bytecode.TypeInvalid)
@@ -588,16 +1193,64 @@ func (c *Checker) assignable(dst, src Type) bool {
 	if dst.Equal(src) {
 		return true
 	}
-	if src.Kind == bytecode.TypeNull && IsRefType(dst) {
+	if dst.IsNullable() {
+		return src.Kind == bytecode.TypeNull || c.assignable(*dst.Elem, src)
+	}
+	if dst.Kind == bytecode.TypeResult && src.Kind == bytecode.TypeResult {
+		return (src.Elem == nil || dst.Elem != nil && c.assignable(*dst.Elem, *src.Elem)) &&
+			(src.Err == nil || dst.Err != nil && c.assignable(*dst.Err, *src.Err))
+	}
+	if dst.Kind == bytecode.TypeTrait {
+		return c.implements(src, dst.Name)
+	}
+	if dst.Kind == bytecode.TypeEnum && src.Kind == bytecode.TypeEnum && dst.Name == src.Name && len(dst.Args) == len(src.Args) {
+		for i := range dst.Args {
+			if !src.Args[i].IsHole() && !c.assignable(dst.Args[i], src.Args[i]) {
+				return false
+			}
+		}
 		return true
 	}
 	return false
 }
 
+// typeRef spells out t as a type annotation at pos would.
+func typeRef(t Type, pos token.Position) *ast.TypeRef {
+	r := &ast.TypeRef{Name: t.String(), Pos: pos}
+	switch t.Kind {
+	case bytecode.TypeNullable:
+		r = typeRef(*t.Elem, pos)
+		r.Nullable = true
+	case bytecode.TypeArray, bytecode.TypeIter, bytecode.TypeChan:
+		r.Name = t.Kind.String()
+		if t.Elem != nil {
+			r.Elem = typeRef(*t.Elem, pos)
+		}
+	case bytecode.TypeResult:
+		r.Name, r.Elem, r.Err = "Result", typeRef(*t.Elem, pos), typeRef(*t.Err, pos)
+	case bytecode.TypeEnum:
+		r.Name = t.Name
+		for _, a := range t.Args {
+			r.Args = append(r.Args, *typeRef(a, pos))
+		}
+	}
+	return r
+}
+
 func (c *Checker) typeFromRef(r *ast.TypeRef) Type {
 	if r == nil {
 		return T(bytecode.TypeVoid)
 	}
+	if r.Nullable {
+		base := *r
+		base.Nullable = false
+		t := c.typeFromRef(&base)
+		if t.Kind == bytecode.TypeVoid {
+			c.errorf(r.Pos, "void cannot be nullable")
+			return T(bytecode.TypeInvalid)
+		}
+		return Nullable(t)
+	}
 
 	if r.Name == "array" {
 		if r.Elem == nil {
@@ -606,7 +1259,31 @@ func (c *Checker) typeFromRef(r *ast.TypeRef) Type {
 		elem := c.typeFromRef(r.Elem)
 		return Arr(elem)
 	}
+	if r.Name == "iter" {
+		if r.Elem == nil {
+			return Iter(T(bytecode.TypeInvalid))
+		}
+		return Iter(c.typeFromRef(r.Elem))
+	}
+	if r.Name == "chan" {
+		if r.Elem == nil {
+			return Chan(T(bytecode.TypeInvalid))
+		}
+		return Chan(c.typeFromRef(r.Elem))
+	}
+	if r.Name == "Result" && r.Elem != nil && r.Err != nil {
+		elem, err := c.typeFromRef(r.Elem), c.typeFromRef(r.Err)
+		if elem.Kind == bytecode.TypeVoid || err.Kind == bytecode.TypeVoid {
+			c.errorf(r.Pos, "Result cannot hold void")
+			return T(bytecode.TypeInvalid)
+		}
+		return Result(&elem, &err)
+	}
 
+	if _, ok := c.enums[r.Name]; !ok && len(r.Args) != 0 {
+		c.errorf(r.Pos, "%s takes no type arguments", r.Name)
+		return T(bytecode.TypeInvalid)
+	}
 	switch r.Name {
 	case "int":
 		return T(bytecode.TypeInt)
@@ -620,11 +1297,42 @@ func (c *Checker) typeFromRef(r *ast.TypeRef) Type {
 		return T(bytecode.TypeChar)
 	case "void":
 		return T(bytecode.TypeVoid)
-	default:
-		return T(bytecode.TypeInvalid)
 	}
+	if _, ok := c.enums[r.Name]; ok {
+		return c.enumType(r)
+	}
+	if _, ok := c.traits[r.Name]; ok {
+		return Trait(r.Name)
+	}
+	if _, ok := c.bounds[r.Name]; ok && len(r.Args) == 0 {
+		return Param(r.Name)
+	}
+	c.errorf(r.Pos, "unknown type %q", r.Name)
+	return T(bytecode.TypeInvalid)
+}
+
+func isTypeName(name string) bool {
+	switch name {
+	case "int", "float", "bool", "string", "char", "void", "array", "iter", "chan", "Result":
+		return true
+	}
+	return false
 }
 
 func (c *Checker) errorf(pos token.Position, format string, args ...any) {
 	c.errs = append(c.errs, fmt.Errorf("%d:%d: %s", pos.Line, pos.Col, fmt.Sprintf(format, args...)))
 }
+
+// Warning is a finding of Check that does not make the program wrong.
+// ID names the kind of finding.
+type Warning struct {
+	ID  string
+	Pos token.Position
+	Msg string
+}
+
+func (w *Warning) Error() string { return fmt.Sprintf("%d:%d: %s", w.Pos.Line, w.Pos.Col, w.Msg) }
+
+func (c *Checker) warnf(id string, pos token.Position, format string, args ...any) {
+	c.warns = append(c.warns, &Warning{ID: id, Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,296 @@
+package sema
+
+import (
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// flow is what the control-flow analysis knows at a point of a function:
+// whether control can reach it, and which lets declared without a value
+// may not have been given one yet on some path there.
+type flow struct {
+	live  bool
+	unset map[*ast.LetStmt]bool
+}
+
+func (f flow) clone() flow {
+	out := flow{live: f.live, unset: make(map[*ast.LetStmt]bool, len(f.unset))}
+	for s := range f.unset {
+		out.unset[s] = true
+	}
+	return out
+}
+
+// join is what holds where the paths reaching f and g meet.
+func (f flow) join(g flow) flow {
+	switch {
+	case !f.live:
+		return g
+	case !g.live:
+		return f
+	}
+	out := f.clone()
+	for s := range g.unset {
+		out.unset[s] = true
+	}
+	return out
+}
+
+// flowChecker runs the control-flow analysis of one function body once
+// its types are checked.
+type flowChecker struct {
+	c *Checker
+	f flow
+	// scopes map the names in scope to the lets declaring them, or to nil
+	// for the parameters and the names patterns and loops bind.
+	scopes []map[string]*ast.LetStmt
+	warned map[*ast.LetStmt]bool
+}
+
+// checkFlow warns about the paths through fn that fall off its end
+// without returning a ret, the uses of variables that may not have a
+// value yet and the statements control never reaches.
+func (c *Checker) checkFlow(fn *ast.FnDecl, ret Type) {
+	a := &flowChecker{c: c, f: flow{live: true, unset: make(map[*ast.LetStmt]bool)}, warned: make(map[*ast.LetStmt]bool)}
+	a.push()
+	for _, p := range fn.Params {
+		a.declare(p.Name, nil)
+	}
+	a.block(fn.Body)
+	if a.f.live && !fn.IsGen && ret.Kind != bytecode.TypeVoid && ret.Kind != bytecode.TypeInvalid {
+		c.warnf("missing-return", fn.FnPos, "missing return in function returning %s", ret)
+	}
+}
+
+func (a *flowChecker) push() { a.scopes = append(a.scopes, make(map[string]*ast.LetStmt)) }
+func (a *flowChecker) pop()  { a.scopes = a.scopes[:len(a.scopes)-1] }
+
+func (a *flowChecker) declare(name string, s *ast.LetStmt) { a.scopes[len(a.scopes)-1][name] = s }
+
+func (a *flowChecker) lookup(name string) *ast.LetStmt {
+	for i := len(a.scopes) - 1; i >= 0; i-- {
+		if s, ok := a.scopes[i][name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func (a *flowChecker) block(b *ast.BlockStmt) {
+	if !a.f.live {
+		return
+	}
+	a.push()
+	defer a.pop()
+	for i, s := range b.Stmts {
+		if !a.f.live {
+			a.unreachable(b.Stmts[i-1], s.Pos())
+			return
+		}
+		a.stmt(s)
+	}
+	if b.Tail == nil {
+		return
+	}
+	if !a.f.live {
+		a.unreachable(b.Stmts[len(b.Stmts)-1], b.Tail.Pos())
+		return
+	}
+	a.expr(b.Tail)
+}
+
+// unreachable reports the code at pos, which control never reaches from
+// the statement before it.
+func (a *flowChecker) unreachable(prev ast.Stmt, pos token.Position) {
+	if _, ok := prev.(*ast.ReturnStmt); ok {
+		a.c.warnf("unreachable", pos, "unreachable code after return")
+		return
+	}
+	a.c.warnf("unreachable", pos, "unreachable code")
+}
+
+func (a *flowChecker) stmt(s ast.Stmt) {
+	switch n := s.(type) {
+	case *ast.BlockStmt:
+		a.block(n)
+	case *ast.LetStmt:
+		if n.Init != nil {
+			a.expr(n.Init)
+		} else {
+			a.f.unset[n] = true
+		}
+		a.declare(n.Name, n)
+	case *ast.AssignStmt:
+		a.expr(n.Value)
+		if l := a.lookup(n.Name); l != nil {
+			delete(a.f.unset, l)
+		}
+	case *ast.ReturnStmt:
+		if n.Value != nil {
+			a.expr(n.Value)
+		}
+		a.f = flow{}
+	case *ast.IfStmt:
+		a.expr(n.Cond)
+		entry := a.f.clone()
+		a.block(n.Then)
+		then := a.f
+		a.f = entry
+		if n.Else != nil {
+			a.stmt(n.Else)
+		}
+		a.f = then.join(a.f)
+	case *ast.WhileStmt:
+		a.expr(n.Cond)
+		entry := a.f.clone()
+		a.block(n.Body)
+		a.f = entry
+		if isTrue(n.Cond) {
+			a.f = flow{}
+		}
+	case *ast.ForStmt:
+		a.push()
+		if n.Init != nil {
+			a.stmt(n.Init)
+		}
+		if n.Cond != nil {
+			a.expr(n.Cond)
+		}
+		entry := a.f.clone()
+		a.block(n.Body)
+		if n.Post != nil && a.f.live {
+			a.stmt(n.Post)
+		}
+		a.f = entry
+		if n.Cond == nil || isTrue(n.Cond) {
+			a.f = flow{}
+		}
+		a.pop()
+	case *ast.ForInStmt:
+		a.expr(n.Iter)
+		entry := a.f.clone()
+		a.push()
+		a.declare(n.Var, nil)
+		a.block(n.Body)
+		a.pop()
+		a.f = entry
+	case *ast.YieldStmt:
+		a.expr(n.Value)
+	case *ast.SpawnStmt:
+		a.expr(n.Call)
+	case *ast.TryStmt:
+		// The body may fail before any of its assignments.
+		entry := a.f.clone()
+		a.block(n.Body)
+		body := a.f
+		a.f = entry
+		a.push()
+		a.declare(n.Var, nil)
+		a.block(n.Catch)
+		a.pop()
+		a.f = body.join(a.f)
+	case *ast.ExprStmt:
+		a.expr(n.X)
+		if isPanic(n.X) {
+			a.f = flow{}
+		}
+	}
+}
+
+func (a *flowChecker) expr(e ast.Expr) {
+	switch n := e.(type) {
+	case *ast.VarRef:
+		if l := a.lookup(n.Name); l != nil && a.f.live && a.f.unset[l] && !a.warned[l] {
+			a.c.warnf("unassigned", n.NamePos, "variable %q may be used before assignment", n.Name)
+			a.warned[l] = true
+		}
+	case *ast.UnaryExpr:
+		a.expr(n.X)
+	case *ast.BinaryExpr:
+		a.expr(n.L)
+		if n.Op != token.AND && n.Op != token.OR && n.Op != token.QQ {
+			a.expr(n.R)
+			return
+		}
+		skip := a.f.clone()
+		a.expr(n.R)
+		a.f = skip.join(a.f)
+	case *ast.CallExpr:
+		for _, arg := range n.Args {
+			a.expr(arg)
+		}
+	case *ast.MethodCallExpr:
+		a.expr(n.X)
+		for _, arg := range n.Args {
+			a.expr(arg)
+		}
+	case *ast.IfExpr:
+		a.expr(n.Cond)
+		entry := a.f.clone()
+		a.block(n.Then)
+		then := a.f
+		a.f = entry
+		a.expr(n.Else)
+		a.f = then.join(a.f)
+	case *ast.BlockExpr:
+		a.block(n.Block)
+	case *ast.ArrayLit:
+		for _, el := range n.Elems {
+			a.expr(el)
+		}
+	case *ast.IndexExpr:
+		a.expr(n.X)
+		a.expr(n.Index)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			a.expr(n.Cap)
+		}
+	case *ast.PropagateExpr:
+		a.expr(n.X)
+	case *ast.MatchExpr:
+		a.expr(n.X)
+		entry := a.f
+		out := flow{}
+		for _, arm := range n.Arms {
+			a.f = entry.clone()
+			a.push()
+			a.bind(arm.Pat)
+			if arm.Guard != nil {
+				a.expr(arm.Guard)
+			}
+			a.expr(arm.Body)
+			a.pop()
+			out = out.join(a.f)
+		}
+		a.f = out
+	}
+}
+
+// bind declares the names p binds.
+func (a *flowChecker) bind(p ast.Pattern) {
+	switch n := p.(type) {
+	case *ast.NamePat:
+		a.declare(n.Name, nil)
+	case *ast.VariantPat:
+		for _, arg := range n.Args {
+			a.bind(arg)
+		}
+	}
+}
+
+func isTrue(e ast.Expr) bool {
+	b, ok := e.(*ast.BoolLit)
+	return ok && b.Value
+}
+
+// isPanic reports whether e is a call of the panic builtin, which never
+// returns.
+func isPanic(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	vr, ok := call.Callee.(*ast.VarRef)
+	return ok && vr.Name == "panic"
+}
@@ -0,0 +1,289 @@
+package sema
+
+import (
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// Bound is what a type parameter asks of the types it stands for. Each
+// bound allows fewer types than the one before it, and the operations of
+// all of them.
+type Bound int
+
+const (
+	// BoundAny allows every type a value can have.
+	BoundAny Bound = iota
+	// BoundEq allows the types == compares.
+	BoundEq
+	// BoundOrd allows the types < compares: int, float and char.
+	BoundOrd
+	// BoundNum allows the types arithmetic works on: int and float.
+	BoundNum
+)
+
+var boundNames = map[string]Bound{"Any": BoundAny, "Eq": BoundEq, "Ord": BoundOrd, "Num": BoundNum}
+
+func (b Bound) String() string {
+	for name, v := range boundNames {
+		if v == b {
+			return name
+		}
+	}
+	return "<?>"
+}
+
+// TypeParam is a type parameter of a generic fn or enum.
+type TypeParam struct {
+	Name  string
+	Bound Bound
+}
+
+// typeParams checks the type parameters a fn or enum declares.
+func (c *Checker) typeParams(tps []ast.TypeParam) []TypeParam {
+	var out []TypeParam
+	seen := make(map[string]bool)
+	for _, tp := range tps {
+		b := BoundAny
+		if tp.Bound != "" {
+			var ok bool
+			if b, ok = boundNames[tp.Bound]; !ok {
+				c.errorf(tp.Pos, "unknown bound %q; want Any, Eq, Ord or Num", tp.Bound)
+			}
+		}
+		if c.isType(tp.Name) {
+			c.errorf(tp.Pos, "type parameter %q shadows a type", tp.Name)
+			continue
+		}
+		if seen[tp.Name] {
+			c.errorf(tp.Pos, "duplicate type parameter %q", tp.Name)
+			continue
+		}
+		seen[tp.Name] = true
+		out = append(out, TypeParam{Name: tp.Name, Bound: b})
+	}
+	return out
+}
+
+// enter makes tps the type parameters in scope, returning those that
+// were.
+func (c *Checker) enter(tps []TypeParam) map[string]Bound {
+	old := c.bounds
+	c.bounds = make(map[string]Bound, len(tps))
+	for _, tp := range tps {
+		c.bounds[tp.Name] = tp.Bound
+	}
+	return old
+}
+
+// bounded reports whether t is a type parameter in scope whose bound
+// allows what b does.
+func (c *Checker) bounded(t Type, b Bound) bool {
+	if t.Kind != bytecode.TypeParam || t.IsHole() {
+		return false
+	}
+	have, ok := c.bounds[t.Name]
+	return ok && have >= b
+}
+
+// satisfies reports whether t is one of the types b allows.
+func (c *Checker) satisfies(t Type, b Bound) bool {
+	switch {
+	case t.IsHole() || t.Kind == bytecode.TypeInvalid:
+		return true
+	case t.Kind == bytecode.TypeParam:
+		return c.bounded(t, b)
+	case b == BoundEq && t.NonNull().Kind == bytecode.TypeParam:
+		return c.satisfies(t.NonNull(), b)
+	}
+	return b.Allows(t)
+}
+
+// Allows reports whether t, a type holding no type parameters, is one of
+// the types b allows.
+func (b Bound) Allows(t Type) bool {
+	if t.Kind == bytecode.TypeVoid {
+		return false
+	}
+	switch b {
+	case BoundEq:
+		u := t.NonNull()
+		return u.Kind != bytecode.TypeEnum && u.Kind != bytecode.TypeResult && u.Kind != bytecode.TypeTrait
+	case BoundOrd:
+		return t.Kind == bytecode.TypeInt || t.Kind == bytecode.TypeFloat || t.Kind == bytecode.TypeChar
+	case BoundNum:
+		return t.Kind == bytecode.TypeInt || t.Kind == bytecode.TypeFloat
+	}
+	return true
+}
+
+// unify binds the type parameters in names that p holds to the parts of
+// a in their place. A parameter bound twice is bound to the join of both
+// types when they have one, and otherwise keeps the first, which the
+// argument check then reports.
+func unify(p, a Type, names map[string]bool, sub map[string]Type) {
+	switch {
+	case p.Kind == bytecode.TypeParam && names[p.Name]:
+		if a.IsHole() || a.Kind == bytecode.TypeInvalid {
+			return
+		}
+		if b, ok := sub[p.Name]; ok {
+			if j, ok := join(b, a); ok {
+				sub[p.Name] = j
+			}
+			return
+		}
+		sub[p.Name] = a
+	case p.Kind == bytecode.TypeNullable && p.Elem != nil:
+		if a.Kind != bytecode.TypeNull {
+			unify(*p.Elem, a.NonNull(), names, sub)
+		}
+	case p.Kind != a.Kind:
+	case p.Kind == bytecode.TypeArray || p.Kind == bytecode.TypeIter || p.Kind == bytecode.TypeChan:
+		if p.Elem != nil && a.Elem != nil {
+			unify(*p.Elem, *a.Elem, names, sub)
+		}
+	case p.Kind == bytecode.TypeResult:
+		if p.Elem != nil && a.Elem != nil {
+			unify(*p.Elem, *a.Elem, names, sub)
+		}
+		if p.Err != nil && a.Err != nil {
+			unify(*p.Err, *a.Err, names, sub)
+		}
+	case p.Kind == bytecode.TypeEnum:
+		if p.Name == a.Name && len(p.Args) == len(a.Args) {
+			for i := range p.Args {
+				unify(p.Args[i], a.Args[i], names, sub)
+			}
+		}
+	}
+}
+
+// Unify binds the type parameters tps that p holds to the parts of a in
+// their place, as an argument of type a to a parameter of type p does.
+func Unify(p, a Type, tps []TypeParam, sub map[string]Type) {
+	names := make(map[string]bool, len(tps))
+	for _, tp := range tps {
+		names[tp.Name] = true
+	}
+	unify(p, a, names, sub)
+}
+
+// Subst replaces the type parameters t holds with the types sub binds
+// them to.
+func Subst(t Type, sub map[string]Type) Type { return subst(t, sub) }
+
+// subst replaces the type parameters t holds with the types sub binds
+// them to.
+func subst(t Type, sub map[string]Type) Type {
+	switch t.Kind {
+	case bytecode.TypeParam:
+		if b, ok := sub[t.Name]; ok {
+			return b
+		}
+	case bytecode.TypeNullable:
+		if t.Elem != nil {
+			return Nullable(subst(*t.Elem, sub))
+		}
+	case bytecode.TypeArray, bytecode.TypeIter, bytecode.TypeChan:
+		if t.Elem != nil {
+			e := subst(*t.Elem, sub)
+			t.Elem = &e
+		}
+	case bytecode.TypeResult:
+		if t.Elem != nil {
+			e := subst(*t.Elem, sub)
+			t.Elem = &e
+		}
+		if t.Err != nil {
+			e := subst(*t.Err, sub)
+			t.Err = &e
+		}
+	case bytecode.TypeEnum:
+		if len(t.Args) != 0 {
+			args := make([]Type, len(t.Args))
+			for i, a := range t.Args {
+				args[i] = subst(a, sub)
+			}
+			t.Args = args
+		}
+	}
+	return t
+}
+
+// instantiate gives the type parameters of the generic fn or variant sym
+// the types sub binds them to, and fill if it does not bind them.
+func instantiate(sym Symbol, sub map[string]Type, fill Type) Symbol {
+	full := make(map[string]Type, len(sym.TypeParams))
+	for _, tp := range sym.TypeParams {
+		if b, ok := sub[tp.Name]; ok {
+			full[tp.Name] = b
+		} else {
+			full[tp.Name] = fill
+		}
+	}
+	params := make([]Type, len(sym.Params))
+	for i, p := range sym.Params {
+		params[i] = subst(p, full)
+	}
+	sym.Params, sym.Ret, sym.TypeParams = params, subst(sym.Ret, full), nil
+	return sym
+}
+
+// checkGenericCall infers the type arguments of a call to the generic fn
+// or variant sym from the types of the arguments. A variant leaves the
+// ones they do not tell open, as in the type of Some(null).
+func (c *Checker) checkGenericCall(call *ast.CallExpr, name string, sym Symbol) Type {
+	names := make(map[string]bool, len(sym.TypeParams))
+	for _, tp := range sym.TypeParams {
+		names[tp.Name] = true
+	}
+	ats := make([]Type, len(call.Args))
+	invalid := false
+	sub := make(map[string]Type)
+	for i, a := range call.Args {
+		ats[i] = c.checkExpr(a)
+		invalid = invalid || ats[i].Kind == bytecode.TypeInvalid
+		unify(sym.Params[i], ats[i], names, sub)
+	}
+
+	fill := Hole()
+	if sym.Kind == SymFn {
+		fill = T(bytecode.TypeInvalid)
+	}
+	for _, tp := range sym.TypeParams {
+		b, ok := sub[tp.Name]
+		switch {
+		case !ok && sym.Kind == SymFn && !invalid:
+			c.errorf(call.Pos(), "cannot infer type parameter %s of %q", tp.Name, name)
+		case ok && !c.satisfies(b, tp.Bound):
+			c.errorf(call.Pos(), "%s does not satisfy %s, the bound of type parameter %s of %q", b, tp.Bound, tp.Name, name)
+		}
+	}
+
+	inst := instantiate(sym, sub, fill)
+	for i, at := range ats {
+		if pt := inst.Params[i]; !c.assignable(pt, at) && at.Kind != bytecode.TypeInvalid {
+			c.errorf(call.Args[i].Pos(), "arg %d: expected %s, got %s", i, pt, at)
+		}
+	}
+	return inst.Ret
+}
+
+// enumType resolves a use of the enum r names, checking its type
+// arguments against the type parameters of the enum.
+func (c *Checker) enumType(r *ast.TypeRef) Type {
+	tps := c.enumParams[r.Name]
+	if len(r.Args) != len(tps) {
+		c.errorf(r.Pos, "enum %s takes %d type arguments, got %d", r.Name, len(tps), len(r.Args))
+		return T(bytecode.TypeInvalid)
+	}
+	args := make([]Type, len(r.Args))
+	for i := range r.Args {
+		args[i] = c.typeFromRef(&r.Args[i])
+		if !c.satisfies(args[i], tps[i].Bound) || args[i].Kind == bytecode.TypeNull {
+			c.errorf(r.Args[i].Pos, "%s does not satisfy %s, the bound of type parameter %s of %s", args[i], tps[i].Bound, tps[i].Name, r.Name)
+			args[i] = T(bytecode.TypeInvalid)
+		}
+	}
+	return Enum(r.Name, args...)
+}
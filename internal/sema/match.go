@@ -0,0 +1,392 @@
+package sema
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// spat is a pattern as the exhaustiveness check sees it: a constructor
+// applied to patterns for the values it carries, or a wildcard when ctor is
+// empty. The constructors of T? are null and some, whose one value is the
+// T.
+type spat struct {
+	ctor string
+	args []spat
+}
+
+func (p spat) String() string {
+	switch {
+	case p.ctor == "":
+		return "_"
+	case p.ctor == "some":
+		return p.args[0].String()
+	case len(p.args) == 0:
+		return p.ctor
+	}
+	parts := make([]string, len(p.args))
+	for i, a := range p.args {
+		parts[i] = a.String()
+	}
+	return p.ctor + "(" + strings.Join(parts, ", ") + ")"
+}
+
+// checkMatch types e as the join of the types of its arms. The arms must
+// cover every value of the matched type, and each must match some value
+// no arm above it does.
+func (c *Checker) checkMatch(e *ast.MatchExpr) Type {
+	xt := c.checkExpr(e.X)
+	entry := c.nonNull
+	var out facts
+
+	analyze := xt.Kind != bytecode.TypeInvalid
+	var rows [][]spat
+	var ty Type
+	for i, arm := range e.Arms {
+		old := c.scope
+		c.scope = NewScope(old)
+		c.nonNull = entry.clone()
+
+		// A name binding the value after a null arm is known not to be
+		// null.
+		pt := xt
+		if np, ok := arm.Pat.(*ast.NamePat); ok && xt.IsNullable() && !c.isVariant(np.Name) &&
+			!c.useful(rows, []spat{{ctor: "null"}}, []Type{xt}) {
+			pt = xt.NonNull()
+		}
+		p, ok := c.checkPattern(arm.Pat, pt)
+		if analyze && ok {
+			if !c.useful(rows, []spat{p}, []Type{xt}) {
+				c.errorf(arm.Pat.Pos(), "unreachable match arm")
+			}
+			if arm.Guard == nil {
+				rows = append(rows, []spat{p})
+			}
+		} else {
+			analyze = false
+		}
+
+		if arm.Guard != nil {
+			gt := c.checkExpr(arm.Guard)
+			if gt.Kind != bytecode.TypeBool && gt.Kind != bytecode.TypeInvalid {
+				c.errorf(arm.Guard.Pos(), "match guard must be bool, got %s", gt)
+			}
+			onTrue, _ := c.condFacts(arm.Guard)
+			c.narrow(onTrue)
+		}
+		bt := c.checkExpr(arm.Body)
+		c.scope = old
+
+		if i == 0 {
+			ty, out = bt, c.nonNull
+			continue
+		}
+		out = out.intersect(c.nonNull)
+		if ty.Kind == bytecode.TypeInvalid || bt.Kind == bytecode.TypeInvalid {
+			ty = T(bytecode.TypeInvalid)
+		} else if j, ok := join(ty, bt); ok {
+			ty = j
+		} else {
+			c.errorf(arm.Body.Pos(), "match arms have different types: %s vs %s", ty, bt)
+			ty = T(bytecode.TypeInvalid)
+		}
+	}
+	if len(e.Arms) == 0 {
+		ty, out = T(bytecode.TypeVoid), entry
+	}
+	c.nonNull = out
+
+	if analyze {
+		if w, ok := c.witness(rows, 1, []Type{xt}); ok {
+			if w[0].String() == "_" {
+				c.errorf(e.MatchPos, "match on %s is not exhaustive; add a _ arm", xt)
+			} else {
+				c.errorf(e.MatchPos, "match on %s is not exhaustive: missing %s", xt, w[0])
+			}
+		}
+	}
+	return ty
+}
+
+func (c *Checker) variant(name string) (Symbol, bool) {
+	sym, ok := c.global.Lookup(name)
+	return sym, ok && sym.Kind == SymVariant
+}
+
+// variantFor is variant, also knowing the ok and err variants of the
+// Result t.
+func (c *Checker) variantFor(name string, t Type) (Symbol, bool) {
+	if name != "ok" && name != "err" {
+		sym, ok := c.variant(name)
+		if ok && len(sym.TypeParams) != 0 {
+			// The variant of a generic enum, in the type t gives it.
+			var sub map[string]Type
+			if u := t.NonNull(); u.Kind == bytecode.TypeEnum && u.Name == sym.Ret.Name && len(u.Args) == len(sym.TypeParams) {
+				sub = make(map[string]Type, len(u.Args))
+				for i, tp := range sym.TypeParams {
+					sub[tp.Name] = u.Args[i]
+				}
+			}
+			sym = instantiate(sym, sub, Hole())
+		}
+		return sym, ok
+	}
+	res := t.NonNull()
+	if res.Kind != bytecode.TypeResult {
+		res = Result(nil, nil)
+	}
+	part := res.Elem
+	if name == "err" {
+		part = res.Err
+	}
+	field := T(bytecode.TypeInvalid)
+	if part != nil {
+		field = *part
+	}
+	return Symbol{Kind: SymVariant, Name: name, Params: []Type{field}, Ret: res}, true
+}
+
+func (c *Checker) isVariant(name string) bool {
+	_, ok := c.variantFor(name, Type{})
+	return ok
+}
+
+// checkPattern checks that p can match values of type t, declaring the
+// names it binds in the current scope. It reports false if p is invalid.
+func (c *Checker) checkPattern(p ast.Pattern, t Type) (spat, bool) {
+	switch p := p.(type) {
+	case *ast.WildcardPat:
+		return spat{}, true
+
+	case *ast.NamePat:
+		if sym, ok := c.variantFor(p.Name, t); ok {
+			return c.checkVariantPat(p.NamePos, p.Name, sym, nil, t)
+		}
+		if !c.scope.Declare(Symbol{Kind: SymVar, Name: p.Name, Pos: p.NamePos, Ty: t}) {
+			c.errorf(p.NamePos, "%q bound twice in one pattern", p.Name)
+		}
+		return spat{}, true
+
+	case *ast.VariantPat:
+		sym, ok := c.variantFor(p.Name, t)
+		if !ok {
+			c.errorf(p.NamePos, "undefined variant %q", p.Name)
+			for _, a := range p.Args {
+				c.checkPattern(a, T(bytecode.TypeInvalid))
+			}
+			return spat{}, false
+		}
+		return c.checkVariantPat(p.NamePos, p.Name, sym, p.Args, t)
+
+	case *ast.LitPat:
+		lt := c.checkExpr(p.Value)
+		if lt.Kind == bytecode.TypeNull {
+			if !t.IsNullable() {
+				if t.Kind != bytecode.TypeInvalid {
+					c.errorf(p.Pos(), "null pattern cannot match non-nullable %s", t)
+				}
+				return spat{}, false
+			}
+			return spat{ctor: "null"}, true
+		}
+		if !lt.Equal(t.NonNull()) {
+			if t.Kind != bytecode.TypeInvalid {
+				c.errorf(p.Pos(), "%s pattern cannot match %s", lt, t)
+			}
+			return spat{}, false
+		}
+		return wrapSome(t, spat{ctor: litCtor(p.Value)}), true
+	}
+	c.errorf(p.Pos(), "unknown pattern")
+	return spat{}, false
+}
+
+func (c *Checker) checkVariantPat(pos token.Position, name string, sym Symbol, args []ast.Pattern, t Type) (spat, bool) {
+	ok := true
+	if !sym.Ret.Equal(t.NonNull()) {
+		if t.Kind != bytecode.TypeInvalid {
+			c.errorf(pos, "variant %q of %s cannot match %s", name, sym.Ret, t)
+		}
+		ok = false
+	}
+	if len(args) != len(sym.Params) {
+		c.errorf(pos, "variant %q carries %d values, got %d patterns", name, len(sym.Params), len(args))
+		ok = false
+	}
+	sub := make([]spat, len(sym.Params))
+	for i, a := range args {
+		ft := T(bytecode.TypeInvalid)
+		if i < len(sym.Params) {
+			ft = sym.Params[i]
+		}
+		s, aok := c.checkPattern(a, ft)
+		ok = ok && aok
+		if i < len(sub) {
+			sub[i] = s
+		}
+	}
+	return wrapSome(t, spat{ctor: name, args: sub}), ok
+}
+
+// wrapSome makes p, a pattern for the values of t without null, a pattern
+// for the values of t.
+func wrapSome(t Type, p spat) spat {
+	if t.IsNullable() {
+		return spat{ctor: "some", args: []spat{p}}
+	}
+	return p
+}
+
+func litCtor(e ast.Expr) string {
+	switch n := e.(type) {
+	case *ast.IntLit:
+		return strconv.FormatInt(n.Value, 10)
+	case *ast.FloatLit:
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	case *ast.BoolLit:
+		return strconv.FormatBool(n.Value)
+	case *ast.StringLit:
+		return strconv.Quote(n.Value)
+	case *ast.CharLit:
+		return "'" + n.Raw + "'"
+	}
+	return ""
+}
+
+// ctors returns every constructor of t, or nil if t has too many values
+// to list.
+func (c *Checker) ctors(t Type) []string {
+	switch t.Kind {
+	case bytecode.TypeNullable:
+		return []string{"null", "some"}
+	case bytecode.TypeEnum:
+		return c.enums[t.Name]
+	case bytecode.TypeBool:
+		return []string{"false", "true"}
+	case bytecode.TypeResult:
+		return []string{"ok", "err"}
+	}
+	return nil
+}
+
+// fields returns the types of the values constructor ctor of t carries.
+func (c *Checker) fields(t Type, ctor string) []Type {
+	switch t.Kind {
+	case bytecode.TypeNullable:
+		if ctor == "some" {
+			return []Type{t.NonNull()}
+		}
+	case bytecode.TypeEnum, bytecode.TypeResult:
+		sym, _ := c.variantFor(ctor, t)
+		return sym.Params
+	}
+	return nil
+}
+
+// specialize keeps the rows whose first pattern can match a ctor value,
+// putting the patterns for the values it carries in its place.
+func specialize(rows [][]spat, ctor string, arity int) [][]spat {
+	var out [][]spat
+	for _, r := range rows {
+		switch r[0].ctor {
+		case ctor:
+			out = append(out, slices.Concat(r[0].args, r[1:]))
+		case "":
+			out = append(out, slices.Concat(make([]spat, arity), r[1:]))
+		}
+	}
+	return out
+}
+
+// defaults keeps the rows whose first pattern is a wildcard, without it.
+func defaults(rows [][]spat) [][]spat {
+	var out [][]spat
+	for _, r := range rows {
+		if r[0].ctor == "" {
+			out = append(out, r[1:])
+		}
+	}
+	return out
+}
+
+func heads(rows [][]spat) map[string]bool {
+	out := make(map[string]bool)
+	for _, r := range rows {
+		out[r[0].ctor] = true
+	}
+	return out
+}
+
+// covers reports whether the first patterns of rows name every one of
+// the constructors sig.
+func covers(rows [][]spat, sig []string) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	used := heads(rows)
+	for _, k := range sig {
+		if !used[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// useful reports whether q, a row of patterns for values of types tys,
+// matches some values none of rows do.
+func (c *Checker) useful(rows [][]spat, q []spat, tys []Type) bool {
+	if len(q) == 0 {
+		return len(rows) == 0
+	}
+	t := tys[0]
+	if q[0].ctor != "" {
+		fs := c.fields(t, q[0].ctor)
+		return c.useful(specialize(rows, q[0].ctor, len(fs)), slices.Concat(q[0].args, q[1:]), slices.Concat(fs, tys[1:]))
+	}
+	if sig := c.ctors(t); covers(rows, sig) {
+		for _, k := range sig {
+			fs := c.fields(t, k)
+			if c.useful(specialize(rows, k, len(fs)), slices.Concat(make([]spat, len(fs)), q[1:]), slices.Concat(fs, tys[1:])) {
+				return true
+			}
+		}
+		return false
+	}
+	return c.useful(defaults(rows), q[1:], tys[1:])
+}
+
+// witness returns n patterns for values of types tys that none of rows
+// match, if there are such values.
+func (c *Checker) witness(rows [][]spat, n int, tys []Type) ([]spat, bool) {
+	if n == 0 {
+		return nil, len(rows) == 0
+	}
+	t := tys[0]
+	sig := c.ctors(t)
+	if covers(rows, sig) {
+		for _, k := range sig {
+			fs := c.fields(t, k)
+			if w, ok := c.witness(specialize(rows, k, len(fs)), len(fs)+n-1, slices.Concat(fs, tys[1:])); ok {
+				return slices.Concat([]spat{{ctor: k, args: w[:len(fs)]}}, w[len(fs):]), true
+			}
+		}
+		return nil, false
+	}
+	w, ok := c.witness(defaults(rows), n-1, tys[1:])
+	if !ok {
+		return nil, false
+	}
+	var head spat
+	used := heads(rows)
+	for _, k := range sig {
+		if !used[k] {
+			head = spat{ctor: k, args: make([]spat, len(c.fields(t, k)))}
+			break
+		}
+	}
+	return slices.Concat([]spat{head}, w), true
+}
@@ -0,0 +1,283 @@
+package sema
+
+import (
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/token"
+)
+
+// varKey names one declared variable: shadowing declarations in inner
+// scopes are different variables.
+type varKey struct {
+	scope *Scope
+	name  string
+}
+
+// facts is the set of nullable variables known not to be null at a point
+// of the function being checked.
+type facts map[varKey]bool
+
+func (f facts) clone() facts {
+	out := make(facts, len(f))
+	for k := range f {
+		out[k] = true
+	}
+	return out
+}
+
+// intersect keeps the facts holding on both of two joining paths.
+func (f facts) intersect(g facts) facts {
+	out := make(facts)
+	for k := range f {
+		if g[k] {
+			out[k] = true
+		}
+	}
+	return out
+}
+
+// nullableVar returns the variable e refers to if it is one of nullable
+// type.
+func (c *Checker) nullableVar(e ast.Expr) (varKey, bool) {
+	vr, ok := e.(*ast.VarRef)
+	if !ok {
+		return varKey{}, false
+	}
+	sym, sc, ok := c.scope.LookupScope(vr.Name)
+	if !ok || sym.Kind != SymVar || !sym.Ty.IsNullable() {
+		return varKey{}, false
+	}
+	return varKey{scope: sc, name: vr.Name}, true
+}
+
+func (c *Checker) narrow(keys []varKey) {
+	for _, k := range keys {
+		c.nonNull[k] = true
+	}
+}
+
+// track updates what is known about a nullable variable just given a value
+// of type t.
+func (c *Checker) track(k varKey, t Type) {
+	if t.IsNullable() || t.Kind == bytecode.TypeNull || t.Kind == bytecode.TypeInvalid {
+		delete(c.nonNull, k)
+		return
+	}
+	c.nonNull[k] = true
+}
+
+// forget drops the facts about the variables assigned anywhere in n, which
+// a loop may do before any of its iterations.
+func (c *Checker) forget(n ast.Node) {
+	names := make(map[string]bool)
+	assignedIn(n, names)
+	for k := range c.nonNull {
+		if names[k.name] {
+			delete(c.nonNull, k)
+		}
+	}
+}
+
+// condFacts returns the variables a condition proves non-null when it is
+// true and when it is false.
+func (c *Checker) condFacts(e ast.Expr) (onTrue, onFalse []varKey) {
+	switch n := e.(type) {
+	case *ast.UnaryExpr:
+		if n.Op == token.BANG {
+			t, f := c.condFacts(n.X)
+			return f, t
+		}
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case token.EQ, token.NEQ:
+			x := n.L
+			if _, ok := n.L.(*ast.NullLit); ok {
+				x = n.R
+			} else if _, ok := n.R.(*ast.NullLit); !ok {
+				return nil, nil
+			}
+			k, ok := c.nullableVar(x)
+			if !ok {
+				return nil, nil
+			}
+			if n.Op == token.NEQ {
+				return []varKey{k}, nil
+			}
+			return nil, []varKey{k}
+		case token.AND:
+			l, _ := c.condFacts(n.L)
+			r, _ := c.condFacts(n.R)
+			return append(l, r...), nil
+		case token.OR:
+			_, l := c.condFacts(n.L)
+			_, r := c.condFacts(n.R)
+			return nil, append(l, r...)
+		}
+	}
+	return nil, nil
+}
+
+// join is the type of a value that is either an a or a b, if there is one.
+func join(a, b Type) (Type, bool) {
+	switch {
+	case a.Equal(b):
+		return a, true
+	case a.Kind == bytecode.TypeResult && b.Kind == bytecode.TypeResult:
+		elem, ok1 := joinPart(a.Elem, b.Elem)
+		err, ok2 := joinPart(a.Err, b.Err)
+		return Result(elem, err), ok1 && ok2
+	case a.Kind == bytecode.TypeEnum && b.Kind == bytecode.TypeEnum && a.Name == b.Name && len(a.Args) == len(b.Args):
+		args := make([]Type, len(a.Args))
+		for i := range args {
+			switch {
+			case a.Args[i].IsHole():
+				args[i] = b.Args[i]
+			case b.Args[i].IsHole():
+				args[i] = a.Args[i]
+			default:
+				var ok bool
+				if args[i], ok = join(a.Args[i], b.Args[i]); !ok {
+					return Type{}, false
+				}
+			}
+		}
+		return Enum(a.Name, args...), true
+	case a.Kind == bytecode.TypeVoid || b.Kind == bytecode.TypeVoid:
+		return Type{}, false
+	case a.Kind == bytecode.TypeNull:
+		return Nullable(b), true
+	case b.Kind == bytecode.TypeNull:
+		return Nullable(a), true
+	case a.NonNull().Equal(b.NonNull()):
+		return Nullable(a.NonNull()), true
+	}
+	return Type{}, false
+}
+
+func joinPart(a, b *Type) (*Type, bool) {
+	if a == nil {
+		return b, true
+	}
+	if b == nil {
+		return a, true
+	}
+	t, ok := join(*a, *b)
+	return &t, ok
+}
+
+// alwaysReturns reports whether control never runs past s.
+func alwaysReturns(s ast.Stmt) bool {
+	switch n := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, st := range n.Stmts {
+			if alwaysReturns(st) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return n.Else != nil && alwaysReturns(n.Then) && alwaysReturns(n.Else)
+	case *ast.TryStmt:
+		return alwaysReturns(n.Body) && alwaysReturns(n.Catch)
+	}
+	return false
+}
+
+// assignedIn adds the names of the variables assigned in n to names.
+func assignedIn(n ast.Node, names map[string]bool) {
+	switch n := n.(type) {
+	case *ast.BlockStmt:
+		for _, s := range n.Stmts {
+			assignedIn(s, names)
+		}
+		if n.Tail != nil {
+			assignedIn(n.Tail, names)
+		}
+	case *ast.LetStmt:
+		if n.Init != nil {
+			assignedIn(n.Init, names)
+		}
+	case *ast.AssignStmt:
+		names[n.Name] = true
+		assignedIn(n.Value, names)
+	case *ast.ReturnStmt:
+		if n.Value != nil {
+			assignedIn(n.Value, names)
+		}
+	case *ast.IfStmt:
+		assignedIn(n.Cond, names)
+		assignedIn(n.Then, names)
+		if n.Else != nil {
+			assignedIn(n.Else, names)
+		}
+	case *ast.WhileStmt:
+		assignedIn(n.Cond, names)
+		assignedIn(n.Body, names)
+	case *ast.ForStmt:
+		if n.Init != nil {
+			assignedIn(n.Init, names)
+		}
+		if n.Cond != nil {
+			assignedIn(n.Cond, names)
+		}
+		if n.Post != nil {
+			assignedIn(n.Post, names)
+		}
+		assignedIn(n.Body, names)
+	case *ast.ForInStmt:
+		assignedIn(n.Iter, names)
+		assignedIn(n.Body, names)
+	case *ast.YieldStmt:
+		assignedIn(n.Value, names)
+	case *ast.SpawnStmt:
+		assignedIn(n.Call, names)
+	case *ast.TryStmt:
+		assignedIn(n.Body, names)
+		assignedIn(n.Catch, names)
+	case *ast.ExprStmt:
+		assignedIn(n.X, names)
+
+	case *ast.UnaryExpr:
+		assignedIn(n.X, names)
+	case *ast.BinaryExpr:
+		assignedIn(n.L, names)
+		assignedIn(n.R, names)
+	case *ast.CallExpr:
+		for _, a := range n.Args {
+			assignedIn(a, names)
+		}
+	case *ast.MethodCallExpr:
+		assignedIn(n.X, names)
+		for _, a := range n.Args {
+			assignedIn(a, names)
+		}
+	case *ast.IfExpr:
+		assignedIn(n.Cond, names)
+		assignedIn(n.Then, names)
+		assignedIn(n.Else, names)
+	case *ast.BlockExpr:
+		assignedIn(n.Block, names)
+	case *ast.ArrayLit:
+		for _, el := range n.Elems {
+			assignedIn(el, names)
+		}
+	case *ast.IndexExpr:
+		assignedIn(n.X, names)
+		assignedIn(n.Index, names)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			assignedIn(n.Cap, names)
+		}
+	case *ast.PropagateExpr:
+		assignedIn(n.X, names)
+	case *ast.MatchExpr:
+		assignedIn(n.X, names)
+		for _, arm := range n.Arms {
+			if arm.Guard != nil {
+				assignedIn(arm.Guard, names)
+			}
+			assignedIn(arm.Body, names)
+		}
+	}
+}
@@ -12,8 +12,32 @@ type LocalID int
 type FuncID int
 
 type ResolvedVar struct {
-	ID LocalID
-	Ty Type
+	ID   LocalID
+	Ty   Type
+	Decl *Local
+}
+
+type LocalKind int
+
+const (
+	LocalParam LocalKind = iota
+	LocalLet
+	// LocalBinding is a name bound by a for-in loop, a catch or a pattern.
+	LocalBinding
+)
+
+// Local is a declared local variable.
+type Local struct {
+	Name string
+	Pos  token.Position
+	Kind LocalKind
+	// Fn is the function declaring the local, nil at the top level.
+	Fn *ast.FnDecl
+	// Shadows is the local of the same name in an enclosing scope that
+	// this one hides, if any.
+	Shadows *Local
+	// Reads counts the expressions reading the local.
+	Reads int
 }
 
 type ResolvedFn struct {
@@ -27,6 +51,8 @@ type ResolveResult struct {
 	Vars map[ast.Expr]ResolvedVar
 	Asgn map[*ast.AssignStmt]ResolvedVar
 	Let  map[*ast.LetStmt]ResolvedVar
+	// Locals are all the locals, in the order they are declared.
+	Locals []*Local
 }
 
 type resolverScope struct {
@@ -61,8 +87,11 @@ type Resolver struct {
 	fnIDs map[string]FuncID
 	nextF FuncID
 
+	variants map[string]bool
+
 	scope *resolverScope
 	nextL LocalID
+	fn    *ast.FnDecl
 
 	out ResolveResult
 
@@ -71,7 +100,8 @@ type Resolver struct {
 
 func NewResolver(exprTypes map[ast.Expr]Type) *Resolver {
 	r := &Resolver{
-		fnIDs: make(map[string]FuncID),
+		fnIDs:    make(map[string]FuncID),
+		variants: map[string]bool{"Error": true},
 		out: ResolveResult{
 			Fns:  make(map[*ast.FnDecl]ResolvedFn),
 			Vars: make(map[ast.Expr]ResolvedVar),
@@ -91,7 +121,19 @@ func (r *Resolver) Result() ResolveResult {
 
 func (r *Resolver) Resolve(prog *ast.Program) {
 	for _, it := range prog.Items {
-		if fn, ok := it.(*ast.FnDecl); ok {
+		if en, ok := it.(*ast.EnumDecl); ok {
+			for _, v := range en.Variants {
+				r.variants[v.Name] = true
+			}
+		}
+		fns := []*ast.FnDecl{}
+		switch n := it.(type) {
+		case *ast.FnDecl:
+			fns = append(fns, n)
+		case *ast.ImplDecl:
+			fns = n.Fns()
+		}
+		for _, fn := range fns {
 			if _, exists := r.fnIDs[fn.Name]; exists {
 				continue
 			}
@@ -104,6 +146,10 @@ func (r *Resolver) Resolve(prog *ast.Program) {
 		switch n := it.(type) {
 		case *ast.FnDecl:
 			r.resolveFn(n)
+		case *ast.ImplDecl:
+			for _, fn := range n.Fns() {
+				r.resolveFn(fn)
+			}
 		case *ast.StmtItem:
 			r.resolveStmt(n.S)
 		}
@@ -117,12 +163,12 @@ func (r *Resolver) resolveFn(fn *ast.FnDecl) {
 	oldNext := r.nextL
 	r.scope = newResolverScope(nil)
 	r.nextL = 0
+	r.fn = fn
 
 	params := make([]Type, 0, len(fn.Params))
 	for _, p := range fn.Params {
 		pty := r.typeFromParam(p)
-		id := r.allocLocal(p.Name, p.Pos, pty)
-		_ = id
+		r.allocLocal(p.Name, p.Pos, LocalParam, pty)
 		params = append(params, pty)
 	}
 
@@ -133,6 +179,7 @@ func (r *Resolver) resolveFn(fn *ast.FnDecl) {
 
 	r.scope = oldScope
 	r.nextL = oldNext
+	r.fn = nil
 }
 
 func (r *Resolver) resolveBlock(b *ast.BlockStmt) {
@@ -141,6 +188,9 @@ func (r *Resolver) resolveBlock(b *ast.BlockStmt) {
 	for _, s := range b.Stmts {
 		r.resolveStmt(s)
 	}
+	if b.Tail != nil {
+		r.resolveExpr(b.Tail)
+	}
 	r.scope = old
 }
 
@@ -179,6 +229,28 @@ func (r *Resolver) resolveStmt(s ast.Stmt) {
 		}
 		r.resolveBlock(n.Body)
 		r.scope = old
+	case *ast.ForInStmt:
+		r.resolveExpr(n.Iter)
+		old := r.scope
+		r.scope = newResolverScope(old)
+		elem := T(bytecode.TypeInvalid)
+		if t, ok := r.types[n.Iter]; ok && (t.Kind == bytecode.TypeIter || t.Kind == bytecode.TypeChan) && t.Elem != nil {
+			elem = *t.Elem
+		}
+		r.allocLocal(n.Var, n.VarPos, LocalBinding, elem)
+		r.resolveBlock(n.Body)
+		r.scope = old
+	case *ast.YieldStmt:
+		r.resolveExpr(n.Value)
+	case *ast.SpawnStmt:
+		r.resolveExpr(n.Call)
+	case *ast.TryStmt:
+		r.resolveBlock(n.Body)
+		old := r.scope
+		r.scope = newResolverScope(old)
+		r.allocLocal(n.Var, n.VarPos, LocalBinding, Enum("Error"))
+		r.resolveBlock(n.Catch)
+		r.scope = old
 	case *ast.ExprStmt:
 		r.resolveExpr(n.X)
 	}
@@ -189,8 +261,7 @@ func (r *Resolver) resolveLet(s *ast.LetStmt) {
 		r.resolveExpr(s.Init)
 	}
 	ty := r.typeFromLet(s)
-	id := r.allocLocal(s.Name, s.LetPos, ty)
-	r.out.Let[s] = ResolvedVar{ID: id, Ty: ty}
+	r.out.Let[s] = r.allocLocal(s.Name, s.LetPos, LocalLet, ty)
 }
 
 func (r *Resolver) resolveAssign(s *ast.AssignStmt) {
@@ -207,11 +278,15 @@ func (r *Resolver) resolveExpr(e ast.Expr) {
 	switch n := e.(type) {
 	case *ast.VarRef:
 		v, ok := r.scope.lookup(n.Name)
+		if !ok && (r.variants[n.Name] || n.Name == "ok" || n.Name == "err") {
+			return
+		}
 		if !ok {
 			r.errorf(n.NamePos, "unresolved identifier %q", n.Name)
 			return
 		}
 		r.out.Vars[e] = v
+		v.Decl.Reads++
 
 	case *ast.UnaryExpr:
 		r.resolveExpr(n.X)
@@ -219,7 +294,15 @@ func (r *Resolver) resolveExpr(e ast.Expr) {
 		r.resolveExpr(n.L)
 		r.resolveExpr(n.R)
 	case *ast.CallExpr:
-		r.resolveExpr(n.Callee)
+		// A callee naming a function or builtin is not a variable.
+		if vr, ok := n.Callee.(*ast.VarRef); !ok || r.isLocal(vr.Name) {
+			r.resolveExpr(n.Callee)
+		}
+		for _, a := range n.Args {
+			r.resolveExpr(a)
+		}
+	case *ast.MethodCallExpr:
+		r.resolveExpr(n.X)
 		for _, a := range n.Args {
 			r.resolveExpr(a)
 		}
@@ -236,23 +319,73 @@ func (r *Resolver) resolveExpr(e ast.Expr) {
 		r.resolveExpr(n.Cond)
 		r.resolveBlock(n.Then)
 		r.resolveExpr(n.Else)
+	case *ast.ChanExpr:
+		if n.Cap != nil {
+			r.resolveExpr(n.Cap)
+		}
+	case *ast.PropagateExpr:
+		r.resolveExpr(n.X)
+	case *ast.MatchExpr:
+		r.resolveExpr(n.X)
+		for _, arm := range n.Arms {
+			old := r.scope
+			r.scope = newResolverScope(old)
+			r.resolvePattern(arm.Pat, r.types[n.X])
+			if arm.Guard != nil {
+				r.resolveExpr(arm.Guard)
+			}
+			r.resolveExpr(arm.Body)
+			r.scope = old
+		}
+	}
+}
+
+// resolvePattern allocates the locals p binds. Only a name binding the
+// whole matched value, of type ty, has its type known here.
+func (r *Resolver) resolvePattern(p ast.Pattern, ty Type) {
+	switch n := p.(type) {
+	case *ast.NamePat:
+		if !r.variants[n.Name] && n.Name != "ok" && n.Name != "err" {
+			r.allocLocal(n.Name, n.NamePos, LocalBinding, ty)
+		}
+	case *ast.VariantPat:
+		for _, a := range n.Args {
+			r.resolvePattern(a, T(bytecode.TypeInvalid))
+		}
 	}
 }
 
-func (r *Resolver) allocLocal(name string, pos token.Position, ty Type) LocalID {
+func (r *Resolver) allocLocal(name string, pos token.Position, kind LocalKind, ty Type) ResolvedVar {
 	id := r.nextL
 	r.nextL++
 
-	if ok := r.scope.declare(name, ResolvedVar{ID: id, Ty: ty}); !ok {
+	decl := &Local{Name: name, Pos: pos, Kind: kind, Fn: r.fn}
+	if r.scope.parent != nil {
+		if v, ok := r.scope.parent.lookup(name); ok {
+			decl.Shadows = v.Decl
+		}
+	}
+	r.out.Locals = append(r.out.Locals, decl)
+
+	v := ResolvedVar{ID: id, Ty: ty, Decl: decl}
+	if ok := r.scope.declare(name, v); !ok {
 		r.errorf(pos, "redeclaration of %q", name)
 	}
-	return id
+	return v
+}
+
+func (r *Resolver) isLocal(name string) bool {
+	_, ok := r.scope.lookup(name)
+	return ok
 }
 
 func (r *Resolver) typeFromLet(s *ast.LetStmt) Type {
 	if s.Type != nil {
 		return typeFromRef(s.Type)
 	}
+	if s.Inferred != nil {
+		return typeFromRef(s.Inferred)
+	}
 	if s.Init != nil && r.types != nil {
 		if t, ok := r.types[s.Init]; ok {
 			return t
@@ -269,6 +402,9 @@ func (r *Resolver) typeFromFnRet(fn *ast.FnDecl) Type {
 	if fn.RetType == nil {
 		return T(bytecode.TypeVoid)
 	}
+	if fn.IsGen {
+		return Iter(typeFromRef(fn.RetType))
+	}
 	return typeFromRef(fn.RetType)
 }
 
@@ -276,12 +412,33 @@ func typeFromRef(rf *ast.TypeRef) Type {
 	if rf == nil {
 		return T(bytecode.TypeVoid)
 	}
+	if rf.Nullable {
+		base := *rf
+		base.Nullable = false
+		return Nullable(typeFromRef(&base))
+	}
 	if rf.Name == "array" {
 		if rf.Elem == nil {
 			return Arr(T(bytecode.TypeInvalid))
 		}
 		return Arr(typeFromRef(rf.Elem))
 	}
+	if rf.Name == "iter" {
+		if rf.Elem == nil {
+			return Iter(T(bytecode.TypeInvalid))
+		}
+		return Iter(typeFromRef(rf.Elem))
+	}
+	if rf.Name == "chan" {
+		if rf.Elem == nil {
+			return Chan(T(bytecode.TypeInvalid))
+		}
+		return Chan(typeFromRef(rf.Elem))
+	}
+	if rf.Name == "Result" && rf.Elem != nil && rf.Err != nil {
+		elem, err := typeFromRef(rf.Elem), typeFromRef(rf.Err)
+		return Result(&elem, &err)
+	}
 	switch rf.Name {
 	case "int":
 		return T(bytecode.TypeInt)
@@ -295,8 +452,10 @@ func typeFromRef(rf *ast.TypeRef) Type {
 		return T(bytecode.TypeChar)
 	case "void":
 		return T(bytecode.TypeVoid)
-	default:
+	case "":
 		return T(bytecode.TypeInvalid)
+	default:
+		return Enum(rf.Name)
 	}
 }
 
@@ -7,6 +7,7 @@ type SymbolKind int
 const (
 	SymVar SymbolKind = iota
 	SymFn
+	SymVariant
 )
 
 type Symbol struct {
@@ -17,9 +18,17 @@ type Symbol struct {
 	// var
 	Ty Type
 
-	// fn
+	// fn, and variant, whose Params are the types of the values it
+	// carries and whose Ret is its enum
 	Params []Type
 	Ret    Type
+	// Gen and Native mark gen fns and functions implemented outside the
+	// program, neither of which can be spawned.
+	Gen    bool
+	Native bool
+	// TypeParams are those of a generic fn, or of the enum of a variant,
+	// which Params and Ret refer to.
+	TypeParams []TypeParam
 }
 
 type Scope struct {
@@ -40,10 +49,16 @@ func (s *Scope) Declare(sym Symbol) bool {
 }
 
 func (s *Scope) Lookup(name string) (Symbol, bool) {
+	sym, _, ok := s.LookupScope(name)
+	return sym, ok
+}
+
+// LookupScope is Lookup also returning the scope that declares name.
+func (s *Scope) LookupScope(name string) (Symbol, *Scope, bool) {
 	for sc := s; sc != nil; sc = sc.Parent {
 		if sym, ok := sc.Syms[name]; ok {
-			return sym, true
+			return sym, sc, true
 		}
 	}
-	return Symbol{}, false
+	return Symbol{}, nil, false
 }
@@ -1,8 +1,10 @@
 package sema
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/dunooo0ooo/lang/internal/ast"
 	"github.com/dunooo0ooo/lang/internal/lexer"
 	"github.com/dunooo0ooo/lang/internal/parser"
 )
@@ -20,7 +22,7 @@ fn f() -> void {
     let c: char = 'a';
     let a: []int = [1,2,3];
     let y: int = a[0];
-    let z = null;
+    let z: int? = null;
     let xi: int = if 1 < 2 { 10 } else { 20 };
     return;
 }
@@ -37,3 +39,604 @@ fn f() -> void {
 		t.Fatalf("sema errors: %v", c.Errors())
 	}
 }
+
+func TestSema_IOBuiltins(t *testing.T) {
+	ok := `
+fn main() -> int {
+    let name: string = read_line();
+    let n: int = read_int();
+    eprintln(name);
+    eprintln(n + 1);
+    return n;
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for _, src := range []string{
+		`fn main() -> int { let s: int = read_line(); return s; }`,
+		`fn main() -> int { let s: string = read_int(); return 0; }`,
+		`fn main() -> int { return read_int(1); }`,
+		`fn main() -> int { eprintln(1, 2); return 0; }`,
+	} {
+		if errs := checkSource(t, src); len(errs) == 0 {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestSema_FloatConversion(t *testing.T) {
+	if errs := checkSource(t, `fn main() -> int { let f: float = float(3) * 0.5; return 0; }`); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for _, src := range []string{
+		`fn main() -> int { let f = float(1.5); return 0; }`,
+		`fn main() -> int { let f = float(); return 0; }`,
+		`fn main() -> int { return float(1); }`,
+	} {
+		if errs := checkSource(t, src); len(errs) == 0 {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestSema_Generators(t *testing.T) {
+	ok := `
+gen fn words() -> string {
+    yield "a";
+    return;
+}
+gen fn lengths(src: iter<string>) -> iter<string> {
+    yield src;
+}
+fn main() -> string {
+    let s: string = next(words());
+    for w in words() { s = w; }
+    for inner in lengths(words()) { s = next(inner); }
+    return s;
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for _, src := range []string{
+		`fn main() -> int { yield 1; return 0; }`,
+		`gen fn g() -> int { yield "x"; }`,
+		`gen fn g() -> int { return 1; }`,
+		`gen fn g() { yield 1; }`,
+		`gen fn g() -> int { yield 1; } fn main() -> int { return g(); }`,
+		`fn main() -> int { return next(3); }`,
+		`fn main() -> int { for x in [1, 2] { } return 0; }`,
+	} {
+		if errs := checkSource(t, src); len(errs) == 0 {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestSema_TasksAndChannels(t *testing.T) {
+	ok := `
+fn fill(c: chan<[]int>, n: int) {
+    send(c, array(n));
+    close(c);
+}
+fn main() -> int {
+    let c: chan<[]int> = chan<[]int>(2);
+    let unbuffered: chan<string> = chan<string>();
+    spawn fill(c, 3);
+    let first: []int = recv(c);
+    for rest in c { first = rest; }
+    wait();
+    return 0;
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for _, src := range []string{
+		`fn main() -> int { let c: chan<int> = chan<string>(1); return 0; }`,
+		`fn main() -> int { let c: chan<int> = chan<int>(1); send(c, "x"); return 0; }`,
+		`fn main() -> int { let c: chan<int> = chan<int>(1); let s: string = recv(c); return 0; }`,
+		`fn main() -> int { let c: chan<int> = chan<int>("big"); return 0; }`,
+		`fn main() -> int { send(3, 1); return 0; }`,
+		`fn main() -> int { let c: chan<[]int> = chan<[]int>(1); send(c, null); return 0; }`,
+		`fn main() -> int { wait(1); return 0; }`,
+		`fn main() -> int { spawn println("x"); return 0; }`,
+		`gen fn g() -> int { yield 1; } fn main() -> int { spawn g(); return 0; }`,
+		`fn f(x: int) { } fn main() -> int { spawn f("x"); return 0; }`,
+	} {
+		if errs := checkSource(t, src); len(errs) == 0 {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestSema_NullSafety(t *testing.T) {
+	ok := `
+fn first(xs: []int?) -> int {
+    if xs == null { return -1; }
+    return get(xs, 0);
+}
+fn both(a: string?, b: string?) -> string {
+    if a != null && b != null { return a; }
+    if !(a == null || b == null) { return b; }
+    let s: string = a ?? b ?? "none";
+    return s;
+}
+fn grow(xs: []int?) -> int {
+    let ys: []int? = xs;
+    while ys == null { ys = array(3); }
+    let n: int = ys[0];
+    let t: int? = ys?.[1];
+    let z: int? = null;
+    z = 4;
+    return n + (t ?? 0) + z;
+}
+fn pick(c: bool) -> string? {
+    let s: string? = if c { "yes" } else { null };
+    let arr: [](string?) = [null, "x", s];
+    return arr[2];
+}
+fn main() -> int {
+    let c: chan<int?> = chan<int?>(1);
+    send(c, null);
+    let v: int = recv(c) ?? 0;
+    return first(null) + first([1]) + v;
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for _, src := range []string{
+		`fn f(xs: []int?) -> int { return get(xs, 0); }`,
+		`fn f(xs: []int?) -> int { return xs[0]; }`,
+		`fn f() -> int { let s: string = null; return 0; }`,
+		`fn f() -> string { return null; }`,
+		`fn f(s: string?) -> string { if s != null { s = null; return s; } return ""; }`,
+		`fn f(s: string?) -> string { if s == null { return ""; } else { } s = null; return s; }`,
+		`fn f(s: string?) -> string { if s != null { } return s; }`,
+		`fn f(s: string?) -> string { while s != null { return s; } return s; }`,
+		`fn f(xs: []int?) -> int { for let i: int = 0; i < 2; i = i + 1 { let n: int = xs[0]; xs = null; } return 0; }`,
+		`fn f(x: int?) -> int { return x + 1; }`,
+		`fn f(x: int?) -> int { return x ?? "zero"; }`,
+		`fn f(xs: []int?) -> int { return xs?.[0]; }`,
+		`fn f() -> int { let v: void? = 1; return 0; }`,
+	} {
+		if errs := checkSource(t, src); len(errs) == 0 {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestSema_EnumsAndMatch(t *testing.T) {
+	ok := `
+fn area(s: Shape) -> float {
+    return match s {
+        Circle(r) => 3.0 * r * r,
+        Rect(w, h) if w == h => w * w,
+        Rect(w, h) => w * h,
+        Empty => 0.0,
+    };
+}
+enum Shape { Circle(float), Rect(float, float), Empty }
+enum List { Cons(int, List), Nil }
+fn sum(l: List) -> int {
+    return match l { Cons(x, rest) => x + sum(rest), Nil => 0 };
+}
+fn name(n: int, b: bool, s: Shape?) -> string {
+    let a: string = match n { 0 => "zero", -1 => "minus one", _ => "many" };
+    let c: string = match b { true => "yes", false => "no" };
+    let d: string = match s { null => "none", Empty => "empty", other => "shape" };
+    match s {
+        Circle(_) => { println(a); }
+        _ => { println(c); }
+    }
+    return d;
+}
+fn main() -> int {
+    let l: List = Cons(1, Cons(2, Nil));
+    let s: Shape = Rect(1.0, 2.0);
+    println(area(s));
+    return sum(l);
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for src, want := range map[string]string{
+		`enum E { A, B(int) } fn f(e: E) -> int { return match e { A => 1 }; }`:                "missing B(_)",
+		`enum E { A, B(bool) } fn f(e: E) -> int { return match e { A => 1, B(true) => 2 }; }`: "missing B(false)",
+		`fn f(x: int) -> int { return match x { 1 => 1, 2 => 2 }; }`:                           "add a _ arm",
+		`fn f(x: int?) -> int { return match x { y => 1, null => 2 }; }`:                       "unreachable match arm",
+		`fn f(x: int?) -> int { return match x { null => 1 }; }`:                               "add a _ arm",
+		`enum E { A, B } fn f(e: E) -> int { return match e { A => 1, _ => 2, B => 3 }; }`:     "unreachable match arm",
+		`enum E { A, B } fn f(e: E) -> int { return match e { A if true => 1, B => 2 }; }`:     "missing A",
+		`enum E { A, B } fn f(e: E) -> int { return match e { A => 1, B => "b" }; }`:           "different types",
+		`enum E { A(int) } fn f(e: E) -> int { return match e { A(x, y) => x }; }`:             "carries 1 values",
+		`enum E { A } enum F { B } fn f(e: E) -> int { return match e { B => 1, _ => 2 }; }`:   "cannot match",
+		`enum E { A } fn f(e: E) -> int { return match e { C(x) => 1, _ => 2 }; }`:             "undefined variant",
+		`fn f(x: int) -> int { return match x { "a" => 1, _ => 2 }; }`:                         "cannot match",
+		`fn f(x: int) -> int { return match x { null => 1, _ => 2 }; }`:                        "non-nullable",
+		`enum E { A(int) } fn f() -> E { return A; }`:                                          "carries 1 values",
+		`enum E { A(int) } fn f() -> E { return A(true); }`:                                    "expected int",
+		`enum E { A } fn f(a: E, b: E) -> bool { return a == b; }`:                             "cannot compare",
+		`enum E { A } enum E { B }`:                                                            "redeclaration of type",
+		`enum E { A } fn A() -> int { return 1; }`:                                             "redeclaration of function",
+		`fn f(s: Shap) -> int { return 1; }`:                                                   "unknown type",
+		`enum E { A(int) } fn f(e: E) -> int { return match e { A(x) if x => 1, _ => 2 }; }`:   "guard must be bool",
+		`enum E { A(int, int) } fn f(e: E) -> int { return match e { A(x, x) => x }; }`:        "bound twice",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_Results(t *testing.T) {
+	ok := `
+fn half(n: int) -> Result<int, string> {
+    if n % 2 != 0 { return err("odd"); }
+    return ok(n / 2);
+}
+fn quarter(s: string) -> Result<int, string?> {
+    let n: int = parse_int(s)?;
+    return ok(half(half(n)?)?);
+}
+fn pick(c: bool) -> Result<[]int, string> {
+    let r: Result<[]int, string> = if c { ok([1]) } else { err("no") };
+    let rs: []Result<int, string> = [ok(1), err("x")];
+    return r;
+}
+fn main() -> int {
+    let f: Result<float, string> = parse_float("1.5");
+    let maybe: Result<int, string>? = null;
+    let a: int = match quarter("8") { ok(v) => v, err(null) => 0, err(e) => -1 };
+    return match maybe { null => a, ok(v) if v > 0 => v, ok(_) => 0, err(_) => -1 };
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for src, want := range map[string]string{
+		`fn f() -> int { let n: int = parse_int("1")?; return n; }`:                  "not a Result",
+		`fn f() -> Result<int, int> { let n: int = parse_int("1")?; return ok(n); }`: "cannot return error string",
+		`fn f(x: int) -> Result<int, int> { return ok(x?); }`:                        "expects a Result",
+		`let n: int = parse_int("1")?;`:                                              "outside a function",
+		`fn f() -> Result<int, string> { return ok("s"); }`:                          "return type",
+		`fn f() -> int { let r = ok(1); return 0; }`:                                 "add a type annotation",
+		`fn f(r: Result<int, string>) -> int { return match r { ok(v) => v }; }`:     "missing err(_)",
+		`fn f(r: Result<int, string>) -> bool { return r == r; }`:                    "cannot compare",
+		`fn f() -> Result<void, string> { return err("x"); }`:                        "cannot hold void",
+		`fn f(x: int) -> int { return match x { ok(v) => v, _ => 0 }; }`:             "cannot match int",
+		`fn f() -> Result<int, string> { return parse_int(1); }`:                     "must be string",
+		`enum E { ok } fn f() -> int { return 1; }`:                                  "redeclaration",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_TryCatch(t *testing.T) {
+	ok := `
+fn message(e: Error) -> string {
+    return match e { Error(msg, _) => msg };
+}
+fn f(xs: []int?, n: int) -> int {
+    if xs == null { return 0; }
+    let line: int = 0;
+    let x: int = 1 + {
+        try {
+            if n < 0 { panic("negative"); }
+            line = xs[n];
+        } catch e {
+            line = match e { Error(_, l) => l };
+            println(message(e));
+        }
+        line
+    };
+    try { return x; } catch e { return xs[0]; }
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for src, want := range map[string]string{
+		`fn f() { panic(1); }`:                           "must be string",
+		`fn f() { panic(); }`:                            "expects 1 args",
+		`fn f() { try { } catch e { let n: int = e; } }`: "cannot assign Error to int",
+		`fn f() { try { } catch e { } println(e); }`:     "undefined",
+		`enum Error { Oops } fn f() { }`:                 "redeclaration of type",
+		`enum E { Error } fn f() { }`:                    "redeclaration",
+		`fn f(xs: []int?) -> int { if xs == null { return 0; } try { xs = null; } catch e { } return xs[0]; }`: "null",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_Generics(t *testing.T) {
+	ok := `
+enum Option<T> { Some(T), None }
+fn max<T: Ord>(a: T, b: T) -> T {
+    if a > b { return a; }
+    return b;
+}
+fn sum<N: Num>(xs: []N, zero: N) -> N {
+    let s: N = zero;
+    for let i: int = 0; i < 2; i = i + 1 { s = s + xs[i]; }
+    return -(-s);
+}
+fn or_else<T>(o: Option<T>, d: T) -> T {
+    return match o { Some(x) => x, None => d };
+}
+fn find<T: Eq>(xs: []T, x: T) -> Option<int> {
+    if xs[0] == x { return Some(0); }
+    return None;
+}
+fn bigger<T: Num>(a: T, b: T) -> T { return max(a, b); }
+fn main() {
+    let i: int = max(1, 2);
+    let c: char = max('a', 'b');
+    let f: float = sum([1.5, 2.5], 0.0);
+    let o: Option<string> = None;
+    let s: string = or_else(o, "none");
+    let n: int = or_else(Some(3), 4) + or_else(None, 5);
+    let at: Option<int> = find(["a", "b"], "b");
+    let m: Option<int?> = Some(null);
+    let b: float = bigger(1.0, 2.0);
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for src, want := range map[string]string{
+		`fn max<T: Ord>(a: T, b: T) -> T { return a; } fn f() { max(1, 2.0); }`:                                            "arg 1: expected int, got float",
+		`fn max<T: Ord>(a: T, b: T) -> T { return a; } fn f() { max(true, false); }`:                                       "bool does not satisfy Ord",
+		`fn max<T: Ord>(a: T, b: T) -> T { return a; } fn f() { max(null, 1); }`:                                           "int? does not satisfy Ord",
+		`fn add<T: Ord>(a: T, b: T) -> T { return a + b; }`:                                                                "arithmetic",
+		`fn lt<T: Eq>(a: T, b: T) -> bool { return a < b; }`:                                                               "comparison",
+		`fn eq<T>(a: T, b: T) -> bool { return a == b; }`:                                                                  "bound T by Eq",
+		`fn f<T: Size>(a: T) { }`:                                                                                          "unknown bound",
+		`enum E { A } fn f<E>(a: E) { }`:                                                                                   "shadows a type",
+		`fn f<T, T>(a: T) { }`:                                                                                             "duplicate type parameter",
+		`fn make<T>() -> T? { return null; } fn f() { make(); }`:                                                           "cannot infer type parameter T",
+		`fn id<T>(a: T) -> T { return a; } fn f() { let n: int = id("s"); }`:                                               "cannot assign string to int",
+		`fn f<T>(a: T) -> int { return a; }`:                                                                               "return type T does not match int",
+		`enum Option<T> { Some(T), None } fn f() { let o = None; }`:                                                        "cannot tell the type",
+		`enum Option<T> { Some(T), None } fn f(o: Option) { }`:                                                             "takes 1 type arguments, got 0",
+		`enum Option<T> { Some(T), None } fn f(o: Option<int>) -> string { return match o { Some(x) => x, None => "" }; }`: "different types",
+		`enum Box<T: Num> { Box(T) } fn f(b: Box<string>) { }`:                                                             "string does not satisfy Num",
+		`enum Box<T: Num> { Box(T) } fn f() { Box("s"); }`:                                                                 "string does not satisfy Num",
+		`fn f<T>(a: T<int>) { }`:                                                                                           "takes no type arguments",
+		`enum Option<T> { Some(T), None } fn f(o: Option<int>) -> int { return match o { Some(x) => x }; }`:                "missing None",
+		`fn f(xs: []string) { set(xs, 0, 1); }`:                                                                            "v must be string, got int",
+		`fn f<T>(xs: []T, x: int) { set(xs, 0, x); }`:                                                                      "v must be T, got int",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_Traits(t *testing.T) {
+	const decls = `
+trait Shape { fn area(self) -> float; fn grow(self, k: float) -> Shape; }
+enum Circle { C(float) }
+impl Shape for Circle {
+    fn area(self) -> float { return match self { C(r) => r * r }; }
+    fn grow(self, k: float) -> Shape { return match self { C(r) => C(r * k) }; }
+}
+`
+	ok := decls + `
+trait Named { fn name(self) -> string; }
+impl Named for int { fn name(self) -> string { return "int"; } }
+impl Named for Circle { fn name(self) -> string { return "circle"; } }
+fn total(xs: []Shape) -> float {
+    let t: float = 0.0;
+    for let i: int = 0; i < xs.len(); i = i + 1 { t = t + xs[i].grow(2.0).area(); }
+    return t;
+}
+fn main() {
+    let xs: []Shape = [C(1.0), C(2.0)];
+    let s: Shape = C(3.0);
+    s = xs[0];
+    let f: float = total([C(1.0)]) + s.area();
+    let n: string = (1).name();
+    n = C(1.0).name();
+    let l: int = "abc".len();
+}
+`
+	if errs := checkSource(t, ok); len(errs) != 0 {
+		t.Fatalf("sema errors: %v", errs)
+	}
+
+	for src, want := range map[string]string{
+		`trait T { fn f(self) -> int; fn g(self); } impl T for int { fn f(self) -> int { return 1; } }`:                       `missing method "g"`,
+		`trait T { fn f(self) -> int; } impl T for int { fn f(self) -> float { return 1.0; } }`:                               "is fn() -> float, want fn() -> int",
+		`trait T { fn f(self); } impl T for int { fn f(self) { } fn g(self) { } }`:                                            `method "g" is not in trait T`,
+		`impl T for int { fn f(self) { } }`:                                                                                   `undefined trait "T"`,
+		`trait T { fn f(self); } impl T for int { fn f(self) { } } impl T for int { fn f(self) { } }`:                         "duplicate impl of T for int",
+		`trait T { fn f(self); } impl T for []int { fn f(self) { } }`:                                                         "cannot implement T for []int",
+		`trait T { fn f(self); fn f(self); }`:                                                                                 "duplicate method",
+		`trait T { fn f(self); } trait U { fn f(self); } impl T for int { fn f(self) { } } impl U for int { fn f(self) { } }`: `int already has a method "f"`,
+		`trait T { fn f(self); } fn g(x: int) { x.f(); }`:                                                                     `int has no method "f"`,
+		`trait T { fn f(self, n: int); } fn g(x: T) { x.f(); }`:                                                               "expects 1 args, got 0",
+		`trait T { fn f(self, n: int); } fn g(x: T) { x.f("s"); }`:                                                            "arg 0: expected int, got string",
+		`trait T { fn f(self); } fn g(x: T?) { x.f(); }`:                                                                      "may be null",
+		`trait T { fn f(self); } fn g(a: T, b: T) -> bool { return a == b; }`:                                                 "T",
+		`trait T { fn f(self); } impl T for int { fn f(self) { } } fn g() { let xs: []T = [1, "s"]; }`:                        "array element 1: string does not implement T",
+		`trait T { fn f(self); } fn g() { let x: T = 1; }`:                                                                    "cannot assign int to T",
+		`trait T { fn f(self); } enum T { A }`:                                                                                "T",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_LetInference(t *testing.T) {
+	src := `
+enum Option<T> { Some(T), None }
+fn make() -> Result<int, string> { return ok(1); }
+fn main() {
+    let n = 5;
+    let arr = array(n);
+    let p = make();
+    let o = Some("s");
+    let m = if n > 1 { 1 } else { null };
+    let xs: []float = [];
+    let f = xs;
+}
+`
+	prog, c := parseAndCheck(t, src)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("sema errors: %v", c.Errors())
+	}
+
+	want := map[string]string{"n": "int", "arr": "[]int", "p": "Result<int, string>", "o": "Option<string>", "m": "int?", "f": "[]float"}
+	for _, it := range prog.Items {
+		fn, ok := it.(*ast.FnDecl)
+		if !ok || fn.Name != "main" {
+			continue
+		}
+		for _, st := range fn.Body.Stmts {
+			let := st.(*ast.LetStmt)
+			w, ok := want[let.Name]
+			switch {
+			case !ok && let.Inferred != nil:
+				t.Errorf("%s: inferred a type for an annotated let", let.Name)
+			case ok && let.Inferred == nil:
+				t.Errorf("%s: no inferred type", let.Name)
+			case ok:
+				if got := c.typeFromRef(let.Inferred).String(); got != w {
+					t.Errorf("%s: inferred %s, want %s", let.Name, got, w)
+				}
+			}
+		}
+	}
+
+	for src, want := range map[string]string{
+		`fn f() { let x = null; }`:                                `cannot tell the type of "x" from null`,
+		`fn f() { let x = []; }`:                                  "empty array literal requires type annotation",
+		`fn f() { let x = [null]; }`:                              "array literal of null requires type annotation",
+		`fn g() { } fn f() { let x = g(); }`:                      `cannot initialize "x" with a value of type void`,
+		`fn f() { let x = 5; x = "s"; }`:                          "cannot assign string to int",
+		`fn f() { let x = 1.5; let y: int = x; }`:                 "cannot assign float to int",
+		`fn f() { let x; }`:                                       `let "x" requires type or initializer`,
+		`fn f() { let xs: []int = []; let ys = xs; ys = ["s"]; }`: "cannot assign []string to []int",
+	} {
+		errs := checkSource(t, src)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an error containing %q, got %v", src, want, errs)
+		}
+	}
+}
+
+func TestSema_Flow(t *testing.T) {
+	for src, want := range map[string][]string{
+		`fn f(a: int) -> int { if a > 0 { return 1; } else if a < 0 { return -1; } else { return 0; } }`: nil,
+		`fn f() -> int { while true { } }`: nil,
+		`fn f() -> int { for ;; { } }`:     nil,
+		`fn f() -> int { panic("no"); }`:   nil,
+		`fn f(r: Result<int, string>) -> int { match r { ok(v) => { return v; }, err(e) => { return 0; } }; }`: nil,
+		`fn f(a: int) -> int { let x: int; if a > 0 { x = 1; } else { x = 2; } return x; }`:                    nil,
+		`fn f() -> int { let x: int; try { x = 1; } catch e { return 0; } return x; }`:                         nil,
+		`fn f(a: int) { }`:               nil,
+		`gen fn f() -> int { yield 1; }`: nil,
+
+		`fn f(a: int) -> int { if a > 0 { return 1; } }`:                              {"1:1: missing return in function returning int"},
+		`fn f(a: int) -> int { if a > 0 { return 1; } else if a < 0 { return 2; } }`:  {"1:1: missing return in function returning int"},
+		`fn f(a: bool) -> int { while a { return 1; } }`:                              {"1:1: missing return in function returning int"},
+		`fn f() -> int { return 1; let x = 2; }`:                                      {"1:27: unreachable code after return"},
+		`fn f() { while true { } let x = 2; }`:                                        {"1:25: unreachable code"},
+		`fn f(a: int) -> int { if a > 0 { return 1; } else { return 2; } return 3; }`: {"1:65: unreachable code"},
+		`fn f(a: int) -> int { let x: int; if a > 0 { x = 1; } return x; }`:           {`1:62: variable "x" may be used before assignment`},
+		`fn f(a: int) -> int { let x: int; while a > 0 { x = 1; } return x + x; }`:    {`1:65: variable "x" may be used before assignment`},
+		`fn f() -> int { let x: int; try { x = 1; } catch e { } return x; }`:          {`1:63: variable "x" may be used before assignment`},
+		`fn f() -> int { let x: int; { let x = 1; } return x; }`:                      {`1:51: variable "x" may be used before assignment`},
+	} {
+		_, c := parseAndCheck(t, src)
+		if len(c.Errors()) != 0 {
+			t.Fatalf("%s: sema errors: %v", src, c.Errors())
+		}
+		var got []string
+		for _, w := range c.Warnings() {
+			got = append(got, w.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: warnings %q, want %q", src, got, want)
+		}
+	}
+}
+
+// parseAndCheck parses and checks src, which must have no syntax errors.
+func parseAndCheck(t *testing.T, src string) (*ast.Program, *Checker) {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%s: parser errors: %v", src, p.Errors())
+	}
+	c := New()
+	c.Check(prog)
+	return prog, c
+}
+
+// checkSource returns the errors the checker finds in src.
+func checkSource(t *testing.T, src string) []error {
+	t.Helper()
+	_, c := parseAndCheck(t, src)
+	return c.Errors()
+}
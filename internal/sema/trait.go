@@ -0,0 +1,205 @@
+package sema
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/ast"
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// Trait is the type of the values of every type implementing the trait
+// declared as name.
+func Trait(name string) Type { return Type{Kind: bytecode.TypeTrait, Name: name} }
+
+// declareTrait resolves the signatures of the methods of d, which leave
+// out self.
+func (c *Checker) declareTrait(d *ast.TraitDecl) {
+	var methods []Symbol
+	for _, m := range d.Methods {
+		sym := Symbol{Kind: SymFn, Name: m.Name, Pos: m.Pos, Ret: c.typeFromRef(m.RetType)}
+		for _, p := range m.Params {
+			sym.Params = append(sym.Params, c.typeFromRef(&p.Type))
+		}
+		if _, dup := findMethod(methods, m.Name); dup {
+			c.errorf(m.Pos, "duplicate method %q in trait %s", m.Name, d.Name)
+			continue
+		}
+		methods = append(methods, sym)
+	}
+	c.traits[d.Name] = methods
+}
+
+func findMethod(methods []Symbol, name string) (Symbol, bool) {
+	for _, m := range methods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// declareImpl declares the methods of d as the functions fns, checking
+// that they are those of the trait, with its signatures.
+func (c *Checker) declareImpl(d *ast.ImplDecl, fns []*ast.FnDecl) {
+	for _, fn := range fns {
+		c.declareFn(fn)
+	}
+	want, ok := c.traits[d.Trait]
+	if !ok {
+		c.errorf(d.TraitPos, "undefined trait %q", d.Trait)
+		return
+	}
+	ty := c.typeFromRef(&d.For)
+	name, ok := implName(ty)
+	if !ok {
+		if ty.Kind != bytecode.TypeInvalid {
+			c.errorf(d.For.Pos, "cannot implement %s for %s; impls are for enums and int, float, bool, string, char", d.Trait, ty)
+		}
+		return
+	}
+	if c.impls[name] == nil {
+		c.impls[name] = make(map[string]bool)
+		c.methods[name] = make(map[string]Symbol)
+	}
+	if c.impls[name][d.Trait] {
+		c.errorf(d.ImplPos, "duplicate impl of %s for %s", d.Trait, ty)
+		return
+	}
+	c.impls[name][d.Trait] = true
+
+	for i, m := range d.Methods {
+		sym, _ := c.global.Lookup(fns[i].Name)
+		tm, ok := findMethod(want, m.Name)
+		switch {
+		case !ok:
+			c.errorf(m.FnPos, "method %q is not in trait %s", m.Name, d.Trait)
+			continue
+		case !sameSignature(tm, sym):
+			c.errorf(m.FnPos, "method %q of %s for %s is %s, want %s", m.Name, d.Trait, ty, signature(sym.Params[1:], sym.Ret), signature(tm.Params, tm.Ret))
+		}
+		if _, dup := c.methods[name][m.Name]; dup {
+			c.errorf(m.FnPos, "%s already has a method %q", ty, m.Name)
+			continue
+		}
+		c.methods[name][m.Name] = sym
+	}
+	for _, tm := range want {
+		if !slices.ContainsFunc(d.Methods, func(m *ast.FnDecl) bool { return m.Name == tm.Name }) {
+			c.errorf(d.ImplPos, "impl of %s for %s is missing method %q", d.Trait, ty, tm.Name)
+		}
+	}
+}
+
+// sameSignature reports whether the function fn, whose first parameter is
+// self, implements the trait method tm.
+func sameSignature(tm, fn Symbol) bool {
+	if len(fn.Params) != len(tm.Params)+1 || !fn.Ret.Equal(tm.Ret) {
+		return false
+	}
+	for i, p := range tm.Params {
+		if !fn.Params[i+1].Equal(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func signature(params []Type, ret Type) string {
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.String()
+	}
+	return "fn(" + strings.Join(parts, ", ") + ") -> " + ret.String()
+}
+
+// implName returns the name the vtables know the type t by, if t is one
+// that can have methods.
+func implName(t Type) (string, bool) {
+	switch t.Kind {
+	case bytecode.TypeInt, bytecode.TypeFloat, bytecode.TypeBool, bytecode.TypeString, bytecode.TypeChar:
+		return t.String(), true
+	case bytecode.TypeEnum:
+		return t.Name, len(t.Args) == 0
+	}
+	return "", false
+}
+
+// implements reports whether t has an impl of the trait named trait.
+func (c *Checker) implements(t Type, trait string) bool {
+	name, ok := implName(t)
+	return ok && c.impls[name][trait]
+}
+
+// method looks up the method name of values of type t, leaving self out
+// of its Params.
+func (c *Checker) method(t Type, name string) (Symbol, bool) {
+	if t.Kind == bytecode.TypeTrait {
+		return findMethod(c.traits[t.Name], name)
+	}
+	if n, ok := implName(t); ok {
+		if sym, ok := c.methods[n][name]; ok {
+			sym.Params = sym.Params[1:]
+			return sym, true
+		}
+	}
+	// The methods built into the language. The len of a string counts its
+	// UTF-8 bytes; chars splits it into its characters.
+	if name == "len" && (t.Kind == bytecode.TypeString || t.Kind == bytecode.TypeArray) {
+		return Symbol{Kind: SymFn, Name: name, Ret: T(bytecode.TypeInt)}, true
+	}
+	if name == "chars" && t.Kind == bytecode.TypeString {
+		return Symbol{Kind: SymFn, Name: name, Ret: Arr(T(bytecode.TypeChar))}, true
+	}
+	return Symbol{}, false
+}
+
+func (c *Checker) checkMethodCall(e *ast.MethodCallExpr) Type {
+	xt := c.checkExpr(e.X)
+	sym, ok := c.method(xt, e.Name)
+	switch {
+	case xt.Kind == bytecode.TypeInvalid:
+	case xt.IsNullable():
+		c.errorf(e.Dot, "cannot call %s on %s that may be null; check it against null first", e.Name, xt)
+	case !ok:
+		c.errorf(e.NamePos, "%s has no method %q", xt, e.Name)
+	case len(e.Args) != len(sym.Params):
+		c.errorf(e.NamePos, "method %q expects %d args, got %d", e.Name, len(sym.Params), len(e.Args))
+	default:
+		for i, a := range e.Args {
+			at := c.checkExprAs(a, sym.Params[i])
+			if !c.assignable(sym.Params[i], at) && at.Kind != bytecode.TypeInvalid {
+				c.errorf(a.Pos(), "arg %d: expected %s, got %s", i, sym.Params[i], at)
+			}
+		}
+		return sym.Ret
+	}
+	for _, a := range e.Args {
+		_ = c.checkExpr(a)
+	}
+	return T(bytecode.TypeInvalid)
+}
+
+// checkExprAs is checkExpr for a value going where a want is expected. An
+// array literal for a []Trait holds values of any types implementing it,
+// and an empty one is a want.
+func (c *Checker) checkExprAs(e ast.Expr, want Type) Type {
+	arr, ok := e.(*ast.ArrayLit)
+	elem := want.NonNull().Elem
+	if ok && len(arr.Elems) == 0 && want.NonNull().IsArray() && elem != nil && elem.Kind != bytecode.TypeInvalid {
+		ty := want.NonNull()
+		c.ExprType[e] = ty
+		return ty
+	}
+	if !ok || !want.NonNull().IsArray() || elem == nil || elem.Kind != bytecode.TypeTrait || len(arr.Elems) == 0 {
+		return c.checkExpr(e)
+	}
+	for i, el := range arr.Elems {
+		if t := c.checkExprAs(el, *elem); !c.assignable(*elem, t) && t.Kind != bytecode.TypeInvalid {
+			c.errorf(el.Pos(), "array element %d: %s does not implement %s", i, t, elem)
+		}
+	}
+	ty := Arr(*elem)
+	c.ExprType[e] = ty
+	return ty
+}
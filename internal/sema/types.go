@@ -1,10 +1,23 @@
 package sema
 
-import "github.com/dunooo0ooo/lang/internal/bytecode"
+import (
+	"slices"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
 
 type Type struct {
 	Kind bytecode.TypeKind
 	Elem *Type
+	// Name is the name of an enum type or a type parameter.
+	Name string
+	// Args are the type arguments of a generic enum type.
+	Args []Type
+	// Err is the error type of a Result, whose Elem is its value type.
+	// Either is nil in the type of ok(v) and err(e), which do not say
+	// what the other one is.
+	Err *Type
 }
 
 func T(k bytecode.TypeKind) Type { return Type{Kind: k} }
@@ -14,13 +27,91 @@ func Arr(elem Type) Type {
 	return Type{Kind: bytecode.TypeArray, Elem: &e}
 }
 
+// Iter is the type of the generators made by a gen fn yielding elem.
+func Iter(elem Type) Type {
+	e := elem
+	return Type{Kind: bytecode.TypeIter, Elem: &e}
+}
+
+// Chan is the type of channels carrying elem values.
+func Chan(elem Type) Type {
+	e := elem
+	return Type{Kind: bytecode.TypeChan, Elem: &e}
+}
+
+// Enum is the type of the values of the enum declared as name, given
+// args for its type parameters.
+func Enum(name string, args ...Type) Type {
+	return Type{Kind: bytecode.TypeEnum, Name: name, Args: args}
+}
+
+// Param is the type parameter name inside the generic fn or enum
+// declaring it.
+func Param(name string) Type { return Type{Kind: bytecode.TypeParam, Name: name} }
+
+// Hole is the type argument a use of a generic enum does not say, as in
+// the type of None: the argument of the type parameter it leaves open.
+func Hole() Type { return Type{Kind: bytecode.TypeParam} }
+
+func (t Type) IsHole() bool { return t.Kind == bytecode.TypeParam && t.Name == "" }
+
+// Result is the type of the ok values of type elem and the err values of
+// type err; nil leaves one of them open.
+func Result(elem, err *Type) Type {
+	return Type{Kind: bytecode.TypeResult, Elem: elem, Err: err}
+}
+
+// IsOpen reports whether t is or holds a Result with an open side or a
+// hole.
+func (t Type) IsOpen() bool {
+	if t.Kind == bytecode.TypeResult && (t.Elem == nil || t.Err == nil) || t.IsHole() {
+		return true
+	}
+	for _, a := range t.Args {
+		if a.IsOpen() {
+			return true
+		}
+	}
+	return t.Elem != nil && t.Elem.IsOpen() || t.Err != nil && t.Err.IsOpen()
+}
+
+// Nullable is the type of t values and null. Making null or an already
+// nullable type nullable leaves it as it is.
+func Nullable(t Type) Type {
+	if t.Kind == bytecode.TypeNullable || t.Kind == bytecode.TypeNull || t.Kind == bytecode.TypeInvalid {
+		return t
+	}
+	e := t
+	return Type{Kind: bytecode.TypeNullable, Elem: &e}
+}
+
+func (t Type) IsNullable() bool { return t.Kind == bytecode.TypeNullable }
+
+// NonNull is t without null: the T of a T?, or t itself.
+func (t Type) NonNull() Type {
+	if t.Kind == bytecode.TypeNullable && t.Elem != nil {
+		return *t.Elem
+	}
+	return t
+}
+
 func (t Type) IsArray() bool { return t.Kind == bytecode.TypeArray }
 
 func (t Type) Equal(u Type) bool {
 	if t.Kind != u.Kind {
 		return false
 	}
-	if t.Kind != bytecode.TypeArray {
+	if t.Kind == bytecode.TypeEnum {
+		return t.Name == u.Name && slices.EqualFunc(t.Args, u.Args, Type.Equal)
+	}
+	if t.Kind == bytecode.TypeParam {
+		return t.Name == u.Name
+	}
+	if t.Kind == bytecode.TypeResult {
+		return equalPart(t.Elem, u.Elem) && equalPart(t.Err, u.Err)
+	}
+	if t.Kind != bytecode.TypeArray && t.Kind != bytecode.TypeIter && t.Kind != bytecode.TypeChan &&
+		t.Kind != bytecode.TypeNullable {
 		return true
 	}
 	if t.Elem == nil || u.Elem == nil {
@@ -29,6 +120,20 @@ func (t Type) Equal(u Type) bool {
 	return t.Elem.Equal(*u.Elem)
 }
 
+func equalPart(a, b *Type) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func partString(t *Type) string {
+	if t == nil {
+		return "_"
+	}
+	return t.String()
+}
+
 func (t Type) String() string {
 	switch t.Kind {
 	case bytecode.TypeInt:
@@ -49,12 +154,49 @@ func (t Type) String() string {
 		if t.Elem == nil {
 			return "[]<?>"
 		}
+		if t.Elem.Kind == bytecode.TypeNullable {
+			return "[](" + t.Elem.String() + ")"
+		}
 		return "[]" + t.Elem.String()
+	case bytecode.TypeIter:
+		if t.Elem == nil {
+			return "iter<?>"
+		}
+		return "iter<" + t.Elem.String() + ">"
+	case bytecode.TypeChan:
+		if t.Elem == nil {
+			return "chan<?>"
+		}
+		return "chan<" + t.Elem.String() + ">"
+	case bytecode.TypeNullable:
+		if t.Elem == nil {
+			return "<?>?"
+		}
+		return t.Elem.String() + "?"
+	case bytecode.TypeEnum:
+		if len(t.Args) == 0 {
+			return t.Name
+		}
+		args := make([]string, len(t.Args))
+		for i, a := range t.Args {
+			args[i] = a.String()
+		}
+		return t.Name + "<" + strings.Join(args, ", ") + ">"
+	case bytecode.TypeTrait:
+		return t.Name
+	case bytecode.TypeParam:
+		if t.Name == "" {
+			return "_"
+		}
+		return t.Name
+	case bytecode.TypeResult:
+		return "Result<" + partString(t.Elem) + ", " + partString(t.Err) + ">"
 	default:
 		return "<?>"
 	}
 }
 
 func IsRefType(t Type) bool {
-	return t.Kind == bytecode.TypeString || t.Kind == bytecode.TypeArray
+	return t.Kind == bytecode.TypeString || t.Kind == bytecode.TypeArray || t.Kind == bytecode.TypeIter ||
+		t.Kind == bytecode.TypeChan
 }
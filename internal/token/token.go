@@ -23,6 +23,17 @@ const (
 	WHILE
 	FOR
 	RETURN
+	GEN
+	YIELD
+	IN
+	SPAWN
+	CHAN
+	ENUM
+	MATCH
+	TRY
+	CATCH
+	TRAIT
+	IMPL
 	TRUE
 	FALSE
 	INT_T    // int
@@ -43,6 +54,7 @@ const (
 	BANG // !
 	AND  // &&
 	OR   // ||
+	QQ   // ??
 
 	EQ  // ==
 	NEQ // !=
@@ -60,8 +72,12 @@ const (
 	SEMICOLON // ;
 	COLON     // :
 	ARROW     // ->
+	FATARROW  // =>
 	LBRACKET  // [
 	RBRACKET  // ]
+	QUESTION  // ?
+	QDOT      // ?.
+	DOT       // .
 )
 
 type Position struct {
@@ -78,6 +94,9 @@ type Token struct {
 
 var keywords = map[string]Type{
 	"let": LET, "fn": FN, "if": IF, "else": ELSE, "while": WHILE, "for": FOR, "return": RETURN,
+	"gen": GEN, "yield": YIELD, "in": IN, "spawn": SPAWN, "chan": CHAN,
+	"enum": ENUM, "match": MATCH, "try": TRY, "catch": CATCH,
+	"trait": TRAIT, "impl": IMPL,
 	"true": TRUE, "false": FALSE,
 
 	"int": INT_T, "bool": BOOL_T,
@@ -117,6 +136,28 @@ func (t Type) String() string {
 		return "FOR"
 	case RETURN:
 		return "RETURN"
+	case GEN:
+		return "GEN"
+	case YIELD:
+		return "YIELD"
+	case IN:
+		return "IN"
+	case SPAWN:
+		return "SPAWN"
+	case CHAN:
+		return "CHAN"
+	case ENUM:
+		return "ENUM"
+	case MATCH:
+		return "MATCH"
+	case TRY:
+		return "TRY"
+	case CATCH:
+		return "CATCH"
+	case TRAIT:
+		return "TRAIT"
+	case IMPL:
+		return "IMPL"
 	case TRUE:
 		return "TRUE"
 	case FALSE:
@@ -143,6 +184,8 @@ func (t Type) String() string {
 		return "AND"
 	case OR:
 		return "OR"
+	case QQ:
+		return "QQ"
 	case EQ:
 		return "EQ"
 	case NEQ:
@@ -171,12 +214,20 @@ func (t Type) String() string {
 		return "COLON"
 	case ARROW:
 		return "ARROW"
+	case FATARROW:
+		return "FATARROW"
 	case CHAR:
 		return "CHAR"
 	case LBRACKET:
 		return "LBRACKET"
 	case RBRACKET:
 		return "RBRACKET"
+	case QUESTION:
+		return "QUESTION"
+	case QDOT:
+		return "QDOT"
+	case DOT:
+		return "DOT"
 	case FLOAT_T:
 		return "FLOAT_T"
 	case STRING_T:
@@ -0,0 +1,451 @@
+package lang_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dunooo0ooo/lang"
+)
+
+func mustCompile(t *testing.T, src string, opts lang.CompileOptions) *lang.Program {
+	t.Helper()
+	prog, err := lang.Compile(src, opts)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	return prog
+}
+
+func TestCallConvertsValues(t *testing.T) {
+	prog := mustCompile(t, `
+fn scale(x: float, k: int) -> float { return x * 2.0 + 0.5; }
+fn pick(b: bool, s: string) -> string { return if b { s } else { "no" }; }
+fn row(n: int) -> []int { return [n, n + 1, n + 2]; }
+fn noop() -> void { return; }
+`, lang.CompileOptions{})
+
+	for _, jit := range []bool{false, true} {
+		vm := lang.NewVM(prog, lang.VMOptions{JIT: jit})
+
+		ret, err := vm.Call("scale", lang.Float(1.25), lang.Int(3))
+		if f, ferr := ret.AsFloat(); err != nil || ferr != nil || f != 3 {
+			t.Fatalf("jit=%v: scale = %v, %v", jit, ret, err)
+		}
+		if _, err := ret.AsInt(); err == nil {
+			t.Fatalf("AsInt of a float succeeded")
+		}
+
+		ret, err = vm.Call("pick", lang.Bool(true), lang.String("yes"))
+		if s, _ := ret.AsString(); err != nil || s != "yes" {
+			t.Fatalf("jit=%v: pick = %v, %v", jit, ret, err)
+		}
+
+		ret, err = vm.Call("row", lang.Int(4))
+		if err != nil || ret.Kind() != lang.KindArray || ret.String() != "[4, 5, 6]" {
+			t.Fatalf("jit=%v: row = %v, %v", jit, ret, err)
+		}
+		items, _ := ret.AsArray()
+		if n, _ := items[2].AsInt(); n != 6 {
+			t.Fatalf("jit=%v: row(4)[2] = %v", jit, items[2])
+		}
+
+		ret, err = vm.Call("noop")
+		if err != nil || !ret.IsNull() {
+			t.Fatalf("jit=%v: noop = %v, %v", jit, ret, err)
+		}
+
+		if _, err := vm.Call("scale", lang.Float(1)); err == nil {
+			t.Fatalf("jit=%v: call with missing argument succeeded", jit)
+		}
+	}
+}
+
+func TestNatives(t *testing.T) {
+	errTooBig := errors.New("too big")
+	var logged []string
+	natives := []lang.Native{
+		{
+			Name:   "clamp",
+			Params: []lang.Type{lang.IntType, lang.IntType},
+			Result: lang.IntType,
+			Fn: func(args []lang.Value) (lang.Value, error) {
+				x, _ := args[0].AsInt()
+				hi, _ := args[1].AsInt()
+				if x > 1000 {
+					return lang.Value{}, errTooBig
+				}
+				return lang.Int(min(x, hi)), nil
+			},
+		},
+		{
+			Name:   "log",
+			Params: []lang.Type{lang.StringType},
+			Fn: func(args []lang.Value) (lang.Value, error) {
+				logged = append(logged, args[0].String())
+				return lang.Value{}, nil
+			},
+		},
+		{
+			Name:   "sum",
+			Params: []lang.Type{lang.ArrayOf(lang.IntType)},
+			Result: lang.IntType,
+			Fn: func(args []lang.Value) (lang.Value, error) {
+				items, err := args[0].AsArray()
+				s := int64(0)
+				for _, it := range items {
+					n, _ := it.AsInt()
+					s += n
+				}
+				return lang.Int(s), err
+			},
+		},
+	}
+	prog := mustCompile(t, `
+fn main(x: int) -> int {
+    log("start");
+    return clamp(x, 10) + sum([1, 2, 3]);
+}
+`, lang.CompileOptions{Natives: natives})
+
+	for _, jit := range []bool{false, true} {
+		logged = nil
+		vm := lang.NewVM(prog, lang.VMOptions{JIT: jit})
+		ret, err := vm.Call("main", lang.Int(42))
+		if n, _ := ret.AsInt(); err != nil || n != 16 {
+			t.Fatalf("jit=%v: main(42) = %v, %v", jit, ret, err)
+		}
+		if strings.Join(logged, ",") != "start" {
+			t.Fatalf("jit=%v: logged %v", jit, logged)
+		}
+
+		_, err = vm.Call("main", lang.Int(5000))
+		var rerr *lang.RuntimeError
+		if !errors.Is(err, errTooBig) || !errors.As(err, &rerr) || rerr.Line != 4 {
+			t.Fatalf("jit=%v: got %v, want native error at line 4", jit, err)
+		}
+	}
+
+	_, err := lang.Compile(`fn main() -> int { return clamp("a", 1); }`, lang.CompileOptions{Natives: natives})
+	var cerr *lang.CompileError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("native argument types were not checked: %v", err)
+	}
+	if _, err := lang.Compile(`fn main() -> int { return 0; }`, lang.CompileOptions{
+		Natives: []lang.Native{{Name: "println", Fn: natives[1].Fn}},
+	}); err == nil {
+		t.Fatalf("native shadowing a builtin was accepted")
+	}
+}
+
+func TestCompileErrorsAndOptions(t *testing.T) {
+	_, err := lang.Compile(`fn main() -> int { return true + 1; }`, lang.CompileOptions{})
+	var cerr *lang.CompileError
+	if !errors.As(err, &cerr) || len(cerr.Errors) == 0 {
+		t.Fatalf("got %v, want a compile error", err)
+	}
+
+	prog := mustCompile(t, `
+fn main() -> int {
+    println("hi");
+    let i: int = 0;
+    while true { i = i + 1; }
+    return i;
+}
+`, lang.CompileOptions{})
+	var out bytes.Buffer
+	vm := lang.NewVM(prog, lang.VMOptions{Stdout: &out, InstructionBudget: 1000})
+	if _, err := vm.Call("main"); !errors.Is(err, lang.ErrBudgetExceeded) {
+		t.Fatalf("got %v, want budget exceeded", err)
+	}
+	if out.String() != "hi\n" {
+		t.Fatalf("stdout = %q", out.String())
+	}
+	if w := prog.Warnings(); len(w) != 1 || !strings.HasPrefix(w[0].Error(), "6:") || !strings.HasSuffix(w[0].Error(), "unreachable code") {
+		t.Fatalf("warnings = %v, want unreachable code on line 6", w)
+	}
+
+	var big strings.Builder
+	big.WriteString("fn main() -> int {\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&big, "    let v%d = %d;\n", i, i)
+	}
+	big.WriteString("    return 0;\n}\n")
+	if _, err := lang.Compile(big.String(), lang.CompileOptions{}); err == nil || errors.As(err, &cerr) ||
+		!strings.Contains(err.Error(), `function "main": too many locals (max 256)`) {
+		t.Fatalf("got %v, want a locals limit error", err)
+	}
+}
+
+func TestVet(t *testing.T) {
+	src := `
+fn main() -> int {
+    let unused = 1;
+    print(float(clamp(7, 0, 10) / 2));
+    return 0;
+}
+`
+	natives := []lang.Native{{
+		Name:   "clamp",
+		Params: []lang.Type{lang.IntType, lang.IntType, lang.IntType},
+		Result: lang.IntType,
+		Fn:     func(args []lang.Value) (lang.Value, error) { return args[0], nil },
+	}}
+	diags, err := lang.Vet(src, lang.VetOptions{
+		Natives:    natives,
+		Severities: map[string]lang.Severity{"unused": lang.SeverityError},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 2 || diags[0].ID != "unused" || diags[0].Severity != lang.SeverityError ||
+		diags[1].ID != "int-div" || diags[1].Severity != lang.SeverityWarning || diags[1].Line != 4 {
+		t.Fatalf("diagnostics = %v", diags)
+	}
+
+	var cerr *lang.CompileError
+	if _, err := lang.Vet(src, lang.VetOptions{}); !errors.As(err, &cerr) {
+		t.Fatalf("got %v, want a compile error for the undeclared native", err)
+	}
+	if _, err := lang.Vet(src, lang.VetOptions{Natives: natives, Severities: map[string]lang.Severity{"nope": lang.SeverityOff}}); err == nil {
+		t.Fatal("Vet accepted an unknown lint")
+	}
+
+	strs := &lang.Analyzer{ID: "strings", Severity: lang.SeverityInfo, Run: func(p *lang.Pass) {
+		for e, t := range p.Types {
+			if t.String() == "string" {
+				p.Reportf(e.Pos(), "string value")
+			}
+		}
+	}}
+	src = `fn main() -> int { println("hi"); return 0; }`
+	diags, err = lang.Vet(src, lang.VetOptions{Analyzers: []*lang.Analyzer{strs}})
+	if err != nil || len(diags) != 1 || diags[0].ID != "strings" || diags[0].Severity != lang.SeverityInfo || diags[0].Col != 28 {
+		t.Fatalf("diagnostics = %v, %v", diags, err)
+	}
+	diags, err = lang.Vet(src, lang.VetOptions{
+		Analyzers:  []*lang.Analyzer{strs},
+		Severities: map[string]lang.Severity{"strings": lang.SeverityOff},
+	})
+	if err != nil || len(diags) != 0 {
+		t.Fatalf("diagnostics = %v, %v with the analyzer off", diags, err)
+	}
+	if _, err := lang.Vet(src, lang.VetOptions{Analyzers: []*lang.Analyzer{{ID: "unused", Run: strs.Run}}}); err == nil {
+		t.Fatal("Vet accepted an analyzer with the ID of a built-in lint")
+	}
+}
+
+func TestTypeAt(t *testing.T) {
+	src := `
+fn main() -> int {
+    let n = 3;
+    let arr = array(n);
+    let s: string = "ab";
+    return arr.len() + n;
+}
+`
+	cases := []struct {
+		line, col int
+		want      string
+		ok        bool
+	}{
+		{3, 9, "int", true},    // n where it is declared
+		{4, 11, "[]int", true}, // the last rune of arr
+		{4, 21, "int", true},   // n passed to array
+		{5, 9, "string", true},
+		{6, 12, "[]int", true},
+		{4, 13, "", false}, // the space after arr
+		{6, 5, "", false},  // return
+	}
+	for _, tc := range cases {
+		got, ok, err := lang.TypeAt(src, tc.line, tc.col, lang.CompileOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("TypeAt(%d, %d) = %q, %v, want %q, %v", tc.line, tc.col, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	_, _, err := lang.TypeAt("fn main() -> int { return x; }", 1, 27, lang.CompileOptions{})
+	var ce *lang.CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("err = %v, want a *CompileError", err)
+	}
+}
+
+func TestNextDrainsIterators(t *testing.T) {
+	prog := mustCompile(t, `
+gen fn fib(n: int) -> int {
+    let a: int = 0;
+    let b: int = 1;
+    for let i: int = 0; i < n; i = i + 1 {
+        yield a;
+        let c: int = a + b;
+        a = b;
+        b = c;
+    }
+}
+fn total(it: iter<int>) -> int {
+    let s: int = 0;
+    for x in it { s = s + x; }
+    return s;
+}
+`, lang.CompileOptions{})
+
+	for _, jit := range []bool{false, true} {
+		vm := lang.NewVM(prog, lang.VMOptions{JIT: jit})
+		vm.SetGCPercent(0)
+
+		it, err := vm.Call("fib", 10)
+		if err != nil || it.Kind() != lang.KindIter {
+			t.Fatalf("jit=%v: fib = %v, %v", jit, it, err)
+		}
+		var got []string
+		for {
+			v, ok, err := vm.Next(it)
+			if err != nil {
+				t.Fatalf("jit=%v: %v", jit, err)
+			}
+			if !ok {
+				break
+			}
+			got = append(got, v.String())
+		}
+		if s := strings.Join(got, " "); s != "0 1 1 2 3 5 8 13 21 34" {
+			t.Fatalf("jit=%v: yielded %s", jit, s)
+		}
+
+		it, _ = vm.Call("fib", 5)
+		if v, ok, err := vm.Next(it); !ok || err != nil || v.String() != "0" {
+			t.Fatalf("jit=%v: next = %v, %v, %v", jit, v, ok, err)
+		}
+		if n, err := lang.CallAs[int](vm, "total", it); err != nil || n != 7 {
+			t.Fatalf("jit=%v: total of the rest = %v, %v, want 7", jit, n, err)
+		}
+		if _, err := vm.Call("total", 3); err == nil {
+			t.Fatalf("jit=%v: passing an int as an iterator succeeded", jit)
+		}
+	}
+}
+
+func TestChannelsPassBetweenCalls(t *testing.T) {
+	prog := mustCompile(t, `
+fn filled(n: int) -> chan<int> {
+    let c: chan<int> = chan<int>(n);
+    for let i: int = 0; i < n; i = i + 1 { send(c, i); }
+    close(c);
+    return c;
+}
+fn total(c: chan<int>) -> int {
+    let s: int = 0;
+    for x in c { s = s + x; }
+    return s;
+}
+fn stuck() -> int {
+    let c: chan<int> = chan<int>();
+    return recv(c);
+}
+`, lang.CompileOptions{})
+
+	vm := lang.NewVM(prog, lang.VMOptions{SchedulerSeed: 3})
+	c, err := vm.Call("filled", 5)
+	if err != nil || c.Kind() != lang.KindChan || c.String() != "<chan>" {
+		t.Fatalf("filled = %v (%s), %v", c, c.Kind(), err)
+	}
+	if n, err := lang.CallAs[int](vm, "total", c); err != nil || n != 10 {
+		t.Fatalf("total = %v, %v, want 10", n, err)
+	}
+	if _, err := vm.Call("stuck"); !errors.Is(err, lang.ErrDeadlock) {
+		t.Fatalf("stuck: err = %v, want a deadlock", err)
+	}
+}
+
+func TestVariantsPassBetweenCalls(t *testing.T) {
+	prog := mustCompile(t, `
+enum Shape { Circle(int), Rect(int, int), Empty }
+enum Unit { One }
+fn rect(w: int, h: int) -> Shape { return Rect(w, h); }
+fn area(s: Shape) -> int {
+    return match s { Circle(r) => 3 * r * r, Rect(w, h) => w * h, Empty => 0 };
+}
+fn one() -> Unit { return One; }
+`, lang.CompileOptions{})
+
+	vm := lang.NewVM(prog, lang.VMOptions{})
+	r, err := vm.Call("rect", 2, 5)
+	if err != nil || r.Kind() != lang.KindVariant || r.String() != "Rect(2, 5)" {
+		t.Fatalf("rect = %v (%s), %v", r, r.Kind(), err)
+	}
+	name, vals, err := r.AsVariant()
+	if err != nil || name != "Rect" || len(vals) != 2 || vals[1].String() != "5" {
+		t.Fatalf("AsVariant = %q, %v, %v", name, vals, err)
+	}
+	if n, err := lang.CallAs[int](vm, "area", r); err != nil || n != 10 {
+		t.Fatalf("area = %v, %v, want 10", n, err)
+	}
+	u, err := vm.Call("one")
+	if err != nil || u.String() != "One" {
+		t.Fatalf("one = %v, %v", u, err)
+	}
+	if _, err := vm.Call("area", u); err == nil {
+		t.Fatal("area accepted a Unit")
+	}
+	if _, _, err := lang.Int(1).AsVariant(); err == nil {
+		t.Fatal("AsVariant accepted an int")
+	}
+}
+
+func TestResultsPassBetweenCalls(t *testing.T) {
+	prog := mustCompile(t, `
+fn parse(s: string) -> Result<int, string> { return parse_int(s); }
+fn or_zero(r: Result<int, string>) -> int { return match r { ok(v) => v, err(_) => 0 }; }
+fn words() -> Result<string, string> { return ok("w"); }
+`, lang.CompileOptions{})
+
+	vm := lang.NewVM(prog, lang.VMOptions{})
+	r, err := vm.Call("parse", "42")
+	if err != nil || r.String() != "ok(42)" {
+		t.Fatalf("parse = %v, %v", r, err)
+	}
+	if n, err := lang.CallAs[int](vm, "or_zero", r); err != nil || n != 42 {
+		t.Fatalf("or_zero = %v, %v, want 42", n, err)
+	}
+	bad, err := vm.Call("parse", "4x")
+	if name, vals, _ := bad.AsVariant(); err != nil || name != "err" || vals[0].Kind() != lang.KindString {
+		t.Fatalf("parse(4x) = %v, %v", bad, err)
+	}
+	w, _ := vm.Call("words")
+	if _, err := vm.Call("or_zero", w); err == nil {
+		t.Fatal("or_zero accepted a Result<string, string>")
+	}
+}
+
+func Example() {
+	prog, err := lang.Compile(`
+fn fact(n: int) -> int {
+    if n <= 1 { return 1; }
+    return n * greet(n) * fact(n - 1);
+}
+`, lang.CompileOptions{Natives: []lang.Native{{
+		Name:   "greet",
+		Params: []lang.Type{lang.IntType},
+		Result: lang.IntType,
+		Fn: func(args []lang.Value) (lang.Value, error) {
+			fmt.Println("hello from", args[0])
+			return lang.Int(1), nil
+		},
+	}}})
+	if err != nil {
+		panic(err)
+	}
+
+	ret, err := lang.NewVM(prog, lang.VMOptions{}).Call("fact", lang.Int(3))
+	fmt.Println(ret, err)
+	// Output:
+	// hello from 3
+	// hello from 2
+	// 6 <nil>
+}
@@ -0,0 +1,402 @@
+package lang
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+var valueType = reflect.TypeOf(Value{})
+
+// CallAs calls the named function and converts its result to T; see Call
+// for how arguments are converted and ValueAs for the result.
+func CallAs[T any](vm *VM, name string, args ...any) (T, error) {
+	ret, err := vm.Call(name, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return convertTo[T](ret, name+" result")
+}
+
+// ValueAs converts v to T. Ints and chars convert to any Go integer type
+// they fit in, such as rune or byte for chars, floats to float32 or
+// float64, strings to string and arrays to slices or Go arrays of the same
+// length. Null converts to a nil pointer or slice; other values convert to
+// a pointer to a new converted copy. Converting to any yields an int64,
+// float64, bool, string, rune, []any or nil, or v itself for an iterator
+// or a channel; converting to Value yields v itself.
+func ValueAs[T any](v Value) (T, error) {
+	return convertTo[T](v, "value")
+}
+
+func convertTo[T any](v Value, path string) (T, error) {
+	var out T
+	if err := fromValue(v.v, reflect.ValueOf(&out).Elem(), path); err != nil {
+		var zero T
+		return zero, err
+	}
+	return out, nil
+}
+
+func fromValue(v bytecode.Value, dst reflect.Value, path string) error {
+	if dst.Type() == valueType {
+		dst.Set(reflect.ValueOf(Value{v}))
+		return nil
+	}
+	mismatch := func() error {
+		return fmt.Errorf("lang: %s: cannot convert %s to %s", path, Value{v}.Kind(), dst.Type())
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return mismatch()
+		}
+		if x := toAny(v); x != nil {
+			dst.Set(reflect.ValueOf(x))
+		}
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v.Kind {
+		case bytecode.ValInt:
+			n = v.I
+		case bytecode.ValChar:
+			n = int64(v.C)
+		default:
+			return mismatch()
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("lang: %s: %d overflows %s", path, n, dst.Type())
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch {
+		case v.Kind == bytecode.ValChar:
+			n = uint64(v.C)
+		case v.Kind == bytecode.ValInt && v.I >= 0:
+			n = uint64(v.I)
+		case v.Kind == bytecode.ValInt:
+			return fmt.Errorf("lang: %s: %d overflows %s", path, v.I, dst.Type())
+		default:
+			return mismatch()
+		}
+		if dst.OverflowUint(n) {
+			return fmt.Errorf("lang: %s: %d overflows %s", path, n, dst.Type())
+		}
+		dst.SetUint(n)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		if v.Kind != bytecode.ValFloat {
+			return mismatch()
+		}
+		dst.SetFloat(v.F)
+		return nil
+
+	case reflect.Bool:
+		if v.Kind != bytecode.ValBool {
+			return mismatch()
+		}
+		dst.SetBool(v.B)
+		return nil
+
+	case reflect.String:
+		if v.Kind != bytecode.ValString {
+			return mismatch()
+		}
+		dst.SetString(v.S)
+		return nil
+
+	case reflect.Pointer:
+		if v.Kind == bytecode.ValNull {
+			dst.SetZero()
+			return nil
+		}
+		p := reflect.New(dst.Type().Elem())
+		if err := fromValue(v, p.Elem(), path); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind == bytecode.ValNull && dst.Kind() == reflect.Slice {
+			dst.SetZero()
+			return nil
+		}
+		if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjArray {
+			return mismatch()
+		}
+		items := v.Obj.Items
+		if dst.Kind() == reflect.Slice {
+			dst.Set(reflect.MakeSlice(dst.Type(), len(items), len(items)))
+		} else if dst.Len() != len(items) {
+			return fmt.Errorf("lang: %s: cannot convert array of length %d to %s", path, len(items), dst.Type())
+		}
+		for i, it := range items {
+			if err := fromValue(it, dst.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return mismatch()
+}
+
+func toAny(v bytecode.Value) any {
+	switch v.Kind {
+	case bytecode.ValInt:
+		return v.I
+	case bytecode.ValFloat:
+		return v.F
+	case bytecode.ValBool:
+		return v.B
+	case bytecode.ValString:
+		return v.S
+	case bytecode.ValChar:
+		return v.C
+	case bytecode.ValObject:
+		if v.Obj.Type != bytecode.ObjArray {
+			return Value{v}
+		}
+		out := make([]any, len(v.Obj.Items))
+		for i, it := range v.Obj.Items {
+			out[i] = toAny(it)
+		}
+		return out
+	}
+	return nil
+}
+
+// instantiate binds the type parameters of the generic fn name to the
+// types of args and returns its parameter types with them filled in.
+// Arguments binding a parameter to different types are left for toValue
+// to reject.
+func instantiate(name string, sig signature, args []any) ([]sema.Type, error) {
+	sub := make(map[string]sema.Type)
+	for i, arg := range args {
+		if t, ok := typeOf(arg); ok {
+			sema.Unify(sig.params[i], t, sig.typeParams, sub)
+		}
+	}
+	for _, tp := range sig.typeParams {
+		t, ok := sub[tp.Name]
+		if !ok {
+			return nil, fmt.Errorf("lang: cannot infer type parameter %s of %q from its arguments", tp.Name, name)
+		}
+		if !tp.Bound.Allows(t) {
+			return nil, fmt.Errorf("lang: %s does not satisfy %s, the bound of type parameter %s of %q", t, tp.Bound, tp.Name, name)
+		}
+	}
+	params := make([]sema.Type, len(sig.params))
+	for i, p := range sig.params {
+		params[i] = sema.Subst(p, sub)
+	}
+	return params, nil
+}
+
+// typeOf returns the type of the program's values x converts to, if a
+// Go value has one type it converts to by default.
+func typeOf(x any) (sema.Type, bool) {
+	if v, ok := x.(Value); ok {
+		return typeOfValue(v.v)
+	}
+	if x == nil {
+		return sema.T(bytecode.TypeNull), true
+	}
+	return goType(reflect.TypeOf(x))
+}
+
+func goType(rt reflect.Type) (sema.Type, bool) {
+	switch rt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return sema.T(bytecode.TypeInt), true
+	case reflect.Float32, reflect.Float64:
+		return sema.T(bytecode.TypeFloat), true
+	case reflect.Bool:
+		return sema.T(bytecode.TypeBool), true
+	case reflect.String:
+		return sema.T(bytecode.TypeString), true
+	case reflect.Slice, reflect.Array:
+		elem, ok := goType(rt.Elem())
+		return sema.Arr(elem), ok
+	case reflect.Pointer:
+		elem, ok := goType(rt.Elem())
+		return sema.Nullable(elem), ok
+	}
+	return sema.Type{}, false
+}
+
+func typeOfValue(v bytecode.Value) (sema.Type, bool) {
+	switch v.Kind {
+	case bytecode.ValInt:
+		return sema.T(bytecode.TypeInt), true
+	case bytecode.ValFloat:
+		return sema.T(bytecode.TypeFloat), true
+	case bytecode.ValBool:
+		return sema.T(bytecode.TypeBool), true
+	case bytecode.ValString:
+		return sema.T(bytecode.TypeString), true
+	case bytecode.ValChar:
+		return sema.T(bytecode.TypeChar), true
+	case bytecode.ValNull:
+		return sema.T(bytecode.TypeNull), true
+	}
+	if v.Obj == nil {
+		return sema.Type{}, false
+	}
+	switch v.Obj.Type {
+	case bytecode.ObjArray:
+		// An empty array says nothing about its elements.
+		elem := sema.Hole()
+		if len(v.Obj.Items) != 0 {
+			var ok bool
+			if elem, ok = typeOfValue(v.Obj.Items[0]); !ok {
+				return sema.Type{}, false
+			}
+		}
+		return sema.Arr(elem), true
+	case bytecode.ObjVariant:
+		desc := v.Obj.State.(*bytecode.Variant)
+		switch desc {
+		case bytecode.OkVariant, bytecode.ErrVariant:
+			t, ok := typeOfValue(v.Obj.Items[0])
+			if desc == bytecode.OkVariant {
+				return sema.Result(&t, nil), ok
+			}
+			return sema.Result(nil, &t), ok
+		}
+		return sema.Enum(desc.Enum), true
+	}
+	return sema.Type{}, false
+}
+
+// toValue converts a Go value to a value of type t, allocating arrays on
+// the VM's heap.
+func (vm *VM) toValue(x any, t sema.Type, path string) (bytecode.Value, error) {
+	if v, ok := x.(Value); ok {
+		if !hasSemaType(v.v, t) {
+			return bytecode.Value{}, fmt.Errorf("lang: %s: cannot use %s value as %s", path, v.Kind(), t)
+		}
+		return v.v, nil
+	}
+
+	rv := reflect.ValueOf(x)
+	if t.IsNullable() {
+		switch {
+		case !rv.IsValid(), (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Slice) && rv.IsNil():
+			return bytecode.Value{Kind: bytecode.ValNull}, nil
+		case rv.Kind() == reflect.Pointer:
+			x = rv.Elem().Interface()
+		}
+		return vm.toValue(x, t.NonNull(), path)
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("lang: %s: cannot use %T as %s", path, x, t)
+	}
+	if !rv.IsValid() {
+		if t.Kind == bytecode.TypeNull {
+			return bytecode.Value{Kind: bytecode.ValNull}, nil
+		}
+		return bytecode.Value{}, mismatch()
+	}
+
+	switch t.Kind {
+	case bytecode.TypeInt:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return bytecode.Value{Kind: bytecode.ValInt, I: rv.Int()}, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if rv.Uint() > math.MaxInt64 {
+				return bytecode.Value{}, fmt.Errorf("lang: %s: %d overflows int", path, rv.Uint())
+			}
+			return bytecode.Value{Kind: bytecode.ValInt, I: int64(rv.Uint())}, nil
+		}
+	case bytecode.TypeFloat:
+		if rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64 {
+			return bytecode.Value{Kind: bytecode.ValFloat, F: rv.Float()}, nil
+		}
+	case bytecode.TypeBool:
+		if rv.Kind() == reflect.Bool {
+			return bytecode.Value{Kind: bytecode.ValBool, B: rv.Bool()}, nil
+		}
+	case bytecode.TypeString:
+		if rv.Kind() == reflect.String {
+			return bytecode.Value{Kind: bytecode.ValString, S: rv.String()}, nil
+		}
+	case bytecode.TypeChar:
+		switch rv.Kind() {
+		case reflect.Uint8:
+			return bytecode.Value{Kind: bytecode.ValChar, C: rune(rv.Uint())}, nil
+		case reflect.Int32:
+			return bytecode.Value{Kind: bytecode.ValChar, C: rune(rv.Int())}, nil
+		}
+	case bytecode.TypeArray:
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			break
+		}
+		items := make([]bytecode.Value, rv.Len())
+		for i := range items {
+			it, err := vm.toValue(rv.Index(i).Interface(), *t.Elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return bytecode.Value{}, err
+			}
+			items[i] = it
+		}
+		return vm.vm.NewArray(items)
+	}
+	return bytecode.Value{}, mismatch()
+}
+
+func hasSemaType(v bytecode.Value, t sema.Type) bool {
+	switch t.Kind {
+	case bytecode.TypeInt:
+		return v.Kind == bytecode.ValInt
+	case bytecode.TypeFloat:
+		return v.Kind == bytecode.ValFloat
+	case bytecode.TypeBool:
+		return v.Kind == bytecode.ValBool
+	case bytecode.TypeString:
+		return v.Kind == bytecode.ValString
+	case bytecode.TypeChar:
+		return v.Kind == bytecode.ValChar
+	case bytecode.TypeArray:
+		return v.Kind == bytecode.ValObject && v.Obj != nil && v.Obj.Type == bytecode.ObjArray
+	case bytecode.TypeIter:
+		return v.Kind == bytecode.ValObject && v.Obj != nil && v.Obj.Type == bytecode.ObjGenerator
+	case bytecode.TypeChan:
+		return v.Kind == bytecode.ValObject && v.Obj != nil && v.Obj.Type == bytecode.ObjChan
+	case bytecode.TypeNull:
+		return v.Kind == bytecode.ValNull
+	case bytecode.TypeNullable:
+		return v.Kind == bytecode.ValNull || hasSemaType(v, t.NonNull())
+	case bytecode.TypeResult:
+		if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjVariant {
+			return false
+		}
+		switch v.Obj.State {
+		case bytecode.OkVariant:
+			return hasSemaType(v.Obj.Items[0], *t.Elem)
+		case bytecode.ErrVariant:
+			return hasSemaType(v.Obj.Items[0], *t.Err)
+		}
+		return false
+	case bytecode.TypeEnum:
+		if v.Kind != bytecode.ValObject || v.Obj == nil || v.Obj.Type != bytecode.ObjVariant {
+			return false
+		}
+		return v.Obj.State.(*bytecode.Variant).Enum == t.Name
+	}
+	return false
+}
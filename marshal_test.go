@@ -0,0 +1,218 @@
+package lang_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dunooo0ooo/lang"
+)
+
+const marshalProgram = `
+fn fact(n: int) -> int {
+    if n <= 1 { return 1; }
+    return n * fact(n - 1);
+}
+fn total(xs: []int) -> int {
+    let s: int = 0;
+    for let i: int = 0; i < 3; i = i + 1 { s = s + xs[i]; }
+    return s;
+}
+fn trace(m: [][]float) -> float {
+    return m[0][0] + m[1][1];
+}
+fn grid(n: int) -> [][]int {
+    return [[n, n + 1], [n + 2, n + 3]];
+}
+fn half(x: float) -> float { return x / 2.0; }
+fn greet(name: string, loud: bool) -> string {
+    return if loud { "HI" } else { name };
+}
+fn letter(c: char) -> char { return c; }
+fn name_or(s: string?, d: string) -> string { return s ?? d; }
+fn wrap(n: int) -> []int? {
+    return if n > 0 { [n] } else { null };
+}
+`
+
+func TestCallMarshalsGoValues(t *testing.T) {
+	prog := mustCompile(t, marshalProgram, lang.CompileOptions{})
+	for _, jit := range []bool{false, true} {
+		vm := lang.NewVM(prog, lang.VMOptions{JIT: jit})
+
+		if n, err := lang.CallAs[int](vm, "fact", 5); err != nil || n != 120 {
+			t.Fatalf("jit=%v: fact = %v, %v", jit, n, err)
+		}
+		if n, err := lang.CallAs[uint8](vm, "total", []int{1, 2, 3}); err != nil || n != 6 {
+			t.Fatalf("jit=%v: total = %v, %v", jit, n, err)
+		}
+		if f, err := lang.CallAs[float64](vm, "trace", [][]float64{{1.5, 0}, {0, 2}}); err != nil || f != 3.5 {
+			t.Fatalf("jit=%v: trace = %v, %v", jit, f, err)
+		}
+		g, err := lang.CallAs[[][]int64](vm, "grid", int8(1))
+		if err != nil || len(g) != 2 || g[1][1] != 4 {
+			t.Fatalf("jit=%v: grid = %v, %v", jit, g, err)
+		}
+		if a, err := lang.CallAs[[2][2]int](vm, "grid", 0); err != nil || a != [2][2]int{{0, 1}, {2, 3}} {
+			t.Fatalf("jit=%v: grid as array = %v, %v", jit, a, err)
+		}
+		if x, err := lang.CallAs[any](vm, "grid", 1); err != nil || len(x.([]any)) != 2 || x.([]any)[0].([]any)[1] != int64(2) {
+			t.Fatalf("jit=%v: grid as any = %#v, %v", jit, x, err)
+		}
+		if s, err := lang.CallAs[string](vm, "greet", "ada", false); err != nil || s != "ada" {
+			t.Fatalf("jit=%v: greet = %v, %v", jit, s, err)
+		}
+		if c, err := lang.CallAs[byte](vm, "letter", byte('q')); err != nil || c != 'q' {
+			t.Fatalf("jit=%v: letter = %v, %v", jit, c, err)
+		}
+		if r, err := lang.CallAs[rune](vm, "letter", 'λ'); err != nil || r != 'λ' {
+			t.Fatalf("jit=%v: letter = %v, %v", jit, r, err)
+		}
+		if c, err := lang.CallAs[byte](vm, "letter", 'λ'); err == nil {
+			t.Fatalf("jit=%v: letter as byte = %v, want an overflow error", jit, c)
+		}
+		if v, err := vm.Call("letter", lang.Rune('λ')); err != nil || v.String() != "λ" {
+			t.Fatalf("jit=%v: letter = %v, %v", jit, v, err)
+		} else if _, err := v.AsChar(); err == nil {
+			t.Fatalf("jit=%v: AsChar of λ did not fail", jit)
+		}
+		if v, err := lang.CallAs[lang.Value](vm, "half", lang.Float(3)); err != nil || v.String() != "1.5" {
+			t.Fatalf("jit=%v: half = %v, %v", jit, v, err)
+		}
+	}
+}
+
+func TestCallMarshalsNullables(t *testing.T) {
+	prog := mustCompile(t, marshalProgram, lang.CompileOptions{})
+	vm := lang.NewVM(prog, lang.VMOptions{})
+
+	name := "ada"
+	var none *string
+	for _, tc := range []struct {
+		arg  any
+		want string
+	}{{nil, "anon"}, {none, "anon"}, {&name, "ada"}, {"bob", "bob"}, {lang.Null(), "anon"}} {
+		if s, err := lang.CallAs[string](vm, "name_or", tc.arg, "anon"); err != nil || s != tc.want {
+			t.Fatalf("name_or(%v) = %q, %v, want %q", tc.arg, s, err, tc.want)
+		}
+	}
+
+	if xs, err := lang.CallAs[[]int](vm, "wrap", 0); err != nil || xs != nil {
+		t.Fatalf("wrap(0) = %v, %v, want nil", xs, err)
+	}
+	if p, err := lang.CallAs[*[]int](vm, "wrap", 0); err != nil || p != nil {
+		t.Fatalf("wrap(0) as pointer = %v, %v, want nil", p, err)
+	}
+	if p, err := lang.CallAs[*[]int](vm, "wrap", 7); err != nil || p == nil || len(*p) != 1 || (*p)[0] != 7 {
+		t.Fatalf("wrap(7) as pointer = %v, %v", p, err)
+	}
+	if _, err := vm.Call("total", nil); err == nil || !strings.Contains(err.Error(), "cannot use <nil> as []int") {
+		t.Fatalf("total(nil): err = %v", err)
+	}
+}
+
+func TestCallMarshalErrors(t *testing.T) {
+	prog := mustCompile(t, marshalProgram, lang.CompileOptions{})
+	vm := lang.NewVM(prog, lang.VMOptions{})
+
+	for _, tc := range []struct {
+		call func() error
+		want string
+	}{
+		{func() error { _, err := vm.Call("fact", "5"); return err }, "fact argument 1: cannot use string as int"},
+		{func() error { _, err := vm.Call("half", 2); return err }, "half argument 1: cannot use int as float"},
+		{func() error { _, err := vm.Call("fact"); return err }, "fact takes 1 arguments, got 0"},
+		{func() error { _, err := vm.Call("nope"); return err }, `unknown function "nope"`},
+		{func() error { _, err := vm.Call("trace", [][]any{{1.0}, nil, {1.0, 2.0, 3.0}, {true}}); return err }, "trace argument 1[3][0]: cannot use bool as float"},
+		{func() error { _, err := vm.Call("fact", lang.Float(1)); return err }, "cannot use float value as int"},
+		{func() error { _, err := vm.Call("fact", uint64(1)<<63); return err }, "overflows int"},
+		{func() error { _, err := lang.CallAs[string](vm, "fact", 3); return err }, "fact result: cannot convert int to string"},
+		{func() error { _, err := lang.CallAs[int8](vm, "fact", 6); return err }, "fact result: 720 overflows int8"},
+		{func() error { _, err := lang.CallAs[[]bool](vm, "grid", 1); return err }, "grid result[0]: cannot convert array to bool"},
+		{func() error { _, err := lang.CallAs[[3][]int](vm, "grid", 1); return err }, "cannot convert array of length 2 to [3][]int"},
+	} {
+		err := tc.call()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got error %v, want it to mention %q", err, tc.want)
+		}
+	}
+}
+
+func TestCallGenericFunctions(t *testing.T) {
+	prog := mustCompile(t, `
+fn max<T: Ord>(a: T, b: T) -> T {
+    if a > b { return a; }
+    return b;
+}
+fn sum<N: Num>(xs: []N, zero: N) -> N {
+    let s = zero;
+    for let i = 0; i < xs.len(); i = i + 1 { s = s + xs[i]; }
+    return s;
+}
+fn first<T>(xs: []T, d: T?) -> T? {
+    return if xs.len() > 0 { xs[0] } else { d };
+}
+`, lang.CompileOptions{})
+
+	for _, jit := range []bool{false, true} {
+		vm := lang.NewVM(prog, lang.VMOptions{JIT: jit})
+		if v, err := vm.Call("max", lang.Int(3), lang.Int(9)); err != nil || v.String() != "9" {
+			t.Fatalf("jit=%v: max = %v, %v", jit, v, err)
+		}
+		if n, err := lang.CallAs[int](vm, "max", 3, 9); err != nil || n != 9 {
+			t.Fatalf("jit=%v: max = %v, %v", jit, n, err)
+		}
+		if c, err := lang.CallAs[rune](vm, "max", lang.Rune('a'), lang.Rune('z')); err != nil || c != 'z' {
+			t.Fatalf("jit=%v: max = %v, %v", jit, c, err)
+		}
+		if f, err := lang.CallAs[float64](vm, "sum", []float64{1.5, 2}, 0.5); err != nil || f != 4 {
+			t.Fatalf("jit=%v: sum = %v, %v", jit, f, err)
+		}
+		if s, err := lang.CallAs[*string](vm, "first", []string{}, "d"); err != nil || s == nil || *s != "d" {
+			t.Fatalf("jit=%v: first = %v, %v", jit, s, err)
+		}
+	}
+
+	vm := lang.NewVM(prog, lang.VMOptions{})
+	for _, tc := range []struct {
+		call func() error
+		want string
+	}{
+		{func() error { _, err := vm.Call("max", 3, 2.5); return err }, "max argument 2: cannot use float64 as int"},
+		{func() error { _, err := vm.Call("max", lang.Int(3), lang.String("s")); return err }, "max argument 2: cannot use string value as int"},
+		{func() error { _, err := lang.CallAs[bool](vm, "max", true, false); return err }, "bool does not satisfy Ord"},
+		{func() error { _, err := vm.Call("sum", []string{"a"}, "b"); return err }, "string does not satisfy Num"},
+		{func() error { _, err := vm.Call("first", nil, nil); return err }, "cannot infer type parameter T"},
+	} {
+		err := tc.call()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got error %v, want it to mention %q", err, tc.want)
+		}
+	}
+}
+
+func TestArgumentArraysSurviveCollections(t *testing.T) {
+	prog := mustCompile(t, `
+fn sum(rows: [][]int, n: int) -> int {
+    let s: int = 0;
+    for let i: int = 0; i < n; i = i + 1 {
+        let junk: []int = array(64);
+        s = s + rows[i][0] + rows[i][1];
+    }
+    return s;
+}
+`, lang.CompileOptions{})
+	vm := lang.NewVM(prog, lang.VMOptions{})
+	vm.SetGCPercent(0)
+
+	rows := make([][]int, 5000)
+	want := 0
+	for i := range rows {
+		rows[i] = []int{i, 2 * i}
+		want += 3 * i
+	}
+	for round := 0; round < 3; round++ {
+		if got, err := lang.CallAs[int](vm, "sum", rows, len(rows)); err != nil || got != want {
+			t.Fatalf("round %d: sum = %v, %v, want %d", round, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package lang
+
+import (
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+// Type is the static type of a native function's parameter or result.
+type Type struct {
+	kind bytecode.TypeKind
+	elem *Type
+}
+
+var (
+	IntType    = Type{kind: bytecode.TypeInt}
+	FloatType  = Type{kind: bytecode.TypeFloat}
+	BoolType   = Type{kind: bytecode.TypeBool}
+	StringType = Type{kind: bytecode.TypeString}
+	CharType   = Type{kind: bytecode.TypeChar}
+	VoidType   = Type{kind: bytecode.TypeVoid}
+)
+
+// ArrayOf is the type of arrays with elements of type elem.
+func ArrayOf(elem Type) Type {
+	return Type{kind: bytecode.TypeArray, elem: &elem}
+}
+
+func (t Type) String() string { return t.sema().String() }
+
+func (t Type) typeKind() bytecode.TypeKind {
+	if t.kind == bytecode.TypeInvalid {
+		return bytecode.TypeVoid
+	}
+	return t.kind
+}
+
+func (t Type) sema() sema.Type {
+	if t.kind == bytecode.TypeArray {
+		return sema.Arr(t.elem.sema())
+	}
+	return sema.T(t.typeKind())
+}
+
+// NativeFunc implements a native function. Its arguments have the types
+// the native declares and it must return a value of its result type; the
+// result of a void native is ignored. A returned error stops the program
+// with a RuntimeError wrapping it, and so does a panic.
+type NativeFunc func(args []Value) (Value, error)
+
+// Native is a Go function that programs call like one of their own.
+type Native struct {
+	Name   string
+	Params []Type
+	// Result is the result type; the zero Type means void.
+	Result Type
+	Fn     NativeFunc
+}
+
+func (f NativeFunc) wrap() func([]bytecode.Value) (bytecode.Value, error) {
+	return func(args []bytecode.Value) (bytecode.Value, error) {
+		ret, err := f(wrapValues(args))
+		return ret.v, err
+	}
+}
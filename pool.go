@@ -0,0 +1,54 @@
+package lang
+
+import (
+	"context"
+	"sync"
+)
+
+// VMPool hands out VMs running one program to concurrent callers. Every VM
+// has its own heap, so calls on different VMs share nothing but the
+// read-only program. Streams in the pool's options are shared by all its
+// VMs and must be safe for concurrent use.
+type VMPool struct {
+	prog *Program
+	opts VMOptions
+	pool sync.Pool
+}
+
+func NewVMPool(prog *Program, opts VMOptions) *VMPool {
+	p := &VMPool{prog: prog, opts: opts}
+	p.pool.New = func() any { return NewVM(prog, opts) }
+	return p
+}
+
+// Get returns a VM for the caller's exclusive use until it is handed back
+// with Put.
+func (p *VMPool) Get() *VM {
+	return p.pool.Get().(*VM)
+}
+
+// Put returns vm to the pool. Its heap is cleared first, so nothing from
+// one caller's calls is kept alive for, or visible to, the next.
+func (p *VMPool) Put(vm *VM) {
+	if vm == nil || vm.prog != p.prog {
+		return
+	}
+	vm.vm.Reset()
+	p.pool.Put(vm)
+}
+
+// PoolCall runs one call on a pooled VM and converts the result to T, as
+// CallAs does. The conversion happens before the VM goes back to the pool,
+// whose heap is cleared then, so T should not be Value when the result is
+// an array.
+func PoolCall[T any](ctx context.Context, p *VMPool, name string, args ...any) (T, error) {
+	vm := p.Get()
+	defer p.Put(vm)
+
+	ret, err := vm.CallContext(ctx, name, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return convertTo[T](ret, name+" result")
+}
@@ -0,0 +1,119 @@
+package lang_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dunooo0ooo/lang"
+)
+
+const poolProgram = `
+fn work(seed: int, n: int) -> []int {
+    let out: []int = array(n);
+    let x: int = seed;
+    for let i: int = 0; i < n; i = i + 1 {
+        let tmp: []int = [x, i];
+        x = (x * 31 + tmp[1]) % 1000003;
+        set(out, i, x);
+    }
+    return out;
+}
+`
+
+func expectedWork(seed, n int) []int {
+	out := make([]int, n)
+	x := seed
+	for i := range out {
+		x = (x*31 + i) % 1000003
+		out[i] = x
+	}
+	return out
+}
+
+func TestVMPool_ConcurrentCalls(t *testing.T) {
+	prog := mustCompile(t, poolProgram, lang.CompileOptions{})
+
+	for _, jit := range []bool{false, true} {
+		pool := lang.NewVMPool(prog, lang.VMOptions{JIT: jit})
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					seed, n := g*100+i, 50+g*10+i
+					got, err := lang.PoolCall[[]int](context.Background(), pool, "work", seed, n)
+					if err != nil {
+						errs <- err
+						return
+					}
+					want := expectedWork(seed, n)
+					if fmt.Sprint(got) != fmt.Sprint(want) {
+						errs <- fmt.Errorf("jit=%v: work(%d, %d) = %v, want %v", jit, seed, n, got, want)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVMPool_VMsAreIsolated(t *testing.T) {
+	prog := mustCompile(t, poolProgram, lang.CompileOptions{})
+	pool := lang.NewVMPool(prog, lang.VMOptions{})
+
+	vm := pool.Get()
+	if _, err := vm.Call("work", 1, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Stats().LiveBytes == 0 {
+		t.Fatal("call left nothing on the heap")
+	}
+	pool.Put(vm)
+	if live := vm.Stats().LiveBytes; live != 0 {
+		t.Fatalf("pooled VM still holds %d bytes", live)
+	}
+
+	a, b := pool.Get(), pool.Get()
+	if a == b {
+		t.Fatal("pool handed out the same VM twice")
+	}
+	pool.Put(a)
+	pool.Put(b)
+}
+
+func TestNewVM_ConcurrentJITOnSharedProgram(t *testing.T) {
+	prog := mustCompile(t, poolProgram, lang.CompileOptions{})
+
+	var wg sync.WaitGroup
+	results := make([]string, 16)
+	for g := range results {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			vm := lang.NewVM(prog, lang.VMOptions{JIT: g%2 == 0})
+			got, err := lang.CallAs[[]int](vm, "work", 7, 100)
+			if err != nil {
+				results[g] = err.Error()
+				return
+			}
+			results[g] = fmt.Sprint(got)
+		}(g)
+	}
+	wg.Wait()
+
+	want := fmt.Sprint(expectedWork(7, 100))
+	for g, got := range results {
+		if got != want {
+			t.Fatalf("goroutine %d: got %s, want %s", g, got, want)
+		}
+	}
+}
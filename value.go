@@ -0,0 +1,219 @@
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+)
+
+// Kind is the kind of a Value.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+	KindString
+	KindChar
+	KindArray
+	KindIter
+	KindChan
+	KindVariant
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "null"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindString:
+		return "string"
+	case KindChar:
+		return "char"
+	case KindArray:
+		return "array"
+	case KindIter:
+		return "iter"
+	case KindChan:
+		return "chan"
+	case KindVariant:
+		return "variant"
+	default:
+		return "invalid"
+	}
+}
+
+// Value is a value passed to or returned from a program; the zero Value is
+// the int 0. Arrays live on the heap of the VM that made them and stay
+// valid until the next call into that VM.
+type Value struct {
+	v bytecode.Value
+}
+
+func Int(i int64) Value     { return Value{bytecode.Value{Kind: bytecode.ValInt, I: i}} }
+func Float(f float64) Value { return Value{bytecode.Value{Kind: bytecode.ValFloat, F: f}} }
+func Bool(b bool) Value     { return Value{bytecode.Value{Kind: bytecode.ValBool, B: b}} }
+func String(s string) Value { return Value{bytecode.Value{Kind: bytecode.ValString, S: s}} }
+func Char(c byte) Value     { return Rune(rune(c)) }
+func Rune(r rune) Value     { return Value{bytecode.Value{Kind: bytecode.ValChar, C: r}} }
+func Null() Value           { return Value{bytecode.Value{Kind: bytecode.ValNull}} }
+
+func (v Value) Kind() Kind {
+	switch v.v.Kind {
+	case bytecode.ValInt:
+		return KindInt
+	case bytecode.ValFloat:
+		return KindFloat
+	case bytecode.ValBool:
+		return KindBool
+	case bytecode.ValString:
+		return KindString
+	case bytecode.ValChar:
+		return KindChar
+	case bytecode.ValObject:
+		switch v.v.Obj.Type {
+		case bytecode.ObjGenerator:
+			return KindIter
+		case bytecode.ObjChan:
+			return KindChan
+		case bytecode.ObjVariant:
+			return KindVariant
+		}
+		return KindArray
+	default:
+		return KindNull
+	}
+}
+
+func (v Value) IsNull() bool { return v.Kind() == KindNull }
+
+func (v Value) kindError(want Kind) error {
+	return fmt.Errorf("lang: value is %s, not %s", v.Kind(), want)
+}
+
+func (v Value) AsInt() (int64, error) {
+	if v.Kind() != KindInt {
+		return 0, v.kindError(KindInt)
+	}
+	return v.v.I, nil
+}
+
+func (v Value) AsFloat() (float64, error) {
+	if v.Kind() != KindFloat {
+		return 0, v.kindError(KindFloat)
+	}
+	return v.v.F, nil
+}
+
+func (v Value) AsBool() (bool, error) {
+	if v.Kind() != KindBool {
+		return false, v.kindError(KindBool)
+	}
+	return v.v.B, nil
+}
+
+func (v Value) AsString() (string, error) {
+	if v.Kind() != KindString {
+		return "", v.kindError(KindString)
+	}
+	return v.v.S, nil
+}
+
+// AsChar returns a char that fits in a byte, such as an ASCII one; use
+// AsRune for any char.
+func (v Value) AsChar() (byte, error) {
+	r, err := v.AsRune()
+	if err != nil {
+		return 0, err
+	}
+	if r > 0xFF {
+		return 0, fmt.Errorf("lang: char %q does not fit in a byte", r)
+	}
+	return byte(r), nil
+}
+
+func (v Value) AsRune() (rune, error) {
+	if v.Kind() != KindChar {
+		return 0, v.kindError(KindChar)
+	}
+	return v.v.C, nil
+}
+
+// AsArray returns a copy of the elements of an array.
+func (v Value) AsArray() ([]Value, error) {
+	if v.Kind() != KindArray {
+		return nil, v.kindError(KindArray)
+	}
+	items := v.v.Obj.Items
+	out := make([]Value, len(items))
+	for i, it := range items {
+		out[i] = Value{it}
+	}
+	return out, nil
+}
+
+// AsVariant returns the name of a variant of an enum and a copy of the
+// values it carries.
+func (v Value) AsVariant() (string, []Value, error) {
+	if v.Kind() != KindVariant {
+		return "", nil, v.kindError(KindVariant)
+	}
+	return v.v.Obj.State.(*bytecode.Variant).Name, wrapValues(v.v.Obj.Items), nil
+}
+
+// String formats v the way print does; arrays print as [1, 2, 3],
+// variants as Rect(1, 2), iterators as <iter> and channels as <chan>.
+func (v Value) String() string {
+	switch v.v.Kind {
+	case bytecode.ValInt:
+		return strconv.FormatInt(v.v.I, 10)
+	case bytecode.ValFloat:
+		return strconv.FormatFloat(v.v.F, 'g', -1, 64)
+	case bytecode.ValBool:
+		return strconv.FormatBool(v.v.B)
+	case bytecode.ValString:
+		return v.v.S
+	case bytecode.ValChar:
+		return string(v.v.C)
+	case bytecode.ValObject:
+		switch k := v.Kind(); k {
+		case KindVariant:
+			name, items, _ := v.AsVariant()
+			if len(items) == 0 {
+				return name
+			}
+			return name + "(" + joinValues(items) + ")"
+		case KindArray:
+			items, _ := v.AsArray()
+			return "[" + joinValues(items) + "]"
+		default:
+			return "<" + k.String() + ">"
+		}
+	default:
+		return "null"
+	}
+}
+
+func joinValues(vals []Value) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = v.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func wrapValues(vals []bytecode.Value) []Value {
+	out := make([]Value, len(vals))
+	for i, v := range vals {
+		out[i] = Value{v}
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package lang
+
+import (
+	"fmt"
+
+	"github.com/dunooo0ooo/lang/internal/lint"
+	"github.com/dunooo0ooo/lang/internal/sema"
+)
+
+// Diagnostic is a finding of Vet: the ID of the lint that made it, its
+// severity, where it is and what it is about.
+type Diagnostic = lint.Diagnostic
+
+// Severity is how much a Diagnostic matters.
+type Severity = lint.Severity
+
+const (
+	// SeverityOff turns a lint off.
+	SeverityOff     = lint.Off
+	SeverityInfo    = lint.Info
+	SeverityWarning = lint.Warning
+	SeverityError   = lint.Error
+)
+
+// ParseSeverity parses "off", "info", "warning" or "error".
+func ParseSeverity(name string) (Severity, error) { return lint.ParseSeverity(name) }
+
+// Analyzer is a lint of the embedding program's own, which Vet runs after
+// its lints when listed in VetOptions.Analyzers. Run reports findings
+// through the Pass it is given; they have Severity unless VetOptions says
+// otherwise, so an analyzer left at SeverityOff only runs when turned on.
+type Analyzer = lint.Analyzer
+
+// Pass is what an Analyzer looks at: the program's syntax tree, the types
+// the checker gave its expressions, the uses of its variables and what
+// the checker warned about.
+type Pass = lint.Pass
+
+// Lint describes one of the lints Vet runs.
+type Lint struct {
+	ID       string
+	Doc      string
+	Severity Severity
+}
+
+// Lints lists the lints Vet runs, with their default severities.
+func Lints() []Lint {
+	out := make([]Lint, len(lint.All))
+	for i, a := range lint.All {
+		out[i] = Lint{ID: a.ID, Doc: a.Doc, Severity: a.Severity}
+	}
+	return out
+}
+
+// VetOptions configures Vet. The zero value runs every lint at its
+// default severity.
+type VetOptions struct {
+	// Natives are the Go functions the program may call.
+	Natives []Native
+	// Severities overrides the severities of lints by ID.
+	Severities map[string]Severity
+	// Analyzers are lints to run besides the built-in ones. Their IDs
+	// must differ from those of the others.
+	Analyzers []*Analyzer
+}
+
+// Vet reports code in src that compiles but is likely a mistake, sorted by
+// position. A "// lang:allow(id, ...)" comment in src turns the listed
+// lints off for it. If src does not compile, Vet returns a *CompileError.
+func Vet(src string, opts VetOptions) ([]Diagnostic, error) {
+	analyzers := append([]*Analyzer(nil), lint.All...)
+	ids := make(map[string]bool, len(analyzers))
+	for _, a := range lint.All {
+		ids[a.ID] = true
+	}
+	for _, a := range opts.Analyzers {
+		if a.ID == "" || a.Run == nil {
+			return nil, fmt.Errorf("analyzer %q needs an ID and a Run function", a.ID)
+		}
+		if ids[a.ID] {
+			return nil, fmt.Errorf("analyzer %q declared twice", a.ID)
+		}
+		ids[a.ID] = true
+		analyzers = append(analyzers, a)
+	}
+	for id := range opts.Severities {
+		if !ids[id] {
+			return nil, fmt.Errorf("unknown lint %q", id)
+		}
+	}
+	setup := func(c *sema.Checker) error { return declareNatives(c, opts.Natives) }
+	diags, errs := lint.Run(src, analyzers, lint.Config(opts.Severities), setup)
+	if len(errs) != 0 {
+		return nil, &CompileError{Errors: errs}
+	}
+	return diags, nil
+}
+
+// declareNatives declares natives to c, so that the program it checks may
+// call them.
+func declareNatives(c *sema.Checker, natives []Native) error {
+	for _, n := range natives {
+		params := make([]sema.Type, len(n.Params))
+		for i, t := range n.Params {
+			params[i] = t.sema()
+		}
+		if err := c.DeclareNative(n.Name, params, n.Result.sema()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
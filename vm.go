@@ -0,0 +1,177 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/dunooo0ooo/lang/internal/bytecode"
+	"github.com/dunooo0ooo/lang/internal/runtime"
+)
+
+// VMOptions configures NewVM. The zero value runs without the JIT, on the
+// process's standard streams, with no instruction budget or heap limit and
+// the default call depth limit.
+type VMOptions struct {
+	// JIT runs an optimized copy of the program's bytecode, made the first
+	// time a VM asks for it.
+	JIT bool
+
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	// InstructionBudget limits the instructions each call may execute.
+	InstructionBudget int64
+	// HeapLimit limits the bytes the heap may hold.
+	HeapLimit int
+	// MaxCallDepth limits how deep calls may nest. Zero keeps the default
+	// of 10000; a negative value removes the limit.
+	MaxCallDepth int
+
+	// SchedulerSeed seeds the scheduler of spawned tasks; runs with the
+	// same seed and inputs interleave tasks the same way.
+	SchedulerSeed int64
+	// TimeSlice is how many instructions a task runs before the scheduler
+	// may switch tasks. Zero keeps the default of 1000; a negative value
+	// switches only when a task blocks.
+	TimeSlice int
+}
+
+var (
+	ErrBudgetExceeded = runtime.ErrBudgetExceeded
+	ErrHeapLimit      = runtime.ErrHeapLimit
+	ErrStackOverflow  = runtime.ErrStackOverflow
+	ErrDeadlock       = runtime.ErrDeadlock
+)
+
+type (
+	// RuntimeError is an error raised while running a program, with the
+	// source line it was raised at.
+	RuntimeError = runtime.RuntimeError
+	Stats        = runtime.Stats
+	HeapCounts   = runtime.HeapCounts
+	Snapshot     = runtime.Snapshot
+	TypeGrowth   = runtime.TypeGrowth
+)
+
+// DiffSnapshots returns the object types whose count or retained size
+// grew from a to b, largest retained growth first.
+func DiffSnapshots(a, b *Snapshot) []TypeGrowth {
+	return runtime.DiffSnapshots(a, b)
+}
+
+// VM runs a Program. Its heap persists across calls. A VM must not be used
+// by more than one goroutine at a time.
+type VM struct {
+	vm   *runtime.VM
+	prog *Program
+}
+
+func NewVM(prog *Program, opts VMOptions) *VM {
+	var ropts []runtime.Option
+	if opts.Stdout != nil {
+		ropts = append(ropts, runtime.Stdout(opts.Stdout))
+	}
+	if opts.Stderr != nil {
+		ropts = append(ropts, runtime.Stderr(opts.Stderr))
+	}
+	if opts.Stdin != nil {
+		ropts = append(ropts, runtime.Stdin(opts.Stdin))
+	}
+	if opts.InstructionBudget > 0 {
+		ropts = append(ropts, runtime.InstructionBudget(opts.InstructionBudget))
+	}
+	if opts.HeapLimit > 0 {
+		ropts = append(ropts, runtime.HeapLimit(opts.HeapLimit))
+	}
+	if opts.MaxCallDepth != 0 {
+		ropts = append(ropts, runtime.MaxCallDepth(opts.MaxCallDepth))
+	}
+	if opts.SchedulerSeed != 0 {
+		ropts = append(ropts, runtime.SchedulerSeed(opts.SchedulerSeed))
+	}
+	if opts.TimeSlice != 0 {
+		ropts = append(ropts, runtime.TimeSlice(opts.TimeSlice))
+	}
+
+	mod := prog.mod
+	if opts.JIT {
+		mod = prog.optimized()
+	}
+	return &VM{vm: runtime.NewVM(mod, false, ropts...), prog: prog}
+}
+
+// Call calls the named function with args. Each argument is either a Value
+// or a Go value converted to the parameter's type: integers to int,
+// float32 and float64 to float, bool, string, byte or rune to char, and
+// slices or arrays of those to arrays, element by element. A nullable
+// parameter also takes nil, a nil pointer or a nil slice as null, and a
+// pointer as the value it points to. Calling a generic fn binds its type
+// parameters to the types of the arguments, which must agree and satisfy
+// their bounds, as in a call from the program.
+func (vm *VM) Call(name string, args ...any) (Value, error) {
+	return vm.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call but stops with ctx.Err() once ctx is done.
+func (vm *VM) CallContext(ctx context.Context, name string, args ...any) (Value, error) {
+	sig, ok := vm.prog.sigs[name]
+	if !ok {
+		return Value{}, fmt.Errorf("lang: unknown function %q", name)
+	}
+	if len(args) != len(sig.params) {
+		return Value{}, fmt.Errorf("lang: %s takes %d arguments, got %d", name, len(sig.params), len(args))
+	}
+	params := sig.params
+	if len(sig.typeParams) != 0 {
+		var err error
+		if params, err = instantiate(name, sig, args); err != nil {
+			return Value{}, err
+		}
+	}
+	vals := make([]bytecode.Value, len(args))
+	for i, arg := range args {
+		v, err := vm.toValue(arg, params[i], fmt.Sprintf("%s argument %d", name, i+1))
+		if err != nil {
+			return Value{}, err
+		}
+		vals[i] = v
+	}
+
+	ret, err := vm.vm.CallContext(ctx, name, vals)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{ret}, nil
+}
+
+// Next resumes the iterator it, as returned by calling a gen fn, until it
+// yields a value, which is returned with true. Once the iterator has
+// finished Next returns false. Like a call's result, it stays valid until
+// the next call into the VM other than Next on it.
+func (vm *VM) Next(it Value) (Value, bool, error) {
+	return vm.NextContext(context.Background(), it)
+}
+
+// NextContext is like Next but stops with ctx.Err() once ctx is done.
+func (vm *VM) NextContext(ctx context.Context, it Value) (Value, bool, error) {
+	if it.Kind() != KindIter {
+		return Value{}, false, it.kindError(KindIter)
+	}
+	ret, ok, err := vm.vm.Next(ctx, it.v)
+	if err != nil {
+		return Value{}, false, err
+	}
+	return Value{ret}, ok, nil
+}
+
+func (vm *VM) Stats() Stats { return vm.vm.Stats() }
+
+// SetGCPercent sets how far the heap may grow past the live data of the
+// last full collection before the next one, in percent, and returns the
+// previous setting. A negative percent turns the collector off.
+func (vm *VM) SetGCPercent(percent int) int { return vm.vm.SetGCPercent(percent) }
+
+// HeapSnapshot writes the heap's object graph to w as JSON; see Snapshot.
+func (vm *VM) HeapSnapshot(w io.Writer) error { return vm.vm.HeapSnapshot(w) }